@@ -0,0 +1,271 @@
+// workstation-sim 模拟检定工位，通过 WebSocket 向证书实时上传测试读数，用于联调和接口测试。
+//
+// 用法示例：
+//
+//	go run ./cmd/workstation-sim -cert CERT-001 -device DEV001 -api-key <key>
+//	go run ./cmd/workstation-sim -cert CERT-001 -device DEV001 -token <jwt> -type voltage -rated 100000
+//
+// 登记了公钥的设备须对读数签名：先生成密钥对并将输出的公钥登记到设备，上传时用 -key 指定私钥
+//
+//	go run ./cmd/workstation-sim -gen-key sm2 -key dev001.pem > dev001.pub.pem
+//	go run ./cmd/workstation-sim -cert CERT-001 -device DEV001 -api-key <key> -key dev001.pem
+package main
+
+import (
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+	"golang.org/x/net/websocket"
+)
+
+// signFunc 对规范化读数签名，返回 base64 编码的签名
+type signFunc func(payload string) (string, error)
+
+func main() {
+	server := flag.String("server", "http://localhost:8080", "服务地址")
+	certNumber := flag.String("cert", "", "证书编号")
+	device := flag.String("device", "DEV001", "检测设备地址")
+	apiKey := flag.String("api-key", "", "工位 API Key")
+	token := flag.String("token", "", "用户访问令牌（未提供 API Key 时使用）")
+	dataType := flag.String("type", "current", "数据类型：current / voltage")
+	rated := flag.Float64("rated", 5, "额定一次电流（A）或电压（V）")
+	points := flag.String("points", "5,20,100,120", "额定百分点，逗号分隔")
+	repeat := flag.Int("repeat", 3, "每个百分点的读数次数")
+	interval := flag.Duration("interval", 500*time.Millisecond, "读数间隔")
+	workstation := flag.String("workstation", "WS01", "工位号")
+	keyFile := flag.String("key", "", "设备签名私钥（PKCS#8 PEM），为空时不签名")
+	genKey := flag.String("gen-key", "", "生成 ecdsa 或 sm2 密钥对：私钥写入 -key 指定的文件，公钥输出到标准输出")
+	flag.Parse()
+
+	if *genKey != "" {
+		if err := generateKey(*genKey, *keyFile); err != nil {
+			log.Fatalf("生成密钥失败: %v", err)
+		}
+		return
+	}
+	if *certNumber == "" || (*apiKey == "" && *token == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	percents, err := parsePercents(*points)
+	if err != nil {
+		log.Fatalf("百分点格式错误: %v", err)
+	}
+
+	var sign signFunc
+	if *keyFile != "" {
+		if sign, err = loadSigner(*keyFile); err != nil {
+			log.Fatalf("读取设备私钥失败: %v", err)
+		}
+	}
+
+	ws, err := dial(*server, *certNumber, *apiKey, *token)
+	if err != nil {
+		log.Fatalf("连接失败: %v", err)
+	}
+	defer ws.Close()
+
+	var seq int64
+	accepted, rejected := 0, 0
+	for _, percent := range percents {
+		for i := 0; i < *repeat; i++ {
+			seq++
+			reading := newReading(seq, *device, *dataType, *workstation, percent, *rated)
+			if sign != nil {
+				if err := signReading(reading, *certNumber, sign); err != nil {
+					log.Fatalf("读数签名失败: %v", err)
+				}
+			}
+			if err := websocket.JSON.Send(ws, reading); err != nil {
+				log.Fatalf("发送读数失败: %v", err)
+			}
+
+			var ack models.TestDataStreamAck
+			if err := websocket.JSON.Receive(ws, &ack); err != nil {
+				log.Fatalf("接收确认失败: %v", err)
+			}
+			if ack.Status == "accepted" {
+				accepted++
+				fmt.Printf("#%d %s %s%% 比值差 %.4f%% 相位差 %.3f′ 已入库 (id=%d)\n",
+					ack.Seq, reading.TestPoint, strconv.FormatFloat(percent, 'f', -1, 64), reading.RatioError, reading.AngleError, ack.Data.ID)
+			} else {
+				rejected++
+				fmt.Printf("#%d 被拒绝: %s\n", ack.Seq, ack.Message)
+			}
+			time.Sleep(*interval)
+		}
+	}
+	fmt.Printf("完成：%d 条入库，%d 条被拒绝\n", accepted, rejected)
+	if rejected > 0 {
+		os.Exit(1)
+	}
+}
+
+// dial 建立到实时上传接口的 WebSocket 连接
+func dial(server, certNumber, apiKey, token string) (*websocket.Conn, error) {
+	origin, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	location := *origin
+	location.Scheme = strings.Replace(origin.Scheme, "http", "ws", 1)
+	location.Path = "/api/v1/test-data/stream/" + url.PathEscape(certNumber)
+
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	config.Header = http.Header{}
+	if apiKey != "" {
+		config.Header.Set("X-API-Key", apiKey)
+	} else {
+		config.Header.Set("Authorization", "Bearer "+token)
+	}
+	return websocket.DialConfig(config)
+}
+
+// newReading 生成一条模拟读数，误差围绕固定偏差随机波动
+func newReading(seq int64, device, dataType, workstation string, percent, rated float64) *models.TestDataStreamReading {
+	actual := percent * (1 + (rand.Float64()-0.5)*0.002)
+	reading := &models.TestDataStreamReading{
+		Seq: seq,
+		TestDataPointRequest: models.TestDataPointRequest{
+			DeviceAddr:        device,
+			DataType:          dataType,
+			TestPoint:         fmt.Sprintf("%s%%", strconv.FormatFloat(percent, 'f', -1, 64)),
+			PercentageValue:   percent,
+			ActualPercentage:  actual,
+			RatioError:        0.02 + (rand.Float64()-0.5)*0.01,
+			AngleError:        0.8 + (rand.Float64()-0.5)*0.4,
+			WorkstationNumber: workstation,
+			TestTimestamp:     time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	if dataType == "voltage" {
+		reading.VoltageValue = rated * actual / 100
+	} else {
+		reading.CurrentValue = rated * actual / 100
+	}
+	return reading
+}
+
+// signReading 按服务端相同的规则换算读数，对规范化读数签名
+func signReading(reading *models.TestDataStreamReading, certNumber string, sign signFunc) error {
+	data, err := service.NewTestData(0, certNumber, &reading.TestDataPointRequest)
+	if err != nil {
+		return err
+	}
+	reading.Signature, err = sign(service.CanonicalTestDataPayload(data))
+	return err
+}
+
+// loadSigner 读取 PKCS#8 PEM 私钥，ECDSA P-256 私钥对 SHA-256 摘要签名，SM2 私钥对读数签名
+func loadSigner(path string) (signFunc, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("不是 PEM 格式的私钥")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return nil, errors.New("仅支持 ECDSA P-256 或 SM2 私钥")
+		}
+		return func(payload string) (string, error) {
+			digest := sha256.Sum256([]byte(payload))
+			sig, err := ecdsa.SignASN1(crand.Reader, ecKey, digest[:])
+			return base64.StdEncoding.EncodeToString(sig), err
+		}, nil
+	}
+
+	sm2Key, err := gmx509.ReadPrivateKeyFromPem(keyPEM, nil)
+	if err != nil {
+		return nil, err
+	}
+	return func(payload string) (string, error) {
+		sig, err := sm2Key.Sign(crand.Reader, []byte(payload), nil)
+		return base64.StdEncoding.EncodeToString(sig), err
+	}, nil
+}
+
+// generateKey 生成设备密钥对，私钥写入文件，公钥以 PEM 格式输出
+func generateKey(algorithm, path string) error {
+	if path == "" {
+		return errors.New("须用 -key 指定私钥文件")
+	}
+
+	var privatePEM, publicPEM []byte
+	switch algorithm {
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		if err != nil {
+			return err
+		}
+		privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return err
+		}
+		publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			return err
+		}
+		privatePEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+		publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	case "sm2":
+		key, err := sm2.GenerateKey(crand.Reader)
+		if err != nil {
+			return err
+		}
+		if privatePEM, err = gmx509.WritePrivateKeyToPem(key, nil); err != nil {
+			return err
+		}
+		if publicPEM, err = gmx509.WritePublicKeyToPem(&key.PublicKey); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("不支持的算法 %q，可选 ecdsa、sm2", algorithm)
+	}
+
+	if err := os.WriteFile(path, privatePEM, 0600); err != nil {
+		return err
+	}
+	_, err := os.Stdout.Write(publicPEM)
+	return err
+}
+
+// parsePercents 解析逗号分隔的百分点列表
+func parsePercents(s string) ([]float64, error) {
+	var percents []float64
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		percents = append(percents, v)
+	}
+	return percents, nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -19,14 +20,424 @@ type DatabaseConfig struct {
 
 // JWTConfig JWT配置
 type JWTConfig struct {
-	Secret string `yaml:"secret"`
+	Secret       string         `yaml:"secret"`       // HS256 共享密钥，未配置 keys 时作为唯一签发密钥；可由环境变量 JWT_SECRET 覆盖
+	Issuer       string         `yaml:"issuer"`       // 令牌签发方（iss），配置后校验时也要求一致
+	TTL          string         `yaml:"ttl"`          // 访问令牌有效期，如 "15m"
+	RefreshTTL   string         `yaml:"refreshTTL"`   // 刷新令牌有效期，如 "168h"
+	CurrentKeyID string         `yaml:"currentKeyId"` // 签发新令牌使用的密钥ID，为空时使用 keys 中的第一个
+	Keys         []JWTKeyConfig `yaml:"keys"`         // 签名密钥列表，旧密钥保留在列表中即可继续校验，移除即退役
+}
+
+// JWTKeyConfig JWT签名密钥配置
+type JWTKeyConfig struct {
+	ID             string `yaml:"id"`             // 密钥ID，写入令牌头部的 kid
+	Algorithm      string `yaml:"algorithm"`      // HS256 / RS256 / ES256
+	Secret         string `yaml:"secret"`         // HS256 共享密钥
+	PrivateKeyPath string `yaml:"privateKeyPath"` // RS256/ES256 私钥（PEM），只用于校验的旧密钥可不配置
+	PublicKeyPath  string `yaml:"publicKeyPath"`  // RS256/ES256 公钥（PEM），未配置时从私钥导出
+}
+
+// TTLDuration 返回访问令牌有效期，未配置或格式错误时默认15分钟
+func (c JWTConfig) TTLDuration() time.Duration {
+	return parseDurationOr(c.TTL, 15*time.Minute)
+}
+
+// RefreshTTLDuration 返回刷新令牌有效期，未配置或格式错误时默认7天
+func (c JWTConfig) RefreshTTLDuration() time.Duration {
+	return parseDurationOr(c.RefreshTTL, 7*24*time.Hour)
+}
+
+// FabricConfig Fabric网络配置
+type FabricConfig struct {
+	Enabled       bool   `yaml:"enabled"`       // 是否启用链上同步
+	ConfigPath    string `yaml:"configPath"`    // SDK连接配置文件路径
+	ChannelName   string `yaml:"channelName"`   // 通道名称
+	ChaincodeName string `yaml:"chaincodeName"` // 链码名称
+	OrgName       string `yaml:"orgName"`       // 组织名称
+	UserName      string `yaml:"userName"`      // 调用链码的用户
+	RetryInterval string `yaml:"retryInterval"` // 测试数据上链失败后的重试间隔，默认 "1m"
+	MaxAttempts   int    `yaml:"maxAttempts"`   // 测试数据上链的最多尝试次数，用尽后标记为失败，默认 10
+}
+
+// RetryIntervalDuration 返回测试数据上链的重试间隔，未配置或格式错误时默认1分钟
+func (c FabricConfig) RetryIntervalDuration() time.Duration {
+	return parseDurationOr(c.RetryInterval, time.Minute)
+}
+
+// MaxAttemptsOrDefault 返回测试数据上链的最多尝试次数，未配置时默认 10
+func (c FabricConfig) MaxAttemptsOrDefault() int {
+	if c.MaxAttempts <= 0 {
+		return 10
+	}
+	return c.MaxAttempts
+}
+
+// PasswordConfig 密码哈希配置
+type PasswordConfig struct {
+	Algorithm         string `yaml:"algorithm"`         // 新密码使用的算法: argon2id / bcrypt
+	Argon2Memory      uint32 `yaml:"argon2Memory"`      // argon2id 内存开销（KiB）
+	Argon2Iterations  uint32 `yaml:"argon2Iterations"`  // argon2id 迭代次数
+	Argon2Parallelism uint8  `yaml:"argon2Parallelism"` // argon2id 并行度
+	BcryptCost        int    `yaml:"bcryptCost"`        // bcrypt 计算成本
+
+	// 密码策略
+	MinLength     int  `yaml:"minLength"`     // 最小长度
+	RequireUpper  bool `yaml:"requireUpper"`  // 必须包含大写字母
+	RequireLower  bool `yaml:"requireLower"`  // 必须包含小写字母
+	RequireDigit  bool `yaml:"requireDigit"`  // 必须包含数字
+	RequireSymbol bool `yaml:"requireSymbol"` // 必须包含特殊字符
+	HistoryCount  int  `yaml:"historyCount"`  // 不允许与最近 N 次使用过的密码相同，0 表示不限制
+	MaxAgeDays    int  `yaml:"maxAgeDays"`    // 密码最长使用天数，0 表示不过期
+}
+
+// LoginProtectionConfig 登录防暴力破解配置
+type LoginProtectionConfig struct {
+	FreeAttempts  int    `yaml:"freeAttempts"`  // 连续失败多少次后开始退避
+	BaseDelay     string `yaml:"baseDelay"`     // 退避基础时长，每多失败一次翻倍，如 "1s"
+	MaxDelay      string `yaml:"maxDelay"`      // 退避最长时长，如 "5m"
+	LockThreshold int    `yaml:"lockThreshold"` // 连续失败多少次后锁定账号
+	LockDuration  string `yaml:"lockDuration"`  // 账号锁定时长，如 "30m"
+	IPWindow      string `yaml:"ipWindow"`      // 统计同一IP失败次数的时间窗口，如 "15m"
+	IPMaxFailures int    `yaml:"ipMaxFailures"` // 时间窗口内同一IP允许的最大失败次数
+}
+
+// BaseDelayDuration 返回退避基础时长，未配置或格式错误时默认1秒
+func (c LoginProtectionConfig) BaseDelayDuration() time.Duration {
+	return parseDurationOr(c.BaseDelay, time.Second)
+}
+
+// MaxDelayDuration 返回退避最长时长，未配置或格式错误时默认5分钟
+func (c LoginProtectionConfig) MaxDelayDuration() time.Duration {
+	return parseDurationOr(c.MaxDelay, 5*time.Minute)
+}
+
+// LockDurationValue 返回账号锁定时长，未配置或格式错误时默认30分钟
+func (c LoginProtectionConfig) LockDurationValue() time.Duration {
+	return parseDurationOr(c.LockDuration, 30*time.Minute)
+}
+
+// IPWindowDuration 返回IP失败统计窗口，未配置或格式错误时默认15分钟
+func (c LoginProtectionConfig) IPWindowDuration() time.Duration {
+	return parseDurationOr(c.IPWindow, 15*time.Minute)
+}
+
+// parseDurationOr 解析时长字符串，未配置或格式错误时返回默认值
+func parseDurationOr(value string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// TwoFactorConfig 两步验证（TOTP）配置
+type TwoFactorConfig struct {
+	Issuer        string   `yaml:"issuer"`        // 验证器应用中显示的签发方名称
+	RequiredRoles []string `yaml:"requiredRoles"` // 必须启用两步验证的角色
+	ChallengeTTL  string   `yaml:"challengeTTL"`  // 登录第二步的挑战令牌有效期，如 "5m"
+}
+
+// ChallengeTTLDuration 返回挑战令牌有效期，未配置或格式错误时默认5分钟
+func (c TwoFactorConfig) ChallengeTTLDuration() time.Duration {
+	return parseDurationOr(c.ChallengeTTL, 5*time.Minute)
+}
+
+// SSOConfig 外部身份认证配置。外部账号首次登录时自动创建本地用户，角色按所属组映射
+type SSOConfig struct {
+	LDAP LDAPConfig `yaml:"ldap"`
+	OIDC OIDCConfig `yaml:"oidc"`
+}
+
+// LDAPConfig LDAP 认证配置：先用服务账号查找用户条目，再以用户 DN 和密码绑定校验
+type LDAPConfig struct {
+	Enabled            bool              `yaml:"enabled"`
+	URL                string            `yaml:"url"`                // 如 "ldaps://ldap.example.com:636"
+	StartTLS           bool              `yaml:"startTLS"`           // ldap:// 连接是否升级为 TLS
+	InsecureSkipVerify bool              `yaml:"insecureSkipVerify"` // 跳过服务器证书校验，仅用于测试环境
+	BindDN             string            `yaml:"bindDN"`             // 查找用户使用的服务账号，为空时匿名查找
+	BindPassword       string            `yaml:"bindPassword"`       // 服务账号密码，可由环境变量 LDAP_BIND_PASSWORD 覆盖
+	BaseDN             string            `yaml:"baseDN"`             // 用户查找的根 DN
+	UserFilter         string            `yaml:"userFilter"`         // 用户查找过滤器，%s 替换为用户名，如 "(uid=%s)"
+	GroupAttribute     string            `yaml:"groupAttribute"`     // 用户条目中记录所属组的属性，如 "memberOf"
+	GroupBaseDN        string            `yaml:"groupBaseDN"`        // 不支持 memberOf 时按组查找的根 DN
+	GroupFilter        string            `yaml:"groupFilter"`        // 组查找过滤器，%s 替换为用户 DN，如 "(member=%s)"
+	GroupRoles         map[string]string `yaml:"groupRoles"`         // 组（DN 或 CN）-> 角色
+	DefaultRole        string            `yaml:"defaultRole"`        // 未匹配任何组时的角色，为空时拒绝登录
+	Timeout            string            `yaml:"timeout"`            // 连接和查询超时，如 "5s"
+}
+
+// TimeoutDuration 返回 LDAP 超时，未配置或格式错误时默认5秒
+func (c LDAPConfig) TimeoutDuration() time.Duration {
+	return parseDurationOr(c.Timeout, 5*time.Second)
+}
+
+// OIDCConfig OpenID Connect 认证配置（授权码模式 + PKCE）
+type OIDCConfig struct {
+	Enabled           bool              `yaml:"enabled"`
+	DisplayName       string            `yaml:"displayName"` // 登录页按钮显示的名称
+	IssuerURL         string            `yaml:"issuerURL"`   // 身份提供方地址，从 /.well-known/openid-configuration 获取端点
+	ClientID          string            `yaml:"clientID"`
+	ClientSecret      string            `yaml:"clientSecret"`      // 可由环境变量 OIDC_CLIENT_SECRET 覆盖，公共客户端可为空
+	RedirectURL       string            `yaml:"redirectURL"`       // 回调地址，如 "http://host:8080/api/v1/auth/sso/oidc/callback"
+	PostLoginRedirect string            `yaml:"postLoginRedirect"` // 登录完成后跳转的前端页面，令牌放在 URL 片段中
+	Scopes            []string          `yaml:"scopes"`            // 额外申请的 scope，openid 总是包含
+	UsernameClaim     string            `yaml:"usernameClaim"`     // 用户名取自 ID Token 的哪个声明，默认 preferred_username
+	GroupsClaim       string            `yaml:"groupsClaim"`       // 组取自 ID Token 的哪个声明，默认 groups
+	GroupRoles        map[string]string `yaml:"groupRoles"`        // 组 -> 角色
+	DefaultRole       string            `yaml:"defaultRole"`       // 未匹配任何组时的角色，为空时拒绝登录
+	StateTTL          string            `yaml:"stateTTL"`          // 跳转到身份提供方后完成登录的时限，如 "10m"
+}
+
+// StateTTLDuration 返回授权请求有效期，未配置或格式错误时默认10分钟
+func (c OIDCConfig) StateTTLDuration() time.Duration {
+	return parseDurationOr(c.StateTTL, 10*time.Minute)
+}
+
+// PermissionConfig 角色权限配置，权限支持通配符，如 "*" 或 "cert:*"
+type PermissionConfig struct {
+	Roles map[string][]string `yaml:"roles"` // 角色 -> 权限列表
+}
+
+// DefaultRolePermissions 返回默认的角色权限映射
+func DefaultRolePermissions() map[string][]string {
+	return map[string][]string{
+		"admin": {"*"},
+		"operator": {
+			"cert:read", "cert:create", "cert:update", "cert:verify",
+			"customer:read", "customer:write", "device:read", "device:write", "testdata:read", "testdata:write",
+		},
+		"viewer": {"cert:read", "cert:verify", "customer:read", "device:read", "testdata:read"},
+	}
+}
+
+// ErrorLimitConfig 互感器误差限值配置，用于根据测试数据自动判定检定结果
+type ErrorLimitConfig struct {
+	EnforceOnIssue bool                 `yaml:"enforceOnIssue"` // 签发时要求人工结论与自动判定一致
+	PointTolerance float64              `yaml:"pointTolerance"` // 额定百分点与限值表百分点的最大偏差（百分点），默认 2
+	Standards      []ErrorLimitStandard `yaml:"standards"`      // 为空时使用 JJG 313 / JJG 314 默认限值
+}
+
+// ErrorLimitStandard 一个检定规程的误差限值表
+type ErrorLimitStandard struct {
+	Name     string                       `yaml:"name"`     // 规程名称，如 "JJG 313-2010"
+	Keywords []string                     `yaml:"keywords"` // 器具名称包含任一关键字时适用该规程
+	Classes  map[string][]ErrorLimitPoint `yaml:"classes"`  // 准确度等级（如 "0.2"、"0.2S"）-> 各百分点的误差限值
+}
+
+// ErrorLimitPoint 某一百分点的误差限值
+type ErrorLimitPoint struct {
+	Percent    float64 `yaml:"percent"`    // 额定电流/电压的百分比
+	RatioError float64 `yaml:"ratioError"` // 比值差限值（±%）
+	AngleError float64 `yaml:"angleError"` // 相位差限值（±′）
+}
+
+// PointToleranceOrDefault 返回百分点匹配容差，未配置时默认2个百分点
+func (c ErrorLimitConfig) PointToleranceOrDefault() float64 {
+	if c.PointTolerance <= 0 {
+		return 2
+	}
+	return c.PointTolerance
+}
+
+// DefaultErrorLimitStandards 返回默认的误差限值表：JJG 313-2010 测量用电流互感器、JJG 314-2010 测量用电压互感器
+func DefaultErrorLimitStandards() []ErrorLimitStandard {
+	pt := func(ratio, angle float64) []ErrorLimitPoint {
+		return []ErrorLimitPoint{{80, ratio, angle}, {100, ratio, angle}, {120, ratio, angle}}
+	}
+	return []ErrorLimitStandard{
+		{
+			Name:     "JJG 313-2010",
+			Keywords: []string{"电流互感器"},
+			Classes: map[string][]ErrorLimitPoint{
+				"0.05": {{5, 0.10, 4}, {20, 0.05, 2}, {100, 0.05, 2}, {120, 0.05, 2}},
+				"0.1":  {{5, 0.4, 15}, {20, 0.2, 8}, {100, 0.1, 5}, {120, 0.1, 5}},
+				"0.2":  {{5, 0.75, 30}, {20, 0.35, 15}, {100, 0.2, 10}, {120, 0.2, 10}},
+				"0.5":  {{5, 1.5, 90}, {20, 0.75, 45}, {100, 0.5, 30}, {120, 0.5, 30}},
+				"0.2S": {{1, 0.75, 30}, {5, 0.35, 15}, {20, 0.2, 10}, {100, 0.2, 10}, {120, 0.2, 10}},
+				"0.5S": {{1, 1.5, 90}, {5, 0.75, 45}, {20, 0.5, 30}, {100, 0.5, 30}, {120, 0.5, 30}},
+			},
+		},
+		{
+			Name:     "JJG 314-2010",
+			Keywords: []string{"电压互感器"},
+			Classes: map[string][]ErrorLimitPoint{
+				"0.05": pt(0.05, 2),
+				"0.1":  pt(0.1, 5),
+				"0.2":  pt(0.2, 10),
+				"0.5":  pt(0.5, 20),
+				"1":    pt(1.0, 40),
+			},
+		},
+	}
+}
+
+// UncertaintyConfig 测量不确定度评定配置
+type UncertaintyConfig struct {
+	Method          string                              `yaml:"method"`          // 评定依据，写入评定结果
+	CoverageFactor  float64                             `yaml:"coverageFactor"`  // 包含因子 k，默认 2
+	StandardClasses map[string]UncertaintyStandardClass `yaml:"standardClasses"` // 标准器准确度等级 -> 误差半宽（B 类，均匀分布）
+	Components      []UncertaintyComponentConfig        `yaml:"components"`      // 其他 B 类分量
+}
+
+// UncertaintyStandardClass 标准器某一准确度等级的比值差和相位差半宽
+type UncertaintyStandardClass struct {
+	RatioError float64 `yaml:"ratioError"` // 比值差半宽（%）
+	AngleError float64 `yaml:"angleError"` // 相位差半宽（′）
+}
+
+// UncertaintyComponentConfig 一个 B 类不确定度分量
+type UncertaintyComponentConfig struct {
+	Name           string  `yaml:"name"`
+	RatioHalfWidth float64 `yaml:"ratioHalfWidth"` // 对比值差的影响半宽（%）
+	AngleHalfWidth float64 `yaml:"angleHalfWidth"` // 对相位差的影响半宽（′）
+	Distribution   string  `yaml:"distribution"`   // uniform / triangular / arcsine / normal，默认 uniform
+	Divisor        float64 `yaml:"divisor"`        // 自定义除数，配置后忽略 distribution 对应的默认除数
+}
+
+// CoverageFactorOrDefault 返回包含因子，未配置时默认 k=2
+func (c UncertaintyConfig) CoverageFactorOrDefault() float64 {
+	if c.CoverageFactor <= 0 {
+		return 2
+	}
+	return c.CoverageFactor
+}
+
+// DefaultUncertaintyStandardClasses 返回常用互感器校验标准器的误差半宽
+func DefaultUncertaintyStandardClasses() map[string]UncertaintyStandardClass {
+	return map[string]UncertaintyStandardClass{
+		"0.01": {RatioError: 0.01, AngleError: 0.3},
+		"0.02": {RatioError: 0.02, AngleError: 0.6},
+		"0.05": {RatioError: 0.05, AngleError: 2},
+		"0.1":  {RatioError: 0.1, AngleError: 5},
+		"0.2":  {RatioError: 0.2, AngleError: 10},
+	}
+}
+
+// MQTTConfig MQTT 测试数据接入配置，未启用时不连接 Broker
+type MQTTConfig struct {
+	Enabled         bool                 `yaml:"enabled"`
+	Broker          string               `yaml:"broker"`   // Broker 地址，如 tcp://127.0.0.1:1883
+	ClientID        string               `yaml:"clientId"` // 多实例部署时各实例须不同
+	Username        string               `yaml:"username"` // 口令可通过环境变量 MQTT_PASSWORD 提供
+	Password        string               `yaml:"password"`
+	Topics          []string             `yaml:"topics"`          // 订阅主题，{deviceAddr} 处为设备地址，如 lab/{deviceAddr}/readings
+	QoS             byte                 `yaml:"qos"`             // 订阅及确认消息的 QoS，默认 1
+	AckTopic        string               `yaml:"ackTopic"`        // 确认消息主题，如 lab/{deviceAddr}/ack，为空时不发送确认
+	DeadLetterTopic string               `yaml:"deadLetterTopic"` // 无法入库的消息转发到该主题，为空时只记录日志
+	ConnectTimeout  string               `yaml:"connectTimeout"`
+	EmbeddedBroker  EmbeddedBrokerConfig `yaml:"embeddedBroker"`
+}
+
+// EmbeddedBrokerConfig 内置 MQTT Broker 配置，用于本地联调和测试，生产环境应使用独立 Broker
+type EmbeddedBrokerConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Address  string `yaml:"address"`  // 监听地址，如 127.0.0.1:1883
+	Username string `yaml:"username"` // 为空时允许匿名连接
+	Password string `yaml:"password"`
+}
+
+// QoSOrDefault 返回消息 QoS，超出范围时默认 1
+func (c MQTTConfig) QoSOrDefault() byte {
+	if c.QoS > 2 {
+		return 1
+	}
+	return c.QoS
+}
+
+// ConnectTimeoutDuration 返回连接 Broker 的超时时间，未配置或格式错误时默认10秒
+func (c MQTTConfig) ConnectTimeoutDuration() time.Duration {
+	return parseDurationOr(c.ConnectTimeout, 10*time.Second)
+}
+
+// IdempotencyConfig 幂等键配置
+type IdempotencyConfig struct {
+	Window string `yaml:"window"` // 首次响应的保留时长，如 "24h"
+	Lease  string `yaml:"lease"`  // 处理中记录的占用时长，超过后视为进程中断，允许重新占用，如 "5m"
+}
+
+// WindowDuration 返回幂等键保留时长，未配置或格式错误时默认24小时
+func (c IdempotencyConfig) WindowDuration() time.Duration {
+	return parseDurationOr(c.Window, 24*time.Hour)
+}
+
+// LeaseDuration 返回处理中记录的占用时长，未配置或格式错误时默认5分钟
+func (c IdempotencyConfig) LeaseDuration() time.Duration {
+	return parseDurationOr(c.Lease, 5*time.Minute)
+}
+
+// AnalyticsConfig 测试数据统计分析和异常检测配置
+type AnalyticsConfig struct {
+	Window         string  `yaml:"window"`         // 默认分析时间范围，如 "2160h"（90天）
+	MaxRange       string  `yaml:"maxRange"`       // 单次分析允许的最大时间范围，默认 "8760h"（365天）
+	MaxRecords     int     `yaml:"maxRecords"`     // 单次分析最多加载的测试数据条数，默认 100000
+	ControlSigma   float64 `yaml:"controlSigma"`   // 控制限为均值 ± controlSigma 倍标准差，默认 3
+	MinSamples     int     `yaml:"minSamples"`     // 计算控制限所需的最少数据点，默认 8
+	DriftRunLength int     `yaml:"driftRunLength"` // 连续位于均值同一侧的点数达到该值时判定为漂移，默认 8
+	ClockTolerance string  `yaml:"clockTolerance"` // 测试时间晚于上传时间的容许偏差（设备时钟误差），默认 "5m"
+}
+
+// WindowDuration 返回默认分析时间范围，未配置或格式错误时默认90天
+func (c AnalyticsConfig) WindowDuration() time.Duration {
+	return parseDurationOr(c.Window, 90*24*time.Hour)
+}
+
+// MaxRangeDuration 返回单次分析允许的最大时间范围，未配置或格式错误时默认365天
+func (c AnalyticsConfig) MaxRangeDuration() time.Duration {
+	return parseDurationOr(c.MaxRange, 365*24*time.Hour)
+}
+
+// MaxRecordsOrDefault 返回单次分析最多加载的测试数据条数，未配置时默认 100000
+func (c AnalyticsConfig) MaxRecordsOrDefault() int {
+	if c.MaxRecords <= 0 {
+		return 100000
+	}
+	return c.MaxRecords
+}
+
+// ControlSigmaOrDefault 返回控制限的标准差倍数，未配置时默认 3
+func (c AnalyticsConfig) ControlSigmaOrDefault() float64 {
+	if c.ControlSigma <= 0 {
+		return 3
+	}
+	return c.ControlSigma
+}
+
+// MinSamplesOrDefault 返回计算控制限所需的最少数据点，未配置时默认 8
+func (c AnalyticsConfig) MinSamplesOrDefault() int {
+	if c.MinSamples < 2 {
+		return 8
+	}
+	return c.MinSamples
+}
+
+// DriftRunLengthOrDefault 返回判定漂移的连续点数，未配置时默认 8
+func (c AnalyticsConfig) DriftRunLengthOrDefault() int {
+	if c.DriftRunLength < 2 {
+		return 8
+	}
+	return c.DriftRunLength
+}
+
+// ClockToleranceDuration 返回设备时钟容许偏差，未配置或格式错误时默认5分钟
+func (c AnalyticsConfig) ClockToleranceDuration() time.Duration {
+	return parseDurationOr(c.ClockTolerance, 5*time.Minute)
 }
 
 // Config 根配置结构
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	JWT      JWTConfig      `yaml:"jwt"`
+	Server      ServerConfig          `yaml:"server"`
+	Database    DatabaseConfig        `yaml:"database"`
+	JWT         JWTConfig             `yaml:"jwt"`
+	Fabric      FabricConfig          `yaml:"fabric"`
+	Idempotency IdempotencyConfig     `yaml:"idempotency"`
+	Password    PasswordConfig        `yaml:"password"`
+	Login       LoginProtectionConfig `yaml:"login"`
+	Permissions PermissionConfig      `yaml:"permissions"`
+	TwoFactor   TwoFactorConfig       `yaml:"twoFactor"`
+	SSO         SSOConfig             `yaml:"sso"`
+	ErrorLimits ErrorLimitConfig      `yaml:"errorLimits"`
+	Uncertainty UncertaintyConfig     `yaml:"uncertainty"`
+	MQTT        MQTTConfig            `yaml:"mqtt"`
+	Analytics   AnalyticsConfig       `yaml:"analytics"`
 }
 
 // LoadConfig 从指定路径加载配置
@@ -39,7 +450,9 @@ func LoadConfig() (*Config, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		log.Printf("无法读取配置文件 %s, 使用默认值. 错误: %v", configPath, err)
-		return defaultConfigs(), nil
+		cfg := defaultConfigs()
+		applyEnvOverrides(cfg)
+		return cfg, nil
 	}
 
 	var cfg Config
@@ -47,9 +460,26 @@ func LoadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	applyEnvOverrides(&cfg)
 	return &cfg, nil
 }
 
+// applyEnvOverrides 使用环境变量覆盖敏感配置，避免密钥写入配置文件
+func applyEnvOverrides(cfg *Config) {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		cfg.JWT.Secret = secret
+	}
+	if password := os.Getenv("LDAP_BIND_PASSWORD"); password != "" {
+		cfg.SSO.LDAP.BindPassword = password
+	}
+	if secret := os.Getenv("OIDC_CLIENT_SECRET"); secret != "" {
+		cfg.SSO.OIDC.ClientSecret = secret
+	}
+	if password := os.Getenv("MQTT_PASSWORD"); password != "" {
+		cfg.MQTT.Password = password
+	}
+}
+
 // defaultConfigs 返回默认配置
 func defaultConfigs() *Config {
 	return &Config{
@@ -60,7 +490,101 @@ func defaultConfigs() *Config {
 			DSN: "user:password@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=True&loc=Local",
 		},
 		JWT: JWTConfig{
-			Secret: "your_super_secret_key",
+			Secret:     "your_super_secret_key",
+			Issuer:     "cert-system",
+			TTL:        "15m",
+			RefreshTTL: "168h",
+		},
+		Fabric: FabricConfig{
+			Enabled:       false,
+			ConfigPath:    "../configs/fabric-config.yaml",
+			ChannelName:   "certchannel",
+			ChaincodeName: "certchaincode",
+			OrgName:       "Org1",
+			UserName:      "User1",
+			RetryInterval: "1m",
+			MaxAttempts:   10,
+		},
+		Idempotency: IdempotencyConfig{
+			Window: "24h",
+			Lease:  "5m",
+		},
+		Password: PasswordConfig{
+			Algorithm:         "argon2id",
+			Argon2Memory:      64 * 1024,
+			Argon2Iterations:  3,
+			Argon2Parallelism: 2,
+			BcryptCost:        12,
+			MinLength:         8,
+			RequireUpper:      false,
+			RequireLower:      true,
+			RequireDigit:      true,
+			RequireSymbol:     false,
+			HistoryCount:      5,
+			MaxAgeDays:        90,
+		},
+		Login: LoginProtectionConfig{
+			FreeAttempts:  3,
+			BaseDelay:     "1s",
+			MaxDelay:      "5m",
+			LockThreshold: 10,
+			LockDuration:  "30m",
+			IPWindow:      "15m",
+			IPMaxFailures: 50,
+		},
+		Permissions: PermissionConfig{
+			Roles: DefaultRolePermissions(),
+		},
+		TwoFactor: TwoFactorConfig{
+			Issuer:        "计量证书系统",
+			RequiredRoles: []string{},
+			ChallengeTTL:  "5m",
+		},
+		SSO: SSOConfig{
+			LDAP: LDAPConfig{
+				UserFilter:     "(uid=%s)",
+				GroupAttribute: "memberOf",
+				Timeout:        "5s",
+			},
+			OIDC: OIDCConfig{
+				DisplayName:   "统一身份认证",
+				UsernameClaim: "preferred_username",
+				GroupsClaim:   "groups",
+				StateTTL:      "10m",
+			},
+		},
+		ErrorLimits: ErrorLimitConfig{
+			EnforceOnIssue: true,
+			PointTolerance: 2,
+			Standards:      DefaultErrorLimitStandards(),
+		},
+		Uncertainty: UncertaintyConfig{
+			Method:          "JJF 1059.1-2012",
+			CoverageFactor:  2,
+			StandardClasses: DefaultUncertaintyStandardClasses(),
+		},
+		MQTT: MQTTConfig{
+			Enabled:         false,
+			Broker:          "tcp://127.0.0.1:1883",
+			ClientID:        "cert-system-ingest",
+			Topics:          []string{"lab/{deviceAddr}/readings"},
+			QoS:             1,
+			AckTopic:        "lab/{deviceAddr}/ack",
+			DeadLetterTopic: "lab/dead-letter",
+			ConnectTimeout:  "10s",
+			EmbeddedBroker: EmbeddedBrokerConfig{
+				Enabled: false,
+				Address: "127.0.0.1:1883",
+			},
+		},
+		Analytics: AnalyticsConfig{
+			Window:         "2160h",
+			MaxRange:       "8760h",
+			MaxRecords:     100000,
+			ControlSigma:   3,
+			MinSamples:     8,
+			DriftRunLength: 8,
+			ClockTolerance: "5m",
 		},
 	}
-}
\ No newline at end of file
+}
@@ -1,51 +1,316 @@
 package api
 
 import (
+	"cert-system/internal/models"
 	"cert-system/internal/service"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // AdminHandler 管理员处理器
 type AdminHandler struct {
-	authService *service.AuthService
+	userService   *service.UserService
+	apiKeyService *service.APIKeyService
 }
 
 // NewAdminHandler 创建 AdminHandler 实例
-func NewAdminHandler(authService *service.AuthService) *AdminHandler {
+func NewAdminHandler(userService *service.UserService, apiKeyService *service.APIKeyService) *AdminHandler {
 	return &AdminHandler{
-		authService: authService,
+		userService:   userService,
+		apiKeyService: apiKeyService,
 	}
 }
 
-// GetAllUsers 获取所有用户
+// parseUserID 解析路径中的用户ID
+func parseUserID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "用户ID无效"})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondUserError 将用户管理错误转换为对应的HTTP响应
+func respondUserError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "用户不存在"})
+	case errors.Is(err, service.ErrUsernameTaken):
+		c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+	case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrInvalidUserStatus), errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrExternalAccount):
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+	case errors.Is(err, service.ErrSelfModification):
+		c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: action + "失败: " + err.Error()})
+	}
+}
+
+// GetAllUsers 获取所有用户（支持分页和按角色过滤）
 func (h *AdminHandler) GetAllUsers(c *gin.Context) {
-	// 假设查询用户列表
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Get All Users",
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	role := c.Query("role")
+	if role != "" && !service.ValidRoles[role] {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: service.ErrInvalidRole.Error()})
+		return
+	}
+
+	users, total, err := h.userService.ListUsers(page, pageSize, role)
+	if err != nil {
+		respondUserError(c, "获取用户列表", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PagedResponse{
+		Code:       200,
+		Message:    "获取用户列表成功",
+		Data:       users,
+		Total:      int(total),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (int(total) + pageSize - 1) / pageSize,
 	})
 }
 
 // CreateUser 创建用户
 func (h *AdminHandler) CreateUser(c *gin.Context) {
-	// 实现创建用户的逻辑
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Create User",
-	})
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+
+	user, err := h.userService.CreateUser(req.Username, req.Password, req.Role)
+	if err != nil {
+		respondUserError(c, "创建用户", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{Code: 201, Message: "用户创建成功", Data: user})
 }
 
-// UpdateUser 更新用户
+// UpdateUser 更新用户角色或状态
 func (h *AdminHandler) UpdateUser(c *gin.Context) {
-	// 实现更新用户的逻辑
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Update User",
-	})
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+	if req.Role == "" && req.Status == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "至少需要提供 role 或 status"})
+		return
+	}
+
+	user, err := h.userService.UpdateUser(c.GetInt64("userID"), userID, req.Role, req.Status)
+	if err != nil {
+		respondUserError(c, "更新用户", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "用户更新成功", Data: user})
 }
 
-// DeleteUser 删除用户
+// DeleteUser 删除用户（已创建证书的用户改为禁用）
 func (h *AdminHandler) DeleteUser(c *gin.Context) {
-	// 实现删除用户的逻辑
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Delete User",
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	disabled, err := h.userService.DeleteUser(c.GetInt64("userID"), userID)
+	if err != nil {
+		respondUserError(c, "删除用户", err)
+		return
+	}
+
+	if disabled {
+		c.JSON(http.StatusOK, models.APIResponse{
+			Code:    200,
+			Message: "用户已创建证书或存在操作记录，已改为禁用",
+			Data:    gin.H{"id": userID, "status": "disabled"},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "用户删除成功", Data: gin.H{"id": userID}})
+}
+
+// ResetPassword 管理员重置用户密码
+func (h *AdminHandler) ResetPassword(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	// 请求体可选，为空时生成临时密码
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+			return
+		}
+	}
+
+	password, err := h.userService.ResetPassword(userID, req.NewPassword)
+	if err != nil {
+		respondUserError(c, "重置密码", err)
+		return
+	}
+
+	data := gin.H{"id": userID}
+	if req.NewPassword == "" {
+		data["temporaryPassword"] = password // 仅在本次响应中返回
+	}
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "密码重置成功", Data: data})
+}
+
+// UnlockUser 解除账号的登录锁定
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.UnlockUser(userID)
+	if err != nil {
+		respondUserError(c, "解锁用户", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "账号已解锁", Data: user})
+}
+
+// ResetTwoFactor 重置用户的两步验证，用户下次登录时需重新启用
+func (h *AdminHandler) ResetTwoFactor(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.ResetTwoFactor(userID)
+	if err != nil {
+		respondUserError(c, "重置两步验证", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "两步验证已重置", Data: user})
+}
+
+// GetLoginAttempts 获取登录审计记录（支持分页和按用户名、IP过滤）
+func (h *AdminHandler) GetLoginAttempts(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if err != nil || pageSize < 1 {
+		pageSize = 20
+	}
+
+	attempts, total, err := h.userService.ListLoginAttempts(page, pageSize, c.Query("username"), c.Query("ip"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "获取登录记录失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PagedResponse{
+		Code:       200,
+		Message:    "获取登录记录成功",
+		Data:       attempts,
+		Total:      int(total),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (int(total) + pageSize - 1) / pageSize,
 	})
 }
+
+// GetAPIKeys 获取 API Key 列表
+func (h *AdminHandler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyService.ListAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "获取 API Key 列表失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取 API Key 列表成功", Data: keys})
+}
+
+// CreateAPIKey 创建 API Key，明文 Key 只在本次响应中返回
+func (h *AdminHandler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "expiresAt 格式错误: " + err.Error()})
+			return
+		}
+		if t.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "expiresAt 不能早于当前时间"})
+			return
+		}
+		expiresAt = &t
+	}
+
+	key, plaintext, err := h.apiKeyService.CreateAPIKey(req.Name, req.Permissions, req.DeviceAddr, expiresAt, c.GetInt64("userID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrDeviceNotFound):
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error() + ": " + req.DeviceAddr})
+		case errors.Is(err, service.ErrInvalidPermission):
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "创建 API Key 失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{
+		Code:    201,
+		Message: "API Key 创建成功，请妥善保存，Key 不会再次显示",
+		Data:    models.CreateAPIKeyResponse{APIKey: key, Key: plaintext},
+	})
+}
+
+// RevokeAPIKey 吊销 API Key
+func (h *AdminHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "API Key ID 无效"})
+		return
+	}
+
+	if err := h.apiKeyService.RevokeAPIKey(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "API Key 不存在"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "吊销 API Key 失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "API Key 已吊销", Data: gin.H{"id": id}})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnalyticsHandler 测试数据统计分析处理器
+type AnalyticsHandler struct {
+	analyticsService *service.AnalyticsService
+}
+
+// NewAnalyticsHandler 创建新的 AnalyticsHandler
+func NewAnalyticsHandler(analyticsService *service.AnalyticsService) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		analyticsService: analyticsService,
+	}
+}
+
+// parseAnalyticsTime 解析时间范围参数，支持 RFC3339 和 YYYY-MM-DD，
+// 日期格式的结束时间包含当天全天
+func parseAnalyticsTime(c *gin.Context, name string, endOfDay bool) (time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: name + " 格式错误，应为 YYYY-MM-DD 或 RFC3339"})
+		return time.Time{}, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t, true
+}
+
+// 异常列表每页最多条数
+const maxAnomalyPageSize = 500
+
+// GetAnomalies 按设备和工位统计测试数据误差并分页返回可疑数据，
+// 可按设备地址、工位号、异常类型和时间范围过滤，未指定时间范围时分析配置的默认范围
+func (h *AnalyticsHandler) GetAnomalies(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if err != nil || pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > maxAnomalyPageSize {
+		pageSize = maxAnomalyPageSize
+	}
+
+	from, ok := parseAnalyticsTime(c, "from", false)
+	if !ok {
+		return
+	}
+	to, ok := parseAnalyticsTime(c, "to", true)
+	if !ok {
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "from 不能晚于 to"})
+		return
+	}
+
+	report, err := h.analyticsService.DetectAnomalies(service.AnomalyFilter{
+		DeviceAddr:        c.Query("deviceAddr"),
+		WorkstationNumber: c.Query("workstation"),
+		Type:              c.Query("type"),
+		From:              from,
+		To:                to,
+		Page:              page,
+		PageSize:          pageSize,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAnomalyType) || errors.Is(err, service.ErrAnalyticsRangeTooLarge) ||
+			errors.Is(err, service.ErrAnalyticsTooManyRecords) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "测试数据分析失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "测试数据分析完成", Data: report})
+}
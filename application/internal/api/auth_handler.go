@@ -3,19 +3,25 @@ package api
 import (
 	"cert-system/internal/service"
 	"cert-system/internal/models"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 // AuthHandler 认证处理器
 type AuthHandler struct {
 	authService *service.AuthService
+	permissions *service.RolePermissions
 }
 
 // NewAuthHandler 创建新的 AuthHandler
-func NewAuthHandler(authService *service.AuthService) *AuthHandler {
+func NewAuthHandler(authService *service.AuthService, permissions *service.RolePermissions) *AuthHandler {
 	return &AuthHandler{
 		authService: authService,
+		permissions: permissions,
 	}
 }
 
@@ -31,9 +37,32 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// 调用 AuthService 的登录方法
-	resp, err := h.authService.Login(req.Username, req.Password)
+	resp, err := h.authService.Login(req.Username, req.Password, c.ClientIP(), c.Request.UserAgent())
 	if err != nil {
 		// 根据错误类型返回不同的HTTP状态码
+		var throttled *service.LoginThrottledError
+		if errors.As(err, &throttled) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, models.APIResponse{
+				Code:    429,
+				Message: err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, service.ErrNoMappedRole) || errors.Is(err, service.ErrAccountConflict) {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Code:    403,
+				Message: err.Error(),
+			})
+			return
+		}
+		if !errors.Is(err, service.ErrInvalidCredentials) && !errors.Is(err, service.ErrAccountDisabled) {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Code:    500,
+				Message: "登录失败，请稍后再试",
+			})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, models.APIResponse{
 			Code:    401,
 			Message: err.Error(),
@@ -45,16 +74,145 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
-// Logout 处理登出请求
+// GetProviders 返回登录页可用的认证方式
+func (h *AuthHandler) GetProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Code:    200,
+		Message: "获取成功",
+		Data:    h.authService.AuthProviders(),
+	})
+}
+
+// ssoStateCookie 保存 state 绑定值的 Cookie，回调时校验 state 由同一浏览器发起
+const ssoStateCookie = "sso_state"
+
+// ssoCookiePath 将 state Cookie 限定在该提供方的 SSO 接口下
+func ssoCookiePath(provider string) string {
+	return "/api/v1/auth/sso/" + provider
+}
+
+// SSOLogin 跳转到外部身份提供方登录
+func (h *AuthHandler) SSOLogin(c *gin.Context) {
+	provider := c.Param("provider")
+	authURL, stateBinding, err := h.authService.StartSSO(provider)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, models.APIResponse{Code: 502, Message: "连接身份提供方失败: " + err.Error()})
+		return
+	}
+
+	// 身份提供方回调是顶层跳转，SameSite=Lax 的 Cookie 会随回调请求发送
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, stateBinding, 0, ssoCookiePath(provider), "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// SSOCallback 处理身份提供方的回调。配置了前端页面时跳转回前端，登录结果放在 URL 片段中（不会发送到服务器日志）
+func (h *AuthHandler) SSOCallback(c *gin.Context) {
+	provider := c.Param("provider")
+	redirect := h.authService.SSOPostLoginRedirect(provider)
+
+	// state Cookie 只用一次
+	stateBinding, _ := c.Cookie(ssoStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, "", -1, ssoCookiePath(provider), "", c.Request.TLS != nil, true)
+
+	var resp *models.LoginResponse
+	var err error
+	if idpError := c.Query("error"); idpError != "" {
+		err = errors.New("身份提供方拒绝登录: " + idpError)
+	} else {
+		resp, err = h.authService.FinishSSO(provider, c.Query("state"), stateBinding, c.Query("code"), c.ClientIP(), c.Request.UserAgent())
+	}
+
+	if redirect == "" {
+		if err != nil {
+			c.JSON(ssoErrorStatus(err), models.APIResponse{Code: ssoErrorStatus(err), Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	fragment := url.Values{}
+	if err != nil {
+		fragment.Set("ssoError", err.Error())
+	} else if resp.Data.TwoFactorRequired {
+		fragment.Set("challengeToken", resp.Data.ChallengeToken)
+	} else {
+		fragment.Set("token", resp.Data.Token)
+		fragment.Set("refreshToken", resp.Data.RefreshToken)
+		if resp.Data.TwoFactorSetupRequired {
+			fragment.Set("twoFactorSetupRequired", "true")
+		}
+	}
+	c.Redirect(http.StatusFound, redirect+"#"+fragment.Encode())
+}
+
+// ssoErrorStatus 返回外部身份认证失败对应的HTTP状态码
+func ssoErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUnknownProvider):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrNoMappedRole), errors.Is(err, service.ErrAccountConflict), errors.Is(err, service.ErrAccountDisabled):
+		return http.StatusForbidden
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// Refresh 使用刷新令牌换取新的访问令牌
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	resp, err := h.authService.Refresh(req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRefreshToken), errors.Is(err, service.ErrRefreshTokenReused), errors.Is(err, service.ErrAccountDisabled),
+			errors.Is(err, service.ErrSessionRevoked):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "刷新令牌失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Logout 处理登出请求，吊销当前会话使其访问令牌和刷新令牌立即失效
 func (h *AuthHandler) Logout(c *gin.Context) {
-	// 登出逻辑，例如将令牌加入黑名单或仅仅返回成功
+	if err := h.authService.Logout(c.GetString("sessionID")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Code:    500,
+			Message: "登出失败: " + err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Code:    200,
 		Message: "登出成功",
 	})
 }
 
-// GetProfile 获取用户信息
+// JWKS 返回 JSON Web Key Set（仅包含非对称密钥的公钥）
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.authService.JWKS())
+}
+
+// GetProfile 获取用户信息及其角色拥有的权限
 func (h *AuthHandler) GetProfile(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -77,6 +235,146 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{
 		Code:    200,
 		Message: "获取成功",
-		Data:    user,
+		Data: models.UserProfile{
+			User:        user,
+			Permissions: h.permissions.For(user.Role),
+		},
 	})
-}
\ No newline at end of file
+}
+
+// ChangePassword 修改当前用户密码
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	resp, err := h.authService.ChangePassword(c.GetInt64("userID"), req.CurrentPassword, req.NewPassword, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		var throttled *service.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, models.APIResponse{Code: 429, Message: err.Error()})
+		case errors.Is(err, service.ErrWrongPassword):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: err.Error()})
+		case errors.Is(err, service.ErrWeakPassword), errors.Is(err, service.ErrPasswordReused), errors.Is(err, service.ErrExternalAccount):
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "修改密码失败: " + err.Error()})
+		}
+		return
+	}
+
+	resp.Message = "密码修改成功"
+	c.JSON(http.StatusOK, resp)
+}
+// LoginTwoFactor 登录第二步：提交挑战令牌和两步验证码（或恢复码）
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	resp, err := h.authService.LoginTwoFactor(req.ChallengeToken, req.Code, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		var throttled *service.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, models.APIResponse{Code: 429, Message: err.Error()})
+		case errors.Is(err, service.ErrInvalidChallenge), errors.Is(err, service.ErrInvalidTwoFactorCode), errors.Is(err, service.ErrAccountDisabled):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "登录失败，请稍后再试"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// EnrollTwoFactor 开始启用两步验证，返回密钥和扫码 URI
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	enrollment, err := h.authService.EnrollTwoFactor(c.GetInt64("userID"))
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorAlreadyEnabled) {
+			c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "生成两步验证密钥失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Code:    200,
+		Message: "请使用验证器应用扫码后提交验证码完成启用",
+		Data:    enrollment,
+	})
+}
+
+// ActivateTwoFactor 提交验证码确认启用两步验证，返回恢复码和新的令牌
+func (h *AuthHandler) ActivateTwoFactor(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	resp, err := h.authService.ActivateTwoFactor(c.GetInt64("userID"), req.Code, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+			c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+		case errors.Is(err, service.ErrTwoFactorNotEnrolled), errors.Is(err, service.ErrInvalidTwoFactorCode):
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "启用两步验证失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DisableTwoFactor 关闭当前用户的两步验证，旧会话全部注销，返回新会话的令牌
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	var req models.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    400,
+			Message: "请求参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	resp, err := h.authService.DisableTwoFactor(c.GetInt64("userID"), req.Password, req.Code, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		var throttled *service.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, models.APIResponse{Code: 429, Message: err.Error()})
+		case errors.Is(err, service.ErrTwoFactorRequired):
+			c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: err.Error()})
+		case errors.Is(err, service.ErrWrongPassword), errors.Is(err, service.ErrInvalidTwoFactorCode):
+			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "关闭两步验证失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
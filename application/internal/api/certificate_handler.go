@@ -5,22 +5,29 @@ import (
 	"cert-system/internal/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"fmt"
+	"log"
 	"errors"
 )
 
 // CertificateHandler 证书处理器
 type CertificateHandler struct {
-	certService *service.CertificateService
+	certService      *service.CertificateService
+	permissions      *service.RolePermissions
+	analyticsService *service.AnalyticsService
 }
 
 // NewCertificateHandler 创建新的 CertificateHandler
-func NewCertificateHandler(certService *service.CertificateService) *CertificateHandler {
+func NewCertificateHandler(certService *service.CertificateService, permissions *service.RolePermissions, analyticsService *service.AnalyticsService) *CertificateHandler {
 	return &CertificateHandler{
-		certService: certService,
+		certService:      certService,
+		permissions:      permissions,
+		analyticsService: analyticsService,
 	}
 }
 
@@ -38,6 +45,21 @@ func parseDate(dateStr string) (time.Time, error) {
 	return time.Time{}, errors.New("无法解析日期: " + dateStr + ". 支持格式如 YYYY-MM-DD 或 YYYY-MM-DDTHH:MM:SSZ")
 }
 
+// certificateETag 根据证书版本号生成 ETag
+func certificateETag(cert *models.Certificate) string {
+	return fmt.Sprintf(`"%d"`, cert.Version)
+}
+
+// parseIfMatch 解析 If-Match 请求头中的证书版本号。"*" 会跳过并发检查，不予接受，必须携带具体的 ETag
+func parseIfMatch(header string) (int64, bool) {
+	header = strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
 // CreateCertificate 创建证书
 func (h *CertificateHandler) CreateCertificate(c *gin.Context) {
 	var req models.CreateCertificateRequest
@@ -87,10 +109,15 @@ func (h *CertificateHandler) CreateCertificate(c *gin.Context) {
 	}
 
 	if err := h.certService.CreateCertificate(cert); err != nil {
+		if errors.Is(err, service.ErrCustomerNotFound) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "创建证书失败: " + err.Error()})
 		return
 	}
 
+	c.Header("ETag", certificateETag(cert))
 	c.JSON(http.StatusCreated, models.APIResponse{Code: 201, Message: "证书创建成功", Data: cert})
 }
 
@@ -125,7 +152,7 @@ func (h *CertificateHandler) GetAllCertificates(c *gin.Context) {
 	})
 }
 
-// GetCertificate 根据证书编号获取证书
+// GetCertificate 根据证书编号获取证书，?anomalies=1 时附带测试数据的异常标记
 func (h *CertificateHandler) GetCertificate(c *gin.Context) {
 	certNumber := c.Param("certNumber")
 	if certNumber == "" {
@@ -154,6 +181,17 @@ func (h *CertificateHandler) GetCertificate(c *gin.Context) {
 		},
 	}
 
+	// 请求了异常分析且有权查看测试数据时附带测试数据的异常标记，分析失败不影响证书查询
+	if c.Query("anomalies") == "1" && hasPermission(c, h.permissions, service.PermTestDataRead) {
+		anomalies, err := h.analyticsService.GetCertificateAnomalies(cert.ID)
+		if err != nil {
+			log.Printf("分析证书 %s 的测试数据失败: %v", cert.CertNumber, err)
+		} else {
+			response["anomalies"] = anomalies
+		}
+	}
+
+	c.Header("ETag", certificateETag(cert))
 	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取证书成功", Data: response})
 }
 
@@ -166,6 +204,13 @@ func (h *CertificateHandler) UpdateCertificate(c *gin.Context) {
 		return
 	}
 
+	// 更新必须携带 If-Match，防止并发修改相互覆盖
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, models.APIResponse{Code: 428, Message: "缺少 If-Match 请求头，请先获取证书的 ETag"})
+		return
+	}
+
 	var updatedCertData struct {
 		CertNumber         string `json:"certNumber"`
 		CustomerID         int64  `json:"customerId"`
@@ -202,6 +247,16 @@ func (h *CertificateHandler) UpdateCertificate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "testResult 必须是 'qualified' 或 'unqualified'"})
 		return
 	}
+	if !validCertificateStatuses[updatedCertData.Status] {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "status 取值无效: " + updatedCertData.Status})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: "未找到用户信息"})
+		return
+	}
 
 	existingCert, err := h.certService.GetCertificateByNumber(certNumber)
 	if err != nil {
@@ -213,6 +268,25 @@ func (h *CertificateHandler) UpdateCertificate(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, ok := parseIfMatch(ifMatch)
+	if !ok || expectedVersion != existingCert.Version {
+		c.Header("ETag", certificateETag(existingCert))
+		c.JSON(http.StatusPreconditionFailed, models.APIResponse{Code: 412, Message: service.ErrVersionConflict.Error()})
+		return
+	}
+
+	// 修改证书编号或状态需要额外权限
+	var changed []string
+	if updatedCertData.CertNumber != existingCert.CertNumber {
+		changed = append(changed, "certNumber")
+	}
+	if updatedCertData.Status != existingCert.Status {
+		changed = append(changed, "status")
+	}
+	if !h.checkChangePermissions(c, changed, existingCert.Status, updatedCertData.Status) {
+		return
+	}
+
 	// 更新字段
 	existingCert.CertNumber = updatedCertData.CertNumber
 	existingCert.CustomerID = updatedCertData.CustomerID
@@ -227,11 +301,233 @@ func (h *CertificateHandler) UpdateCertificate(c *gin.Context) {
 	existingCert.Status = updatedCertData.Status
 	existingCert.UpdatedAt = time.Now() // ✅ 自动更新时间
 
-	if err := h.certService.UpdateCertificate(existingCert); err != nil {
+	if err := h.certService.UpdateCertificate(existingCert, userID.(int64), expectedVersion); err != nil {
+		if errors.Is(err, service.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, models.APIResponse{Code: 412, Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrCustomerNotFound) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrCertNumberImmutable) || errors.Is(err, service.ErrCertNumberTaken) ||
+			errors.Is(err, service.ErrUncertaintyStale) {
+			c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrResultMismatch) {
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{Code: 422, Message: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "更新证书失败: " + err.Error()})
 		return
 	}
 
+	c.Header("ETag", certificateETag(existingCert))
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "证书更新成功", Data: existingCert})
+}
+
+// certificateFieldPermissions 修改时需要额外权限的证书字段（status 按原状态和目标状态另行判断）
+var certificateFieldPermissions = map[string]string{
+	"certNumber": service.PermCertRenumber,
+}
+
+// checkChangePermissions 检查实际发生变化的字段是否需要额外权限，无权限时写入 403 响应并返回 false
+func (h *CertificateHandler) checkChangePermissions(c *gin.Context, changed []string, oldStatus, newStatus string) bool {
+	for _, field := range changed {
+		var perms []string
+		if field == "status" {
+			perms = service.StatusChangePermissions(oldStatus, newStatus)
+		} else if perm := certificateFieldPermissions[field]; perm != "" {
+			perms = []string{perm}
+		}
+		for _, perm := range perms {
+			if !hasPermission(c, h.permissions, perm) {
+				c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: fmt.Sprintf("当前角色无权修改字段 %s，需要 %s 权限", field, perm)})
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validCertificateStatuses 证书状态的有效取值
+var validCertificateStatuses = map[string]bool{
+	"draft": true, "testing": true, "completed": true, "issued": true, "revoked": true,
+}
+
+// applyCertificatePatch 按 JSON Merge Patch (RFC 7396) 语义将补丁应用到证书上
+// 返回实际发生变化的字段名
+func applyCertificatePatch(cert *models.Certificate, patch map[string]json.RawMessage) ([]string, error) {
+	var changed []string
+	for field, raw := range patch {
+		isNull := string(raw) == "null"
+		var err error
+		var modified bool
+		switch field {
+		case "certNumber", "instrumentName", "testResult", "status":
+			if isNull {
+				return nil, fmt.Errorf("%s 不能为空", field)
+			}
+			var v string
+			if err = json.Unmarshal(raw, &v); err != nil {
+				break
+			}
+			if v == "" {
+				return nil, fmt.Errorf("%s 不能为空", field)
+			}
+			switch field {
+			case "certNumber":
+				modified, cert.CertNumber = cert.CertNumber != v, v
+			case "instrumentName":
+				modified, cert.InstrumentName = cert.InstrumentName != v, v
+			case "testResult":
+				if v != "qualified" && v != "unqualified" {
+					return nil, errors.New("testResult 必须是 'qualified' 或 'unqualified'")
+				}
+				modified, cert.TestResult = cert.TestResult != v, v
+			case "status":
+				if !validCertificateStatuses[v] {
+					return nil, errors.New("status 取值无效: " + v)
+				}
+				modified, cert.Status = cert.Status != v, v
+			}
+		case "instrumentNumber", "manufacturer", "modelSpec", "instrumentAccuracy":
+			// 可选字段，null 表示清空
+			var v string
+			if !isNull {
+				if err = json.Unmarshal(raw, &v); err != nil {
+					break
+				}
+			}
+			switch field {
+			case "instrumentNumber":
+				modified, cert.InstrumentNumber = cert.InstrumentNumber != v, v
+			case "manufacturer":
+				modified, cert.Manufacturer = cert.Manufacturer != v, v
+			case "modelSpec":
+				modified, cert.ModelSpec = cert.ModelSpec != v, v
+			case "instrumentAccuracy":
+				modified, cert.InstrumentAccuracy = cert.InstrumentAccuracy != v, v
+			}
+		case "customerId":
+			if isNull {
+				return nil, errors.New("customerId 不能为空")
+			}
+			var v int64
+			if err = json.Unmarshal(raw, &v); err != nil {
+				break
+			}
+			modified, cert.CustomerID = cert.CustomerID != v, v
+		case "testDate", "expireDate":
+			if isNull {
+				return nil, fmt.Errorf("%s 不能为空", field)
+			}
+			var v string
+			if err = json.Unmarshal(raw, &v); err != nil {
+				break
+			}
+			var t time.Time
+			if t, err = parseDate(v); err != nil {
+				break
+			}
+			if field == "testDate" {
+				modified, cert.TestDate = !cert.TestDate.Equal(t), t
+			} else {
+				modified, cert.ExpireDate = !cert.ExpireDate.Equal(t), t
+			}
+		default:
+			return nil, fmt.Errorf("字段 %s 不允许修改", field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s 格式错误: %v", field, err)
+		}
+		if modified {
+			changed = append(changed, field)
+		}
+	}
+	return changed, nil
+}
+
+// PatchCertificate 按 JSON Merge Patch 部分更新证书
+func (h *CertificateHandler) PatchCertificate(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if certNumber == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "证书编号不能为空"})
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, models.APIResponse{Code: 428, Message: "缺少 If-Match 请求头，请先获取证书的 ETag"})
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误，补丁必须是 JSON 对象: " + err.Error()})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: "未找到用户信息"})
+		return
+	}
+
+	existingCert, err := h.certService.GetCertificateByNumber(certNumber)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书未找到"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "获取证书失败: " + err.Error()})
+		return
+	}
+
+	expectedVersion, ok := parseIfMatch(ifMatch)
+	if !ok || expectedVersion != existingCert.Version {
+		c.Header("ETag", certificateETag(existingCert))
+		c.JSON(http.StatusPreconditionFailed, models.APIResponse{Code: 412, Message: service.ErrVersionConflict.Error()})
+		return
+	}
+
+	oldStatus := existingCert.Status
+	changed, err := applyCertificatePatch(existingCert, patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+		return
+	}
+
+	// 字段级权限检查，仅对实际变化的字段生效
+	if !h.checkChangePermissions(c, changed, oldStatus, existingCert.Status) {
+		return
+	}
+	existingCert.UpdatedAt = time.Now()
+
+	if err := h.certService.UpdateCertificate(existingCert, userID.(int64), expectedVersion); err != nil {
+		if errors.Is(err, service.ErrVersionConflict) {
+			c.JSON(http.StatusPreconditionFailed, models.APIResponse{Code: 412, Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrCustomerNotFound) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrCertNumberImmutable) || errors.Is(err, service.ErrCertNumberTaken) ||
+			errors.Is(err, service.ErrUncertaintyStale) {
+			c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrResultMismatch) {
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{Code: 422, Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "更新证书失败: " + err.Error()})
+		return
+	}
+
+	c.Header("ETag", certificateETag(existingCert))
 	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "证书更新成功", Data: existingCert})
 }
 
@@ -325,9 +621,141 @@ func (h *CertificateHandler) GetCertificateHistory(c *gin.Context) {
 
 	history, err := h.certService.GetCertificateHistory(certNumber)
 	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书未找到"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "获取历史记录失败: " + err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取历史记录成功", Data: history})
 }
+
+
+// GetCertificateDiff 获取证书两个账本版本之间的字段差异
+func (h *CertificateHandler) GetCertificateDiff(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if certNumber == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "证书编号不能为空"})
+		return
+	}
+
+	diff, err := h.certService.DiffCertificateVersions(certNumber, c.Query("from"), c.Query("to"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrLedgerUnavailable):
+			c.JSON(http.StatusServiceUnavailable, models.APIResponse{Code: 503, Message: err.Error()})
+		case errors.Is(err, service.ErrVersionNotFound):
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: err.Error()})
+		case errors.Is(err, service.ErrInvalidVersionRange):
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "获取证书差异失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取证书差异成功", Data: diff})
+}
+// GetCertificateTraceability 获取证书的量值溯源链：所用检测设备及其校准证书，逐级追溯到外部证书
+func (h *CertificateHandler) GetCertificateTraceability(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if certNumber == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "证书编号不能为空"})
+		return
+	}
+
+	trace, err := h.certService.GetCertificateTraceability(certNumber)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书未找到"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "获取溯源链失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取溯源链成功", Data: trace})
+}
+
+// AnchorCertificateTraceability 将证书当前的溯源链锚定到账本
+func (h *CertificateHandler) AnchorCertificateTraceability(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if certNumber == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "证书编号不能为空"})
+		return
+	}
+
+	anchor, err := h.certService.AnchorCertificateTraceability(certNumber)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书未找到"})
+		case errors.Is(err, service.ErrLedgerUnavailable):
+			c.JSON(http.StatusServiceUnavailable, models.APIResponse{Code: 503, Message: err.Error()})
+		case errors.Is(err, service.ErrTraceabilityIncomplete):
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{Code: 422, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "溯源链锚定失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "溯源链已锚定到账本", Data: anchor})
+}
+
+// GetCertificateEvaluation 根据测试数据和误差限值表给出建议的检定结果及各测试点的判定说明
+func (h *CertificateHandler) GetCertificateEvaluation(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if certNumber == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "证书编号不能为空"})
+		return
+	}
+
+	evaluation, err := h.certService.EvaluateCertificateResult(certNumber)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书未找到"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "判定检定结果失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "判定检定结果成功", Data: evaluation})
+}
+
+// CalculateUncertainty 根据测试数据评定证书的测量不确定度，结果随证书保存并纳入区块链哈希
+func (h *CertificateHandler) CalculateUncertainty(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if certNumber == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "证书编号不能为空"})
+		return
+	}
+
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: "未找到用户信息"})
+		return
+	}
+
+	cert, err := h.certService.CalculateUncertainty(certNumber, userID.(int64))
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书未找到"})
+		case errors.Is(err, service.ErrCertificateLocked):
+			c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+		case errors.Is(err, service.ErrVersionConflict):
+			c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+		case errors.Is(err, service.ErrUncertaintyUnavailable):
+			c.JSON(http.StatusUnprocessableEntity, models.APIResponse{Code: 422, Message: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "评定测量不确定度失败: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "测量不确定度评定成功", Data: cert})
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const handlerTestCertNumber = "CT-H-001"
+
+// newCertificateTestRouter 创建只挂载证书修改接口的测试路由：数据库为内存 SQLite，登记一张草稿证书，
+// 请求以 role 角色的用户身份处理。除默认角色外另有 supervisor，可流转状态但不能签发或撤销
+func newCertificateTestRouter(t *testing.T, role string) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t, &models.Customer{}, &models.Certificate{}, &models.TestData{}, &models.CertificateHistory{})
+
+	customer := models.Customer{CustomerName: "测试委托方"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+	if err := db.Create(&models.Certificate{
+		CertNumber:     handlerTestCertNumber,
+		CustomerID:     customer.ID,
+		InstrumentName: "电流互感器",
+		TestDate:       time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		ExpireDate:     time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+		TestResult:     "qualified",
+		Status:         "draft",
+		Version:        1,
+	}).Error; err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+
+	roles := config.DefaultRolePermissions()
+	roles["supervisor"] = append([]string{"cert:status"}, roles["operator"]...)
+	client := &database.Client{DB: db}
+	certService := service.NewCertificateService(client, nil,
+		service.NewResultEvaluator(config.ErrorLimitConfig{}), service.NewUncertaintyCalculator(config.UncertaintyConfig{}))
+	handler := NewCertificateHandler(certService, service.NewRolePermissions(config.PermissionConfig{Roles: roles}), nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userID", int64(1))
+		c.Set("role", role)
+	})
+	router.PUT("/certificates/:certNumber", handler.UpdateCertificate)
+	router.PATCH("/certificates/:certNumber", handler.PatchCertificate)
+	return router, db
+}
+
+// putCertificateBody 构造测试证书的完整更新请求体，status 为目标状态
+func putCertificateBody(status string) map[string]interface{} {
+	return map[string]interface{}{
+		"certNumber":     handlerTestCertNumber,
+		"customerId":     1,
+		"instrumentName": "电流互感器",
+		"testDate":       "2024-05-01",
+		"expireDate":     "2025-05-01",
+		"testResult":     "qualified",
+		"status":         status,
+	}
+}
+
+// sendCertificateRequest 以 JSON 请求体调用证书修改接口，ifMatch 为空时不携带 If-Match
+func sendCertificateRequest(t *testing.T, router *gin.Engine, method, ifMatch string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("编码请求体失败: %v", err)
+	}
+	req := httptest.NewRequest(method, "/certificates/"+handlerTestCertNumber, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCertificateStatusChangePermissions(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		role       string
+		body       interface{}
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "PUT 操作员流转状态", method: http.MethodPut, role: "operator", body: putCertificateBody("testing"), wantCode: http.StatusForbidden, wantStatus: "draft"},
+		{name: "PUT 操作员签发", method: http.MethodPut, role: "operator", body: putCertificateBody("issued"), wantCode: http.StatusForbidden, wantStatus: "draft"},
+		{name: "PUT 操作员不改状态", method: http.MethodPut, role: "operator", body: putCertificateBody("draft"), wantCode: http.StatusOK, wantStatus: "draft"},
+		{name: "PUT 主管流转状态", method: http.MethodPut, role: "supervisor", body: putCertificateBody("completed"), wantCode: http.StatusOK, wantStatus: "completed"},
+		{name: "PUT 主管签发", method: http.MethodPut, role: "supervisor", body: putCertificateBody("issued"), wantCode: http.StatusForbidden, wantStatus: "draft"},
+		{name: "PUT 管理员流转状态", method: http.MethodPut, role: "admin", body: putCertificateBody("testing"), wantCode: http.StatusOK, wantStatus: "testing"},
+		{name: "PATCH 操作员流转状态", method: http.MethodPatch, role: "operator", body: map[string]string{"status": "completed"}, wantCode: http.StatusForbidden, wantStatus: "draft"},
+		{name: "PATCH 操作员修改其他字段", method: http.MethodPatch, role: "operator", body: map[string]string{"instrumentName": "电压互感器"}, wantCode: http.StatusOK, wantStatus: "draft"},
+		{name: "PATCH 主管流转状态", method: http.MethodPatch, role: "supervisor", body: map[string]string{"status": "testing"}, wantCode: http.StatusOK, wantStatus: "testing"},
+		{name: "PATCH 主管撤销", method: http.MethodPatch, role: "supervisor", body: map[string]string{"status": "revoked"}, wantCode: http.StatusForbidden, wantStatus: "draft"},
+		{name: "PATCH 管理员流转状态", method: http.MethodPatch, role: "admin", body: map[string]string{"status": "completed"}, wantCode: http.StatusOK, wantStatus: "completed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, db := newCertificateTestRouter(t, tt.role)
+			w := sendCertificateRequest(t, router, tt.method, `"1"`, tt.body)
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			var cert models.Certificate
+			if err := db.Where("cert_number = ?", handlerTestCertNumber).First(&cert).Error; err != nil {
+				t.Fatalf("查询证书失败: %v", err)
+			}
+			if cert.Status != tt.wantStatus {
+				t.Fatalf("期望状态 %s，实际 %s", tt.wantStatus, cert.Status)
+			}
+		})
+	}
+}
+
+func TestCertificateIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		ifMatch  string
+		body     interface{}
+		wantCode int
+	}{
+		{name: "PUT 缺少 If-Match", method: http.MethodPut, body: putCertificateBody("draft"), wantCode: http.StatusPreconditionRequired},
+		{name: "PUT 版本过期", method: http.MethodPut, ifMatch: `"0"`, body: putCertificateBody("draft"), wantCode: http.StatusPreconditionFailed},
+		{name: "PUT 通配符", method: http.MethodPut, ifMatch: "*", body: putCertificateBody("draft"), wantCode: http.StatusPreconditionFailed},
+		{name: "PUT 无法解析", method: http.MethodPut, ifMatch: `"v1"`, body: putCertificateBody("draft"), wantCode: http.StatusPreconditionFailed},
+		{name: "PUT 弱 ETag", method: http.MethodPut, ifMatch: `W/"1"`, body: putCertificateBody("draft"), wantCode: http.StatusOK},
+		{name: "PUT 状态无效", method: http.MethodPut, ifMatch: `"1"`, body: putCertificateBody("archived"), wantCode: http.StatusBadRequest},
+		{name: "PUT 缺少状态", method: http.MethodPut, ifMatch: `"1"`, body: putCertificateBody(""), wantCode: http.StatusBadRequest},
+		{name: "PATCH 缺少 If-Match", method: http.MethodPatch, body: map[string]string{"instrumentName": "电压互感器"}, wantCode: http.StatusPreconditionRequired},
+		{name: "PATCH 版本过期", method: http.MethodPatch, ifMatch: `"2"`, body: map[string]string{"instrumentName": "电压互感器"}, wantCode: http.StatusPreconditionFailed},
+		{name: "PATCH 通配符", method: http.MethodPatch, ifMatch: "*", body: map[string]string{"instrumentName": "电压互感器"}, wantCode: http.StatusPreconditionFailed},
+		{name: "PATCH 状态无效", method: http.MethodPatch, ifMatch: `"1"`, body: map[string]string{"status": "archived"}, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newCertificateTestRouter(t, "admin")
+			w := sendCertificateRequest(t, router, tt.method, tt.ifMatch, tt.body)
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			// 版本不匹配时返回当前 ETag，便于客户端重新获取后重试
+			if w.Code == http.StatusPreconditionFailed && w.Header().Get("ETag") != `"1"` {
+				t.Fatalf("412 响应应携带当前 ETag，实际 %q", w.Header().Get("ETag"))
+			}
+		})
+	}
+}
+
+func TestCertificateETagRoundTrip(t *testing.T) {
+	router, _ := newCertificateTestRouter(t, "admin")
+
+	w := sendCertificateRequest(t, router, http.MethodPatch, `"1"`, map[string]string{"instrumentName": "电压互感器"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("期望更新成功并返回 ETag \"2\"，实际 %d %q", w.Code, w.Header().Get("ETag"))
+	}
+	etag := w.Header().Get("ETag")
+
+	// 并发的另一方仍持有旧 ETag，修改被拒绝
+	if w := sendCertificateRequest(t, router, http.MethodPut, `"1"`, putCertificateBody("testing")); w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != etag {
+		t.Fatalf("期望旧 ETag 返回 412 和当前 ETag，实际 %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// 使用上次响应的 ETag 可以继续修改
+	w = sendCertificateRequest(t, router, http.MethodPut, etag, putCertificateBody("testing"))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("期望更新成功并返回 ETag \"3\"，实际 %d %q: %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// CustomerHandler 委托方处理器
+type CustomerHandler struct {
+	customerService *service.CustomerService
+}
+
+// NewCustomerHandler 创建新的 CustomerHandler
+func NewCustomerHandler(customerService *service.CustomerService) *CustomerHandler {
+	return &CustomerHandler{
+		customerService: customerService,
+	}
+}
+
+// parseCustomerID 解析路径中的委托方ID
+func parseCustomerID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "委托方ID无效"})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondCustomerError 将委托方管理错误转换为对应的HTTP响应，重名时返回已存在的委托方
+func respondCustomerError(c *gin.Context, action string, err error) {
+	var duplicate *service.DuplicateCustomerError
+	switch {
+	case errors.As(err, &duplicate):
+		c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error(), Data: duplicate.Existing})
+	case errors.Is(err, service.ErrCustomerNotFound):
+		c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: err.Error()})
+	case errors.Is(err, service.ErrCustomerInUse):
+		c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: action + "失败: " + err.Error()})
+	}
+}
+
+// GetCustomers 获取委托方列表（支持分页和按名称、联系人、电话搜索）
+func (h *CustomerHandler) GetCustomers(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	customers, total, err := h.customerService.ListCustomers(page, pageSize, c.Query("keyword"))
+	if err != nil {
+		respondCustomerError(c, "获取委托方列表", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PagedResponse{
+		Code:       200,
+		Message:    "获取委托方列表成功",
+		Data:       customers,
+		Total:      int(total),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (int(total) + pageSize - 1) / pageSize,
+	})
+}
+
+// GetCustomer 获取单个委托方
+func (h *CustomerHandler) GetCustomer(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+
+	customer, err := h.customerService.GetCustomer(id)
+	if err != nil {
+		respondCustomerError(c, "获取委托方", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取委托方成功", Data: customer})
+}
+
+// CreateCustomer 创建委托方
+func (h *CustomerHandler) CreateCustomer(c *gin.Context) {
+	var req models.CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+
+	customer := &models.Customer{
+		CustomerName:    req.CustomerName,
+		CustomerAddress: req.CustomerAddress,
+		ContactPerson:   req.ContactPerson,
+		ContactPhone:    req.ContactPhone,
+	}
+	if err := h.customerService.CreateCustomer(customer); err != nil {
+		respondCustomerError(c, "创建委托方", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{Code: 201, Message: "委托方创建成功", Data: customer})
+}
+
+// UpdateCustomer 更新委托方
+func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+
+	var req models.CustomerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+
+	customer, err := h.customerService.UpdateCustomer(id, &models.Customer{
+		CustomerName:    req.CustomerName,
+		CustomerAddress: req.CustomerAddress,
+		ContactPerson:   req.ContactPerson,
+		ContactPhone:    req.ContactPhone,
+	})
+	if err != nil {
+		respondCustomerError(c, "更新委托方", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "委托方更新成功", Data: customer})
+}
+
+// DeleteCustomer 删除委托方（已被证书引用时拒绝）
+func (h *CustomerHandler) DeleteCustomer(c *gin.Context) {
+	id, ok := parseCustomerID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.customerService.DeleteCustomer(id); err != nil {
+		respondCustomerError(c, "删除委托方", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "委托方删除成功"})
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeviceHandler 检测设备处理器
+type DeviceHandler struct {
+	deviceService *service.DeviceService
+}
+
+// NewDeviceHandler 创建新的 DeviceHandler
+func NewDeviceHandler(deviceService *service.DeviceService) *DeviceHandler {
+	return &DeviceHandler{
+		deviceService: deviceService,
+	}
+}
+
+// parseDeviceID 解析路径中的设备ID
+func parseDeviceID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "设备ID无效"})
+		return 0, false
+	}
+	return id, true
+}
+
+// parseCalibrationDueDate 解析校准有效期截止日，为空时返回 nil
+func parseCalibrationDueDate(c *gin.Context, value string) (*time.Time, bool) {
+	if value == "" {
+		return nil, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "calibrationDueDate 格式错误，应为 YYYY-MM-DD"})
+		return nil, false
+	}
+	return &t, true
+}
+
+// optionalString 空字符串返回 nil
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// respondDeviceError 将设备管理错误转换为对应的HTTP响应
+func respondDeviceError(c *gin.Context, action string, err error) {
+	switch {
+	case errors.Is(err, service.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: err.Error()})
+	case errors.Is(err, service.ErrDeviceAddrTaken):
+		c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+	case errors.Is(err, service.ErrInvalidDeviceStatus), errors.Is(err, service.ErrInvalidCalibrationRef),
+		errors.Is(err, service.ErrInvalidPublicKey):
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: action + "失败: " + err.Error()})
+	}
+}
+
+// GetDevices 获取设备列表（支持分页、按状态过滤和按地址、名称、型号搜索）
+func (h *DeviceHandler) GetDevices(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	status := c.Query("status")
+	if status != "" && status != service.DeviceStatusActive && status != service.DeviceStatusMaintenance && status != service.DeviceStatusRetired {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: service.ErrInvalidDeviceStatus.Error()})
+		return
+	}
+
+	devices, total, err := h.deviceService.ListDevices(page, pageSize, status, c.Query("keyword"))
+	if err != nil {
+		respondDeviceError(c, "获取设备列表", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PagedResponse{
+		Code:       200,
+		Message:    "获取设备列表成功",
+		Data:       devices,
+		Total:      int(total),
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: (int(total) + pageSize - 1) / pageSize,
+	})
+}
+
+// GetDevice 获取单个设备
+func (h *DeviceHandler) GetDevice(c *gin.Context) {
+	id, ok := parseDeviceID(c)
+	if !ok {
+		return
+	}
+
+	device, err := h.deviceService.GetDevice(id)
+	if err != nil {
+		respondDeviceError(c, "获取设备", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "获取设备成功", Data: device})
+}
+
+// CreateDevice 登记设备
+func (h *DeviceHandler) CreateDevice(c *gin.Context) {
+	var req models.CreateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+	dueDate, ok := parseCalibrationDueDate(c, req.CalibrationDueDate)
+	if !ok {
+		return
+	}
+
+	device := &models.Device{
+		DeviceAddr:             req.DeviceAddr,
+		DeviceName:             req.DeviceName,
+		Manufacturer:           req.Manufacturer,
+		Model:                  req.Model,
+		AccuracyClass:          req.AccuracyClass,
+		Status:                 req.Status,
+		CalibrationCertNumber:  optionalString(req.CalibrationCertNumber),
+		CalibrationExternalRef: req.CalibrationExternalRef,
+		CalibrationDueDate:     dueDate,
+	}
+	if err := h.deviceService.CreateDevice(device); err != nil {
+		respondDeviceError(c, "登记设备", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.APIResponse{Code: 201, Message: "设备登记成功", Data: device})
+}
+
+// UpdateDevice 更新设备信息、状态和校准证书
+func (h *DeviceHandler) UpdateDevice(c *gin.Context) {
+	id, ok := parseDeviceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+	dueDate, ok := parseCalibrationDueDate(c, req.CalibrationDueDate)
+	if !ok {
+		return
+	}
+
+	device, err := h.deviceService.UpdateDevice(id, &models.Device{
+		DeviceName:             req.DeviceName,
+		Manufacturer:           req.Manufacturer,
+		Model:                  req.Model,
+		AccuracyClass:          req.AccuracyClass,
+		Status:                 req.Status,
+		CalibrationCertNumber:  optionalString(req.CalibrationCertNumber),
+		CalibrationExternalRef: req.CalibrationExternalRef,
+		CalibrationDueDate:     dueDate,
+	})
+	if err != nil {
+		respondDeviceError(c, "更新设备", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "设备更新成功", Data: device})
+}
+
+// SetDevicePublicKey 登记或更换设备签名公钥，登记后该设备提交的测试数据须附带签名
+func (h *DeviceHandler) SetDevicePublicKey(c *gin.Context) {
+	id, ok := parseDeviceID(c)
+	if !ok {
+		return
+	}
+
+	var req models.SetDevicePublicKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "请求参数错误: " + err.Error()})
+		return
+	}
+
+	device, err := h.deviceService.SetDevicePublicKey(id, req.PublicKey)
+	if err != nil {
+		respondDeviceError(c, "登记设备公钥", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "设备公钥登记成功", Data: device})
+}
+
+// DeleteDevice 删除设备（已上传测试数据或绑定 API Key 的设备改为停用）
+func (h *DeviceHandler) DeleteDevice(c *gin.Context) {
+	id, ok := parseDeviceID(c)
+	if !ok {
+		return
+	}
+
+	retired, err := h.deviceService.DeleteDevice(id)
+	if err != nil {
+		respondDeviceError(c, "删除设备", err)
+		return
+	}
+
+	if retired {
+		c.JSON(http.StatusOK, models.APIResponse{
+			Code:    200,
+			Message: "设备已有测试数据或绑定了 API Key，已改为停用",
+			Data:    gin.H{"id": id, "status": service.DeviceStatusRetired},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Code: 200, Message: "设备删除成功", Data: gin.H{"id": id}})
+}
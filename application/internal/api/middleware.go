@@ -1,16 +1,50 @@
 package api
 
 import (
-	"cert-system/internal/helper" // 导入 helper 包
+	"bytes"
 	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"io"
+	"log"
 	"net/http"
 	"strings"
 )
 
-// AuthMiddleware 认证中间件
-func AuthMiddleware() gin.HandlerFunc {
+// passwordChangeAllowedPaths 必须修改密码时仍允许访问的接口
+var passwordChangeAllowedPaths = map[string]bool{
+	"/api/v1/auth/password": true,
+	"/api/v1/auth/profile":  true,
+	"/api/v1/auth/logout":   true,
+}
+
+// twoFactorSetupAllowedPaths 必须启用两步验证时仍允许访问的接口
+var twoFactorSetupAllowedPaths = map[string]bool{
+	"/api/v1/auth/2fa/enroll":   true,
+	"/api/v1/auth/2fa/activate": true,
+	"/api/v1/auth/password":     true,
+	"/api/v1/auth/profile":      true,
+	"/api/v1/auth/logout":       true,
+}
+
+// apiKeyAllowedPrefixes API Key 可以访问的接口，登录、账号和系统管理接口只允许用户令牌访问
+var apiKeyAllowedPrefixes = []string{"/api/v1/certificates", "/api/v1/test-data"}
+
+// credentialCheckKey 上下文中重新校验当前凭据的函数，长连接据此定期确认会话或 API Key 仍然有效
+const credentialCheckKey = "credentialCheck"
+
+// AuthMiddleware 认证中间件，支持 Authorization: Bearer <JWT> 和 X-API-Key 两种方式
+func AuthMiddleware(authService *service.AuthService, apiKeyService *service.APIKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			authenticateAPIKey(c, apiKeyService, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: "未提供认证令牌"})
@@ -26,30 +60,205 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		claims, err := helper.ParseJWT(tokenString) // 调用 helper 包中的解析函数
+		claims, err := authService.ParseToken(tokenString)
+		if errors.Is(err, service.ErrSessionRevoked) {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: err.Error()})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: "无效或过期的令牌"})
 			c.Abort()
 			return
 		}
 
+		// 需要修改密码的令牌只能访问修改密码相关接口
+		if claims.PasswordChangeRequired && !passwordChangeAllowedPaths[c.FullPath()] {
+			c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: "请先修改密码"})
+			c.Abort()
+			return
+		}
+		if claims.TwoFactorSetupRequired && !twoFactorSetupAllowedPaths[c.FullPath()] {
+			c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: "请先启用两步验证"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("sessionID", claims.SessionID)
+		c.Set(credentialCheckKey, func() error { return authService.ValidateSession(claims) })
 
 		c.Next()
 	}
 }
 
-// AdminMiddleware 管理员权限中间件
-func AdminMiddleware() gin.HandlerFunc {
+// authenticateAPIKey 使用 API Key 认证。API Key 以创建人的身份操作，但权限只限于 Key 本身授予的范围
+func authenticateAPIKey(c *gin.Context, apiKeyService *service.APIKeyService, plaintext string) {
+	allowed := false
+	for _, prefix := range apiKeyAllowedPrefixes {
+		if strings.HasPrefix(c.FullPath(), prefix) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: "API Key 不能访问该接口"})
+		c.Abort()
+		return
+	}
+
+	key, err := apiKeyService.Authenticate(plaintext, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			c.JSON(http.StatusUnauthorized, models.APIResponse{Code: 401, Message: err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "API Key 校验失败: " + err.Error()})
+		}
+		c.Abort()
+		return
+	}
+
+	c.Set("userID", key.CreatedBy)
+	c.Set("username", "apikey:"+key.Name)
+	c.Set("role", "")
+	c.Set("apiKeyID", key.ID)
+	c.Set("apiKeyPermissions", service.APIKeyPermissions(key))
+	c.Set(credentialCheckKey, func() error { return apiKeyService.ValidateAPIKey(key.ID) })
+	if key.DeviceAddr != nil {
+		c.Set("apiKeyDeviceAddr", *key.DeviceAddr)
+	}
+
+	c.Next()
+}
+
+// hasPermission 判断当前请求是否拥有指定权限：API Key 请求按 Key 授予的权限判断，其他请求按角色判断
+func hasPermission(c *gin.Context, permissions *service.RolePermissions, perm string) bool {
+	if granted, ok := c.Get("apiKeyPermissions"); ok {
+		for _, p := range granted.([]string) {
+			if p == perm {
+				return true
+			}
+		}
+		return false
+	}
+	return permissions.Has(c.GetString("role"), perm)
+}
+
+// RequirePermission 权限中间件，当前用户的角色（或 API Key）必须拥有 perm 权限，需在 AuthMiddleware 之后使用
+func RequirePermission(permissions *service.RolePermissions, perm string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role.(string) != "admin" {
-			c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: "权限不足"})
+		if !hasPermission(c, permissions, perm) {
+			c.JSON(http.StatusForbidden, models.APIResponse{Code: 403, Message: "权限不足，需要 " + perm + " 权限"})
 			c.Abort()
 			return
 		}
 		c.Next()
 	}
+}
+
+// maxIdempotencyKeyLength Idempotency-Key 的最大长度
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder 记录响应内容，用于保存幂等请求的首次响应
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// requestBodyHash 计算请求体哈希，JSON 请求体先规范化以忽略空白和键顺序差异
+func requestBodyHash(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// IdempotencyMiddleware 幂等键中间件（需在 AuthMiddleware 之后使用）
+// 携带 Idempotency-Key 的请求首次响应会被保存，在有效期内使用相同键和请求体的重试直接重放该响应
+func IdempotencyMiddleware(idemService *service.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "Idempotency-Key 过长"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "读取请求体失败: " + err.Error()})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := c.GetInt64("userID")
+		apiKeyID := c.GetInt64("apiKeyID")
+		record, replay, err := idemService.Begin(key, userID, apiKeyID, c.Request.Method, c.FullPath(), requestBodyHash(body))
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrIdempotencyKeyMismatch):
+				c.JSON(http.StatusUnprocessableEntity, models.APIResponse{Code: 422, Message: err.Error()})
+			case errors.Is(err, service.ErrIdempotencyInProgress):
+				c.JSON(http.StatusConflict, models.APIResponse{Code: 409, Message: err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "幂等键处理失败: " + err.Error()})
+			}
+			c.Abort()
+			return
+		}
+
+		if replay {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.StatusCode, "application/json; charset=utf-8", []byte(record.ResponseBody))
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		// 服务端错误（包括 panic）不保存，允许客户端使用同一键重试
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+			if err := idemService.Release(record); err != nil {
+				log.Printf("释放幂等键 %s 失败: %v", key, err)
+			}
+		}()
+
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		if err := idemService.Complete(record, status, recorder.body.String()); err != nil {
+			log.Printf("保存幂等键 %s 的响应失败: %v", key, err)
+			return
+		}
+		saved = true
+	}
 }
\ No newline at end of file
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// newIdempotencyTestRouter 创建挂载幂等键中间件的测试路由，POST /orders 每次成功处理都返回新的序号，
+// 请求体为 fail 时返回 500，为 panic 时处理函数 panic
+func newIdempotencyTestRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t, &models.IdempotencyRecord{})
+	// 与 init.sql 的 uk_idempotency 一致，重复占用依赖唯一索引冲突
+	if err := db.Exec("CREATE UNIQUE INDEX uk_idempotency ON idempotency_keys (idem_key, user_id, api_key_id, method, path)").Error; err != nil {
+		t.Fatalf("创建唯一索引失败: %v", err)
+	}
+
+	served := 0
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.Recovery(), func(c *gin.Context) {
+		c.Set("userID", int64(1))
+		if c.GetHeader("X-Test-API-Key") != "" {
+			c.Set("apiKeyID", int64(7))
+		}
+	})
+	router.Use(IdempotencyMiddleware(service.NewIdempotencyService(&database.Client{DB: db}, time.Hour, time.Minute)))
+	router.POST("/orders", func(c *gin.Context) {
+		var body struct {
+			Action string `json:"action"`
+		}
+		_ = c.ShouldBindJSON(&body)
+		switch body.Action {
+		case "fail":
+			c.JSON(http.StatusInternalServerError, models.APIResponse{Code: 500, Message: "failed"})
+			return
+		case "panic":
+			panic("handler panic")
+		}
+		served++
+		c.JSON(http.StatusCreated, models.APIResponse{Code: 200, Data: served})
+	})
+	return router, db
+}
+
+// sendIdempotentRequest 携带幂等键发送 POST /orders
+func sendIdempotentRequest(router *gin.Engine, key, body string, apiKey bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	if apiKey {
+		req.Header.Set("X-Test-API-Key", "1")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// first 为同一幂等键的首次请求体，为空时不发送首次请求
+		first        string
+		body         string
+		key          string
+		apiKey       bool
+		wantCode     int
+		wantReplayed bool
+		wantBody     string
+	}{
+		{name: "未携带幂等键", first: `{"action":"create"}`, body: `{"action":"create"}`, wantCode: http.StatusCreated, wantBody: `"data":2`},
+		{name: "重试重放首次响应", first: `{"action":"create"}`, body: `{"action":"create"}`, key: "k1", wantCode: http.StatusCreated, wantReplayed: true, wantBody: `"data":1`},
+		{name: "请求体格式差异不影响重放", first: `{"action":"create","n":1}`, body: "{\"n\":1,\n \"action\":\"create\"}", key: "k1", wantCode: http.StatusCreated, wantReplayed: true, wantBody: `"data":1`},
+		{name: "请求体不同", first: `{"action":"create"}`, body: `{"action":"update"}`, key: "k1", wantCode: http.StatusUnprocessableEntity},
+		{name: "API Key 与用户本人隔离", first: `{"action":"create"}`, body: `{"action":"create"}`, key: "k1", apiKey: true, wantCode: http.StatusCreated, wantBody: `"data":2`},
+		{name: "服务端错误后可重试", first: `{"action":"fail"}`, body: `{"action":"fail"}`, key: "k1", wantCode: http.StatusInternalServerError},
+		{name: "panic 后可重试", first: `{"action":"panic"}`, body: `{"action":"panic"}`, key: "k1", wantCode: http.StatusInternalServerError},
+		{name: "幂等键过长", body: `{"action":"create"}`, key: strings.Repeat("k", maxIdempotencyKeyLength+1), wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, db := newIdempotencyTestRouter(t)
+			if tt.first != "" {
+				sendIdempotentRequest(router, tt.key, tt.first, false)
+			}
+
+			w := sendIdempotentRequest(router, tt.key, tt.body, tt.apiKey)
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Fatalf("期望重放=%v，实际 %v", tt.wantReplayed, replayed)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Fatalf("响应应包含 %s，实际 %s", tt.wantBody, w.Body.String())
+			}
+			// 服务端错误不保存响应，幂等键被释放
+			if tt.wantCode == http.StatusInternalServerError {
+				var count int64
+				if err := db.Model(&models.IdempotencyRecord{}).Count(&count).Error; err != nil {
+					t.Fatalf("统计幂等键失败: %v", err)
+				}
+				if count != 0 {
+					t.Fatalf("服务端错误后应释放幂等键，实际剩余 %d 条", count)
+				}
+			}
+		})
+	}
+}
+
+// authTestPassword 认证测试用户的密码
+const authTestPassword = "Init-Passw0rd"
+
+// authTestFixture 挂载认证和权限中间件的测试路由及其依赖的服务
+type authTestFixture struct {
+	router  *gin.Engine
+	db      *gorm.DB
+	auth    *service.AuthService
+	apiKeys *service.APIKeyService
+}
+
+// newAuthTestFixture 创建测试路由：/api/v1/certificates（GET 需 cert:read，POST 需 cert:create）、
+// /api/v1/admin/users（需 user:manage）和 /api/v1/auth/profile 均经过 AuthMiddleware，处理成功时返回 200
+func newAuthTestFixture(t *testing.T) *authTestFixture {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.PasswordHistory{}, &models.AuthSession{}, &models.RefreshToken{},
+		&models.RecoveryCode{}, &models.LoginChallenge{}, &models.LoginAttempt{}, &models.Device{}, &models.APIKey{})
+	client := &database.Client{DB: db}
+	passwords := helper.NewPasswordManager(helper.NewBcryptHasher(bcrypt.MinCost))
+	tokens, err := helper.NewJWTManager(config.JWTConfig{Secret: "test-secret"})
+	if err != nil {
+		t.Fatalf("初始化JWT密钥失败: %v", err)
+	}
+	auth := service.NewAuthService(client, passwords, service.NewPasswordPolicy(config.PasswordConfig{}),
+		service.NewLoginProtection(client, config.LoginProtectionConfig{}), tokens, time.Hour, config.TwoFactorConfig{},
+		service.NewAuthProviders(client, passwords, config.SSOConfig{}))
+	apiKeys := service.NewAPIKeyService(client)
+	permissions := service.NewRolePermissions(config.PermissionConfig{})
+
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, models.APIResponse{Code: 200, Data: c.GetInt64("userID")}) }
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/api/v1", AuthMiddleware(auth, apiKeys))
+	v1.GET("/certificates", RequirePermission(permissions, service.PermCertRead), ok)
+	v1.POST("/certificates", RequirePermission(permissions, service.PermCertCreate), ok)
+	v1.GET("/admin/users", RequirePermission(permissions, service.PermUserManage), ok)
+	v1.GET("/auth/profile", ok)
+	return &authTestFixture{router: router, db: db, auth: auth, apiKeys: apiKeys}
+}
+
+// createUser 创建密码为 authTestPassword 的用户
+func (f *authTestFixture) createUser(t *testing.T, username, role string) *models.User {
+	t.Helper()
+	hash, err := helper.NewBcryptHasher(bcrypt.MinCost).Hash(authTestPassword)
+	if err != nil {
+		t.Fatalf("计算密码哈希失败: %v", err)
+	}
+	user := &models.User{Username: username, PasswordHash: hash, Role: role, Status: "active", PasswordChangedAt: time.Now()}
+	if err := f.db.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+// login 登录并返回登录响应
+func (f *authTestFixture) login(t *testing.T, username string) *models.LoginResponse {
+	t.Helper()
+	resp, err := f.auth.Login(username, authTestPassword, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	return resp
+}
+
+// send 发送请求，header 为附加的请求头
+func (f *authTestFixture) send(method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// bearer 返回携带访问令牌的请求头
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func TestAuthMiddlewareSessionRevocation(t *testing.T) {
+	tests := []struct {
+		name string
+		// revoke 在登录后使会话失效
+		revoke func(t *testing.T, f *authTestFixture, login *models.LoginResponse)
+	}{
+		{
+			name: "注销",
+			revoke: func(t *testing.T, f *authTestFixture, login *models.LoginResponse) {
+				claims, err := f.auth.ParseToken(login.Data.Token)
+				if err != nil {
+					t.Fatalf("解析令牌失败: %v", err)
+				}
+				if err := f.auth.Logout(claims.SessionID); err != nil {
+					t.Fatalf("注销失败: %v", err)
+				}
+			},
+		},
+		{
+			name: "刷新令牌重放",
+			revoke: func(t *testing.T, f *authTestFixture, login *models.LoginResponse) {
+				if _, err := f.auth.Refresh(login.Data.RefreshToken); err != nil {
+					t.Fatalf("刷新失败: %v", err)
+				}
+				if _, err := f.auth.Refresh(login.Data.RefreshToken); !errors.Is(err, service.ErrRefreshTokenReused) {
+					t.Fatalf("期望 ErrRefreshTokenReused，实际 %v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAuthTestFixture(t)
+			f.createUser(t, "alice", "operator")
+			login := f.login(t, "alice")
+			if w := f.send(http.MethodGet, "/api/v1/certificates", bearer(login.Data.Token)); w.Code != http.StatusOK {
+				t.Fatalf("期望 200，实际 %d: %s", w.Code, w.Body.String())
+			}
+
+			// 会话失效后，尚未过期的访问令牌立即被拒绝
+			tt.revoke(t, f, login)
+			if w := f.send(http.MethodGet, "/api/v1/certificates", bearer(login.Data.Token)); w.Code != http.StatusUnauthorized {
+				t.Fatalf("期望 401，实际 %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		method   string
+		path     string
+		wantCode int
+	}{
+		{name: "查看员查看证书", role: "viewer", method: http.MethodGet, path: "/api/v1/certificates", wantCode: http.StatusOK},
+		{name: "查看员创建证书", role: "viewer", method: http.MethodPost, path: "/api/v1/certificates", wantCode: http.StatusForbidden},
+		{name: "操作员创建证书", role: "operator", method: http.MethodPost, path: "/api/v1/certificates", wantCode: http.StatusOK},
+		{name: "操作员管理用户", role: "operator", method: http.MethodGet, path: "/api/v1/admin/users", wantCode: http.StatusForbidden},
+		{name: "管理员管理用户", role: "admin", method: http.MethodGet, path: "/api/v1/admin/users", wantCode: http.StatusOK},
+		{name: "无需权限的接口", role: "viewer", method: http.MethodGet, path: "/api/v1/auth/profile", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAuthTestFixture(t)
+			f.createUser(t, "alice", tt.role)
+			login := f.login(t, "alice")
+			if w := f.send(tt.method, tt.path, bearer(login.Data.Token)); w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareAPIKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		wantCode int
+	}{
+		{name: "授予的权限", method: http.MethodGet, path: "/api/v1/certificates", wantCode: http.StatusOK},
+		{name: "创建人有权限但 Key 未授予", method: http.MethodPost, path: "/api/v1/certificates", wantCode: http.StatusForbidden},
+		{name: "管理接口不接受 API Key", method: http.MethodGet, path: "/api/v1/admin/users", wantCode: http.StatusForbidden},
+		{name: "账号接口不接受 API Key", method: http.MethodGet, path: "/api/v1/auth/profile", wantCode: http.StatusForbidden},
+		{name: "Key 无效", method: http.MethodGet, path: "/api/v1/certificates", key: "ck_invalid", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAuthTestFixture(t)
+			admin := f.createUser(t, "alice", "admin")
+			_, plaintext, err := f.apiKeys.CreateAPIKey("station", []string{service.PermCertRead, service.PermTestDataWrite}, "", nil, admin.ID)
+			if err != nil {
+				t.Fatalf("创建 API Key 失败: %v", err)
+			}
+			if tt.key != "" {
+				plaintext = tt.key
+			}
+
+			w := f.send(tt.method, tt.path, map[string]string{"X-API-Key": plaintext})
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			// API Key 以创建人的身份操作
+			if w.Code == http.StatusOK && !strings.Contains(w.Body.String(), fmt.Sprintf(`"data":%d`, admin.ID)) {
+				t.Fatalf("应以创建人身份处理请求: %s", w.Body.String())
+			}
+		})
+	}
+}
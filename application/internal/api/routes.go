@@ -9,12 +9,12 @@ import (
 )
 
 // SetupRoutes 设置API路由
-func SetupRoutes(router *gin.Engine, certService *service.CertificateService, testDataService *service.TestDataService, authService *service.AuthService) {
+func SetupRoutes(router *gin.Engine, certService *service.CertificateService, testDataService *service.TestDataService, authService *service.AuthService, userService *service.UserService, idemService *service.IdempotencyService, permissions *service.RolePermissions, apiKeyService *service.APIKeyService, customerService *service.CustomerService, deviceService *service.DeviceService, analyticsService *service.AnalyticsService) {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // 生产环境可限制具体域名
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "If-Match", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "ETag", "Idempotent-Replayed", "Retry-After"},
 		AllowCredentials: true,
 	}))
 	
@@ -27,56 +27,120 @@ func SetupRoutes(router *gin.Engine, certService *service.CertificateService, te
 		})
 	})
 
+	// 公钥集合，供其他服务校验本系统签发的令牌
+	router.GET("/.well-known/jwks.json", NewAuthHandler(authService, permissions).JWKS)
+
+	authRequired := AuthMiddleware(authService, apiKeyService)
+
 	// API版本组
 	v1 := router.Group("/api/v1")
 	{
 		// 认证相关路由
 		auth := v1.Group("/auth")
 		{
-			authHandler := NewAuthHandler(authService)
+			authHandler := NewAuthHandler(authService, permissions)
 			auth.POST("/login", authHandler.Login)
-			auth.POST("/logout", AuthMiddleware(), authHandler.Logout)
-			auth.GET("/profile", AuthMiddleware(), authHandler.GetProfile)
+			auth.POST("/login/2fa", authHandler.LoginTwoFactor)
+			auth.GET("/providers", authHandler.GetProviders)
+			auth.GET("/sso/:provider/login", authHandler.SSOLogin)
+			auth.GET("/sso/:provider/callback", authHandler.SSOCallback)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", authRequired, authHandler.Logout)
+			auth.GET("/profile", authRequired, authHandler.GetProfile)
+			auth.POST("/password", authRequired, authHandler.ChangePassword)
+			auth.POST("/2fa/enroll", authRequired, authHandler.EnrollTwoFactor)
+			auth.POST("/2fa/activate", authRequired, authHandler.ActivateTwoFactor)
+			auth.POST("/2fa/disable", authRequired, authHandler.DisableTwoFactor)
 		}
 
 		// 证书相关路由
 		certificates := v1.Group("/certificates")
-		certificates.Use(AuthMiddleware()) // 所有证书API都需要认证
+		certificates.Use(authRequired) // 所有证书API都需要认证
+		{
+			certHandler := NewCertificateHandler(certService, permissions, analyticsService)
+			certificates.POST("", RequirePermission(permissions, service.PermCertCreate), IdempotencyMiddleware(idemService), certHandler.CreateCertificate)
+			certificates.GET("", RequirePermission(permissions, service.PermCertRead), certHandler.GetAllCertificates)
+			certificates.GET("/:certNumber", RequirePermission(permissions, service.PermCertRead), certHandler.GetCertificate)
+			certificates.PUT("/:certNumber", RequirePermission(permissions, service.PermCertUpdate), certHandler.UpdateCertificate)
+			certificates.PATCH("/:certNumber", RequirePermission(permissions, service.PermCertUpdate), certHandler.PatchCertificate)
+			certificates.DELETE("/:certNumber", RequirePermission(permissions, service.PermCertDelete), certHandler.DeleteCertificate)  // 确保这行没有反斜杠
+			certificates.POST("/:certNumber/verify", RequirePermission(permissions, service.PermCertVerify), certHandler.VerifyCertificate)
+			certificates.GET("/:certNumber/history", RequirePermission(permissions, service.PermCertRead), certHandler.GetCertificateHistory)
+			certificates.GET("/:certNumber/diff", RequirePermission(permissions, service.PermCertRead), certHandler.GetCertificateDiff)
+			certificates.GET("/:certNumber/evaluation", RequirePermission(permissions, service.PermCertRead), certHandler.GetCertificateEvaluation)
+			certificates.POST("/:certNumber/uncertainty", RequirePermission(permissions, service.PermCertUpdate), certHandler.CalculateUncertainty)
+			certificates.GET("/:certNumber/traceability", RequirePermission(permissions, service.PermCertRead), certHandler.GetCertificateTraceability)
+			certificates.POST("/:certNumber/traceability/anchor", RequirePermission(permissions, service.PermCertIssue), certHandler.AnchorCertificateTraceability)
+		}
+
+		// 委托方相关路由
+		customers := v1.Group("/customers")
+		customers.Use(authRequired)
 		{
-			certHandler := NewCertificateHandler(certService)
-			certificates.POST("", certHandler.CreateCertificate)
-			certificates.GET("", certHandler.GetAllCertificates)
-			certificates.GET("/:certNumber", certHandler.GetCertificate)
-			certificates.PUT("/:certNumber", certHandler.UpdateCertificate)
-			certificates.DELETE("/:certNumber", certHandler.DeleteCertificate)  // 确保这行没有反斜杠
-			certificates.POST("/:certNumber/verify", certHandler.VerifyCertificate)
-			certificates.GET("/:certNumber/history", certHandler.GetCertificateHistory)
+			customerHandler := NewCustomerHandler(customerService)
+			customers.GET("", RequirePermission(permissions, service.PermCustomerRead), customerHandler.GetCustomers)
+			customers.POST("", RequirePermission(permissions, service.PermCustomerWrite), IdempotencyMiddleware(idemService), customerHandler.CreateCustomer)
+			customers.GET("/:id", RequirePermission(permissions, service.PermCustomerRead), customerHandler.GetCustomer)
+			customers.PUT("/:id", RequirePermission(permissions, service.PermCustomerWrite), customerHandler.UpdateCustomer)
+			customers.DELETE("/:id", RequirePermission(permissions, service.PermCustomerWrite), customerHandler.DeleteCustomer)
+		}
+
+		// 检测设备相关路由
+		devices := v1.Group("/devices")
+		devices.Use(authRequired)
+		{
+			deviceHandler := NewDeviceHandler(deviceService)
+			devices.GET("", RequirePermission(permissions, service.PermDeviceRead), deviceHandler.GetDevices)
+			devices.POST("", RequirePermission(permissions, service.PermDeviceWrite), IdempotencyMiddleware(idemService), deviceHandler.CreateDevice)
+			devices.GET("/:id", RequirePermission(permissions, service.PermDeviceRead), deviceHandler.GetDevice)
+			devices.PUT("/:id", RequirePermission(permissions, service.PermDeviceWrite), deviceHandler.UpdateDevice)
+			devices.PUT("/:id/public-key", RequirePermission(permissions, service.PermDeviceWrite), deviceHandler.SetDevicePublicKey)
+			devices.DELETE("/:id", RequirePermission(permissions, service.PermDeviceWrite), deviceHandler.DeleteDevice)
 		}
 
 		// 测试数据相关路由
 		testData := v1.Group("/test-data")
-		testData.Use(AuthMiddleware())
+		testData.Use(authRequired)
 		{
 			testHandler := NewTestDataHandler(testDataService, certService)
-			testData.POST("", testHandler.AddTestData)
-			testData.GET("/certificate/:certId", testHandler.GetTestDataByCert)
+			testData.POST("", RequirePermission(permissions, service.PermTestDataWrite), IdempotencyMiddleware(idemService), testHandler.AddTestData)
+			testData.GET("/certificate/:certId", RequirePermission(permissions, service.PermTestDataRead), testHandler.GetTestDataByCert)
+			testData.GET("/:id/signature", RequirePermission(permissions, service.PermTestDataRead), testHandler.GetTestDataSignature)
+			testData.GET("/stream/:certNumber", RequirePermission(permissions, service.PermTestDataWrite), testHandler.StreamTestData)
+			testData.GET("/watch/:certNumber", RequirePermission(permissions, service.PermTestDataRead), testHandler.WatchTestData)
+		}
+
+		// 测试数据统计分析路由
+		analytics := v1.Group("/analytics")
+		analytics.Use(authRequired)
+		{
+			analyticsHandler := NewAnalyticsHandler(analyticsService)
+			analytics.GET("/anomalies", RequirePermission(permissions, service.PermTestDataRead), analyticsHandler.GetAnomalies)
 		}
 
 		// 公开验证接口（不需要认证）
 		public := v1.Group("/public")
 		{
-			public.GET("/verify/:certNumber", NewCertificateHandler(certService).PublicVerifyCertificate)
+			public.GET("/verify/:certNumber", NewCertificateHandler(certService, permissions, analyticsService).PublicVerifyCertificate)
 		}
 
-		// 系统管理相关路由（仅管理员）
+		// 系统管理相关路由（按权限控制，默认仅管理员）
 		admin := v1.Group("/admin")
-		admin.Use(AuthMiddleware(), AdminMiddleware())
+		admin.Use(authRequired)
 		{
-			adminHandler := NewAdminHandler(authService)
-			admin.GET("/users", adminHandler.GetAllUsers)
-			admin.POST("/users", adminHandler.CreateUser)
-			admin.PUT("/users/:id", adminHandler.UpdateUser)
-			admin.DELETE("/users/:id", adminHandler.DeleteUser)
+			adminHandler := NewAdminHandler(userService, apiKeyService)
+			manageUsers := RequirePermission(permissions, service.PermUserManage)
+			admin.GET("/users", manageUsers, adminHandler.GetAllUsers)
+			admin.POST("/users", manageUsers, adminHandler.CreateUser)
+			admin.PUT("/users/:id", manageUsers, adminHandler.UpdateUser)
+			admin.DELETE("/users/:id", manageUsers, adminHandler.DeleteUser)
+			admin.POST("/users/:id/reset-password", manageUsers, adminHandler.ResetPassword)
+			admin.POST("/users/:id/unlock", manageUsers, adminHandler.UnlockUser)
+			admin.POST("/users/:id/reset-2fa", manageUsers, adminHandler.ResetTwoFactor)
+			admin.GET("/login-attempts", RequirePermission(permissions, service.PermAuditRead), adminHandler.GetLoginAttempts)
+			admin.GET("/api-keys", manageUsers, adminHandler.GetAPIKeys)
+			admin.POST("/api-keys", manageUsers, adminHandler.CreateAPIKey)
+			admin.DELETE("/api-keys/:id", manageUsers, adminHandler.RevokeAPIKey)
 		}
 	}
 }
\ No newline at end of file
@@ -2,10 +2,11 @@ package api
 
 import (
     "cert-system/internal/service"
+    "errors"
     "cert-system/internal/models"
     "github.com/gin-gonic/gin"
     "net/http"
-    "time"
+    "strconv"
 )
 
 // TestDataHandler 测试数据处理器
@@ -33,6 +34,19 @@ func (h *TestDataHandler) AddTestData(c *gin.Context) {
         return
     }
 
+    // 绑定了设备的 API Key 只能提交该设备的测试数据
+    if boundDevice := c.GetString("apiKeyDeviceAddr"); boundDevice != "" {
+        for _, d := range req.Data {
+            if d.DeviceAddr != boundDevice {
+                c.JSON(http.StatusForbidden, models.APIResponse{
+                    Code:    403,
+                    Message: "API Key 绑定设备 " + boundDevice + "，不能提交设备 " + d.DeviceAddr + " 的测试数据",
+                })
+                return
+            }
+        }
+    }
+
     // 查证书
     cert, err := h.certService.GetCertificateByNumber(req.CertNumber)
     if err != nil || cert == nil {
@@ -44,29 +58,42 @@ func (h *TestDataHandler) AddTestData(c *gin.Context) {
     }
 
     var dataList []*models.TestData
-    for _, d := range req.Data {
-        t, err := time.Parse(time.RFC3339, d.TestTimestamp)
+    for i := range req.Data {
+        data, err := service.NewTestData(cert.ID, req.CertNumber, &req.Data[i])
         if err != nil {
             c.JSON(http.StatusBadRequest, models.APIResponse{
                 Code:    400,
-                Message: "testTimestamp 格式错误: " + err.Error(),
+                Message: err.Error(),
             })
             return
         }
-
-        dataList = append(dataList, &models.TestData{
-            CertID:           cert.ID,
-            CertNumber:       req.CertNumber,
-            DeviceAddr:       d.DeviceAddr,
-            TestPoint:        d.TestPoint,
-            ActualPercentage: d.ActualPercentage,
-            RatioError:       d.RatioError,
-            AngleError:       d.AngleError,
-            TestTimestamp:    t,
-        })
+        dataList = append(dataList, data)
     }
 
     if err := h.testDataService.BatchAddTestData(dataList); err != nil {
+        if errors.Is(err, service.ErrInvalidTestData) || errors.Is(err, service.ErrInvalidSignature) {
+            c.JSON(http.StatusBadRequest, models.APIResponse{
+                Code:    400,
+                Message: err.Error(),
+            })
+            return
+        }
+        // 设备未登记、维修中、已停用或超出校准有效期
+        if errors.Is(err, service.ErrDeviceNotFound) || errors.Is(err, service.ErrDeviceUnavailable) ||
+            errors.Is(err, service.ErrDeviceOutOfCalibration) {
+            c.JSON(http.StatusBadRequest, models.APIResponse{
+                Code:    400,
+                Message: "设备校验失败: " + err.Error(),
+            })
+            return
+        }
+        if errors.Is(err, service.ErrCertificateLocked) {
+            c.JSON(http.StatusConflict, models.APIResponse{
+                Code:    409,
+                Message: err.Error(),
+            })
+            return
+        }
         c.JSON(http.StatusInternalServerError, models.APIResponse{
             Code:    500,
             Message: "添加测试数据失败: " + err.Error(),
@@ -117,6 +144,40 @@ func (h *TestDataHandler) GetTestDataByCert(c *gin.Context) {
     })
 }
 
+// GetTestDataSignature 重新校验测试数据的设备签名，返回规范化读数、签名和签名时的设备公钥供第三方复核
+func (h *TestDataHandler) GetTestDataSignature(c *gin.Context) {
+    id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+    if err != nil || id < 1 {
+        c.JSON(http.StatusBadRequest, models.APIResponse{
+            Code:    400,
+            Message: "测试数据ID无效",
+        })
+        return
+    }
+
+    signature, err := h.testDataService.GetTestDataSignature(id)
+    if err != nil {
+        if errors.Is(err, service.ErrTestDataNotFound) {
+            c.JSON(http.StatusNotFound, models.APIResponse{
+                Code:    404,
+                Message: err.Error(),
+            })
+            return
+        }
+        c.JSON(http.StatusInternalServerError, models.APIResponse{
+            Code:    500,
+            Message: "校验设备签名失败: " + err.Error(),
+        })
+        return
+    }
+
+    c.JSON(http.StatusOK, models.APIResponse{
+        Code:    200,
+        Message: "获取设备签名成功",
+        Data:    signature,
+    })
+}
+
 // GenerateTestData 生成测试数据（占位逻辑）
 func (h *TestDataHandler) GenerateTestData(c *gin.Context) {
     c.JSON(http.StatusOK, models.APIResponse{
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bytes"
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// newTestDataHandlerRouter 创建检测中证书和在校准有效期内的设备 DEV001、DEV002，boundDevice 不为空时模拟绑定设备的 API Key
+func newTestDataHandlerRouter(t *testing.T, boundDevice string) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t, &models.Customer{}, &models.Certificate{}, &models.Device{}, &models.TestData{}, &models.CertificateHistory{})
+	customer := models.Customer{CustomerName: "测试委托方"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+	if err := db.Create(&models.Certificate{CertNumber: handlerTestCertNumber, CustomerID: customer.ID, InstrumentName: "电流互感器",
+		TestDate: time.Now(), ExpireDate: time.Now().AddDate(1, 0, 0), TestResult: "qualified", Status: "testing", Version: 1}).Error; err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+	due := time.Now().AddDate(1, 0, 0)
+	for _, addr := range []string{"DEV001", "DEV002"} {
+		device := &models.Device{DeviceAddr: addr, Status: service.DeviceStatusActive, CalibrationExternalRef: "省计量院 JZ-0001", CalibrationDueDate: &due}
+		if err := db.Create(device).Error; err != nil {
+			t.Fatalf("登记设备失败: %v", err)
+		}
+	}
+
+	client := &database.Client{DB: db}
+	certService := service.NewCertificateService(client, nil,
+		service.NewResultEvaluator(config.ErrorLimitConfig{}), service.NewUncertaintyCalculator(config.UncertaintyConfig{}))
+	handler := NewTestDataHandler(service.NewTestDataService(client, nil, nil), certService)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/test-data", func(c *gin.Context) {
+		if boundDevice != "" {
+			c.Set("apiKeyDeviceAddr", boundDevice)
+		}
+	}, handler.AddTestData)
+	return router, db
+}
+
+// postTestData 提交测试数据，返回响应和入库的测试数据条数
+func postTestData(t *testing.T, router *gin.Engine, db *gorm.DB, payload []byte) (*httptest.ResponseRecorder, int64) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test-data", bytes.NewReader(payload)))
+	var stored int64
+	if err := db.Model(&models.TestData{}).Count(&stored).Error; err != nil {
+		t.Fatalf("统计测试数据失败: %v", err)
+	}
+	return w, stored
+}
+
+func TestAddTestDataBoundDevice(t *testing.T) {
+	tests := []struct {
+		name        string
+		boundDevice string
+		devices     []string
+		wantCode    int
+		wantStored  int64
+	}{
+		{name: "用户令牌不限设备", devices: []string{"DEV001", "DEV002"}, wantCode: http.StatusCreated, wantStored: 2},
+		{name: "绑定设备提交本设备数据", boundDevice: "DEV001", devices: []string{"DEV001"}, wantCode: http.StatusCreated, wantStored: 1},
+		{name: "绑定设备提交其他设备数据", boundDevice: "DEV001", devices: []string{"DEV002"}, wantCode: http.StatusForbidden},
+		{name: "批量中混有其他设备数据", boundDevice: "DEV001", devices: []string{"DEV001", "DEV002"}, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, db := newTestDataHandlerRouter(t, tt.boundDevice)
+
+			req := models.AddTestDataRequest{CertNumber: handlerTestCertNumber}
+			for _, addr := range tt.devices {
+				req.Data = append(req.Data, models.TestDataPointRequest{
+					DeviceAddr: addr, DataType: "current", TestPoint: "100%", PercentageValue: 100, ActualPercentage: 100.02,
+					RatioError: 0.05, AngleError: 2, CurrentValue: 5, TestTimestamp: time.Now().Add(-time.Minute).Format(time.RFC3339),
+				})
+			}
+			payload, _ := json.Marshal(req)
+			w, stored := postTestData(t, router, db, payload)
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if stored != tt.wantStored {
+				t.Fatalf("期望入库 %d 条，实际 %d 条", tt.wantStored, stored)
+			}
+		})
+	}
+}
+
+func TestAddTestDataValidation(t *testing.T) {
+	timestamp := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	tests := []struct {
+		name     string
+		points   []map[string]interface{}
+		wantCode int
+		// wantStored 入库记录应有的列值，为空时期望不入库
+		wantStored map[string]interface{}
+	}{
+		{
+			name:       "电压互感器测试点按 V 保存",
+			points:     []map[string]interface{}{{"deviceAddr": "DEV001", "dataType": "voltage", "testPoint": "100%", "percentageValue": 100, "actualPercentage": 100.01, "voltageValue": 10, "voltageUnit": "kV", "workstationNumber": "WS-01", "testTimestamp": timestamp}},
+			wantCode:   http.StatusCreated,
+			wantStored: map[string]interface{}{"voltage_value": 10000.0, "current_value": 0.0, "workstation_number": "WS-01", "data_type": "voltage"},
+		},
+		{
+			name:       "电流互感器测试点按 A 保存",
+			points:     []map[string]interface{}{{"deviceAddr": "DEV001", "dataType": "current", "testPoint": "5%", "percentageValue": 5, "actualPercentage": 5.02, "currentValue": 250, "currentUnit": "mA", "testTimestamp": timestamp}},
+			wantCode:   http.StatusCreated,
+			wantStored: map[string]interface{}{"current_value": 0.25, "voltage_value": 0.0, "percentage_value": 5.0, "data_type": "current"},
+		},
+		{
+			name:     "缺少数据类型",
+			points:   []map[string]interface{}{{"deviceAddr": "DEV001", "testPoint": "100%", "actualPercentage": 100.02, "currentValue": 5, "testTimestamp": timestamp}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "数据类型无效",
+			points:   []map[string]interface{}{{"deviceAddr": "DEV001", "dataType": "power", "testPoint": "100%", "actualPercentage": 100.02, "currentValue": 5, "testTimestamp": timestamp}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "电压互感器测试点填写了电流",
+			points:   []map[string]interface{}{{"deviceAddr": "DEV001", "dataType": "voltage", "testPoint": "100%", "actualPercentage": 100.02, "voltageValue": 10, "currentValue": 5, "testTimestamp": timestamp}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "批量中任一测试点无效时全部不入库",
+			points: []map[string]interface{}{
+				{"deviceAddr": "DEV001", "dataType": "current", "testPoint": "100%", "actualPercentage": 100.02, "currentValue": 5, "testTimestamp": timestamp},
+				{"deviceAddr": "DEV001", "dataType": "current", "testPoint": "120%", "actualPercentage": 120.01, "currentValue": 6, "currentUnit": "MV", "testTimestamp": timestamp},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "工位号过长",
+			points:   []map[string]interface{}{{"deviceAddr": "DEV001", "dataType": "current", "testPoint": "100%", "actualPercentage": 100.02, "currentValue": 5, "workstationNumber": "WS-0123456789-0123456", "testTimestamp": timestamp}},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, db := newTestDataHandlerRouter(t, "")
+			payload, _ := json.Marshal(map[string]interface{}{"certNumber": handlerTestCertNumber, "data": tt.points})
+			w, stored := postTestData(t, router, db, payload)
+			if w.Code != tt.wantCode {
+				t.Fatalf("期望 %d，实际 %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantStored == nil {
+				if stored != 0 {
+					t.Fatalf("校验失败时不应入库，实际 %d 条", stored)
+				}
+				return
+			}
+
+			row := map[string]interface{}{}
+			if err := db.Model(&models.TestData{}).Take(&row).Error; err != nil {
+				t.Fatalf("查询测试数据失败: %v", err)
+			}
+			for column, want := range tt.wantStored {
+				if row[column] != want {
+					t.Fatalf("期望 %s=%v，实际 %v", column, want, row[column])
+				}
+			}
+		})
+	}
+}
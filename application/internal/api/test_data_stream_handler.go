@@ -0,0 +1,227 @@
+package api
+
+import (
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// streamIdleTimeout 工位连接在该时间内没有上传读数时断开
+	streamIdleTimeout = 2 * time.Minute
+	// streamWriteTimeout 发送确认消息的超时时间
+	streamWriteTimeout = 10 * time.Second
+	// watchHeartbeatInterval 实时查看连接的心跳间隔，防止代理因空闲断开连接
+	watchHeartbeatInterval = 30 * time.Second
+)
+
+// credentialCheckInterval 长连接重新校验会话或 API Key 的间隔，凭据被注销或吊销后最迟在该时间内断开
+var credentialCheckInterval = time.Minute
+
+// 读数确认状态
+const (
+	streamAckAccepted = "accepted"
+	streamAckRejected = "rejected"
+)
+
+// StreamTestData 工位通过 WebSocket 实时上传证书的测试读数：每条消息为一条读数，
+// 服务端逐条校验并入库，返回带相同 seq 的确认消息，单条读数被拒绝不影响后续读数
+func (h *TestDataHandler) StreamTestData(c *gin.Context) {
+	if !strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{Code: 400, Message: "该接口需要使用 WebSocket 连接"})
+		return
+	}
+
+	certNumber := c.Param("certNumber")
+	cert, err := h.certService.GetCertificateByNumber(certNumber)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书不存在: " + certNumber})
+		return
+	}
+	boundDevice := c.GetString("apiKeyDeviceAddr")
+	check := credentialCheck(c)
+
+	server := websocket.Server{
+		Handshake: checkStreamOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			stop := watchCredential(check, func(err error) {
+				log.Printf("证书 %s 的工位连接凭据已失效，断开连接: %v", cert.CertNumber, err)
+				ws.Close()
+			})
+			defer stop()
+			h.receiveReadings(ws, cert, boundDevice)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkStreamOrigin 校验 WebSocket 握手的来源。工位客户端通常不带 Origin，不做限制；
+// 浏览器发起的连接只允许同源，防止其他网站借用户浏览器中的凭据建立连接
+func checkStreamOrigin(_ *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(u.Host, req.Host) {
+		return fmt.Errorf("不允许来自 %s 的 WebSocket 连接", origin)
+	}
+	return nil
+}
+
+// credentialCheck 返回认证中间件登记的凭据校验函数，未登记时返回 nil
+func credentialCheck(c *gin.Context) func() error {
+	if v, ok := c.Get(credentialCheckKey); ok {
+		if check, ok := v.(func() error); ok {
+			return check
+		}
+	}
+	return nil
+}
+
+// checkCredential 重新校验长连接的凭据，会话已注销或 API Key 已失效时返回原因；
+// 数据库暂时不可用等其他错误只记录日志，不断开连接
+func checkCredential(check func() error) error {
+	if check == nil {
+		return nil
+	}
+	err := check()
+	if errors.Is(err, service.ErrSessionRevoked) || errors.Is(err, service.ErrInvalidAPIKey) {
+		return err
+	}
+	if err != nil {
+		log.Printf("校验长连接凭据失败: %v", err)
+	}
+	return nil
+}
+
+// watchCredential 按 credentialCheckInterval 定期校验凭据，失效时调用 onRevoked，返回停止校验的函数
+func watchCredential(check func() error, onRevoked func(error)) (stop func()) {
+	if check == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(credentialCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := checkCredential(check); err != nil {
+					onRevoked(err)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// receiveReadings 循环读取工位上传的读数直到连接关闭或空闲超时
+func (h *TestDataHandler) receiveReadings(ws *websocket.Conn, cert *models.Certificate, boundDevice string) {
+	for {
+		ws.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+		var reading models.TestDataStreamReading
+		var ack *models.TestDataStreamAck
+		if err := websocket.JSON.Receive(ws, &reading); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr) {
+				if err != io.EOF {
+					log.Printf("证书 %s 的工位连接断开: %v", cert.CertNumber, err)
+				}
+				return
+			}
+			ack = &models.TestDataStreamAck{Status: streamAckRejected, Message: "读数格式错误: " + err.Error()}
+		} else {
+			ack = h.acceptReading(cert, boundDevice, &reading)
+		}
+
+		ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := websocket.JSON.Send(ws, ack); err != nil {
+			log.Printf("向证书 %s 的工位发送确认失败: %v", cert.CertNumber, err)
+			return
+		}
+	}
+}
+
+// acceptReading 校验并保存单条读数，返回确认消息
+func (h *TestDataHandler) acceptReading(cert *models.Certificate, boundDevice string, reading *models.TestDataStreamReading) *models.TestDataStreamAck {
+	ack := &models.TestDataStreamAck{Seq: reading.Seq, Status: streamAckRejected}
+
+	// 绑定了设备的 API Key 只能提交该设备的测试数据
+	data, err := h.testDataService.IngestReading(cert, boundDevice, &reading.TestDataPointRequest, service.TestDataSourceStream)
+	if err != nil {
+		if service.IsRejectedReading(err) {
+			ack.Message = err.Error()
+		} else {
+			log.Printf("保存证书 %s 的实时测试数据失败: %v", cert.CertNumber, err)
+			ack.Message = "保存测试数据失败"
+		}
+		return ack
+	}
+
+	ack.Status = streamAckAccepted
+	ack.Data = data
+	return ack
+}
+
+// WatchTestData 以 Server-Sent Events 推送证书新入库的测试数据（批量上传和工位实时上传），
+// 供界面实时查看测试进度。连接建立时先发送 ready 事件，之后每批数据发送一个 testData 事件
+func (h *TestDataHandler) WatchTestData(c *gin.Context) {
+	certNumber := c.Param("certNumber")
+	if _, err := h.certService.GetCertificateByNumber(certNumber); err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{Code: 404, Message: "证书不存在: " + certNumber})
+		return
+	}
+
+	events, unsubscribe := h.testDataService.SubscribeTestData(certNumber)
+	defer unsubscribe()
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+	check := credentialCheck(c)
+	credentialTicker := time.NewTicker(credentialCheckInterval)
+	defer credentialTicker.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // 禁止 Nginx 缓冲事件流
+	c.SSEvent("ready", gin.H{"certNumber": certNumber})
+	c.Writer.Flush()
+
+	c.Stream(func(io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("testData", event)
+			return true
+		case t := <-heartbeat.C:
+			c.SSEvent("ping", t.Format(time.RFC3339))
+			return true
+		case <-credentialTicker.C:
+			// 会话注销或 API Key 吊销后通知客户端并结束推送
+			if err := checkCredential(check); err != nil {
+				c.SSEvent("revoked", gin.H{"message": err.Error()})
+				return false
+			}
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
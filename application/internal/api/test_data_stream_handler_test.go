@@ -0,0 +1,370 @@
+package api
+
+import (
+	"bufio"
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+	"gorm.io/gorm"
+)
+
+const streamTestCertNumber = "CT-2024-001"
+
+// newStreamTestServer 启动只挂载工位上传和实时查看接口的测试服务：数据库为内存 SQLite，登记 dev-1（登记了公钥）、
+// dev-2（未登记公钥）和一张草稿证书；boundDevice 非空时模拟绑定了该设备的 API Key，check 非空时作为认证中间件登记的凭据校验函数
+func newStreamTestServer(t *testing.T, key *ecdsa.PrivateKey, boundDevice string, check func() error) (*httptest.Server, *gorm.DB) {
+	t.Helper()
+	db := newTestDB(t, &models.Customer{}, &models.Certificate{}, &models.Device{}, &models.TestData{})
+
+	customer := models.Customer{CustomerName: "测试委托方"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+	if err := db.Create(&models.Certificate{
+		CertNumber:     streamTestCertNumber,
+		CustomerID:     customer.ID,
+		InstrumentName: "电流互感器",
+		TestDate:       time.Now(),
+		ExpireDate:     time.Now().AddDate(1, 0, 0),
+		TestResult:     "qualified",
+		Status:         "draft",
+		Version:        1,
+	}).Error; err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("编码公钥失败: %v", err)
+	}
+	due := time.Now().AddDate(1, 0, 0)
+	devices := []models.Device{
+		{DeviceAddr: "dev-1", PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), KeyAlgorithm: service.SignatureAlgorithmECDSA},
+		{DeviceAddr: "dev-2"},
+	}
+	for i := range devices {
+		devices[i].Status = service.DeviceStatusActive
+		devices[i].CalibrationExternalRef = "省计量院 JZ-0001"
+		devices[i].CalibrationDueDate = &due
+		if err := db.Create(&devices[i]).Error; err != nil {
+			t.Fatalf("登记设备失败: %v", err)
+		}
+	}
+
+	client := &database.Client{DB: db}
+	certService := service.NewCertificateService(client, nil,
+		service.NewResultEvaluator(config.ErrorLimitConfig{}), service.NewUncertaintyCalculator(config.UncertaintyConfig{}))
+	handler := NewTestDataHandler(service.NewTestDataService(client, nil, service.NewTestDataHub()), certService)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if boundDevice != "" {
+			c.Set("apiKeyDeviceAddr", boundDevice)
+		}
+		if check != nil {
+			c.Set(credentialCheckKey, check)
+		}
+	})
+	router.GET("/stream/:certNumber", handler.StreamTestData)
+	router.GET("/watch/:certNumber", handler.WatchTestData)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, db
+}
+
+// dialStream 以 WebSocket 连接工位上传接口
+func dialStream(t *testing.T, server *httptest.Server, certNumber string) *websocket.Conn {
+	t.Helper()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/stream/"+certNumber, "", server.URL)
+	if err != nil {
+		t.Fatalf("建立 WebSocket 连接失败: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// streamReading 生成一条电流互感器读数，key 不为 nil 时按规范化载荷签名
+func streamReading(t *testing.T, seq int64, deviceAddr string, key *ecdsa.PrivateKey) *models.TestDataStreamReading {
+	t.Helper()
+	reading := &models.TestDataStreamReading{Seq: seq, TestDataPointRequest: models.TestDataPointRequest{
+		DeviceAddr:       deviceAddr,
+		DataType:         service.TestDataTypeCurrent,
+		TestPoint:        "20%",
+		PercentageValue:  20,
+		ActualPercentage: 20.01,
+		RatioError:       -0.08,
+		AngleError:       3.2,
+		CurrentValue:     1,
+		TestTimestamp:    time.Now().UTC().Format(time.RFC3339),
+	}}
+	if key != nil {
+		data, err := service.NewTestData(0, streamTestCertNumber, &reading.TestDataPointRequest)
+		if err != nil {
+			t.Fatalf("构造读数失败: %v", err)
+		}
+		digest := sha256.Sum256([]byte(service.CanonicalTestDataPayload(data)))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("签名失败: %v", err)
+		}
+		reading.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	return reading
+}
+
+// exchange 发送一条消息（字符串原样发送，其他值序列化为 JSON）并读取确认
+func exchange(t *testing.T, ws *websocket.Conn, message interface{}) *models.TestDataStreamAck {
+	t.Helper()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+	var err error
+	if text, ok := message.(string); ok {
+		err = websocket.Message.Send(ws, text)
+	} else {
+		err = websocket.JSON.Send(ws, message)
+	}
+	if err != nil {
+		t.Fatalf("发送读数失败: %v", err)
+	}
+	var ack models.TestDataStreamAck
+	if err := websocket.JSON.Receive(ws, &ack); err != nil {
+		t.Fatalf("读取确认失败: %v", err)
+	}
+	return &ack
+}
+
+func TestStreamTestData(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	server, db := newStreamTestServer(t, key, "", nil)
+	ws := dialStream(t, server, streamTestCertNumber)
+
+	// 同一连接上逐条确认，被拒绝的读数不影响后续读数
+	tests := []struct {
+		name        string
+		message     interface{}
+		wantSeq     int64
+		wantStatus  string
+		wantMessage string
+	}{
+		{name: "签名读数", message: streamReading(t, 1, "dev-1", key), wantSeq: 1, wantStatus: streamAckAccepted},
+		{name: "未登记公钥的设备", message: streamReading(t, 2, "dev-2", nil), wantSeq: 2, wantStatus: streamAckAccepted},
+		{name: "登记了公钥但未签名", message: streamReading(t, 3, "dev-1", nil), wantSeq: 3, wantStatus: streamAckRejected, wantMessage: service.ErrInvalidSignature.Error()},
+		{name: "设备未登记", message: streamReading(t, 4, "dev-9", nil), wantSeq: 4, wantStatus: streamAckRejected, wantMessage: service.ErrDeviceNotFound.Error()},
+		{name: "格式错误", message: `{"seq": "x"`, wantStatus: streamAckRejected, wantMessage: "读数格式错误"},
+		{name: "测试点无效", message: func() *models.TestDataStreamReading {
+			r := streamReading(t, 5, "dev-2", nil)
+			r.CurrentValue = 0
+			return r
+		}(), wantSeq: 5, wantStatus: streamAckRejected, wantMessage: service.ErrInvalidTestData.Error()},
+		{name: "拒绝后继续接收", message: streamReading(t, 6, "dev-1", key), wantSeq: 6, wantStatus: streamAckAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := exchange(t, ws, tt.message)
+			if ack.Seq != tt.wantSeq || ack.Status != tt.wantStatus || !strings.Contains(ack.Message, tt.wantMessage) {
+				t.Fatalf("确认不符: %+v", ack)
+			}
+			if ack.Status == streamAckAccepted && (ack.Data == nil || ack.Data.ID == 0) {
+				t.Fatalf("接受的读数未返回入库数据: %+v", ack)
+			}
+		})
+	}
+
+	var count int64
+	if err := db.Model(&models.TestData{}).Count(&count).Error; err != nil {
+		t.Fatalf("统计测试数据失败: %v", err)
+	}
+	if count != 3 {
+		t.Fatalf("期望入库 3 条读数，实际 %d 条", count)
+	}
+}
+
+func TestStreamTestDataBoundDevice(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	server, _ := newStreamTestServer(t, key, "dev-1", nil)
+	ws := dialStream(t, server, streamTestCertNumber)
+
+	ack := exchange(t, ws, streamReading(t, 1, "dev-2", nil))
+	if ack.Status != streamAckRejected || !strings.Contains(ack.Message, service.ErrDeviceMismatch.Error()) {
+		t.Fatalf("绑定设备的 API Key 提交其他设备的读数应被拒绝: %+v", ack)
+	}
+
+	// 省略设备地址时使用绑定的设备
+	reading := streamReading(t, 2, "dev-1", key)
+	reading.DeviceAddr = ""
+	ack = exchange(t, ws, reading)
+	if ack.Status != streamAckAccepted || ack.Data.DeviceAddr != "dev-1" {
+		t.Fatalf("绑定设备的读数应被接受: %+v", ack)
+	}
+}
+
+func TestStreamTestDataRequiresWebSocket(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	server, _ := newStreamTestServer(t, key, "", nil)
+
+	resp, err := http.Get(server.URL + "/stream/" + streamTestCertNumber)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("普通 HTTP 请求期望 400，实际 %d", resp.StatusCode)
+	}
+
+	if _, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/stream/CT-MISSING", "", server.URL); err == nil {
+		t.Fatal("证书不存在时不应建立连接")
+	}
+}
+
+// shortenCredentialCheck 缩短凭据校验间隔，测试结束后恢复
+func shortenCredentialCheck(t *testing.T) {
+	t.Helper()
+	interval := credentialCheckInterval
+	credentialCheckInterval = 20 * time.Millisecond
+	t.Cleanup(func() { credentialCheckInterval = interval })
+}
+
+func TestStreamTestDataClosesOnRevokedCredential(t *testing.T) {
+	shortenCredentialCheck(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	var revoked atomic.Bool
+	server, _ := newStreamTestServer(t, key, "", func() error {
+		if revoked.Load() {
+			return service.ErrSessionRevoked
+		}
+		return nil
+	})
+	ws := dialStream(t, server, streamTestCertNumber)
+
+	if ack := exchange(t, ws, streamReading(t, 1, "dev-1", key)); ack.Status != streamAckAccepted {
+		t.Fatalf("会话有效时读数应被接受: %+v", ack)
+	}
+
+	// 会话注销后服务端主动断开连接
+	revoked.Store(true)
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ack models.TestDataStreamAck
+	err = websocket.JSON.Receive(ws, &ack)
+	if err == nil {
+		t.Fatalf("会话注销后连接应被关闭，实际收到 %+v", ack)
+	}
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("会话注销后连接未在校验间隔内关闭")
+	}
+}
+
+func TestStreamTestDataKeepsConnectionOnCheckFailure(t *testing.T) {
+	shortenCredentialCheck(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	// 数据库暂时不可用等错误不代表凭据失效，不断开连接
+	server, _ := newStreamTestServer(t, key, "", func() error { return errors.New("数据库连接超时") })
+	ws := dialStream(t, server, streamTestCertNumber)
+
+	time.Sleep(100 * time.Millisecond)
+	if ack := exchange(t, ws, streamReading(t, 1, "dev-1", key)); ack.Status != streamAckAccepted {
+		t.Fatalf("读数应被接受: %+v", ack)
+	}
+}
+
+func TestWatchTestDataClosesOnRevokedCredential(t *testing.T) {
+	shortenCredentialCheck(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	server, _ := newStreamTestServer(t, key, "", func() error { return service.ErrInvalidAPIKey })
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(server.URL + "/watch/" + streamTestCertNumber)
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// 先收到 ready 事件，凭据失效后收到 revoked 事件并结束推送
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event:"); ok {
+			events = append(events, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("读取事件流失败: %v", err)
+	}
+	if len(events) != 2 || events[0] != "ready" || events[1] != "revoked" {
+		t.Fatalf("事件序列不符: %v", events)
+	}
+}
+
+func TestCheckStreamOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		wantErr bool
+	}{
+		{name: "工位客户端不带 Origin", origin: ""},
+		{name: "同源", origin: "https://cert.example.com"},
+		{name: "同源大小写不同", origin: "https://CERT.example.com"},
+		{name: "其他网站", origin: "https://evil.example.com", wantErr: true},
+		{name: "端口不同", origin: "https://cert.example.com:8443", wantErr: true},
+		{name: "无法解析", origin: "://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://cert.example.com/api/v1/test-data/stream/"+streamTestCertNumber, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if err := checkStreamOrigin(nil, req); (err != nil) != tt.wantErr {
+				t.Fatalf("期望出错 %v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestStreamTestDataRejectsCrossOrigin(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	server, _ := newStreamTestServer(t, key, "", nil)
+
+	if _, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/stream/"+streamTestCertNumber, "", "https://evil.example.com"); err == nil {
+		t.Fatal("跨源的浏览器连接应被拒绝")
+	}
+}
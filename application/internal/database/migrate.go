@@ -0,0 +1,120 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles 数据库升级脚本，按文件名顺序执行，文件名去掉 .sql 后缀即为版本号
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockName 执行升级时持有的 MySQL 命名锁，防止多个实例同时升级
+const migrationLockName = "cert_system_schema_migrations"
+
+// migrationLockTimeout 等待其他实例完成升级的最长时间（秒）
+const migrationLockTimeout = 300
+
+// Migrate 按顺序执行尚未执行的升级脚本，将按旧版 init.sql 创建的数据库升级到当前结构，返回本次执行的版本。
+// 新安装时 init.sql 已创建当前结构并登记全部版本，不会重复执行。
+// MySQL 的 DDL 不能回滚，脚本中途失败时须人工处理后再启动
+func (c *Client) Migrate() ([]string, error) {
+	if c.DB.Dialector.Name() != "mysql" {
+		return migrate(c.DB, migrationFiles)
+	}
+
+	// 命名锁属于数据库连接，加锁、升级和解锁须使用同一连接
+	var applied []string
+	err := c.DB.Connection(func(conn *gorm.DB) error {
+		var locked int
+		if err := conn.Raw("SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeout).Scan(&locked).Error; err != nil {
+			return fmt.Errorf("获取数据库升级锁失败: %w", err)
+		}
+		if locked != 1 {
+			return fmt.Errorf("等待数据库升级锁超时")
+		}
+		defer conn.Exec("SELECT RELEASE_LOCK(?)", migrationLockName)
+
+		var err error
+		applied, err = migrate(conn, migrationFiles)
+		return err
+	})
+	return applied, err
+}
+
+// migrate 执行 fsys 中 migrations 目录下尚未登记的升级脚本，每个脚本执行完成后登记版本
+func migrate(db *gorm.DB, fsys fs.FS) ([]string, error) {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version VARCHAR(100) PRIMARY KEY,
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`).Error
+	if err != nil {
+		return nil, fmt.Errorf("创建升级版本表失败: %w", err)
+	}
+
+	var done []string
+	if err := db.Table("schema_migrations").Pluck("version", &done).Error; err != nil {
+		return nil, fmt.Errorf("查询已执行的升级脚本失败: %w", err)
+	}
+	doneSet := make(map[string]bool, len(done))
+	for _, version := range done {
+		doneSet[version] = true
+	}
+
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var applied []string
+	for _, file := range files {
+		version := strings.TrimSuffix(path.Base(file), ".sql")
+		if doneSet[version] {
+			continue
+		}
+		script, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return applied, err
+		}
+		for _, statement := range splitStatements(string(script)) {
+			if err := db.Exec(statement).Error; err != nil {
+				return applied, fmt.Errorf("执行升级脚本 %s 失败: %w", version, err)
+			}
+		}
+		if err := db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version).Error; err != nil {
+			return applied, fmt.Errorf("登记升级脚本 %s 失败: %w", version, err)
+		}
+		applied = append(applied, version)
+	}
+	return applied, nil
+}
+
+// splitStatements 按行尾分号拆分脚本中的语句并去掉整行注释，分号只能出现在语句末尾
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// initSQLPath 新安装时使用的建表脚本
+const initSQLPath = "../../../database/init.sql"
+
+// newMigrateTestDB 打开内存 SQLite 测试库
+func newMigrateTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// registeredVersions 返回已登记的升级版本
+func registeredVersions(t *testing.T, db *gorm.DB) []string {
+	t.Helper()
+	var versions []string
+	if err := db.Table("schema_migrations").Order("version ASC").Pluck("version", &versions).Error; err != nil {
+		t.Fatalf("查询升级版本失败: %v", err)
+	}
+	return versions
+}
+
+func TestMigrate(t *testing.T) {
+	db := newMigrateTestDB(t)
+	// 0002 依赖 0001 创建的表，须按文件名顺序执行
+	fsys := fstest.MapFS{
+		"migrations/0002_add_status.sql": {Data: []byte("-- 增加状态列\nALTER TABLE devices ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active';\nCREATE INDEX idx_devices_status ON devices(status);\n")},
+		"migrations/0001_devices.sql":    {Data: []byte("CREATE TABLE devices (\n    id INTEGER PRIMARY KEY,\n    device_addr VARCHAR(100)\n);\n")},
+		"migrations/README.md":           {Data: []byte("不是升级脚本")},
+	}
+
+	applied, err := migrate(db, fsys)
+	if err != nil {
+		t.Fatalf("升级失败: %v", err)
+	}
+	want := []string{"0001_devices", "0002_add_status"}
+	if !slices.Equal(applied, want) || !slices.Equal(registeredVersions(t, db), want) {
+		t.Fatalf("期望执行并登记 %v，实际执行 %v", want, applied)
+	}
+	if err := db.Exec("INSERT INTO devices (device_addr) VALUES ('DEV001')").Error; err != nil {
+		t.Fatalf("升级后写入失败: %v", err)
+	}
+
+	// 已登记的脚本不再执行，只执行新增的脚本
+	applied, err = migrate(db, fsys)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("重复升级不应执行脚本，实际 %v（%v）", applied, err)
+	}
+	fsys["migrations/0003_add_name.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE devices ADD COLUMN device_name VARCHAR(100);")}
+	applied, err = migrate(db, fsys)
+	if err != nil || !slices.Equal(applied, []string{"0003_add_name"}) {
+		t.Fatalf("期望只执行新增的脚本，实际 %v（%v）", applied, err)
+	}
+}
+
+func TestMigrateStopsOnFailure(t *testing.T) {
+	db := newMigrateTestDB(t)
+	fsys := fstest.MapFS{
+		"migrations/0001_devices.sql": {Data: []byte("CREATE TABLE devices (id INTEGER PRIMARY KEY);")},
+		"migrations/0002_broken.sql":  {Data: []byte("ALTER TABLE missing_table ADD COLUMN status VARCHAR(20);\nCREATE TABLE api_keys (id INTEGER PRIMARY KEY);")},
+		"migrations/0003_later.sql":   {Data: []byte("CREATE TABLE customers (id INTEGER PRIMARY KEY);")},
+	}
+
+	applied, err := migrate(db, fsys)
+	if err == nil || !strings.Contains(err.Error(), "0002_broken") {
+		t.Fatalf("期望报告失败的脚本 0002_broken，实际 %v", err)
+	}
+	if !slices.Equal(applied, []string{"0001_devices"}) || !slices.Equal(registeredVersions(t, db), []string{"0001_devices"}) {
+		t.Fatalf("只应登记失败前执行完成的脚本，实际 %v", applied)
+	}
+	for _, table := range []string{"api_keys", "customers"} {
+		if db.Migrator().HasTable(table) {
+			t.Fatalf("失败后不应继续执行，实际已创建 %s", table)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{name: "单条语句", script: "CREATE INDEX idx_a ON a(b);", want: []string{"CREATE INDEX idx_a ON a(b)"}},
+		{
+			name:   "多行语句和整行注释",
+			script: "-- 说明\nALTER TABLE a\n    ADD COLUMN b INT COMMENT '说明，含逗号',\n    ADD COLUMN c INT;\n\n-- 索引\nCREATE INDEX idx_b ON a(b);\n",
+			want:   []string{"ALTER TABLE a\n    ADD COLUMN b INT COMMENT '说明，含逗号',\n    ADD COLUMN c INT", "CREATE INDEX idx_b ON a(b)"},
+		},
+		{name: "末尾缺少分号", script: "CREATE INDEX idx_a ON a(b);\nCREATE INDEX idx_c ON a(c)\n", want: []string{"CREATE INDEX idx_a ON a(b)", "CREATE INDEX idx_c ON a(c)"}},
+		{name: "只有注释", script: "-- 没有语句\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !slices.Equal(got, tt.want) {
+				t.Fatalf("期望 %q，实际 %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestMigrationsRegisteredInInitSQL 新安装的数据库由 init.sql 直接建成当前结构，
+// 须登记全部升级脚本，否则启动时会对已有的列重复执行升级
+func TestMigrationsRegisteredInInitSQL(t *testing.T) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("读取升级脚本失败: %v", err)
+	}
+	var versions []string
+	for _, file := range files {
+		script, _ := fs.ReadFile(migrationFiles, file)
+		if len(splitStatements(string(script))) == 0 {
+			t.Fatalf("升级脚本 %s 没有语句", file)
+		}
+		versions = append(versions, strings.TrimSuffix(path.Base(file), ".sql"))
+	}
+
+	schema, err := os.ReadFile(initSQLPath)
+	if err != nil {
+		t.Fatalf("读取建表脚本失败: %v", err)
+	}
+	insert := regexp.MustCompile(`(?s)INSERT INTO schema_migrations \(version\) VALUES(.*?);`).FindSubmatch(schema)
+	if insert == nil {
+		t.Fatal("init.sql 未登记升级版本")
+	}
+	var registered []string
+	for _, m := range regexp.MustCompile(`'([^']+)'`).FindAllSubmatch(insert[1], -1) {
+		registered = append(registered, string(m[1]))
+	}
+	if !slices.Equal(registered, versions) {
+		t.Fatalf("init.sql 登记的版本 %v 与升级脚本 %v 不一致", registered, versions)
+	}
+}
@@ -0,0 +1,39 @@
+-- 用户账号：密码哈希改为自描述格式，增加账号状态、登录保护、两步验证和外部身份认证字段，
+-- 新增密码历史表和登录审计表
+ALTER TABLE users
+    MODIFY COLUMN password_hash VARCHAR(255) NOT NULL COMMENT '密码哈希（自描述格式，如 $argon2id$...）',
+    ADD COLUMN status ENUM('active', 'disabled') DEFAULT 'active' COMMENT '账号状态' AFTER role,
+    ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE COMMENT '下次登录必须修改密码' AFTER status,
+    ADD COLUMN password_changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '密码最后修改时间' AFTER must_change_password,
+    ADD COLUMN failed_login_count INT NOT NULL DEFAULT 0 COMMENT '连续登录失败次数' AFTER password_changed_at,
+    ADD COLUMN last_failed_login_at TIMESTAMP NULL COMMENT '最近一次登录失败时间' AFTER failed_login_count,
+    ADD COLUMN locked_until TIMESTAMP NULL COMMENT '锁定截止时间' AFTER last_failed_login_at,
+    ADD COLUMN totp_secret VARCHAR(64) NULL COMMENT 'TOTP 密钥（base32），启用前为待确认状态' AFTER locked_until,
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否已启用两步验证' AFTER totp_secret,
+    ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0 COMMENT '最近一次使用的 TOTP 时间步，防止验证码重放' AFTER totp_enabled,
+    ADD COLUMN auth_provider VARCHAR(20) NOT NULL DEFAULT 'local' COMMENT '认证方式: local / ldap / oidc' AFTER totp_last_counter,
+    ADD COLUMN external_id VARCHAR(255) NULL COMMENT '外部身份源中的唯一标识（LDAP DN / OIDC sub）' AFTER auth_provider;
+
+CREATE UNIQUE INDEX idx_users_external ON users(auth_provider, external_id);
+
+CREATE TABLE IF NOT EXISTS password_history (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    password_hash VARCHAR(255) NOT NULL COMMENT '历史密码哈希',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    username VARCHAR(100) NOT NULL COMMENT '登录时提交的用户名',
+    user_id BIGINT NULL COMMENT '匹配到的用户ID',
+    ip VARCHAR(64) NOT NULL COMMENT '客户端IP',
+    user_agent VARCHAR(255) COMMENT '客户端 User-Agent',
+    success BOOLEAN NOT NULL COMMENT '是否成功',
+    reason VARCHAR(50) COMMENT '失败原因',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_ip ON login_attempts(ip, created_at);
+CREATE INDEX idx_login_attempts_username ON login_attempts(username, created_at);
@@ -0,0 +1,56 @@
+-- 登录会话、刷新令牌、两步验证恢复码、登录挑战和外部身份认证授权请求
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id VARCHAR(64) PRIMARY KEY COMMENT '会话ID（访问令牌中的 sid）',
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    ip VARCHAR(64) COMMENT '登录IP',
+    user_agent VARCHAR(255) COMMENT '登录 User-Agent',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL COMMENT '最近一次刷新时间',
+    expires_at TIMESTAMP NULL COMMENT '会话过期时间',
+    revoked_at TIMESTAMP NULL COMMENT '吊销时间',
+    revoke_reason VARCHAR(50) COMMENT '吊销原因',
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_auth_sessions_user ON auth_sessions(user_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    session_id VARCHAR(64) NOT NULL COMMENT '所属会话ID',
+    token_hash CHAR(64) NOT NULL UNIQUE COMMENT '刷新令牌 SHA-256 哈希',
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    used_at TIMESTAMP NULL COMMENT '已用于刷新的时间，再次使用视为重放',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES auth_sessions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    code_hash CHAR(64) NOT NULL COMMENT '恢复码 SHA-256 哈希',
+    used_at TIMESTAMP NULL COMMENT '使用时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    token_hash CHAR(64) NOT NULL UNIQUE COMMENT '挑战令牌 SHA-256 哈希',
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    ip VARCHAR(64) COMMENT '登录IP',
+    user_agent VARCHAR(255) COMMENT '登录 User-Agent',
+    attempts INT NOT NULL DEFAULT 0 COMMENT '已尝试次数',
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sso_login_states (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    state_hash CHAR(64) NOT NULL UNIQUE COMMENT 'state 参数 SHA-256 哈希',
+    provider VARCHAR(20) NOT NULL COMMENT '认证提供方',
+    code_verifier VARCHAR(128) NOT NULL COMMENT 'PKCE 校验码',
+    nonce VARCHAR(64) NOT NULL COMMENT 'ID Token nonce',
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
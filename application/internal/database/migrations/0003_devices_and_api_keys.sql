@@ -0,0 +1,27 @@
+-- 检测设备增加状态、校准证书和签名公钥，新增 API Key 表。
+-- 已有设备升级后没有校准证书，须登记校准证书后才能继续上传测试数据
+ALTER TABLE devices
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active' COMMENT '设备状态: active, maintenance, retired' AFTER accuracy_class,
+    ADD COLUMN calibration_cert_number VARCHAR(100) NULL COMMENT '本系统签发的设备校准证书编号' AFTER status,
+    ADD COLUMN calibration_external_ref VARCHAR(200) COMMENT '外部机构出具的设备校准证书' AFTER calibration_cert_number,
+    ADD COLUMN calibration_due_date DATE NULL COMMENT '校准有效期截止日' AFTER calibration_external_ref,
+    ADD COLUMN public_key TEXT COMMENT '设备签名公钥（PEM），登记后测试数据须附带签名' AFTER calibration_due_date,
+    ADD COLUMN key_algorithm VARCHAR(20) COMMENT '签名算法: ECDSA-P256-SHA256, SM2-SM3' AFTER public_key;
+
+CREATE INDEX idx_devices_calibration_cert ON devices(calibration_cert_number);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL COMMENT '名称',
+    key_prefix VARCHAR(16) NOT NULL COMMENT '明文前缀，用于识别',
+    key_hash CHAR(64) NOT NULL UNIQUE COMMENT 'API Key SHA-256 哈希',
+    permissions VARCHAR(500) NOT NULL COMMENT '授予的权限，逗号分隔',
+    device_addr VARCHAR(100) NULL COMMENT '绑定的设备地址，绑定后只能提交该设备的测试数据',
+    created_by BIGINT NOT NULL COMMENT '创建人ID',
+    expires_at TIMESTAMP NULL COMMENT '过期时间，NULL 表示不过期',
+    last_used_at TIMESTAMP NULL COMMENT '最近使用时间',
+    last_used_ip VARCHAR(64) COMMENT '最近使用IP',
+    revoked_at TIMESTAMP NULL COMMENT '吊销时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
@@ -0,0 +1,25 @@
+-- 证书增加测量不确定度评定和乐观锁版本号，变更记录增加不确定度评定操作，新增幂等键记录表
+ALTER TABLE certificates
+    ADD COLUMN uncertainty_budget JSON COMMENT '测量不确定度评定结果' AFTER blockchain_hash,
+    ADD COLUMN uncertainty_stale BOOLEAN NOT NULL DEFAULT FALSE COMMENT '评定后又新增了测试数据，需重新评定' AFTER uncertainty_budget,
+    ADD COLUMN version INT NOT NULL DEFAULT 1 COMMENT '版本号（乐观锁）' AFTER status;
+
+ALTER TABLE certificate_history
+    MODIFY COLUMN operation_type ENUM('create', 'update', 'verify', 'issue', 'revoke', 'uncertainty') COMMENT '操作类型';
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    idem_key VARCHAR(255) NOT NULL COMMENT '客户端提供的 Idempotency-Key',
+    user_id BIGINT NOT NULL COMMENT '请求用户ID',
+    api_key_id BIGINT NOT NULL DEFAULT 0 COMMENT '请求使用的 API Key ID（0 表示用户令牌）',
+    method VARCHAR(10) NOT NULL COMMENT '请求方法',
+    path VARCHAR(255) NOT NULL COMMENT '请求路径',
+    request_hash VARCHAR(64) NOT NULL COMMENT '请求体哈希',
+    status_code INT NOT NULL DEFAULT 0 COMMENT '首次响应状态码（0 表示处理中）',
+    response_body MEDIUMTEXT COMMENT '首次响应内容',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    UNIQUE KEY uk_idempotency (idem_key, user_id, api_key_id, method, path)
+);
+
+CREATE INDEX idx_idempotency_expires ON idempotency_keys(expires_at);
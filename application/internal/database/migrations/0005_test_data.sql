@@ -0,0 +1,12 @@
+-- 测试数据记录上传时设备的校准证书、设备签名和上链状态。
+-- 升级前的测试数据上链状态为 none，不参与上链重试
+ALTER TABLE test_data
+    ADD COLUMN calibration_cert_number VARCHAR(100) NULL COMMENT '上传时设备的本系统校准证书编号' AFTER blockchain_hash,
+    ADD COLUMN calibration_external_ref VARCHAR(200) COMMENT '上传时设备的外部校准证书' AFTER calibration_cert_number,
+    ADD COLUMN signature VARCHAR(256) COMMENT '设备对规范化读数的签名（base64）' AFTER calibration_external_ref,
+    ADD COLUMN signature_algorithm VARCHAR(20) COMMENT '签名算法' AFTER signature,
+    ADD COLUMN signer_public_key TEXT COMMENT '签名时设备登记的公钥快照' AFTER signature_algorithm,
+    ADD COLUMN ledger_status ENUM('none', 'pending', 'confirmed', 'failed') NOT NULL DEFAULT 'none' COMMENT '上链状态' AFTER signer_public_key,
+    ADD COLUMN ledger_attempts INT NOT NULL DEFAULT 0 COMMENT '上链尝试次数' AFTER ledger_status,
+    ADD COLUMN ledger_error VARCHAR(500) COMMENT '最近一次上链失败的原因' AFTER ledger_attempts,
+    ADD INDEX idx_test_data_ledger_status (ledger_status);
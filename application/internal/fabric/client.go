@@ -60,6 +60,22 @@ func (c *Client) InvokeChaincode(function string, args [][]byte) ([]byte, error)
 	return response.Payload, nil
 }
 
+// SubmitTransaction 提交链码交易，返回交易ID和链码返回值
+func (c *Client) SubmitTransaction(function string, args [][]byte) (string, []byte, error) {
+	request := channel.Request{
+		ChaincodeID: c.ChaincodeName,
+		Fcn:         function,
+		Args:        args,
+	}
+
+	response, err := c.ChannelClient.Execute(request)
+	if err != nil {
+		return "", nil, fmt.Errorf("链码调用失败: %v", err)
+	}
+
+	return string(response.TransactionID), response.Payload, nil
+}
+
 // QueryChaincode 查询链码
 func (c *Client) QueryChaincode(function string, args [][]byte) ([]byte, error) {
 	request := channel.Request{
@@ -76,15 +92,15 @@ func (c *Client) QueryChaincode(function string, args [][]byte) ([]byte, error)
 	return response.Payload, nil
 }
 
-// CreateCertificate 在区块链上创建证书
-func (c *Client) CreateCertificate(cert interface{}) error {
+// CreateCertificate 在区块链上创建证书，返回交易ID
+func (c *Client) CreateCertificate(cert interface{}) (string, error) {
 	certJSON, err := json.Marshal(cert)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	_, err = c.InvokeChaincode("CreateCertificate", [][]byte{certJSON})
-	return err
+	txID, _, err := c.SubmitTransaction("CreateCertificate", [][]byte{certJSON})
+	return txID, err
 }
 
 // GetCertificate 从区块链获取证书
@@ -92,6 +108,22 @@ func (c *Client) GetCertificate(certNumber string) ([]byte, error) {
 	return c.QueryChaincode("GetCertificate", [][]byte{[]byte(certNumber)})
 }
 
+// UpdateCertificate 在区块链上更新证书，返回交易ID
+func (c *Client) UpdateCertificate(certNumber string, cert interface{}) (string, error) {
+	certJSON, err := json.Marshal(cert)
+	if err != nil {
+		return "", err
+	}
+
+	txID, _, err := c.SubmitTransaction("UpdateCertificate", [][]byte{[]byte(certNumber), certJSON})
+	return txID, err
+}
+
+// GetCertificateHistory 获取证书在账本上的变更历史
+func (c *Client) GetCertificateHistory(certNumber string) ([]byte, error) {
+	return c.QueryChaincode("GetCertificateHistory", [][]byte{[]byte(certNumber)})
+}
+
 // AddTestData 在区块链上添加测试数据
 func (c *Client) AddTestData(testData interface{}) error {
 	testDataJSON, err := json.Marshal(testData)
@@ -113,6 +145,38 @@ func (c *Client) VerifyCertificate(certNumber string) ([]byte, error) {
 	return c.QueryChaincode("VerifyCertificate", [][]byte{[]byte(certNumber)})
 }
 
+// AnchorTraceability 在区块链上锚定证书的溯源链，返回交易ID
+func (c *Client) AnchorTraceability(anchor interface{}) (string, error) {
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return "", err
+	}
+
+	txID, _, err := c.SubmitTransaction("AnchorTraceability", [][]byte{anchorJSON})
+	return txID, err
+}
+
+// GetTraceabilityAnchor 获取证书的溯源链锚定记录，未锚定时返回空
+func (c *Client) GetTraceabilityAnchor(certNumber string) ([]byte, error) {
+	return c.QueryChaincode("GetTraceabilityAnchor", [][]byte{[]byte(certNumber)})
+}
+
+// RegisterDeviceKey 在区块链上登记或更换设备签名公钥，返回交易ID
+func (c *Client) RegisterDeviceKey(key interface{}) (string, error) {
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+
+	txID, _, err := c.SubmitTransaction("RegisterDeviceKey", [][]byte{keyJSON})
+	return txID, err
+}
+
+// GetDeviceKey 获取账本上登记的设备签名公钥
+func (c *Client) GetDeviceKey(deviceAddr string) ([]byte, error) {
+	return c.QueryChaincode("GetDeviceKey", [][]byte{[]byte(deviceAddr)})
+}
+
 // Close 关闭客户端
 func (c *Client) Close() {
 	if c.SDK != nil {
@@ -1,52 +1,283 @@
 package helper
 
 import (
+	"cert-system/config"
 	"cert-system/internal/models"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
 	"fmt"
+	"math/big"
+	"os"
+	"sort"
 	"time"
+
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// JWTSecretKey 用于签署JWT的密钥
-// 在生产环境中，请将其放在环境变量或配置中心中
-var JWTSecretKey = []byte("your_super_secret_key")
+// defaultJWTKeyID 仅配置 secret 时使用的密钥ID
+const defaultJWTKeyID = "default"
+
+// jwtKey 一个签名密钥，signKey 为空时只能用于校验
+type jwtKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// JWK JSON Web Key（仅公钥）
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWTManager 签发和校验JWT令牌，支持多密钥轮换（通过 kid 区分）
+type JWTManager struct {
+	keys    map[string]*jwtKey
+	current *jwtKey
+	issuer  string
+	ttl     time.Duration
+}
+
+// NewJWTManager 根据配置创建 JWTManager
+func NewJWTManager(cfg config.JWTConfig) (*JWTManager, error) {
+	m := &JWTManager{
+		keys:   make(map[string]*jwtKey),
+		issuer: cfg.Issuer,
+		ttl:    cfg.TTLDuration(),
+	}
+
+	keyConfigs := cfg.Keys
+	currentID := cfg.CurrentKeyID
+	if len(keyConfigs) == 0 {
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("未配置JWT签名密钥")
+		}
+		keyConfigs = []config.JWTKeyConfig{{ID: defaultJWTKeyID, Algorithm: "HS256", Secret: cfg.Secret}}
+		currentID = defaultJWTKeyID
+	}
+	if currentID == "" {
+		currentID = keyConfigs[0].ID
+	}
+
+	for _, kc := range keyConfigs {
+		if kc.ID == "" {
+			return nil, fmt.Errorf("JWT密钥缺少 id")
+		}
+		if _, exists := m.keys[kc.ID]; exists {
+			return nil, fmt.Errorf("JWT密钥 %s 重复", kc.ID)
+		}
+		key, err := loadJWTKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("加载JWT密钥 %s 失败: %v", kc.ID, err)
+		}
+		m.keys[kc.ID] = key
+	}
+
+	m.current = m.keys[currentID]
+	if m.current == nil {
+		return nil, fmt.Errorf("当前JWT密钥 %s 不在密钥列表中", currentID)
+	}
+	if m.current.signKey == nil {
+		return nil, fmt.Errorf("当前JWT密钥 %s 缺少私钥，无法签发令牌", currentID)
+	}
+	return m, nil
+}
+
+// loadJWTKey 根据算法加载对称密钥或PEM格式的非对称密钥
+func loadJWTKey(kc config.JWTKeyConfig) (*jwtKey, error) {
+	key := &jwtKey{id: kc.ID}
+
+	switch kc.Algorithm {
+	case "HS256", "":
+		if kc.Secret == "" {
+			return nil, fmt.Errorf("HS256 密钥缺少 secret")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(kc.Secret)
+		key.verifyKey = []byte(kc.Secret)
 
-// GenerateJWT 生成JWT令牌
-func GenerateJWT(userID int64, username string, role string) (tokenString string, expirationTime time.Time, err error) {
-	expirationTime = time.Now().Add(24 * time.Hour) // 令牌有效期为24小时
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyPath != "" {
+			data, err := os.ReadFile(kc.PrivateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		}
+		if kc.PublicKeyPath != "" {
+			data, err := os.ReadFile(kc.PublicKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		}
+
+	case "ES256":
+		key.method = jwt.SigningMethodES256
+		if kc.PrivateKeyPath != "" {
+			data, err := os.ReadFile(kc.PrivateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseECPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		}
+		if kc.PublicKeyPath != "" {
+			data, err := os.ReadFile(kc.PublicKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseECPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		}
+		// ES256 只能使用 P-256 曲线，其他曲线的密钥签发的令牌无法被标准实现校验
+		if pub, ok := key.verifyKey.(*ecdsa.PublicKey); ok && pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 密钥必须使用 P-256 曲线，实际为 %s", pub.Curve.Params().Name)
+		}
+		if priv, ok := key.signKey.(*ecdsa.PrivateKey); ok && priv.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 私钥必须使用 P-256 曲线，实际为 %s", priv.Curve.Params().Name)
+		}
+
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s", kc.Algorithm)
+	}
+
+	if key.verifyKey == nil {
+		return nil, fmt.Errorf("%s 密钥需要配置 privateKeyPath 或 publicKeyPath", kc.Algorithm)
+	}
+	return key, nil
+}
+
+// TTL 返回令牌有效期
+func (m *JWTManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Generate 使用当前密钥为登录会话 sessionID 生成访问令牌，pwdChangeRequired 为 true 的令牌只能用于修改密码，
+// mfaSetupRequired 为 true 的令牌只能用于启用两步验证
+func (m *JWTManager) Generate(userID int64, username string, role string, sessionID string, pwdChangeRequired, mfaSetupRequired bool) (tokenString string, expirationTime time.Time, err error) {
+	now := time.Now()
+	expirationTime = now.Add(m.ttl)
 	claims := &models.JWTClaims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:                 userID,
+		Username:               username,
+		Role:                   role,
+		SessionID:              sessionID,
+		PasswordChangeRequired: pwdChangeRequired,
+		TwoFactorSetupRequired: mfaSetupRequired,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err = token.SignedString(JWTSecretKey)
+	token := jwt.NewWithClaims(m.current.method, claims)
+	token.Header["kid"] = m.current.id
+	tokenString, err = token.SignedString(m.current.signKey)
 	return
 }
 
-func ParseJWT(tokenString string) (*models.JWTClaims, error) {
-    token, err := jwt.ParseWithClaims(tokenString, &models.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-            return nil, fmt.Errorf("非法的签名方法: %v", token.Header["alg"])
-        }
-        return JWTSecretKey, nil
-    })
-
-    if err != nil {
-        return nil, fmt.Errorf("token解析失败: %v", err)
-    }
-
-    if claims, ok := token.Claims.(*models.JWTClaims); ok && token.Valid {
-        // 检查token是否过期
-        if time.Now().After(time.Unix(claims.ExpiresAt.Unix(), 0)) {
-            return nil, fmt.Errorf("token已过期")
-        }
-        return claims, nil
-    }
-    
-    return nil, fmt.Errorf("无效的token")
-}
\ No newline at end of file
+// Parse 校验并解析JWT令牌。根据 kid 选择密钥，未携带 kid 的旧令牌使用默认密钥校验
+func (m *JWTManager) Parse(tokenString string) (*models.JWTClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &models.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = defaultJWTKeyID
+		}
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("未知的密钥: %s", kid)
+		}
+		// 签名算法必须与密钥配置一致，防止算法混淆攻击
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("非法的签名方法: %v", token.Header["alg"])
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("token解析失败: %v", err)
+	}
+
+	claims, ok := token.Claims.(*models.JWTClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("无效的token")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("token缺少过期时间")
+	}
+	if m.issuer != "" && claims.Issuer != m.issuer {
+		return nil, fmt.Errorf("token签发方缺失或不匹配")
+	}
+	return claims, nil
+}
+
+// JWKS 返回所有非对称密钥的公钥，供其他服务校验本系统签发的令牌。对称密钥不会公开
+func (m *JWTManager) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range m.keys {
+		jwk, ok := publicJWK(key)
+		if ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// publicJWK 将公钥转换为 JWK 格式
+func publicJWK(key *jwtKey) (JWK, bool) {
+	jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+
+	switch pub := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// ES256 的 JWK 只能声明 P-256 曲线
+		if pub.Curve != elliptic.P256() {
+			return JWK{}, false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
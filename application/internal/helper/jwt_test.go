@@ -0,0 +1,299 @@
+package helper
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writePEM 将 DER 数据以 PEM 格式写入临时目录，返回文件路径
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("写入密钥文件失败: %v", err)
+	}
+	return path
+}
+
+// writeECKey 生成指定曲线的 ECDSA 密钥，返回私钥和私钥、公钥文件路径
+func writeECKey(t *testing.T, curve elliptic.Curve) (*ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("生成 ECDSA 密钥失败: %v", err)
+	}
+	privDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("编码私钥失败: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("编码公钥失败: %v", err)
+	}
+	return priv, writePEM(t, "ec.key", "EC PRIVATE KEY", privDER), writePEM(t, "ec.pub", "PUBLIC KEY", pubDER)
+}
+
+// writeRSAKey 生成 RSA 密钥，返回私钥、私钥文件路径和 PEM 格式的公钥
+func writeRSAKey(t *testing.T) (*rsa.PrivateKey, string, []byte) {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成 RSA 密钥失败: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("编码公钥失败: %v", err)
+	}
+	path := writePEM(t, "rsa.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv))
+	return priv, path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+}
+
+// signTestToken 以指定算法和 kid 签发令牌，kid 为空时不写入头部
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid, issuer string, key interface{}) string {
+	t.Helper()
+	claims := &models.JWTClaims{
+		UserID:    1,
+		Username:  "alice",
+		Role:      "operator",
+		SessionID: "session-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+	return tokenString
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	_, ecPath, _ := writeECKey(t, elliptic.P256())
+	before, err := NewJWTManager(config.JWTConfig{Keys: []config.JWTKeyConfig{
+		{ID: "2024-01", Algorithm: "HS256", Secret: "old-secret"},
+	}})
+	if err != nil {
+		t.Fatalf("创建轮换前的 JWTManager 失败: %v", err)
+	}
+	oldToken, _, err := before.Generate(1, "alice", "operator", "session-1", false, false)
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+
+	// 新密钥成为当前密钥，旧密钥保留在列表中继续校验
+	after, err := NewJWTManager(config.JWTConfig{CurrentKeyID: "2024-06", Keys: []config.JWTKeyConfig{
+		{ID: "2024-01", Algorithm: "HS256", Secret: "old-secret"},
+		{ID: "2024-06", Algorithm: "ES256", PrivateKeyPath: ecPath},
+	}})
+	if err != nil {
+		t.Fatalf("创建轮换后的 JWTManager 失败: %v", err)
+	}
+	newToken, _, err := after.Generate(1, "alice", "operator", "session-2", false, false)
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+	header, _, _ := strings.Cut(newToken, ".")
+	if data, _ := base64.RawURLEncoding.DecodeString(header); !strings.Contains(string(data), `"kid":"2024-06"`) {
+		t.Fatalf("新令牌应使用当前密钥签发: %s", data)
+	}
+
+	// 旧密钥从列表中移除即退役
+	retired, err := NewJWTManager(config.JWTConfig{Keys: []config.JWTKeyConfig{
+		{ID: "2024-06", Algorithm: "ES256", PrivateKeyPath: ecPath},
+	}})
+	if err != nil {
+		t.Fatalf("创建退役旧密钥后的 JWTManager 失败: %v", err)
+	}
+	legacy, err := NewJWTManager(config.JWTConfig{Secret: "legacy-secret"})
+	if err != nil {
+		t.Fatalf("创建仅配置 secret 的 JWTManager 失败: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		manager *JWTManager
+		token   string
+		wantErr bool
+	}{
+		{name: "旧密钥签发的令牌在轮换后仍有效", manager: after, token: oldToken},
+		{name: "新密钥签发的令牌", manager: after, token: newToken},
+		{name: "退役密钥签发的令牌", manager: retired, token: oldToken, wantErr: true},
+		{name: "未知 kid", manager: after, token: signTestToken(t, jwt.SigningMethodHS256, "2023-01", "", []byte("old-secret")), wantErr: true},
+		{name: "未携带 kid 的令牌使用默认密钥", manager: legacy, token: signTestToken(t, jwt.SigningMethodHS256, "", "", []byte("legacy-secret"))},
+		{name: "未携带 kid 且没有默认密钥", manager: after, token: signTestToken(t, jwt.SigningMethodHS256, "", "", []byte("old-secret")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.manager.Parse(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望出错 %v，实际 %v", tt.wantErr, err)
+			}
+			if err == nil && claims.Username != "alice" {
+				t.Fatalf("令牌声明不符: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWTAlgorithmConfusion(t *testing.T) {
+	rsaKey, rsaPath, rsaPublicPEM := writeRSAKey(t)
+	m, err := NewJWTManager(config.JWTConfig{Keys: []config.JWTKeyConfig{
+		{ID: "rsa", Algorithm: "RS256", PrivateKeyPath: rsaPath},
+		{ID: "hmac", Algorithm: "HS256", Secret: "shared-secret"},
+	}})
+	if err != nil {
+		t.Fatalf("创建 JWTManager 失败: %v", err)
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, &models.JWTClaims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}})
+	unsigned.Header["kid"] = "rsa"
+	noneToken, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("构造未签名令牌失败: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "RS256 密钥签发的令牌", token: signTestToken(t, jwt.SigningMethodRS256, "rsa", "", rsaKey)},
+		// 攻击者以公开的 RSA 公钥作为 HMAC 密钥伪造令牌
+		{name: "以公钥作 HS256 密钥", token: signTestToken(t, jwt.SigningMethodHS256, "rsa", "", rsaPublicPEM), wantErr: true},
+		{name: "HS256 密钥收到 RS256 令牌", token: signTestToken(t, jwt.SigningMethodRS256, "hmac", "", rsaKey), wantErr: true},
+		{name: "未签名令牌", token: noneToken, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.Parse(tt.token); (err != nil) != tt.wantErr {
+				t.Fatalf("期望出错 %v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJWTIssuer(t *testing.T) {
+	m, err := NewJWTManager(config.JWTConfig{Secret: "secret", Issuer: "cert-system"})
+	if err != nil {
+		t.Fatalf("创建 JWTManager 失败: %v", err)
+	}
+	issued, _, err := m.Generate(1, "alice", "operator", "session-1", false, false)
+	if err != nil {
+		t.Fatalf("签发令牌失败: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "本系统签发", token: issued},
+		{name: "签发方不符", token: signTestToken(t, jwt.SigningMethodHS256, defaultJWTKeyID, "other-system", []byte("secret")), wantErr: true},
+		{name: "缺少签发方", token: signTestToken(t, jwt.SigningMethodHS256, defaultJWTKeyID, "", []byte("secret")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := m.Parse(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("期望出错 %v，实际 %v", tt.wantErr, err)
+			}
+			if err == nil && claims.Issuer != "cert-system" {
+				t.Fatalf("签发方不符: %q", claims.Issuer)
+			}
+		})
+	}
+
+	// 未配置签发方时不校验 iss
+	open, err := NewJWTManager(config.JWTConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("创建 JWTManager 失败: %v", err)
+	}
+	if _, err := open.Parse(signTestToken(t, jwt.SigningMethodHS256, defaultJWTKeyID, "other-system", []byte("secret"))); err != nil {
+		t.Fatalf("未配置签发方时不应校验 iss: %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ecKey, _, ecPubPath := writeECKey(t, elliptic.P256())
+	rsaKey, rsaPath, _ := writeRSAKey(t)
+	m, err := NewJWTManager(config.JWTConfig{CurrentKeyID: "b-rsa", Keys: []config.JWTKeyConfig{
+		{ID: "c-hmac", Algorithm: "HS256", Secret: "secret"},
+		{ID: "b-rsa", Algorithm: "RS256", PrivateKeyPath: rsaPath},
+		{ID: "a-ec", Algorithm: "ES256", PublicKeyPath: ecPubPath},
+	}})
+	if err != nil {
+		t.Fatalf("创建 JWTManager 失败: %v", err)
+	}
+
+	// 对称密钥不公开，其余按 kid 排序
+	set := m.JWKS()
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "a-ec" || set.Keys[1].Kid != "b-rsa" {
+		t.Fatalf("JWKS 密钥不符: %+v", set.Keys)
+	}
+
+	ec := set.Keys[0]
+	if ec.Kty != "EC" || ec.Alg != "ES256" || ec.Crv != "P-256" || ec.Use != "sig" {
+		t.Fatalf("EC 公钥参数不符: %+v", ec)
+	}
+	x, _ := base64.RawURLEncoding.DecodeString(ec.X)
+	y, _ := base64.RawURLEncoding.DecodeString(ec.Y)
+	if len(x) != 32 || len(y) != 32 || new(big.Int).SetBytes(x).Cmp(ecKey.X) != 0 || new(big.Int).SetBytes(y).Cmp(ecKey.Y) != 0 {
+		t.Fatal("EC 公钥坐标不符")
+	}
+
+	rsaJWK := set.Keys[1]
+	n, _ := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rsaJWK.E)
+	if rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaKey.E) {
+		t.Fatalf("RSA 公钥参数不符: %+v", rsaJWK)
+	}
+
+	// 其他曲线的密钥即使绕过加载校验也不会出现在 JWKS 中
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成 P-384 密钥失败: %v", err)
+	}
+	if _, ok := publicJWK(&jwtKey{id: "p384", method: jwt.SigningMethodES256, verifyKey: &p384.PublicKey}); ok {
+		t.Fatal("非 P-256 曲线的密钥不应输出为 ES256 JWK")
+	}
+}
+
+func TestES256RequiresP256(t *testing.T) {
+	_, privPath, pubPath := writeECKey(t, elliptic.P384())
+	tests := []struct {
+		name string
+		key  config.JWTKeyConfig
+	}{
+		{name: "P-384 私钥", key: config.JWTKeyConfig{ID: "ec", Algorithm: "ES256", PrivateKeyPath: privPath}},
+		{name: "P-384 公钥", key: config.JWTKeyConfig{ID: "ec", Algorithm: "ES256", PublicKeyPath: pubPath}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadJWTKey(tt.key); err == nil || !strings.Contains(err.Error(), "P-256") {
+				t.Fatalf("期望拒绝非 P-256 曲线的密钥，实际 %v", err)
+			}
+		})
+	}
+}
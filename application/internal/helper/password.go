@@ -0,0 +1,224 @@
+package helper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHashFormat 无法识别的密码哈希格式
+var ErrUnknownHashFormat = errors.New("无法识别的密码哈希格式")
+
+// PasswordHasher 密码哈希算法。哈希结果为自描述格式，包含算法标识和参数
+type PasswordHasher interface {
+	// Hash 计算密码哈希
+	Hash(password string) (string, error)
+	// Matches 判断哈希是否由该算法生成
+	Matches(encoded string) bool
+	// Verify 校验密码；needsRehash 表示哈希参数弱于当前配置，应重新计算
+	Verify(password, encoded string) (ok bool, needsRehash bool, err error)
+}
+
+// 存储的 argon2id 哈希中允许的参数上限，防止异常参数导致计算时崩溃或耗尽资源
+const (
+	maxArgon2Memory      = 1024 * 1024 // KiB，即 1 GiB
+	maxArgon2Iterations  = 64
+	maxArgon2Parallelism = 64
+	minArgon2KeyLength   = 16
+	maxArgon2KeyLength   = 128
+)
+
+// Argon2idHasher argon2id 哈希，格式为 $argon2id$v=19$m=<KiB>,t=<迭代>,p=<并行度>$<盐>$<哈希>
+type Argon2idHasher struct {
+	Memory      uint32 // 内存开销（KiB）
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher 使用给定参数创建 argon2id 哈希算法，参数为 0 时使用默认值
+func NewArgon2idHasher(memory, iterations uint32, parallelism uint8) *Argon2idHasher {
+	h := &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	if memory > 0 {
+		h.Memory = memory
+	}
+	if iterations > 0 {
+		h.Iterations = iterations
+	}
+	if parallelism > 0 {
+		h.Parallelism = parallelism
+	}
+	return h
+}
+
+// Hash 计算 argon2id 哈希
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Matches 判断是否为 argon2id 哈希
+func (h *Argon2idHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// Verify 校验 argon2id 哈希
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("不支持的 argon2 版本: %s", parts[2])
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, fmt.Errorf("argon2 参数格式错误: %v", err)
+	}
+	if memory == 0 || memory > maxArgon2Memory || iterations == 0 || iterations > maxArgon2Iterations ||
+		parallelism == 0 || parallelism > maxArgon2Parallelism {
+		return false, false, fmt.Errorf("argon2 参数超出允许范围: %s", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("argon2 盐值格式错误: %v", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("argon2 哈希格式错误: %v", err)
+	}
+	if len(key) < minArgon2KeyLength || len(key) > maxArgon2KeyLength {
+		return false, false, fmt.Errorf("argon2 哈希长度无效: %d 字节", len(key))
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+	needsRehash := memory < h.Memory || iterations < h.Iterations || parallelism < h.Parallelism
+	return true, needsRehash, nil
+}
+
+// BcryptHasher bcrypt 哈希，格式为 $2a$<cost>$...
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher 创建 bcrypt 哈希算法，cost 为 0 时使用默认值
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash 计算 bcrypt 哈希
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+// Matches 判断是否为 bcrypt 哈希
+func (h *BcryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Verify 校验 bcrypt 哈希
+func (h *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost < h.Cost, nil
+}
+
+// LegacySHA256Hasher 旧版无盐 SHA-256 哈希（十六进制），仅用于校验历史数据，校验通过后总是需要重新哈希
+type LegacySHA256Hasher struct{}
+
+// Hash 计算无盐 SHA-256 哈希（仅为兼容保留，不应用于新密码）
+func (LegacySHA256Hasher) Hash(password string) (string, error) {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Matches 判断是否为 64 位十六进制的 SHA-256 哈希
+func (LegacySHA256Hasher) Matches(encoded string) bool {
+	if len(encoded) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(encoded)
+	return err == nil
+}
+
+// Verify 校验无盐 SHA-256 哈希
+func (h LegacySHA256Hasher) Verify(password, encoded string) (bool, bool, error) {
+	computed, _ := h.Hash(password)
+	ok := subtle.ConstantTimeCompare([]byte(computed), []byte(strings.ToLower(encoded))) == 1
+	return ok, ok, nil
+}
+
+// PasswordManager 根据哈希格式选择算法校验密码，并使用默认算法生成新哈希
+type PasswordManager struct {
+	current PasswordHasher
+	hashers []PasswordHasher
+}
+
+// NewPasswordManager 创建 PasswordManager，current 为新密码使用的算法，legacy 为仍可校验的其他算法
+func NewPasswordManager(current PasswordHasher, legacy ...PasswordHasher) *PasswordManager {
+	return &PasswordManager{
+		current: current,
+		hashers: append([]PasswordHasher{current}, legacy...),
+	}
+}
+
+// Hash 使用当前算法计算密码哈希
+func (m *PasswordManager) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+// Verify 校验密码，needsRehash 为 true 时调用方应使用 Hash 重新计算并保存
+func (m *PasswordManager) Verify(password, encoded string) (ok bool, needsRehash bool, err error) {
+	for _, h := range m.hashers {
+		if !h.Matches(encoded) {
+			continue
+		}
+		ok, needsRehash, err = h.Verify(password, encoded)
+		if h != m.current && ok {
+			needsRehash = true
+		}
+		return ok, needsRehash, err
+	}
+	return false, false, ErrUnknownHashFormat
+}
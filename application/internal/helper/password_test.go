@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// legacyAdminHash 为 init.sql 中 SHA2('admin123',256) 的结果
+const legacyAdminHash = "240be518fabd2724ddb6f04eeb1da5967448d7e831c08c8fa822809f74c720a9"
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := []struct {
+		name   string
+		hasher PasswordHasher
+		prefix string
+	}{
+		{name: "argon2id", hasher: NewArgon2idHasher(1024, 1, 1), prefix: "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{name: "bcrypt", hasher: NewBcryptHasher(bcrypt.MinCost), prefix: "$2a$04$"},
+	}
+	for _, tt := range hashers {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := tt.hasher.Hash("S3cret-pass")
+			if err != nil {
+				t.Fatalf("计算哈希失败: %v", err)
+			}
+			if !strings.HasPrefix(first, tt.prefix) || !tt.hasher.Matches(first) {
+				t.Fatalf("哈希格式不符: %s", first)
+			}
+			// 每次哈希使用新的随机盐
+			second, _ := tt.hasher.Hash("S3cret-pass")
+			if first == second {
+				t.Fatal("相同密码的两次哈希不应相同")
+			}
+			if ok, needsRehash, err := tt.hasher.Verify("S3cret-pass", first); !ok || needsRehash || err != nil {
+				t.Fatalf("正确密码校验失败: ok=%v needsRehash=%v err=%v", ok, needsRehash, err)
+			}
+			if ok, _, err := tt.hasher.Verify("wrong-pass", first); ok || err != nil {
+				t.Fatalf("错误密码不应通过: ok=%v err=%v", ok, err)
+			}
+			if tt.hasher.Matches(legacyAdminHash) {
+				t.Fatal("不应识别旧版 SHA-256 哈希")
+			}
+		})
+	}
+}
+
+func TestArgon2idVerifyRejectsMalformed(t *testing.T) {
+	h := NewArgon2idHasher(1024, 1, 1)
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{name: "段数不足", encoded: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA"},
+		{name: "版本不支持", encoded: "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$" + strings.Repeat("A", 43)},
+		{name: "内存超出上限", encoded: "$argon2id$v=19$m=4194304,t=1,p=1$c2FsdHNhbHQ$" + strings.Repeat("A", 43)},
+		{name: "迭代次数为 0", encoded: "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$" + strings.Repeat("A", 43)},
+		{name: "盐值格式错误", encoded: "$argon2id$v=19$m=1024,t=1,p=1$!!$" + strings.Repeat("A", 43)},
+		{name: "哈希过短", encoded: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$AAAA"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, _, err := h.Verify("S3cret-pass", tt.encoded); ok || err == nil {
+				t.Fatalf("期望拒绝异常哈希，实际 ok=%v err=%v", ok, err)
+			}
+		})
+	}
+}
+
+func TestPasswordManagerVerify(t *testing.T) {
+	weakArgon2, _ := NewArgon2idHasher(1024, 1, 1).Hash("S3cret-pass")
+	strongArgon2, _ := NewArgon2idHasher(2048, 1, 1).Hash("S3cret-pass")
+	bcryptHash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("S3cret-pass")
+
+	manager := NewPasswordManager(NewArgon2idHasher(2048, 1, 1), NewBcryptHasher(bcrypt.MinCost), LegacySHA256Hasher{})
+	tests := []struct {
+		name            string
+		password        string
+		encoded         string
+		wantOK          bool
+		wantNeedsRehash bool
+		wantErr         error
+	}{
+		{name: "当前算法和参数", password: "S3cret-pass", encoded: strongArgon2, wantOK: true},
+		{name: "当前算法参数较弱", password: "S3cret-pass", encoded: weakArgon2, wantOK: true, wantNeedsRehash: true},
+		{name: "其他算法", password: "S3cret-pass", encoded: bcryptHash, wantOK: true, wantNeedsRehash: true},
+		{name: "旧版 SHA-256", password: "admin123", encoded: legacyAdminHash, wantOK: true, wantNeedsRehash: true},
+		{name: "旧版 SHA-256 大写", password: "admin123", encoded: strings.ToUpper(legacyAdminHash), wantOK: true, wantNeedsRehash: true},
+		{name: "旧版 SHA-256 密码错误", password: "admin124", encoded: legacyAdminHash},
+		{name: "其他算法密码错误", password: "wrong-pass", encoded: bcryptHash},
+		{name: "无法识别的格式", password: "admin123", encoded: "plain-text", wantErr: ErrUnknownHashFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := manager.Verify(tt.password, tt.encoded)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if ok != tt.wantOK || needsRehash != tt.wantNeedsRehash {
+				t.Fatalf("期望 ok=%v needsRehash=%v，实际 ok=%v needsRehash=%v", tt.wantOK, tt.wantNeedsRehash, ok, needsRehash)
+			}
+		})
+	}
+
+	// 新密码总是使用当前算法
+	hash, err := manager.Hash("S3cret-pass")
+	if err != nil || !strings.HasPrefix(hash, "$argon2id$v=19$m=2048,") {
+		t.Fatalf("期望使用当前算法，实际 %s（%v）", hash, err)
+	}
+}
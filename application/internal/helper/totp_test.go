@@ -0,0 +1,103 @@
+package helper
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238 附录 B 测试向量的 SHA-1 密钥 "12345678901234567890"（base32）
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// 附录 B 给出 8 位验证码，6 位验证码取其后 6 位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, err := TOTPCode(rfc6238Secret, TOTPCounter(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatalf("计算验证码失败: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("期望 %s，实际 %s", tt.want, got)
+			}
+		})
+	}
+
+	// 小写和带填充的密钥同样可用
+	if got, _ := TOTPCode(strings.ToLower(rfc6238Secret)+"====", 1); got != "287082" {
+		t.Fatalf("小写带填充的密钥计算结果不符: %s", got)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Fatal("格式错误的密钥应返回错误")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := TOTPCounter(now)
+	codeAt := func(offset int64) string {
+		code, _ := TOTPCode(rfc6238Secret, counter+offset)
+		return code
+	}
+	tests := []struct {
+		name        string
+		code        string
+		wantOK      bool
+		wantCounter int64
+	}{
+		{name: "当前时间步", code: codeAt(0), wantOK: true, wantCounter: counter},
+		{name: "上一时间步", code: codeAt(-1), wantOK: true, wantCounter: counter - 1},
+		{name: "下一时间步", code: codeAt(1), wantOK: true, wantCounter: counter + 1},
+		{name: "超出允许偏差", code: codeAt(2)},
+		{name: "位数不符", code: codeAt(0)[:5]},
+		{name: "错误验证码", code: "000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(rfc6238Secret, tt.code, now, 1)
+			if ok != tt.wantOK || got != tt.wantCounter {
+				t.Fatalf("期望 ok=%v 时间步 %d，实际 ok=%v 时间步 %d", tt.wantOK, tt.wantCounter, ok, got)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	first, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	second, _ := GenerateTOTPSecret()
+	if len(first) != 32 || first == second {
+		t.Fatalf("期望 32 位随机 base32 密钥，实际 %s / %s", first, second)
+	}
+	if _, err := TOTPCode(first, 1); err != nil {
+		t.Fatalf("生成的密钥无法使用: %v", err)
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("计量证书系统", "alice@lab", rfc6238Secret)
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("URI 无法解析: %v", err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/计量证书系统:alice@lab" {
+		t.Fatalf("URI 格式不符: %s", uri)
+	}
+	query := parsed.Query()
+	if query.Get("secret") != rfc6238Secret || query.Get("issuer") != "计量证书系统" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Fatalf("URI 参数不符: %v", query)
+	}
+}
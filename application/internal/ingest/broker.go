@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"cert-system/config"
+	"fmt"
+	"log"
+
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// StartEmbeddedBroker 启动内置 MQTT Broker，用于本地联调和测试设备上报，
+// 配置了用户名时只允许该账号连接，否则允许任意客户端连接
+func StartEmbeddedBroker(cfg config.EmbeddedBrokerConfig) (*mqttserver.Server, error) {
+	server := mqttserver.New(nil)
+
+	var err error
+	if cfg.Username == "" {
+		err = server.AddHook(new(auth.AllowHook), nil)
+	} else {
+		err = server.AddHook(new(auth.Hook), &auth.Options{
+			Ledger: &auth.Ledger{
+				Auth: auth.AuthRules{
+					{Username: auth.RString(cfg.Username), Password: auth.RString(cfg.Password), Allow: true},
+				},
+			},
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("配置内置 MQTT Broker 认证失败: %w", err)
+	}
+
+	if err := server.AddListener(listeners.NewTCP("tcp", cfg.Address, nil)); err != nil {
+		return nil, fmt.Errorf("内置 MQTT Broker 监听 %s 失败: %w", cfg.Address, err)
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Printf("内置 MQTT Broker 运行失败: %v", err)
+		}
+	}()
+	return server, nil
+}
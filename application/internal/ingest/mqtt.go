@@ -0,0 +1,195 @@
+// Package ingest 接入检测设备通过消息中间件上报的测试数据
+package ingest
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"gorm.io/gorm"
+)
+
+// deviceAddrPlaceholder 主题配置中代表设备地址的占位符
+const deviceAddrPlaceholder = "{deviceAddr}"
+
+// ErrRejectedMessage 消息内容无法入库，重试也不会成功，转入死信主题
+var ErrRejectedMessage = errors.New("消息被拒绝")
+
+// MQTTBridge 订阅设备读数主题，将读数按与 HTTP 上传相同的规则校验入库，
+// 成功后向确认主题回复，无法入库的消息转入死信主题；数据库等临时故障时不确认消息，由 Broker 重新投递。
+// 主题中的设备地址只用于拒绝与主题不符的读数，读数须带有设备登记公钥对应的签名
+type MQTTBridge struct {
+	cfg             config.MQTTConfig
+	client          mqtt.Client
+	certService     *service.CertificateService
+	testDataService *service.TestDataService
+}
+
+// NewMQTTBridge 创建新的 MQTTBridge
+func NewMQTTBridge(cfg config.MQTTConfig, certService *service.CertificateService, testDataService *service.TestDataService) *MQTTBridge {
+	b := &MQTTBridge{
+		cfg:             cfg,
+		certService:     certService,
+		testDataService: testDataService,
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(false). // 保留会话，断线期间的 QoS 1/2 消息在重连后补投
+		SetAutoReconnect(true).
+		SetAutoAckDisabled(true). // 入库后再确认，临时故障时消息可重新投递
+		SetConnectTimeout(cfg.ConnectTimeoutDuration()).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			// 重连后重新订阅
+			if err := b.subscribe(client); err != nil {
+				log.Printf("订阅 MQTT 主题失败: %v", err)
+			}
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT 连接断开，等待重连: %v", err)
+		})
+	b.client = mqtt.NewClient(opts)
+	return b
+}
+
+// Start 连接 Broker 并订阅配置的主题
+func (b *MQTTBridge) Start() error {
+	if len(b.cfg.Topics) == 0 {
+		return errors.New("未配置 MQTT 订阅主题")
+	}
+	token := b.client.Connect()
+	if !token.WaitTimeout(b.cfg.ConnectTimeoutDuration()) {
+		return fmt.Errorf("连接 MQTT Broker %s 超时", b.cfg.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("连接 MQTT Broker %s 失败: %w", b.cfg.Broker, err)
+	}
+	return nil
+}
+
+// Stop 断开与 Broker 的连接
+func (b *MQTTBridge) Stop() {
+	b.client.Disconnect(250)
+}
+
+// subscribe 订阅全部读数主题，主题中的设备地址占位符替换为单层通配符
+func (b *MQTTBridge) subscribe(client mqtt.Client) error {
+	filters := make(map[string]byte, len(b.cfg.Topics))
+	for _, topic := range b.cfg.Topics {
+		filters[strings.ReplaceAll(topic, deviceAddrPlaceholder, "+")] = b.cfg.QoSOrDefault()
+	}
+	token := client.SubscribeMultiple(filters, b.handleMessage)
+	token.Wait()
+	return token.Error()
+}
+
+// handleMessage 处理一条读数消息
+func (b *MQTTBridge) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	deviceAddr := b.topicDevice(msg.Topic())
+
+	var reading models.MQTTReading
+	if err := json.Unmarshal(msg.Payload(), &reading); err != nil {
+		b.deadLetter(msg, fmt.Errorf("%w: 读数格式错误: %v", ErrRejectedMessage, err))
+		return
+	}
+
+	data, err := b.ingest(deviceAddr, &reading)
+	if err != nil {
+		if errors.Is(err, ErrRejectedMessage) || service.IsRejectedReading(err) {
+			b.deadLetter(msg, err)
+			return
+		}
+		// 临时故障不确认，Broker 在重连后重新投递
+		log.Printf("保存 MQTT 主题 %s 的测试数据失败: %v", msg.Topic(), err)
+		return
+	}
+
+	if deviceAddr == "" {
+		deviceAddr = data.DeviceAddr
+	}
+	b.publish(strings.ReplaceAll(b.cfg.AckTopic, deviceAddrPlaceholder, deviceAddr), &models.TestDataStreamAck{
+		Seq:    reading.Seq,
+		Status: "accepted",
+		Data:   data,
+	})
+	msg.Ack()
+}
+
+// ingest 查找读数所属证书并校验入库，主题中带设备地址时读数必须来自该设备
+func (b *MQTTBridge) ingest(deviceAddr string, reading *models.MQTTReading) (*models.TestData, error) {
+	if reading.CertNumber == "" {
+		return nil, fmt.Errorf("%w: 缺少证书编号", ErrRejectedMessage)
+	}
+	cert, err := b.certService.GetCertificateByNumber(reading.CertNumber)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: 证书不存在: %s", ErrRejectedMessage, reading.CertNumber)
+		}
+		return nil, err
+	}
+	return b.testDataService.IngestReading(cert, deviceAddr, &reading.TestDataPointRequest, service.TestDataSourceMQTT)
+}
+
+// topicDevice 从主题中取出设备地址，与配置的主题均不匹配或主题不含占位符时返回空
+func (b *MQTTBridge) topicDevice(topic string) string {
+	levels := strings.Split(topic, "/")
+	for _, pattern := range b.cfg.Topics {
+		patternLevels := strings.Split(pattern, "/")
+		if len(patternLevels) != len(levels) {
+			continue
+		}
+		device, matched := "", true
+		for i, level := range patternLevels {
+			switch {
+			case level == deviceAddrPlaceholder:
+				device = levels[i]
+			case level != levels[i]:
+				matched = false
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return device
+		}
+	}
+	return ""
+}
+
+// deadLetter 将无法入库的消息转发到死信主题并确认，避免反复投递
+func (b *MQTTBridge) deadLetter(msg mqtt.Message, reason error) {
+	log.Printf("MQTT 主题 %s 的消息无法入库: %v", msg.Topic(), reason)
+	if b.cfg.DeadLetterTopic != "" {
+		b.publish(b.cfg.DeadLetterTopic, &models.MQTTDeadLetter{
+			Topic:      msg.Topic(),
+			Payload:    string(msg.Payload()),
+			Reason:     reason.Error(),
+			ReceivedAt: time.Now(),
+		})
+	}
+	msg.Ack()
+}
+
+// publish 发布 JSON 消息。在消息回调中调用，不能等待发布完成，否则会阻塞客户端的消息分发
+func (b *MQTTBridge) publish(topic string, v interface{}) {
+	if topic == "" {
+		return
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("序列化 MQTT 消息失败: %v", err)
+		return
+	}
+	b.client.Publish(topic, b.cfg.QoSOrDefault(), false, payload)
+}
@@ -0,0 +1,325 @@
+package ingest
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"cert-system/internal/service"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testCertNumber = "CT-2024-001"
+
+// testDevice 测试用的检测设备，key 为 nil 时设备未登记公钥
+type testDevice struct {
+	addr string
+	key  *ecdsa.PrivateKey
+}
+
+// newTestServices 创建基于内存 SQLite 的证书和测试数据服务，登记设备（外部校准证书一年后到期）和一张草稿证书
+func newTestServices(t *testing.T, devices ...testDevice) (*service.CertificateService, *service.TestDataService, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.Customer{}, &models.Certificate{}, &models.Device{}, &models.TestData{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+
+	customer := models.Customer{CustomerName: "测试委托方"}
+	if err := db.Create(&customer).Error; err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+	cert := models.Certificate{
+		CertNumber:         testCertNumber,
+		CustomerID:         customer.ID,
+		InstrumentName:     "电流互感器",
+		InstrumentAccuracy: "0.2级",
+		TestDate:           time.Now(),
+		ExpireDate:         time.Now().AddDate(1, 0, 0),
+		TestResult:         "qualified",
+		Status:             "draft",
+		Version:            1,
+	}
+	if err := db.Create(&cert).Error; err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+
+	due := time.Now().AddDate(1, 0, 0)
+	for _, d := range devices {
+		device := models.Device{
+			DeviceAddr:             d.addr,
+			Status:                 service.DeviceStatusActive,
+			CalibrationExternalRef: "省计量院 JZ-0001",
+			CalibrationDueDate:     &due,
+		}
+		if d.key != nil {
+			der, err := x509.MarshalPKIXPublicKey(&d.key.PublicKey)
+			if err != nil {
+				t.Fatalf("编码公钥失败: %v", err)
+			}
+			device.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+			device.KeyAlgorithm = service.SignatureAlgorithmECDSA
+		}
+		if err := db.Create(&device).Error; err != nil {
+			t.Fatalf("登记设备失败: %v", err)
+		}
+	}
+
+	client := &database.Client{DB: db}
+	certService := service.NewCertificateService(client, nil,
+		service.NewResultEvaluator(config.ErrorLimitConfig{}), service.NewUncertaintyCalculator(config.UncertaintyConfig{}))
+	return certService, service.NewTestDataService(client, nil, service.NewTestDataHub()), db
+}
+
+// newReading 生成一条电流互感器读数，key 不为 nil 时按规范化载荷签名
+func newReading(t *testing.T, seq int64, deviceAddr string, key *ecdsa.PrivateKey) *models.MQTTReading {
+	t.Helper()
+	reading := &models.MQTTReading{CertNumber: testCertNumber}
+	reading.Seq = seq
+	reading.TestDataPointRequest = models.TestDataPointRequest{
+		DeviceAddr:       deviceAddr,
+		DataType:         service.TestDataTypeCurrent,
+		TestPoint:        "100%",
+		PercentageValue:  100,
+		ActualPercentage: 100.02,
+		RatioError:       0.05,
+		AngleError:       1.5,
+		CurrentValue:     5,
+		TestTimestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+	if key != nil {
+		data, err := service.NewTestData(0, testCertNumber, &reading.TestDataPointRequest)
+		if err != nil {
+			t.Fatalf("构造读数失败: %v", err)
+		}
+		digest := sha256.Sum256([]byte(service.CanonicalTestDataPayload(data)))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("签名失败: %v", err)
+		}
+		reading.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	return reading
+}
+
+// mqttHarness 内置 Broker、桥接和模拟设备的 MQTT 客户端，确认和死信消息分别收集到通道中
+type mqttHarness struct {
+	device      mqtt.Client
+	acks        chan *models.TestDataStreamAck
+	deadLetters chan *models.MQTTDeadLetter
+}
+
+func newMQTTHarness(t *testing.T, certService *service.CertificateService, testDataService *service.TestDataService) *mqttHarness {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("获取空闲端口失败: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	broker, err := StartEmbeddedBroker(config.EmbeddedBrokerConfig{Address: address})
+	if err != nil {
+		t.Fatalf("启动内置 Broker 失败: %v", err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	cfg := config.MQTTConfig{
+		Broker:          "tcp://" + address,
+		ClientID:        "cert-system-test",
+		Topics:          []string{"lab/{deviceAddr}/readings"},
+		QoS:             1,
+		AckTopic:        "lab/{deviceAddr}/ack",
+		DeadLetterTopic: "lab/dead-letter",
+		ConnectTimeout:  "5s",
+	}
+	bridge := NewMQTTBridge(cfg, certService, testDataService)
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("启动 MQTT 桥接失败: %v", err)
+	}
+	t.Cleanup(bridge.Stop)
+	// 桥接在连接回调中异步订阅，等订阅生效后再发布读数
+	deadline := time.Now().Add(5 * time.Second)
+	for len(broker.Topics.Subscribers("lab/probe/readings").Subscriptions) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("桥接未订阅读数主题")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h := &mqttHarness{
+		acks:        make(chan *models.TestDataStreamAck, 10),
+		deadLetters: make(chan *models.MQTTDeadLetter, 10),
+	}
+	h.device = mqtt.NewClient(mqtt.NewClientOptions().AddBroker(cfg.Broker).SetClientID("device-test"))
+	if token := h.device.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("设备连接 Broker 失败: %v", token.Error())
+	}
+	t.Cleanup(func() { h.device.Disconnect(100) })
+
+	token := h.device.SubscribeMultiple(map[string]byte{"lab/+/ack": 1, cfg.DeadLetterTopic: 1}, func(_ mqtt.Client, msg mqtt.Message) {
+		if msg.Topic() == cfg.DeadLetterTopic {
+			var dl models.MQTTDeadLetter
+			if err := json.Unmarshal(msg.Payload(), &dl); err == nil {
+				h.deadLetters <- &dl
+			}
+			return
+		}
+		var ack models.TestDataStreamAck
+		if err := json.Unmarshal(msg.Payload(), &ack); err == nil {
+			h.acks <- &ack
+		}
+	})
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("订阅确认主题失败: %v", token.Error())
+	}
+	return h
+}
+
+// publish 向读数主题发布消息，payload 为字符串时原样发送，否则序列化为 JSON
+func (h *mqttHarness) publish(t *testing.T, deviceAddr string, payload interface{}) {
+	t.Helper()
+	body, ok := payload.(string)
+	if !ok {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("序列化读数失败: %v", err)
+		}
+		body = string(raw)
+	}
+	token := h.device.Publish("lab/"+deviceAddr+"/readings", 1, false, body)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("发布读数失败: %v", token.Error())
+	}
+}
+
+func (h *mqttHarness) expectAck(t *testing.T) *models.TestDataStreamAck {
+	t.Helper()
+	select {
+	case ack := <-h.acks:
+		return ack
+	case dl := <-h.deadLetters:
+		t.Fatalf("期望确认消息，实际转入死信: %s", dl.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待确认消息超时")
+	}
+	return nil
+}
+
+func (h *mqttHarness) expectDeadLetter(t *testing.T, reasonContains string) {
+	t.Helper()
+	select {
+	case dl := <-h.deadLetters:
+		if !strings.Contains(dl.Reason, reasonContains) {
+			t.Fatalf("死信原因应包含 %q，实际 %q", reasonContains, dl.Reason)
+		}
+	case ack := <-h.acks:
+		t.Fatalf("期望转入死信，实际收到确认: %+v", ack)
+	case <-time.After(5 * time.Second):
+		t.Fatal("等待死信消息超时")
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	certService, testDataService, db := newTestServices(t,
+		testDevice{addr: "dev-1", key: key},
+		testDevice{addr: "dev-2", key: key},
+		testDevice{addr: "dev-3"},
+	)
+	h := newMQTTHarness(t, certService, testDataService)
+
+	t.Run("签名读数入库并确认", func(t *testing.T) {
+		// 读数省略设备地址，由主题中的设备地址补全
+		reading := newReading(t, 1, "dev-1", key)
+		reading.DeviceAddr = ""
+		h.publish(t, "dev-1", reading)
+
+		ack := h.expectAck(t)
+		if ack.Seq != 1 || ack.Status != "accepted" || ack.Data == nil || ack.Data.DeviceAddr != "dev-1" {
+			t.Fatalf("确认消息不符: %+v", ack)
+		}
+		var saved models.TestData
+		if err := db.First(&saved, ack.Data.ID).Error; err != nil {
+			t.Fatalf("读数未入库: %v", err)
+		}
+		if saved.SignatureAlgorithm != service.SignatureAlgorithmECDSA {
+			t.Fatalf("未记录签名算法: %+v", saved)
+		}
+	})
+
+	t.Run("读数设备与主题不符", func(t *testing.T) {
+		h.publish(t, "dev-1", newReading(t, 2, "dev-2", key))
+		h.expectDeadLetter(t, service.ErrDeviceMismatch.Error())
+	})
+
+	t.Run("缺少签名", func(t *testing.T) {
+		h.publish(t, "dev-1", newReading(t, 3, "dev-1", nil))
+		h.expectDeadLetter(t, service.ErrInvalidSignature.Error())
+	})
+
+	t.Run("签名与读数不符", func(t *testing.T) {
+		reading := newReading(t, 4, "dev-1", key)
+		reading.RatioError = 0.01
+		h.publish(t, "dev-1", reading)
+		h.expectDeadLetter(t, service.ErrInvalidSignature.Error())
+	})
+
+	t.Run("设备未登记公钥", func(t *testing.T) {
+		h.publish(t, "dev-3", newReading(t, 5, "dev-3", nil))
+		h.expectDeadLetter(t, "未登记公钥")
+	})
+
+	t.Run("读数格式错误", func(t *testing.T) {
+		h.publish(t, "dev-1", "{not json")
+		h.expectDeadLetter(t, "读数格式错误")
+	})
+
+	t.Run("证书不存在", func(t *testing.T) {
+		reading := newReading(t, 6, "dev-1", key)
+		reading.CertNumber = "CT-MISSING"
+		h.publish(t, "dev-1", reading)
+		h.expectDeadLetter(t, "证书不存在")
+	})
+
+	t.Run("证书已签发", func(t *testing.T) {
+		if err := db.Model(&models.Certificate{}).Where("cert_number = ?", testCertNumber).Update("status", "issued").Error; err != nil {
+			t.Fatalf("更新证书状态失败: %v", err)
+		}
+		h.publish(t, "dev-1", newReading(t, 7, "dev-1", key))
+		h.expectDeadLetter(t, service.ErrCertificateLocked.Error())
+	})
+
+	var count int64
+	if err := db.Model(&models.TestData{}).Count(&count).Error; err != nil {
+		t.Fatalf("统计测试数据失败: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("只有签名有效的读数应入库，实际入库 %d 条", count)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -24,12 +25,40 @@ type PagedResponse struct {
 }
 
 
-// HistoryRecord 历史记录
+// HistoryRecord 历史记录（合并数据库变更记录与账本历史后的时间线条目）
 type HistoryRecord struct {
-	TxID      string      `json:"txId"`
-	Value     interface{} `json:"value"`
-	Timestamp string      `json:"timestamp"`
-	IsDelete  bool        `json:"isDelete"`
+	TxID          string                 `json:"txId"`
+	Value         interface{}            `json:"value,omitempty"`
+	Timestamp     string                 `json:"timestamp"`
+	IsDelete      bool                   `json:"isDelete"`
+	Source        string                 `json:"source"` // database / ledger / both
+	OperationType string                 `json:"operationType,omitempty"`
+	ChangedFields []string               `json:"changedFields,omitempty"`
+	OldValues     map[string]interface{} `json:"oldValues,omitempty"`
+	NewValues     map[string]interface{} `json:"newValues,omitempty"`
+	OperatorID    int64                  `json:"operatorId,omitempty"`
+	OperatorName  string                 `json:"operatorName,omitempty"`
+}
+
+// FieldChange 单个字段的变更
+type FieldChange struct {
+	Field     string      `json:"field"`
+	OldValue  interface{} `json:"oldValue"`
+	NewValue  interface{} `json:"newValue"`
+	Immutable bool        `json:"immutable,omitempty"` // 该字段在签发后不应再变更
+}
+
+// CertificateDiff 证书两个账本版本之间的差异
+type CertificateDiff struct {
+	CertNumber          string        `json:"certNumber"`
+	FromTxID            string        `json:"fromTxId"`
+	ToTxID              string        `json:"toTxId"`
+	FromTimestamp       string        `json:"fromTimestamp"`
+	ToTimestamp         string        `json:"toTimestamp"`
+	FromStatus          string        `json:"fromStatus"`
+	Changes             []FieldChange `json:"changes"`
+	TestDataHashChanged bool          `json:"testDataHashChanged"`
+	ImmutableViolations []string      `json:"immutableViolations"` // 签发后被修改的不可变字段
 }
 
 // --- 请求/响应模型 ---
@@ -42,15 +71,32 @@ type LoginRequest struct {
 
 // LoginResponse 用户登录响应
 type LoginResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
-	Data    struct {
-		Token     string `json:"token"`
-		UserID    int64  `json:"userId"`
-		Username  string `json:"username"`
-		Role      string `json:"role"`
-		ExpiresAt string `json:"expiresAt"` // JWT 实际的过期时间字符串
-	} `json:"data"`
+	Code    int       `json:"code"`
+	Message string    `json:"message"`
+	Data    LoginData `json:"data"`
+}
+
+// LoginData 登录成功返回的令牌及用户信息
+type LoginData struct {
+	Token                  string   `json:"token"`
+	UserID                 int64    `json:"userId"`
+	Username               string   `json:"username"`
+	Role                   string   `json:"role"`
+	ExpiresAt              string   `json:"expiresAt"`                        // JWT 实际的过期时间字符串
+	RefreshToken           string   `json:"refreshToken"`                     // 刷新令牌，只能使用一次，刷新后返回新的刷新令牌
+	RefreshExpiresAt       string   `json:"refreshExpiresAt"`                 // 刷新令牌过期时间
+	PasswordChangeRequired bool     `json:"passwordChangeRequired"`           // 为 true 时必须先修改密码才能使用其他接口
+	PasswordChangeReason   string   `json:"passwordChangeReason,omitempty"`   // initial: 初始或重置密码; expired: 密码已过期
+	TwoFactorRequired      bool     `json:"twoFactorRequired,omitempty"`      // 为 true 时需使用 challengeToken 提交两步验证码完成登录
+	ChallengeToken         string   `json:"challengeToken,omitempty"`         // 登录第二步使用的挑战令牌
+	TwoFactorSetupRequired bool     `json:"twoFactorSetupRequired,omitempty"` // 当前角色必须启用两步验证，启用前只能访问启用相关接口
+	RecoveryCodes          []string `json:"recoveryCodes,omitempty"`          // 启用两步验证时生成的恢复码，只返回一次
+}
+
+// ChangePasswordRequest 修改密码请求
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
 }
 
 // CreateCertificateRequest 创建证书请求
@@ -77,12 +123,28 @@ type BatchTestDataRequest struct {
 
 // User 用户模型
 type User struct {
-	ID           int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
-	Username     string    `gorm:"column:username;uniqueIndex" json:"username"`
-	PasswordHash string    `gorm:"column:password_hash" json:"-"` // 存储密码哈希
-	Role         string    `gorm:"column:role" json:"role"`      // ENUM ('admin', 'operator', 'viewer')
-	CreatedAt    time.Time `gorm:"column:created_at" json:"createdAt"`
-	UpdatedAt    time.Time `gorm:"column:updated_at" json:"updatedAt"`
+	ID           int64  `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Username     string `gorm:"column:username;uniqueIndex" json:"username"`
+	PasswordHash string `gorm:"column:password_hash" json:"-"` // 存储密码哈希
+	Role         string `gorm:"column:role" json:"role"`       // ENUM ('admin', 'operator', 'viewer')
+	Status       string `gorm:"column:status" json:"status"`   // ENUM ('active', 'disabled')
+
+	MustChangePassword bool      `gorm:"column:must_change_password" json:"mustChangePassword"`
+	PasswordChangedAt  time.Time `gorm:"column:password_changed_at" json:"passwordChangedAt"`
+
+	FailedLoginCount  int        `gorm:"column:failed_login_count" json:"failedLoginCount"`
+	LastFailedLoginAt *time.Time `gorm:"column:last_failed_login_at" json:"lastFailedLoginAt"`
+	LockedUntil       *time.Time `gorm:"column:locked_until" json:"lockedUntil"`
+
+	TOTPSecret      *string `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabled     bool    `gorm:"column:totp_enabled" json:"totpEnabled"`
+	TOTPLastCounter int64   `gorm:"column:totp_last_counter" json:"-"`
+
+	AuthProvider string  `gorm:"column:auth_provider;default:local" json:"authProvider"` // local / ldap / oidc
+	ExternalID   *string `gorm:"column:external_id" json:"externalId,omitempty"`         // 外部身份源中的唯一标识（LDAP DN / OIDC sub）
+
+	CreatedAt time.Time `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updatedAt"`
 	// No DeletedAt field to match the provided schema without soft delete
 }
 
@@ -91,11 +153,192 @@ func (User) TableName() string {
 	return "users"
 }
 
+// AuthSession 登录会话模型，同一会话内轮换的刷新令牌属于同一令牌族
+type AuthSession struct {
+	ID           string     `gorm:"column:id;primaryKey" json:"id"`
+	UserID       int64      `gorm:"column:user_id" json:"userId"`
+	IP           string     `gorm:"column:ip" json:"ip"`
+	UserAgent    string     `gorm:"column:user_agent" json:"userAgent"`
+	CreatedAt    time.Time  `gorm:"column:created_at" json:"createdAt"`
+	LastUsedAt   *time.Time `gorm:"column:last_used_at" json:"lastUsedAt"`
+	ExpiresAt    time.Time  `gorm:"column:expires_at" json:"expiresAt"`
+	RevokedAt    *time.Time `gorm:"column:revoked_at" json:"revokedAt"`
+	RevokeReason string     `gorm:"column:revoke_reason" json:"revokeReason,omitempty"` // logout / refresh_reuse / password_changed / user_disabled / user_deleted
+}
+
+// TableName 指定表名
+func (AuthSession) TableName() string {
+	return "auth_sessions"
+}
+
+// RefreshToken 刷新令牌模型（只保存哈希）
+type RefreshToken struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	SessionID string     `gorm:"column:session_id"`
+	TokenHash string     `gorm:"column:token_hash"`
+	ExpiresAt time.Time  `gorm:"column:expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+// TableName 指定表名
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RefreshTokenRequest 刷新令牌请求
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// UserProfile 当前用户信息及其有效权限，供前端控制可用功能
+type UserProfile struct {
+	*User
+	Permissions []string `json:"permissions"`
+}
+
+// APIKey 机器客户端使用的 API Key 模型（只保存哈希）
+type APIKey struct {
+	ID          int64      `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Name        string     `gorm:"column:name" json:"name"`
+	KeyPrefix   string     `gorm:"column:key_prefix" json:"keyPrefix"`
+	KeyHash     string     `gorm:"column:key_hash" json:"-"`
+	Permissions string     `gorm:"column:permissions" json:"permissions"` // 逗号分隔
+	DeviceAddr  *string    `gorm:"column:device_addr" json:"deviceAddr"`
+	CreatedBy   int64      `gorm:"column:created_by" json:"createdBy"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at" json:"expiresAt"`
+	LastUsedAt  *time.Time `gorm:"column:last_used_at" json:"lastUsedAt"`
+	LastUsedIP  string     `gorm:"column:last_used_ip" json:"lastUsedIp"`
+	RevokedAt   *time.Time `gorm:"column:revoked_at" json:"revokedAt"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"createdAt"`
+}
+
+// TableName 指定表名
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// CreateAPIKeyResponse 创建 API Key 的响应，明文 Key 只在此时返回一次
+type CreateAPIKeyResponse struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+// RecoveryCode 两步验证恢复码模型（只保存哈希）
+type RecoveryCode struct {
+	ID        int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	UserID    int64      `gorm:"column:user_id"`
+	CodeHash  string     `gorm:"column:code_hash"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+// TableName 指定表名
+func (RecoveryCode) TableName() string {
+	return "user_recovery_codes"
+}
+
+// LoginChallenge 登录挑战模型，密码校验通过后等待两步验证码
+type LoginChallenge struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	TokenHash string    `gorm:"column:token_hash"`
+	UserID    int64     `gorm:"column:user_id"`
+	IP        string    `gorm:"column:ip"`
+	UserAgent string    `gorm:"column:user_agent"`
+	Attempts  int       `gorm:"column:attempts"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+// TableName 指定表名
+func (LoginChallenge) TableName() string {
+	return "login_challenges"
+}
+
+// SSOLoginState 跳转到外部身份提供方前保存的授权请求状态（state、PKCE 校验码、nonce）
+type SSOLoginState struct {
+	ID           int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	StateHash    string    `gorm:"column:state_hash"`
+	Provider     string    `gorm:"column:provider"`
+	CodeVerifier string    `gorm:"column:code_verifier"`
+	Nonce        string    `gorm:"column:nonce"`
+	ExpiresAt    time.Time `gorm:"column:expires_at"`
+	CreatedAt    time.Time `gorm:"column:created_at"`
+}
+
+// TableName 指定表名
+func (SSOLoginState) TableName() string {
+	return "sso_login_states"
+}
+
+// AuthProviderInfo 登录页可用的认证方式
+type AuthProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Redirect    bool   `json:"redirect"` // 为 true 时需跳转到身份提供方登录，否则使用用户名密码登录
+}
+
+// TwoFactorLoginRequest 登录第二步请求，code 为验证器应用中的 6 位验证码或恢复码
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// TwoFactorCodeRequest 提交两步验证码的请求
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest 关闭两步验证请求
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// TwoFactorEnrollment 开始启用两步验证时返回的密钥和扫码 URI
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// LoginAttempt 登录审计记录模型
+type LoginAttempt struct {
+	ID        int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	Username  string    `gorm:"column:username" json:"username"`
+	UserID    *int64    `gorm:"column:user_id" json:"userId"`
+	IP        string    `gorm:"column:ip" json:"ip"`
+	UserAgent string    `gorm:"column:user_agent" json:"userAgent"`
+	Success   bool      `gorm:"column:success" json:"success"`
+	Reason    string    `gorm:"column:reason" json:"reason,omitempty"` // bad_credentials / locked / throttled / ip_throttled / disabled / bad_second_factor / not_authorized / account_conflict
+	CreatedAt time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+// TableName 指定表名
+func (LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+// PasswordHistory 密码历史模型
+type PasswordHistory struct {
+	ID           int64     `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	UserID       int64     `gorm:"column:user_id" json:"userId"`
+	PasswordHash string    `gorm:"column:password_hash" json:"-"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"createdAt"`
+}
+
+// TableName 指定表名
+func (PasswordHistory) TableName() string {
+	return "password_history"
+}
+
 // JWTClaims JWT声明结构
 type JWTClaims struct {
-	UserID   int64  `json:"userId"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID                 int64  `json:"userId"`
+	Username               string `json:"username"`
+	Role                   string `json:"role"`
+	SessionID              string `json:"sid"`                         // 登录会话ID，会话被吊销后令牌立即失效
+	PasswordChangeRequired bool   `json:"pwdChangeRequired,omitempty"` // 必须修改密码的令牌只能访问修改密码等少数接口
+	TwoFactorSetupRequired bool   `json:"mfaSetupRequired,omitempty"`  // 必须先启用两步验证的令牌只能访问启用相关接口
 	jwt.RegisteredClaims
 }
 
@@ -115,9 +358,15 @@ type Certificate struct {
 	BlockchainTxID     string    `json:"blockchainTxId" gorm:"column:blockchain_tx_id"`
 	BlockchainHash     string    `json:"blockchainHash" gorm:"column:blockchain_hash"` // 新增
 	Status             string    `json:"status" gorm:"column:status"`
+	Version            int64     `json:"version" gorm:"column:version"` // 乐观锁版本号，每次更新加一
 	CreatedBy          int64     `json:"createdBy" gorm:"column:created_by"`
 	CreatedAt          time.Time `gorm:"column:created_at" json:"createdAt"`
 	UpdatedAt          time.Time `gorm:"column:updated_at" json:"updatedAt"`
+
+	// 测量不确定度评定，计入证书哈希
+	UncertaintyBudget *UncertaintyBudget `json:"uncertaintyBudget" gorm:"column:uncertainty_budget;serializer:json"`
+	// 评定后又新增了测试数据，评定结果已过时，重新评定前不能签发（不计入哈希）
+	UncertaintyStale bool `json:"uncertaintyStale" gorm:"column:uncertainty_stale"`
 	
 	Customer Customer `json:"customer" gorm:"foreignKey:CustomerID"`
 }
@@ -145,6 +394,46 @@ type VerificationResult struct {
 	Certificate    *Certificate `json:"certificate,omitempty"`
 }
 
+// CertificateHistory 证书变更记录模型
+type CertificateHistory struct {
+	ID             int64           `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	CertID         int64           `json:"certId" gorm:"column:cert_id"`
+	CertNumber     string          `json:"certNumber" gorm:"column:cert_number"`
+	OperationType  string          `json:"operationType" gorm:"column:operation_type"` // ENUM ('create', 'update', 'verify', 'issue', 'revoke', 'uncertainty')
+	ChangedFields  json.RawMessage `json:"changedFields" gorm:"column:changed_fields;type:json"`
+	OldValues      json.RawMessage `json:"oldValues" gorm:"column:old_values;type:json"`
+	NewValues      json.RawMessage `json:"newValues" gorm:"column:new_values;type:json"`
+	BlockchainTxID string          `json:"blockchainTxId" gorm:"column:blockchain_tx_id"`
+	OperatorID     int64           `json:"operatorId" gorm:"column:operator_id"`
+	OperatorName   string          `json:"operatorName" gorm:"->;column:operator_name"` // 仅查询时通过 JOIN users 填充
+	OperationTime  time.Time       `json:"operationTime" gorm:"column:operation_time"`
+}
+
+// TableName 指定表名
+func (CertificateHistory) TableName() string {
+	return "certificate_history"
+}
+
+// IdempotencyRecord 幂等键记录模型
+type IdempotencyRecord struct {
+	ID           int64     `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	Key          string    `json:"key" gorm:"column:idem_key"`
+	UserID       int64     `json:"userId" gorm:"column:user_id"`
+	APIKeyID     int64     `json:"apiKeyId" gorm:"column:api_key_id"` // 0 表示用户令牌，各 API Key 的幂等键互相隔离
+	Method       string    `json:"method" gorm:"column:method"`
+	Path         string    `json:"path" gorm:"column:path"`
+	RequestHash  string    `json:"requestHash" gorm:"column:request_hash"`
+	StatusCode   int       `json:"statusCode" gorm:"column:status_code"` // 0 表示首次请求仍在处理中
+	ResponseBody string    `json:"responseBody" gorm:"column:response_body"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"createdAt"`
+	ExpiresAt    time.Time `gorm:"column:expires_at" json:"expiresAt"`
+}
+
+// TableName 指定表名
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
 // Customer 客户模型
 type Customer struct {
 	ID              int64     `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
@@ -173,23 +462,121 @@ type TestData struct {
     ActualPercentage  float64   `json:"actualPercentage" gorm:"column:actual_percentage"`
     TestTimestamp     time.Time `json:"testTimestamp" gorm:"column:test_timestamp"`
     BlockchainHash    string    `json:"blockchainHash" gorm:"column:blockchain_hash"`
+    // 上传时设备登记的校准证书快照，用于追溯当时使用的测量标准
+    CalibrationCertNumber  *string `json:"calibrationCertNumber" gorm:"column:calibration_cert_number"`
+    CalibrationExternalRef string  `json:"calibrationExternalRef" gorm:"column:calibration_external_ref"`
+    // 设备对规范化读数的签名（base64），SignerPublicKey 为签名时设备登记的公钥快照，用于事后重新校验
+    Signature          string `json:"signature,omitempty" gorm:"column:signature"`
+    SignatureAlgorithm string `json:"signatureAlgorithm,omitempty" gorm:"column:signature_algorithm"`
+    SignerPublicKey    string `json:"-" gorm:"column:signer_public_key"`
+    // 上链状态：none 账本未启用 / pending 待上链 / confirmed 已上链 / failed 重试次数用尽，入库提交后再上链，失败的由后台重试
+    LedgerStatus   string `json:"ledgerStatus" gorm:"column:ledger_status;default:none"`
+    LedgerAttempts int    `json:"ledgerAttempts" gorm:"column:ledger_attempts"`
+    LedgerError    string `json:"ledgerError,omitempty" gorm:"column:ledger_error"`
     EncryptedData     string    `json:"-" gorm:"column:encrypted_data"` // 不返回给前端
     DecryptedData     string    `json:"decryptedData" gorm:"-"`        // 不存数据库
     CreatedAt         time.Time `gorm:"column:created_at" json:"createdAt"`
 }
 
+// TestDataSignature 测试数据的设备签名及重新校验结果，第三方可用其中的规范化载荷、签名和公钥自行校验
+type TestDataSignature struct {
+	TestDataID         int64  `json:"testDataId"`
+	CertNumber         string `json:"certNumber"`
+	DeviceAddr         string `json:"deviceAddr"`
+	Payload            string `json:"payload"` // 设备签名的规范化读数
+	Signature          string `json:"signature"`
+	SignatureAlgorithm string `json:"signatureAlgorithm"`
+	PublicKey          string `json:"publicKey"` // 签名时设备登记的公钥
+	Verified           bool   `json:"verified"`
+	Message            string `json:"message,omitempty"` // 未签名或校验失败的原因
+}
+
+// TestDataStatistics 同一设备或工位、同一数据类型的测试数据某项误差的统计量
+type TestDataStatistics struct {
+	Scope           string   `json:"scope"` // device 按设备 / workstation 按工位
+	Key             string   `json:"key"`   // 设备地址或工位号
+	DataType        string   `json:"dataType"`
+	PercentageValue float64  `json:"percentageValue"` // 额定百分点
+	Metric          string   `json:"metric"`          // ratioError 比值差 / angleError 相位差
+	Count           int      `json:"count"`
+	Mean            float64  `json:"mean"`
+	StdDev          float64  `json:"stdDev"`
+	UCL             *float64 `json:"ucl"` // 上控制限，数据点不足时为空
+	LCL             *float64 `json:"lcl"` // 下控制限
+}
+
+// TestDataAnomaly 被标记为可疑的测试数据
+type TestDataAnomaly struct {
+	Type              string    `json:"type"` // duplicate / out_of_control / drift / impossible_timestamp
+	TestDataID        int64     `json:"testDataId"`
+	CertNumber        string    `json:"certNumber"`
+	DeviceAddr        string    `json:"deviceAddr"`
+	WorkstationNumber string    `json:"workstationNumber"`
+	TestPoint         string    `json:"testPoint"`
+	TestTimestamp     time.Time `json:"testTimestamp"`
+	Message           string    `json:"message"`
+	RelatedIDs        []int64   `json:"relatedIds,omitempty"` // 与之重复的其他测试数据
+}
+
+// AnomalyReport 测试数据统计分析和异常检测结果
+type AnomalyReport struct {
+	From         time.Time             `json:"from"`
+	To           time.Time             `json:"to"`
+	Total        int                   `json:"total"` // 参与分析的测试数据条数
+	Statistics   []*TestDataStatistics `json:"statistics"`
+	Anomalies    []*TestDataAnomaly    `json:"anomalies"`    // 当前页的可疑数据
+	AnomalyTotal int                   `json:"anomalyTotal"` // 可疑数据总数
+	Page         int                   `json:"page"`
+	PageSize     int                   `json:"pageSize"`
+	TotalPages   int                   `json:"totalPages"`
+	Summary      map[string]int        `json:"summary"` // 各类异常的数量
+	GeneratedAt  time.Time             `json:"generatedAt"`
+}
+
+// TestDataEvent 推送给实时查看者的测试数据入库事件
+type TestDataEvent struct {
+	CertNumber string      `json:"certNumber"`
+	Source     string      `json:"source"` // batch 批量上传 / stream 工位实时上传 / mqtt 设备 MQTT 上报
+	Data       []*TestData `json:"data"`
+	Timestamp  time.Time   `json:"timestamp"`
+}
+
+// MQTTDeadLetter 无法入库的 MQTT 消息，转发到死信主题供人工排查
+type MQTTDeadLetter struct {
+	Topic      string    `json:"topic"`
+	Payload    string    `json:"payload"`
+	Reason     string    `json:"reason"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+// TestDataStreamAck 工位实时上传或设备 MQTT 上报时服务端对每条读数的确认
+type TestDataStreamAck struct {
+	Seq     int64     `json:"seq"`
+	Status  string    `json:"status"` // accepted / rejected
+	Message string    `json:"message,omitempty"`
+	Data    *TestData `json:"data,omitempty"`
+}
+
 
 
 // Device 设备模型
+// 设备自身的校准证书二选一：本系统签发的证书（CalibrationCertNumber，有效期取该证书的有效期）
+// 或外部机构出具的证书（CalibrationExternalRef，有效期为 CalibrationDueDate）
 type Device struct {
-	ID            int64     `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
-	DeviceAddr    string    `json:"deviceAddr" gorm:"column:device_addr"`
-	DeviceName    string    `json:"deviceName" gorm:"column:device_name"`
-	Manufacturer  string    `json:"manufacturer" gorm:"column:manufacturer"`
-	Model         string    `json:"model" gorm:"column:model"`
-	AccuracyClass string    `json:"accuracyClass" gorm:"column:accuracy_class"`
-	CreatedAt     time.Time `gorm:"column:created_at" json:"createdAt"`
-	UpdatedAt     time.Time `gorm:"column:updated_at" json:"updatedAt"`
+	ID                     int64      `json:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	DeviceAddr             string     `json:"deviceAddr" gorm:"column:device_addr"`
+	DeviceName             string     `json:"deviceName" gorm:"column:device_name"`
+	Manufacturer           string     `json:"manufacturer" gorm:"column:manufacturer"`
+	Model                  string     `json:"model" gorm:"column:model"`
+	AccuracyClass          string     `json:"accuracyClass" gorm:"column:accuracy_class"`
+	Status                 string     `json:"status" gorm:"column:status;default:active"`                    // active / maintenance / retired
+	CalibrationCertNumber  *string    `json:"calibrationCertNumber" gorm:"column:calibration_cert_number"`   // 本系统签发的校准证书编号
+	CalibrationExternalRef string     `json:"calibrationExternalRef" gorm:"column:calibration_external_ref"` // 外部校准证书，如 "机构名称 证书编号"
+	CalibrationDueDate     *time.Time `json:"calibrationDueDate" gorm:"column:calibration_due_date"`         // 校准有效期截止日（含当日）
+	PublicKey              string     `json:"publicKey,omitempty" gorm:"column:public_key"`                  // 设备签名公钥（PEM），登记后该设备的测试数据须附带签名
+	KeyAlgorithm           string     `json:"keyAlgorithm,omitempty" gorm:"column:key_algorithm"`            // ECDSA-P256-SHA256 / SM2-SM3
+	CreatedAt              time.Time  `gorm:"column:created_at" json:"createdAt"`
+	UpdatedAt              time.Time  `gorm:"column:updated_at" json:"updatedAt"`
 }
 
 // BlockchainTransaction 区块链交易模型
@@ -204,6 +591,13 @@ type BlockchainTransaction struct {
 	CreatedAt       time.Time `gorm:"column:created_at" json:"createdAt"`
 }
 
+// BlockchainDeviceKey 登记到账本的设备签名公钥，链码用其校验上链测试数据的签名
+type BlockchainDeviceKey struct {
+	DeviceAddr string `json:"deviceAddr"`
+	PublicKey  string `json:"publicKey"`
+	Algorithm  string `json:"algorithm"`
+}
+
 // BlockchainCertificate 区块链证书模型
 type BlockchainCertificate struct {
 	CertNumber         string  `json:"certNumber"`
@@ -218,6 +612,8 @@ type BlockchainCertificate struct {
 	ExpireDate         string  `json:"expireDate"`
 	TestResult         string  `json:"testResult"`
 	Status             string  `json:"status"`
+	Version            int64   `json:"version"`
+	UncertaintyHash    string  `json:"uncertaintyHash,omitempty"` // 不确定度评定的哈希
 }
 
 // BlockchainTestData 区块链测试数据模型
@@ -234,5 +630,125 @@ type BlockchainTestData struct {
 	TestPoint         string  `json:"testPoint"`
 	ActualPercentage  float64 `json:"actualPercentage"`
 	TestTimestamp     string  `json:"testTimestamp"`
+	RecordID          int64   `json:"recordId"`            // 数据库记录ID，链码以其为键，重复提交同一记录不会重复上链
+	Signature         string  `json:"signature,omitempty"` // 设备签名，链码按账本登记的公钥校验
 	EncryptedData     string  `json:"encryptedData"`
-}
\ No newline at end of file
+}
+// CertificateTraceability 证书的量值溯源链：证书 -> 使用的检测设备 -> 设备的校准证书 -> ...
+type CertificateTraceability struct {
+	Root        *TraceabilityNode   `json:"root"`
+	Complete    bool                `json:"complete"`  // 溯源链完整且各环节有效
+	Issues      []string            `json:"issues"`    // 断链、过期、撤销等问题
+	ChainHash   string              `json:"chainHash"` // 整条溯源链的哈希，与账本锚定记录比对
+	Anchor      *TraceabilityAnchor `json:"anchor"`    // 账本上的锚定记录，未锚定或未启用账本时为空
+	AnchorValid bool                `json:"anchorValid"`
+	GeneratedAt time.Time           `json:"generatedAt"`
+}
+
+// TraceabilityNode 溯源链中的一张本系统证书
+type TraceabilityNode struct {
+	CertNumber     string                `json:"certNumber"`
+	InstrumentName string                `json:"instrumentName"`
+	Status         string                `json:"status"`
+	TestDate       time.Time             `json:"testDate"`
+	ExpireDate     time.Time             `json:"expireDate"`
+	BlockchainTxID string                `json:"blockchainTxId"`
+	BlockchainHash string                `json:"blockchainHash"`
+	IsHashValid    bool                  `json:"isHashValid"`
+	OnLedger       *bool                 `json:"onLedger"` // 账本上是否存在该证书，未启用账本时为空
+	Devices        []*TraceabilityDevice `json:"devices"`
+}
+
+// TraceabilityDevice 出具证书时使用的检测设备及其校准证书
+type TraceabilityDevice struct {
+	DeviceAddr             string            `json:"deviceAddr"`
+	DeviceName             string            `json:"deviceName"`
+	AccuracyClass          string            `json:"accuracyClass"`
+	CalibrationCertNumber  string            `json:"calibrationCertNumber,omitempty"`
+	CalibrationExternalRef string            `json:"calibrationExternalRef,omitempty"`
+	FirstUsedAt            time.Time         `json:"firstUsedAt"`
+	LastUsedAt             time.Time         `json:"lastUsedAt"`
+	Upstream               *TraceabilityNode `json:"upstream,omitempty"` // 本系统签发的上级校准证书
+}
+
+// TraceabilityLink 证书与所用设备校准证书之间的一条溯源关系
+type TraceabilityLink struct {
+	DeviceAddr             string `json:"deviceAddr"`
+	CalibrationCertNumber  string `json:"calibrationCertNumber,omitempty"`
+	CalibrationExternalRef string `json:"calibrationExternalRef,omitempty"`
+}
+
+// TraceabilityAnchor 账本上的溯源链锚定记录
+type TraceabilityAnchor struct {
+	CertNumber string              `json:"certNumber"`
+	ChainHash  string              `json:"chainHash"`
+	Upstream   []*TraceabilityLink `json:"upstream"`
+	TxID       string              `json:"txId"`
+	AnchoredAt string              `json:"anchoredAt"`
+}
+
+// ResultEvaluation 根据测试数据和误差限值表自动判定的检定结果
+type ResultEvaluation struct {
+	CertNumber      string             `json:"certNumber"`
+	InstrumentName  string             `json:"instrumentName"`
+	AccuracyClass   string             `json:"accuracyClass"`   // 归一化后的准确度等级，如 "0.2S"
+	Standard        string             `json:"standard"`        // 适用的检定规程，无适用规程时为空
+	Applicable      bool               `json:"applicable"`      // 是否有适用的误差限值表
+	SuggestedResult string             `json:"suggestedResult"` // qualified / unqualified，无法判定时为空
+	ManualResult    string             `json:"manualResult"`    // 证书上人工填写的检定结果
+	Agrees          bool               `json:"agrees"`          // 人工结论与自动判定一致
+	Points          []*PointEvaluation `json:"points"`
+	MissingPoints   []float64          `json:"missingPoints"` // 限值表中没有测试数据的百分点
+	Messages        []string           `json:"messages"`
+}
+
+// PointEvaluation 单个测试点的判定结果
+type PointEvaluation struct {
+	TestDataID       int64   `json:"testDataId"`
+	DeviceAddr       string  `json:"deviceAddr"`
+	TestPoint        string  `json:"testPoint"`
+	PercentageValue  float64 `json:"percentageValue"` // 额定百分点，按它匹配限值表
+	ActualPercentage float64 `json:"actualPercentage"`
+	LimitPercent     float64 `json:"limitPercent"` // 匹配到的限值表百分点
+	RatioError       float64 `json:"ratioError"`
+	RatioLimit       float64 `json:"ratioLimit"`
+	AngleError       float64 `json:"angleError"`
+	AngleLimit       float64 `json:"angleLimit"`
+	Evaluated        bool    `json:"evaluated"` // 未匹配到限值表百分点时为 false，整张证书无法判定
+	Passed           bool    `json:"passed"`
+	Explanation      string  `json:"explanation"`
+}
+
+// UncertaintyBudget 证书的测量不确定度评定（按测试点分别评定比值差和相位差）
+type UncertaintyBudget struct {
+	Method         string              `json:"method"`         // 评定依据，如 "JJF 1059.1-2012"
+	CoverageFactor float64             `json:"coverageFactor"` // 包含因子 k
+	Points         []*PointUncertainty `json:"points"`
+	Notes          []string            `json:"notes"`
+	CalculatedAt   string              `json:"calculatedAt"` // RFC3339，以字符串保存保证哈希稳定
+}
+
+// PointUncertainty 单个测试点的不确定度评定
+type PointUncertainty struct {
+	TestPoint       string                  `json:"testPoint"`
+	Percent         float64                 `json:"percent"`  // 各次读数实测百分比的平均值
+	Readings        int                     `json:"readings"` // 读数次数
+	MeanRatioError  float64                 `json:"meanRatioError"`
+	MeanAngleError  float64                 `json:"meanAngleError"`
+	RatioComponents []*UncertaintyComponent `json:"ratioComponents"`
+	AngleComponents []*UncertaintyComponent `json:"angleComponents"`
+	RatioCombined   float64                 `json:"ratioCombined"` // 比值差合成标准不确定度（%）
+	AngleCombined   float64                 `json:"angleCombined"` // 相位差合成标准不确定度（′）
+	RatioExpanded   float64                 `json:"ratioExpanded"` // 比值差扩展不确定度 U（%）
+	AngleExpanded   float64                 `json:"angleExpanded"` // 相位差扩展不确定度 U（′）
+}
+
+// UncertaintyComponent 不确定度分量
+type UncertaintyComponent struct {
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`         // A / B
+	Distribution string  `json:"distribution"` // B 类分量的分布，如 uniform
+	HalfWidth    float64 `json:"halfWidth"`    // B 类分量的区间半宽
+	Divisor      float64 `json:"divisor"`      // B 类分量的除数（包含因子）
+	Value        float64 `json:"value"`        // 标准不确定度
+}
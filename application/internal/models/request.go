@@ -1,17 +1,106 @@
 package models
 
 // 单个测试点数据请求
+// 电流互感器（dataType=current）测试点须提供一次电流，电压互感器（dataType=voltage）须提供一次电压，
+// 电流、电压按单位换算为 A、V 保存；比值差单位为 %，相位差单位为 ′
 type TestDataPointRequest struct {
-    DeviceAddr       string  `json:"deviceAddr" binding:"required"`
-    TestPoint        string  `json:"testPoint" binding:"required"`
-    ActualPercentage float64 `json:"actualPercentage" binding:"required"`
-    RatioError       float64 `json:"ratioError"`
-    AngleError       float64 `json:"angleError"`
-    TestTimestamp    string  `json:"testTimestamp" binding:"required"`
+    DeviceAddr        string  `json:"deviceAddr" binding:"required"`
+    DataType          string  `json:"dataType" binding:"required,oneof=current voltage"`
+    TestPoint         string  `json:"testPoint" binding:"required"`
+    PercentageValue   float64 `json:"percentageValue"`                     // 额定百分点（%），如 5、20、100、120
+    ActualPercentage  float64 `json:"actualPercentage" binding:"required"` // 实测百分比（%）
+    RatioError        float64 `json:"ratioError"`                          // 比值差（%）
+    AngleError        float64 `json:"angleError"`                          // 相位差（′）
+    CurrentValue      float64 `json:"currentValue"`                        // 一次电流
+    CurrentUnit       string  `json:"currentUnit"`                         // A / mA / kA，默认 A
+    VoltageValue      float64 `json:"voltageValue"`                        // 一次电压
+    VoltageUnit       string  `json:"voltageUnit"`                         // V / kV，默认 V
+    WorkstationNumber string  `json:"workstationNumber" binding:"max=20"`  // 工位号
+    TestTimestamp     string  `json:"testTimestamp" binding:"required"`
+    Signature         string  `json:"signature"`                           // 设备对规范化读数的签名（base64），登记了公钥的设备必填
+}
+
+// TestDataStreamReading 工位通过 WebSocket 实时上传的单条读数，Seq 由工位生成，原样返回在确认消息中
+type TestDataStreamReading struct {
+    Seq int64 `json:"seq"`
+    TestDataPointRequest
+}
+
+// MQTTReading 检测设备通过 MQTT 上报的单条读数，deviceAddr 可省略，由主题中的设备地址补全
+type MQTTReading struct {
+    CertNumber string `json:"certNumber"`
+    TestDataStreamReading
 }
 
 // 添加多条测试数据请求
 type AddTestDataRequest struct {
     CertNumber string                 `json:"certNumber" binding:"required"`
-    Data       []TestDataPointRequest `json:"data" binding:"required"` // ✅ 改为数组
+    Data       []TestDataPointRequest `json:"data" binding:"required,dive"` // ✅ 改为数组
+}
+
+
+// CreateUserRequest 管理员创建用户请求
+type CreateUserRequest struct {
+    Username string `json:"username" binding:"required"`
+    Password string `json:"password" binding:"required"`
+    Role     string `json:"role" binding:"required"` // admin / operator / viewer
+}
+
+// UpdateUserRequest 管理员更新用户请求，未提供的字段保持不变
+type UpdateUserRequest struct {
+    Role   string `json:"role"`   // admin / operator / viewer
+    Status string `json:"status"` // active / disabled
+}
+
+// ResetPasswordRequest 管理员重置密码请求，NewPassword 为空时生成临时密码
+type ResetPasswordRequest struct {
+    NewPassword string `json:"newPassword"`
+}
+
+// CreateAPIKeyRequest 管理员创建 API Key 请求
+type CreateAPIKeyRequest struct {
+    Name        string   `json:"name" binding:"required"`
+    Permissions []string `json:"permissions" binding:"required"` // 如 testdata:write
+    DeviceAddr  string   `json:"deviceAddr"`                     // 可选，绑定设备地址
+    ExpiresAt   string   `json:"expiresAt"`                      // 可选，RFC3339 格式
+}
+
+// CustomerRequest 创建或更新委托方请求
+type CustomerRequest struct {
+    CustomerName    string `json:"customerName" binding:"required,max=200"`
+    CustomerAddress string `json:"customerAddress"`
+    ContactPerson   string `json:"contactPerson" binding:"max=100"`
+    ContactPhone    string `json:"contactPhone" binding:"max=20"`
+}
+
+// CreateDeviceRequest 登记检测设备请求
+type CreateDeviceRequest struct {
+    DeviceAddr         string `json:"deviceAddr" binding:"required,max=100"`
+    DeviceName         string `json:"deviceName" binding:"required,max=200"`
+    Manufacturer       string `json:"manufacturer" binding:"max=100"`
+    Model              string `json:"model" binding:"max=100"`
+    AccuracyClass      string `json:"accuracyClass" binding:"max=50"`
+    Status             string `json:"status"`             // active / maintenance / retired，默认 active
+    CalibrationDueDate string `json:"calibrationDueDate"` // 外部校准证书的有效期截止日，格式 2006-01-02
+    // 设备校准证书二选一：本系统证书编号或外部证书（需同时填写有效期）
+    CalibrationCertNumber  string `json:"calibrationCertNumber" binding:"max=100"`
+    CalibrationExternalRef string `json:"calibrationExternalRef" binding:"max=200"`
+}
+
+// SetDevicePublicKeyRequest 登记或更换设备签名公钥请求
+type SetDevicePublicKeyRequest struct {
+    PublicKey string `json:"publicKey" binding:"required,max=4096"` // PEM 格式（PUBLIC KEY），ECDSA P-256 或 SM2
+}
+
+// UpdateDeviceRequest 更新检测设备请求，设备地址已被测试数据引用，不允许修改
+type UpdateDeviceRequest struct {
+    DeviceName         string `json:"deviceName" binding:"required,max=200"`
+    Manufacturer       string `json:"manufacturer" binding:"max=100"`
+    Model              string `json:"model" binding:"max=100"`
+    AccuracyClass      string `json:"accuracyClass" binding:"max=50"`
+    Status             string `json:"status" binding:"required"` // active / maintenance / retired
+    CalibrationDueDate string `json:"calibrationDueDate"`        // 外部校准证书的有效期截止日，格式 2006-01-02
+    // 设备校准证书二选一：本系统证书编号或外部证书（需同时填写有效期），都为空表示未登记
+    CalibrationCertNumber  string `json:"calibrationCertNumber" binding:"max=100"`
+    CalibrationExternalRef string `json:"calibrationExternalRef" binding:"max=200"`
 }
@@ -0,0 +1,470 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 测试数据异常类型
+const (
+	AnomalyDuplicate           = "duplicate"            // 不同证书中出现完全相同的读数
+	AnomalyOutOfControl        = "out_of_control"       // 误差超出设备或工位的控制限
+	AnomalyDrift               = "drift"                // 设备误差连续位于均值同一侧
+	AnomalyImpossibleTimestamp = "impossible_timestamp" // 测试时间晚于证书签发时间或上传时间
+)
+
+// 统计分组范围
+const (
+	StatisticsScopeDevice      = "device"
+	StatisticsScopeWorkstation = "workstation"
+)
+
+// 异常列表默认每页条数
+const defaultAnomalyPageSize = 50
+
+var (
+	// ErrInvalidAnomalyType 异常类型取值无效
+	ErrInvalidAnomalyType = errors.New("异常类型无效，可选值: duplicate, out_of_control, drift, impossible_timestamp")
+	// ErrAnalyticsRangeTooLarge 分析的时间范围超过配置的上限
+	ErrAnalyticsRangeTooLarge = errors.New("分析时间范围过大")
+	// ErrAnalyticsTooManyRecords 时间范围内的测试数据超过单次分析的上限
+	ErrAnalyticsTooManyRecords = errors.New("测试数据过多")
+)
+
+// anomalyTypes 全部异常类型，用于校验过滤条件和汇总计数
+var anomalyTypes = []string{AnomalyDuplicate, AnomalyOutOfControl, AnomalyDrift, AnomalyImpossibleTimestamp}
+
+// errorMetrics 参与统计的误差项
+var errorMetrics = []struct {
+	name  string
+	label string
+	unit  string
+	value func(*models.TestData) float64
+}{
+	{"ratioError", "比值差", "%", func(d *models.TestData) float64 { return d.RatioError }},
+	{"angleError", "相位差", "′", func(d *models.TestData) float64 { return d.AngleError }},
+}
+
+// AnomalyFilter 异常检测的数据范围和过滤条件，From/To 为零值时使用配置的默认时间范围，
+// Page/PageSize 只对异常列表分页，统计量和汇总基于全部数据
+type AnomalyFilter struct {
+	DeviceAddr        string
+	WorkstationNumber string
+	Type              string
+	From              time.Time
+	To                time.Time
+	Page              int
+	PageSize          int
+}
+
+// AnalyticsService 测试数据统计分析服务：按设备和工位统计误差、计算控制限并标记可疑数据
+type AnalyticsService struct {
+	dbClient *database.Client
+	cfg      config.AnalyticsConfig
+}
+
+// NewAnalyticsService 创建新的 AnalyticsService
+func NewAnalyticsService(dbClient *database.Client, cfg config.AnalyticsConfig) *AnalyticsService {
+	return &AnalyticsService{
+		dbClient: dbClient,
+		cfg:      cfg,
+	}
+}
+
+// DetectAnomalies 分析时间范围内的测试数据，返回各设备、工位的统计量和分页后的可疑数据，
+// 统计量基于过滤后的数据计算；时间范围和加载的数据条数受配置上限约束
+func (s *AnalyticsService) DetectAnomalies(filter AnomalyFilter) (*models.AnomalyReport, error) {
+	if filter.Type != "" && !slices.Contains(anomalyTypes, filter.Type) {
+		return nil, ErrInvalidAnomalyType
+	}
+	maxRange := s.cfg.MaxRangeDuration()
+	if filter.To.IsZero() {
+		filter.To = time.Now()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.Add(-min(s.cfg.WindowDuration(), maxRange))
+	}
+	if filter.To.Sub(filter.From) > maxRange {
+		return nil, fmt.Errorf("%w，最多分析 %s 天的数据", ErrAnalyticsRangeTooLarge, formatNumber(maxRange.Hours()/24))
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 {
+		filter.PageSize = defaultAnomalyPageSize
+	}
+
+	query := s.dbClient.DB.Where("test_timestamp BETWEEN ? AND ?", filter.From, filter.To)
+	if filter.DeviceAddr != "" {
+		query = query.Where("device_addr = ?", filter.DeviceAddr)
+	}
+	if filter.WorkstationNumber != "" {
+		query = query.Where("workstation_number = ?", filter.WorkstationNumber)
+	}
+	maxRecords := s.cfg.MaxRecordsOrDefault()
+	var data []*models.TestData
+	if err := query.Order("test_timestamp, id").Limit(maxRecords + 1).Find(&data).Error; err != nil {
+		return nil, err
+	}
+	if len(data) > maxRecords {
+		return nil, fmt.Errorf("%w，单次最多分析 %d 条，请缩小时间范围或按设备、工位过滤", ErrAnalyticsTooManyRecords, maxRecords)
+	}
+	issuedAt, err := s.attachCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, anomalies := s.analyze(data, issuedAt)
+	if filter.Type != "" {
+		filtered := anomalies[:0]
+		for _, a := range anomalies {
+			if a.Type == filter.Type {
+				filtered = append(filtered, a)
+			}
+		}
+		anomalies = filtered
+	}
+
+	report := &models.AnomalyReport{
+		From:         filter.From,
+		To:           filter.To,
+		Total:        len(data),
+		Statistics:   stats,
+		AnomalyTotal: len(anomalies),
+		Page:         filter.Page,
+		PageSize:     filter.PageSize,
+		TotalPages:   (len(anomalies) + filter.PageSize - 1) / filter.PageSize,
+		Summary:      make(map[string]int, len(anomalyTypes)),
+		GeneratedAt:  time.Now(),
+	}
+	for _, t := range anomalyTypes {
+		report.Summary[t] = 0
+	}
+	for _, a := range anomalies {
+		report.Summary[a.Type]++
+	}
+	start := min((filter.Page-1)*filter.PageSize, len(anomalies))
+	end := min(start+filter.PageSize, len(anomalies))
+	report.Anomalies = anomalies[start:end]
+	return report, nil
+}
+
+// GetCertificateAnomalies 返回证书测试数据中的可疑数据，统计基准为证书所用设备和工位在默认时间范围内的全部数据
+func (s *AnalyticsService) GetCertificateAnomalies(certID int64) ([]*models.TestDataAnomaly, error) {
+	var own []*models.TestData
+	if err := s.dbClient.DB.Where("cert_id = ?", certID).Find(&own).Error; err != nil {
+		return nil, err
+	}
+	anomalies := []*models.TestDataAnomaly{}
+	if len(own) == 0 {
+		return anomalies, nil
+	}
+
+	ownIDs := make(map[int64]bool, len(own))
+	var devices, workstations []string
+	for _, d := range own {
+		ownIDs[d.ID] = true
+		if !slices.Contains(devices, d.DeviceAddr) {
+			devices = append(devices, d.DeviceAddr)
+		}
+		if d.WorkstationNumber != "" && !slices.Contains(workstations, d.WorkstationNumber) {
+			workstations = append(workstations, d.WorkstationNumber)
+		}
+	}
+
+	related := s.dbClient.DB.Where("device_addr IN ?", devices)
+	if len(workstations) > 0 {
+		related = related.Or("workstation_number IN ?", workstations)
+	}
+	var data []*models.TestData
+	err := s.dbClient.DB.Where(related).
+		Where("test_timestamp >= ? OR cert_id = ?", time.Now().Add(-s.cfg.WindowDuration()), certID).
+		Order("test_timestamp, id").Find(&data).Error
+	if err != nil {
+		return nil, err
+	}
+	issuedAt, err := s.attachCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, all := s.analyze(data, issuedAt)
+	for _, a := range all {
+		if ownIDs[a.TestDataID] {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies, nil
+}
+
+// attachCertificates 为测试数据填写证书编号，并返回各证书的首次签发时间
+func (s *AnalyticsService) attachCertificates(data []*models.TestData) (map[int64]time.Time, error) {
+	issuedAt := make(map[int64]time.Time)
+	if len(data) == 0 {
+		return issuedAt, nil
+	}
+	var certIDs []int64
+	for _, d := range data {
+		if !slices.Contains(certIDs, d.CertID) {
+			certIDs = append(certIDs, d.CertID)
+		}
+	}
+
+	var certs []*models.Certificate
+	if err := s.dbClient.DB.Select("id, cert_number").Where("id IN ?", certIDs).Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	certNumbers := make(map[int64]string, len(certs))
+	for _, cert := range certs {
+		certNumbers[cert.ID] = cert.CertNumber
+	}
+	for _, d := range data {
+		d.CertNumber = certNumbers[d.CertID]
+	}
+
+	var rows []struct {
+		CertID   int64
+		IssuedAt time.Time
+	}
+	err := s.dbClient.DB.Model(&models.CertificateHistory{}).
+		Select("cert_id, MIN(operation_time) AS issued_at").
+		Where("operation_type = ? AND cert_id IN ?", "issue", certIDs).
+		Group("cert_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		issuedAt[row.CertID] = row.IssuedAt
+	}
+	return issuedAt, nil
+}
+
+// analyze 计算统计量并标记可疑数据，data 须按测试时间排序
+func (s *AnalyticsService) analyze(data []*models.TestData, issuedAt map[int64]time.Time) ([]*models.TestDataStatistics, []*models.TestDataAnomaly) {
+	var anomalies []*models.TestDataAnomaly
+	anomalies = append(anomalies, s.findImpossibleTimestamps(data, issuedAt)...)
+	anomalies = append(anomalies, findDuplicates(data)...)
+
+	stats := []*models.TestDataStatistics{}
+	for _, scope := range []string{StatisticsScopeDevice, StatisticsScopeWorkstation} {
+		for _, group := range groupTestData(data, scope) {
+			for _, metric := range errorMetrics {
+				stat, flagged := s.checkControl(group, metric.name, metric.label, metric.unit, metric.value)
+				stats = append(stats, stat)
+				anomalies = append(anomalies, flagged...)
+				if scope == StatisticsScopeDevice && stat.UCL != nil {
+					anomalies = append(anomalies, s.findDrift(group, stat, metric.label, metric.value)...)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(anomalies, func(i, j int) bool {
+		if !anomalies[i].TestTimestamp.Equal(anomalies[j].TestTimestamp) {
+			return anomalies[i].TestTimestamp.Before(anomalies[j].TestTimestamp)
+		}
+		return anomalies[i].TestDataID < anomalies[j].TestDataID
+	})
+	if anomalies == nil {
+		anomalies = []*models.TestDataAnomaly{}
+	}
+	return stats, anomalies
+}
+
+// testDataGroup 同一设备或工位、同一数据类型和额定百分点的测试数据
+type testDataGroup struct {
+	scope      string
+	key        string
+	dataType   string
+	percentage float64
+	data       []*models.TestData
+}
+
+// groupTestData 按设备或工位、数据类型和额定百分点分组，不同百分点的误差水平不同，不能放在一起计算控制限；
+// 保持原有顺序，未填写工位号的数据不参与工位统计
+func groupTestData(data []*models.TestData, scope string) []*testDataGroup {
+	index := make(map[string]*testDataGroup)
+	var groups []*testDataGroup
+	for _, d := range data {
+		key := d.DeviceAddr
+		if scope == StatisticsScopeWorkstation {
+			key = d.WorkstationNumber
+		}
+		if key == "" {
+			continue
+		}
+		id := key + "|" + d.DataType + "|" + formatNumber(d.PercentageValue)
+		group, ok := index[id]
+		if !ok {
+			group = &testDataGroup{scope: scope, key: key, dataType: d.DataType, percentage: d.PercentageValue}
+			index[id] = group
+			groups = append(groups, group)
+		}
+		group.data = append(group.data, d)
+	}
+	return groups
+}
+
+// label 返回分组的中文描述，如 "设备 DEV001 20% 点"
+func (g *testDataGroup) label() string {
+	name := "设备 "
+	if g.scope == StatisticsScopeWorkstation {
+		name = "工位 "
+	}
+	return name + g.key + " " + formatNumber(g.percentage) + "% 点"
+}
+
+// checkControl 计算一组数据某项误差的均值、标准差和控制限，数据点足够时标记超出控制限的数据
+func (s *AnalyticsService) checkControl(group *testDataGroup, metric, label, unit string, value func(*models.TestData) float64) (*models.TestDataStatistics, []*models.TestDataAnomaly) {
+	values := make([]float64, len(group.data))
+	for i, d := range group.data {
+		values[i] = value(d)
+	}
+	stat := &models.TestDataStatistics{
+		Scope:           group.scope,
+		Key:             group.key,
+		DataType:        group.dataType,
+		PercentageValue: group.percentage,
+		Metric:          metric,
+		Count:           len(values),
+		Mean:            roundTo(mean(values), 6),
+	}
+	if len(values) < 2 {
+		return stat, nil
+	}
+	m, sd := mean(values), sampleStdDev(values)
+	stat.StdDev = roundTo(sd, 6)
+	if len(values) < s.cfg.MinSamplesOrDefault() {
+		return stat, nil
+	}
+
+	k := s.cfg.ControlSigmaOrDefault()
+	ucl, lcl := roundTo(m+k*sd, 6), roundTo(m-k*sd, 6)
+	stat.UCL, stat.LCL = &ucl, &lcl
+
+	var anomalies []*models.TestDataAnomaly
+	for i, d := range group.data {
+		if values[i] > ucl || values[i] < lcl {
+			anomalies = append(anomalies, newAnomaly(AnomalyOutOfControl, d, fmt.Sprintf("%s%s %s%s 超出控制限 [%s, %s]（均值 ± %s 倍标准差）",
+				group.label(), label, formatNumber(values[i]), unit, formatNumber(lcl), formatNumber(ucl), formatNumber(k))))
+		}
+	}
+	return stat, anomalies
+}
+
+// findDrift 标记设备误差连续位于均值同一侧的数据，连续点数达到配置值时在该点标记一次
+func (s *AnalyticsService) findDrift(group *testDataGroup, stat *models.TestDataStatistics, label string, value func(*models.TestData) float64) []*models.TestDataAnomaly {
+	runLength := s.cfg.DriftRunLengthOrDefault()
+	var anomalies []*models.TestDataAnomaly
+	side, run, start := 0, 0, 0
+	for i, d := range group.data {
+		current := 0
+		switch v := value(d); {
+		case v > stat.Mean:
+			current = 1
+		case v < stat.Mean:
+			current = -1
+		}
+		if current == 0 || current != side {
+			side, run, start = current, 0, i
+		}
+		if current == 0 {
+			continue
+		}
+		run++
+		if run == runLength {
+			direction := "高于"
+			if side < 0 {
+				direction = "低于"
+			}
+			anomalies = append(anomalies, newAnomaly(AnomalyDrift, d, fmt.Sprintf("%s%s自 %s 起连续 %d 个点%s均值 %s，可能存在漂移",
+				group.label(), label, group.data[start].TestTimestamp.Format("2006-01-02 15:04:05"), runLength, direction, formatNumber(stat.Mean))))
+		}
+	}
+	return anomalies
+}
+
+// findImpossibleTimestamps 标记测试时间晚于证书首次签发时间，或晚于上传时间超过设备时钟容许偏差的数据
+func (s *AnalyticsService) findImpossibleTimestamps(data []*models.TestData, issuedAt map[int64]time.Time) []*models.TestDataAnomaly {
+	tolerance := s.cfg.ClockToleranceDuration()
+	var anomalies []*models.TestDataAnomaly
+	for _, d := range data {
+		if issued, ok := issuedAt[d.CertID]; ok && d.TestTimestamp.After(issued) {
+			anomalies = append(anomalies, newAnomaly(AnomalyImpossibleTimestamp, d, fmt.Sprintf("测试时间 %s 晚于证书签发时间 %s",
+				d.TestTimestamp.Format("2006-01-02 15:04:05"), issued.Format("2006-01-02 15:04:05"))))
+			continue
+		}
+		if !d.CreatedAt.IsZero() && d.TestTimestamp.After(d.CreatedAt.Add(tolerance)) {
+			anomalies = append(anomalies, newAnomaly(AnomalyImpossibleTimestamp, d, fmt.Sprintf("测试时间 %s 晚于上传时间 %s",
+				d.TestTimestamp.Format("2006-01-02 15:04:05"), d.CreatedAt.Format("2006-01-02 15:04:05"))))
+		}
+	}
+	return anomalies
+}
+
+// findDuplicates 标记在不同证书中出现的完全相同的读数（同一设备、数据类型和全部测量值）
+func findDuplicates(data []*models.TestData) []*models.TestDataAnomaly {
+	index := make(map[string][]*models.TestData)
+	var keys []string
+	for _, d := range data {
+		key := strings.Join([]string{
+			d.DeviceAddr, d.DataType,
+			fmt.Sprintf("%.6f|%.6f|%.6f|%.6f", d.PercentageValue, d.ActualPercentage, d.RatioError, d.AngleError),
+			fmt.Sprintf("%.3f|%.3f", d.CurrentValue, d.VoltageValue),
+		}, "|")
+		if _, ok := index[key]; !ok {
+			keys = append(keys, key)
+		}
+		index[key] = append(index[key], d)
+	}
+
+	var anomalies []*models.TestDataAnomaly
+	for _, key := range keys {
+		same := index[key]
+		if len(same) < 2 {
+			continue
+		}
+		for _, d := range same {
+			var relatedIDs []int64
+			var certNumbers []string
+			for _, other := range same {
+				if other.CertID == d.CertID {
+					continue
+				}
+				relatedIDs = append(relatedIDs, other.ID)
+				if !slices.Contains(certNumbers, other.CertNumber) {
+					certNumbers = append(certNumbers, other.CertNumber)
+				}
+			}
+			if len(relatedIDs) == 0 {
+				continue
+			}
+			anomaly := newAnomaly(AnomalyDuplicate, d, fmt.Sprintf("读数与证书 %s 中设备 %s 的读数完全相同",
+				strings.Join(certNumbers, "、"), d.DeviceAddr))
+			anomaly.RelatedIDs = relatedIDs
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies
+}
+
+// newAnomaly 根据测试数据构造异常记录
+func newAnomaly(anomalyType string, d *models.TestData, message string) *models.TestDataAnomaly {
+	return &models.TestDataAnomaly{
+		Type:              anomalyType,
+		TestDataID:        d.ID,
+		CertNumber:        d.CertNumber,
+		DeviceAddr:        d.DeviceAddr,
+		WorkstationNumber: d.WorkstationNumber,
+		TestPoint:         d.TestPoint,
+		TestTimestamp:     d.TestTimestamp,
+		Message:           message,
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGroupTestDataByPercentage(t *testing.T) {
+	data := []*models.TestData{
+		{ID: 1, DeviceAddr: "dev-1", WorkstationNumber: "W1", DataType: TestDataTypeCurrent, PercentageValue: 20},
+		{ID: 2, DeviceAddr: "dev-1", WorkstationNumber: "W1", DataType: TestDataTypeCurrent, PercentageValue: 100},
+		{ID: 3, DeviceAddr: "dev-1", WorkstationNumber: "W1", DataType: TestDataTypeCurrent, PercentageValue: 20},
+		{ID: 4, DeviceAddr: "dev-1", DataType: TestDataTypeVoltage, PercentageValue: 20},
+	}
+
+	groups := groupTestData(data, StatisticsScopeDevice)
+	if len(groups) != 3 {
+		t.Fatalf("期望按设备分为 3 组，实际 %d 组", len(groups))
+	}
+	if g := groups[0]; g.percentage != 20 || len(g.data) != 2 || g.data[0].ID != 1 || g.data[1].ID != 3 {
+		t.Fatalf("20%% 点分组不符: %+v", g)
+	}
+	if g := groups[1]; g.percentage != 100 || len(g.data) != 1 {
+		t.Fatalf("100%% 点分组不符: %+v", g)
+	}
+	if label := groups[0].label(); label != "设备 dev-1 20% 点" {
+		t.Fatalf("分组描述不符: %q", label)
+	}
+
+	// 未填写工位号的数据不参与工位统计
+	if groups := groupTestData(data, StatisticsScopeWorkstation); len(groups) != 2 {
+		t.Fatalf("期望按工位分为 2 组，实际 %d 组", len(groups))
+	}
+}
+
+func TestDetectAnomaliesRejectsLargeRange(t *testing.T) {
+	s := NewAnalyticsService(nil, config.AnalyticsConfig{MaxRange: "720h"})
+	to := time.Now()
+	_, err := s.DetectAnomalies(AnomalyFilter{From: to.Add(-31 * 24 * time.Hour), To: to})
+	if !errors.Is(err, ErrAnalyticsRangeTooLarge) {
+		t.Fatalf("期望 ErrAnalyticsRangeTooLarge，实际 %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// apiKeyPrefix API Key 明文前缀，便于在日志和配置中识别
+const apiKeyPrefix = "ck_"
+
+// apiKeyTouchInterval 最近使用时间的更新间隔，避免每个请求都写库
+const apiKeyTouchInterval = time.Minute
+
+// ErrInvalidAPIKey API Key 不存在、已吊销或已过期
+var ErrInvalidAPIKey = errors.New("API Key 无效或已过期")
+
+// ErrInvalidPermission 权限不在系统定义的范围内
+var ErrInvalidPermission = errors.New("权限取值无效")
+
+// APIKeyService API Key 管理服务
+type APIKeyService struct {
+	dbClient *database.Client
+}
+
+// NewAPIKeyService 创建新的 APIKeyService
+func NewAPIKeyService(dbClient *database.Client) *APIKeyService {
+	return &APIKeyService{
+		dbClient: dbClient,
+	}
+}
+
+// APIKeyPermissions 解析 API Key 授予的权限
+func APIKeyPermissions(key *models.APIKey) []string {
+	if key.Permissions == "" {
+		return nil
+	}
+	return strings.Split(key.Permissions, ",")
+}
+
+// CreateAPIKey 创建 API Key，返回记录和只展示一次的明文 Key
+func (s *APIKeyService) CreateAPIKey(name string, permissions []string, deviceAddr string, expiresAt *time.Time, createdBy int64) (*models.APIKey, string, error) {
+	if len(permissions) == 0 {
+		return nil, "", ErrInvalidPermission
+	}
+	for _, perm := range permissions {
+		valid := false
+		for _, known := range AllPermissions {
+			if perm == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidPermission, perm)
+		}
+	}
+
+	var boundDevice *string
+	if deviceAddr != "" {
+		var count int64
+		if err := s.dbClient.DB.Model(&models.Device{}).Where("device_addr = ?", deviceAddr).Count(&count).Error; err != nil {
+			return nil, "", err
+		}
+		if count == 0 {
+			return nil, "", ErrDeviceNotFound
+		}
+		boundDevice = &deviceAddr
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		Name:        name,
+		KeyPrefix:   plaintext[:len(apiKeyPrefix)+8],
+		KeyHash:     hashToken(plaintext),
+		Permissions: strings.Join(permissions, ","),
+		DeviceAddr:  boundDevice,
+		CreatedBy:   createdBy,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.dbClient.DB.Create(key).Error; err != nil {
+		return nil, "", err
+	}
+	return key, plaintext, nil
+}
+
+// ListAPIKeys 获取所有 API Key（不含明文）
+func (s *APIKeyService) ListAPIKeys() ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	err := s.dbClient.DB.Order("id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey 吊销 API Key
+func (s *APIKeyService) RevokeAPIKey(id int64) error {
+	var key models.APIKey
+	if err := s.dbClient.DB.First(&key, id).Error; err != nil {
+		return err
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	return s.dbClient.DB.Model(&key).Update("revoked_at", time.Now()).Error
+}
+
+// ValidateAPIKey 确认已认证的 API Key 仍然有效，供长连接定期检查 Key 是否已被吊销或过期
+func (s *APIKeyService) ValidateAPIKey(id int64) error {
+	var key models.APIKey
+	if err := s.dbClient.DB.First(&key, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidAPIKey
+		}
+		return err
+	}
+	return s.checkValid(&key, time.Now())
+}
+
+// checkValid 检查 API Key 未吊销、未过期，且创建人仍处于启用状态
+func (s *APIKeyService) checkValid(key *models.APIKey, now time.Time) error {
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return ErrInvalidAPIKey
+	}
+
+	// 创建人被禁用或删除后，其创建的 API Key 一并失效
+	var creators int64
+	if err := s.dbClient.DB.Model(&models.User{}).Where("id = ? AND status = ?", key.CreatedBy, "active").Count(&creators).Error; err != nil {
+		return err
+	}
+	if creators == 0 {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
+
+// Authenticate 校验请求携带的 API Key 并记录最近使用时间和IP
+func (s *APIKeyService) Authenticate(plaintext, ip string) (*models.APIKey, error) {
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	if err := s.dbClient.DB.Where("key_hash = ?", hashToken(plaintext)).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.checkValid(&key, now); err != nil {
+		return nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval || key.LastUsedIP != ip {
+		err := s.dbClient.DB.Model(&key).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ip,
+		}).Error
+		if err != nil {
+			log.Printf("更新 API Key %s 使用时间失败: %v", key.KeyPrefix, err)
+		}
+	}
+	return &key, nil
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAPIKeyFixture 创建 API Key 服务、创建人 alice 和已登记的设备 DEV001
+func newTestAPIKeyFixture(t *testing.T) (*database.Client, *APIKeyService, *models.User) {
+	t.Helper()
+	client := newTestDB(t)
+	creator := createTestUser(t, newTestAuthService(t, client, config.LoginProtectionConfig{}), "alice", "admin")
+	if err := client.DB.Create(&models.Device{DeviceAddr: "DEV001", Status: DeviceStatusActive}).Error; err != nil {
+		t.Fatalf("登记设备失败: %v", err)
+	}
+	return client, NewAPIKeyService(client), creator
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		deviceAddr  string
+		wantErr     error
+	}{
+		{name: "创建", permissions: []string{PermTestDataWrite, PermCertRead}},
+		{name: "绑定设备", permissions: []string{PermTestDataWrite}, deviceAddr: "DEV001"},
+		{name: "未授予权限", permissions: nil, wantErr: ErrInvalidPermission},
+		{name: "权限取值无效", permissions: []string{PermTestDataWrite, "cert:*"}, wantErr: ErrInvalidPermission},
+		{name: "设备未登记", permissions: []string{PermTestDataWrite}, deviceAddr: "DEV404", wantErr: ErrDeviceNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, s, creator := newTestAPIKeyFixture(t)
+			key, plaintext, err := s.CreateAPIKey("station", tt.permissions, tt.deviceAddr, nil, creator.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(plaintext, apiKeyPrefix) || !strings.HasPrefix(plaintext, key.KeyPrefix) {
+				t.Fatalf("明文 Key 格式不符: %s（前缀 %s）", plaintext, key.KeyPrefix)
+			}
+
+			// 数据库只保存哈希
+			var stored models.APIKey
+			if err := client.DB.First(&stored, key.ID).Error; err != nil {
+				t.Fatalf("查询 API Key 失败: %v", err)
+			}
+			if stored.KeyHash != hashToken(plaintext) || strings.Contains(stored.KeyHash, plaintext) {
+				t.Fatalf("应只保存明文的哈希: %+v", stored)
+			}
+			if got := APIKeyPermissions(&stored); strings.Join(got, ",") != strings.Join(tt.permissions, ",") {
+				t.Fatalf("权限不符: %v", got)
+			}
+			if (stored.DeviceAddr != nil) != (tt.deviceAddr != "") {
+				t.Fatalf("设备绑定不符: %v", stored.DeviceAddr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 在创建 Key 后调整状态，返回用于认证的明文
+		prepare func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string
+		// unknown 为 true 时认证使用的明文不对应已创建的 Key
+		unknown bool
+		wantErr error
+	}{
+		{
+			name: "有效",
+			prepare: func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string {
+				return plaintext
+			},
+		},
+		{
+			name: "前缀不符",
+			prepare: func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string {
+				return strings.TrimPrefix(plaintext, apiKeyPrefix)
+			},
+			unknown: true,
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "不存在",
+			prepare: func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string {
+				return plaintext + "x"
+			},
+			unknown: true,
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "已吊销",
+			prepare: func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string {
+				if err := s.RevokeAPIKey(key.ID); err != nil {
+					t.Fatalf("吊销失败: %v", err)
+				}
+				return plaintext
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "已过期",
+			prepare: func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string {
+				if err := client.DB.Model(key).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+					t.Fatalf("调整过期时间失败: %v", err)
+				}
+				return plaintext
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name: "创建人已禁用",
+			prepare: func(t *testing.T, client *database.Client, s *APIKeyService, key *models.APIKey, plaintext string) string {
+				if err := client.DB.Model(&models.User{}).Where("id = ?", key.CreatedBy).Update("status", "disabled").Error; err != nil {
+					t.Fatalf("禁用创建人失败: %v", err)
+				}
+				return plaintext
+			},
+			wantErr: ErrInvalidAPIKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, s, creator := newTestAPIKeyFixture(t)
+			expiresAt := time.Now().Add(time.Hour)
+			key, plaintext, err := s.CreateAPIKey("station", []string{PermTestDataWrite}, "DEV001", &expiresAt, creator.ID)
+			if err != nil {
+				t.Fatalf("创建 API Key 失败: %v", err)
+			}
+
+			authenticated, err := s.Authenticate(tt.prepare(t, client, s, key, plaintext), "10.0.0.8")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			// 长连接定期校验与认证时的结论一致
+			if validateErr := s.ValidateAPIKey(key.ID); !tt.unknown && !errors.Is(validateErr, tt.wantErr) {
+				t.Fatalf("ValidateAPIKey 期望 %v，实际 %v", tt.wantErr, validateErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var stored models.APIKey
+			if err := client.DB.First(&stored, key.ID).Error; err != nil {
+				t.Fatalf("查询 API Key 失败: %v", err)
+			}
+			if stored.LastUsedAt == nil || stored.LastUsedIP != "10.0.0.8" {
+				t.Fatalf("应记录最近使用时间和IP: %+v", stored)
+			}
+			if authenticated.DeviceAddr == nil || *authenticated.DeviceAddr != "DEV001" || authenticated.CreatedBy != creator.ID {
+				t.Fatalf("认证结果不符: %+v", authenticated)
+			}
+		})
+	}
+}
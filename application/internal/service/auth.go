@@ -1,89 +1,256 @@
 package service
 
 import (
+	"cert-system/config"
 	"cert-system/internal/database"
 	"cert-system/internal/helper" // 假设你有一个用于哈希的 helper 包
 	"cert-system/internal/models"
 	"errors"
 	"log"
 	"time"
-	"crypto/sha256" // 导入 sha256 包
-	"encoding/hex"  // 导入 encoding/hex 包
 )
 
 // AuthService 认证服务
 type AuthService struct {
-	dbClient *database.Client
+	dbClient   *database.Client
+	passwords  *helper.PasswordManager
+	policy     *PasswordPolicy
+	protection *LoginProtection
+	tokens     *helper.JWTManager
+	refreshTTL time.Duration
+	twoFactor  config.TwoFactorConfig
+	providers  *AuthProviders
 }
 
 // NewAuthService 创建新的 AuthService
-func NewAuthService(dbClient *database.Client) *AuthService {
+func NewAuthService(dbClient *database.Client, passwords *helper.PasswordManager, policy *PasswordPolicy, protection *LoginProtection, tokens *helper.JWTManager, refreshTTL time.Duration, twoFactor config.TwoFactorConfig, providers *AuthProviders) *AuthService {
 	return &AuthService{
-		dbClient: dbClient,
+		dbClient:   dbClient,
+		passwords:  passwords,
+		policy:     policy,
+		protection: protection,
+		tokens:     tokens,
+		refreshTTL: refreshTTL,
+		twoFactor:  twoFactor,
+		providers:  providers,
 	}
 }
 
-// hashPassword 使用 SHA256 对密码进行哈希（与数据库中的存储方式一致）
-// 在实际应用中，请考虑使用更安全的 bcrypt 或 Argon2
-func hashPassword(password string) string {
-	hasher := sha256.New()
-	hasher.Write([]byte(password))
-	return hex.EncodeToString(hasher.Sum(nil))
-}
+// Login 登录功能，ip 和 userAgent 用于失败限流和登录审计
+func (s *AuthService) Login(username, password, ip, userAgent string) (*models.LoginResponse, error) {
+	now := time.Now()
 
-// Login 登录功能
-func (s *AuthService) Login(username, password string) (*models.LoginResponse, error) {
-	// 1. 根据用户名查询数据库中的用户
-	var user models.User
-	// 注意：这里的查询现在会使用 models.User 结构体，并且 GORM 会自动忽略 PasswordHash 字段（如果它的 GORM tag 是 json:"-"）
-	// 我们需要显式查询 PasswordHash
-	result := s.dbClient.DB.Where("username = ?", username).First(&user)
-	if result.Error != nil {
-		if errors.Is(result.Error, database.ErrRecordNotFound) {
-			log.Printf("用户 %s 未找到", username)
-			return nil, errors.New("用户名或密码错误")
+	// 1. 同一IP失败次数过多时直接拒绝，不再查询账号
+	if err := s.protection.CheckIP(ip, now); err != nil {
+		var throttled *LoginThrottledError
+		if errors.As(err, &throttled) {
+			s.protection.RecordAttempt(username, nil, ip, userAgent, false, loginReasonIPThrottled)
 		}
+		return nil, err
+	}
+
+	// 2. 根据用户名查询数据库中的用户，不存在时交给支持自动开通的外部认证源（如 LDAP）
+	var user *models.User
+	var existing models.User
+	result := s.dbClient.DB.Where("username = ?", username).First(&existing)
+	if result.Error != nil && !errors.Is(result.Error, database.ErrRecordNotFound) {
 		log.Printf("数据库查询失败: %v", result.Error)
 		return nil, result.Error
 	}
+	if result.Error == nil {
+		user = &existing
+	}
+	var userID *int64
+	if user != nil {
+		userID = &user.ID
+	}
+
+	// 3. 账号处于锁定期或退避期时不校验密码
+	if user != nil {
+		if reason, err := s.protection.CheckUser(user, now); err != nil {
+			s.protection.RecordAttempt(username, userID, ip, userAgent, false, reason)
+			return nil, err
+		}
+	}
 
-	// 2. 验证密码哈希
-	// 将用户输入的明文密码进行哈希，然后与数据库中存储的哈希值进行比较
-	inputPasswordHash := hashPassword(password)
+	// 4. 由账号对应的认证提供方校验密码
+	identity, err := s.authenticate(user, username, password)
+	if err != nil {
+		if errors.Is(err, ErrNoMappedRole) {
+			s.protection.RecordAttempt(username, userID, ip, userAgent, false, loginReasonNotAuthorized)
+			return nil, err
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("用户 %s 认证失败: %v", username, err)
+			return nil, err
+		}
+		if user != nil {
+			if err := s.protection.RecordUserFailure(user.ID, now); err != nil {
+				log.Printf("记录登录失败次数失败: %v", err)
+			}
+		}
+		s.protection.RecordAttempt(username, userID, ip, userAgent, false, loginReasonBadCredentials)
+		return nil, ErrInvalidCredentials
+	}
 
-	if user.PasswordHash != inputPasswordHash {
-		log.Printf("用户 %s 密码验证失败", username)
-		return nil, errors.New("用户名或密码错误")
+	// 外部账号首次登录时自动创建本地用户，之后每次登录同步角色
+	if identity.Provider != localProviderName {
+		if user, err = s.syncExternalUser(identity, user); err != nil {
+			if errors.Is(err, ErrAccountConflict) {
+				s.protection.RecordAttempt(username, userID, ip, userAgent, false, loginReasonAccountConflict)
+			}
+			return nil, err
+		}
 	}
 
-	// 3. 生成JWT令牌
-	// 假设 helper.GenerateJWT 函数能正确生成 token
-	tokenString, expirationTime, err := helper.GenerateJWT(user.ID, user.Username, user.Role)
+	return s.completeLogin(user, ip, userAgent)
+}
+
+// authenticate 依次尝试账号可用的认证提供方，全部失败时返回最后一个错误
+func (s *AuthService) authenticate(user *models.User, username, password string) (*ExternalIdentity, error) {
+	err := ErrInvalidCredentials
+	for _, provider := range s.providers.forUser(user) {
+		var identity *ExternalIdentity
+		identity, err = provider.Authenticate(username, password)
+		if err == nil {
+			return identity, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// completeLogin 身份校验通过后的公共流程：检查账号状态，已启用两步验证时返回登录挑战，否则创建会话
+func (s *AuthService) completeLogin(user *models.User, ip, userAgent string) (*models.LoginResponse, error) {
+	// 被禁用的账号不允许登录
+	if user.Status == "disabled" {
+		s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, false, loginReasonDisabled)
+		return nil, ErrAccountDisabled
+	}
+
+	// 已启用两步验证的账号先返回登录挑战，验证码通过后才创建会话
+	if user.TOTPEnabled {
+		resp, err := s.startTwoFactorChallenge(user, ip, userAgent)
+		if err != nil {
+			log.Printf("创建两步验证挑战失败: %v", err)
+			return nil, errors.New("登录失败，请稍后再试")
+		}
+		return resp, nil
+	}
+
+	// 5. 创建登录会话，生成访问令牌和刷新令牌
+	resp, err := s.startSession(user, ip, userAgent)
 	if err != nil {
 		log.Printf("生成JWT令牌失败: %v", err)
 		return nil, errors.New("登录失败，请稍后再试")
 	}
 
-	// 4. 返回登录响应
-	resp := &models.LoginResponse{
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := s.protection.ResetUser(user.ID); err != nil {
+			log.Printf("重置登录失败次数失败: %v", err)
+		}
+	}
+	s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, true, "")
+	return resp, nil
+}
+
+// issueLoginResponse 为会话生成访问令牌，并标明是否需要先修改密码
+func (s *AuthService) issueLoginResponse(user *models.User, sessionID, refreshToken string, refreshExpiresAt time.Time) (*models.LoginResponse, error) {
+	// 外部认证账号的密码由身份源管理，不检查本地密码状态
+	reason := ""
+	if isLocalAccount(user) {
+		if user.MustChangePassword {
+			reason = "initial"
+		} else if s.policy.IsExpired(user.PasswordChangedAt) {
+			reason = "expired"
+		}
+	}
+	changeRequired := reason != ""
+	setupRequired := s.twoFactorRequiredFor(user.Role) && !user.TOTPEnabled
+
+	tokenString, expirationTime, err := s.tokens.Generate(user.ID, user.Username, user.Role, sessionID, changeRequired, setupRequired)
+	if err != nil {
+		return nil, err
+	}
+
+	message := "登录成功"
+	if changeRequired {
+		message = "登录成功，请先修改密码"
+	} else if setupRequired {
+		message = "登录成功，请先启用两步验证"
+	}
+	return &models.LoginResponse{
 		Code:    200,
-		Message: "登录成功",
-		Data: struct {
-			Token     string `json:"token"`
-			UserID    int64  `json:"userId"`
-			Username  string `json:"username"`
-			Role      string `json:"role"`
-			ExpiresAt string `json:"expiresAt"`
-		}{
-			Token:     tokenString,
-			UserID:    user.ID,
-			Username:  user.Username,
-			Role:      user.Role,
-			ExpiresAt: expirationTime.Format(time.RFC3339),
+		Message: message,
+		Data: models.LoginData{
+			Token:                  tokenString,
+			UserID:                 user.ID,
+			Username:               user.Username,
+			Role:                   user.Role,
+			ExpiresAt:              expirationTime.Format(time.RFC3339),
+			RefreshToken:           refreshToken,
+			RefreshExpiresAt:       refreshExpiresAt.Format(time.RFC3339),
+			PasswordChangeRequired: changeRequired,
+			PasswordChangeReason:   reason,
+			TwoFactorSetupRequired: setupRequired,
 		},
+	}, nil
+}
+
+// ChangePassword 用户修改自己的密码。当前密码错误计入账号的连续失败次数，账号锁定或退避期间拒绝修改；
+// 成功后注销该用户的所有会话，并返回新会话的登录令牌
+func (s *AuthService) ChangePassword(userID int64, currentPassword, newPassword, ip, userAgent string) (*models.LoginResponse, error) {
+	now := time.Now()
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if !isLocalAccount(&user) {
+		return nil, ErrExternalAccount
+	}
+	if reason, err := s.protection.CheckUser(&user, now); err != nil {
+		s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, false, reason)
+		return nil, err
 	}
 
-	return resp, nil
+	ok, _, err := s.passwords.Verify(currentPassword, user.PasswordHash)
+	if err != nil || !ok {
+		s.recordReauthFailure(&user, ip, userAgent, loginReasonBadCredentials, now)
+		return nil, ErrWrongPassword
+	}
+
+	if err := setPassword(s.dbClient.DB, s.passwords, s.policy, &user, newPassword, false); err != nil {
+		return nil, err
+	}
+	if err := revokeUserSessions(s.dbClient.DB, user.ID, revokeReasonPasswordChanged); err != nil {
+		return nil, err
+	}
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := s.protection.ResetUser(user.ID); err != nil {
+			log.Printf("重置登录失败次数失败: %v", err)
+		}
+	}
+
+	return s.startSession(&user, ip, userAgent)
+}
+
+// ParseToken 校验访问令牌及其所属会话，返回令牌中的声明
+func (s *AuthService) ParseToken(tokenString string) (*models.JWTClaims, error) {
+	claims, err := s.tokens.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ValidateSession(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// JWKS 返回用于校验令牌的公钥集合
+func (s *AuthService) JWKS() helper.JWKS {
+	return s.tokens.JWKS()
 }
 
 // GetProfile 获取用户信息
@@ -95,4 +262,4 @@ func (s *AuthService) GetProfile(userID int64) (*models.User, error) {
 		return nil, result.Error
 	}
 	return &user, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 认证提供方名称，与 users.auth_provider 对应
+const (
+	localProviderName = "local"
+	ldapProviderName  = "ldap"
+	oidcProviderName  = "oidc"
+)
+
+// ErrNoMappedRole 外部账号不属于任何已授权的组
+var ErrNoMappedRole = errors.New("账号未被授权访问本系统，请联系管理员")
+
+// ErrExternalAccount 外部认证账号不能在本系统修改或重置密码
+var ErrExternalAccount = errors.New("该账号使用统一身份认证登录，请在统一身份认证系统中修改密码")
+
+// ErrAccountConflict 外部账号的用户名已被其他认证方式的本地账号占用
+var ErrAccountConflict = errors.New("用户名已被其他账号占用，请联系管理员")
+
+// ErrUnknownProvider 认证提供方不存在或未启用
+var ErrUnknownProvider = errors.New("认证方式不存在或未启用")
+
+// ExternalIdentity 认证提供方校验通过后返回的身份
+type ExternalIdentity struct {
+	Provider string // 认证提供方名称
+	Subject  string // 身份源中的唯一标识（LDAP DN / OIDC sub），本地账号为用户名
+	Username string
+	Role     string // 按组映射后的角色
+}
+
+// AuthProvider 用户名密码认证方式（本地密码、LDAP 绑定等）
+type AuthProvider interface {
+	// Name 提供方名称
+	Name() string
+	// Authenticate 校验用户名和密码，用户名或密码错误时返回 ErrInvalidCredentials
+	Authenticate(username, password string) (*ExternalIdentity, error)
+}
+
+// RedirectProvider 需要跳转到身份提供方登录的认证方式（OIDC 等）
+type RedirectProvider interface {
+	// Name 提供方名称
+	Name() string
+	// DisplayName 登录页显示的名称
+	DisplayName() string
+	// AuthCodeURL 返回跳转到身份提供方的授权地址
+	AuthCodeURL(state, nonce, codeChallenge string) (string, error)
+	// Exchange 使用授权码换取并校验身份
+	Exchange(code, codeVerifier, nonce string) (*ExternalIdentity, error)
+	// PostLoginRedirect 登录完成后跳转的前端页面
+	PostLoginRedirect() string
+	// StateTTL 跳转到身份提供方后完成登录的时限
+	StateTTL() time.Duration
+}
+
+// AuthProviders 已启用的认证提供方
+type AuthProviders struct {
+	password map[string]AuthProvider
+	redirect map[string]RedirectProvider
+	// provisioning 本地不存在的用户名依次尝试的提供方（首次登录自动开通）
+	provisioning []AuthProvider
+}
+
+// NewAuthProviders 根据配置创建认证提供方，本地密码认证总是启用
+func NewAuthProviders(dbClient *database.Client, passwords *helper.PasswordManager, cfg config.SSOConfig) *AuthProviders {
+	p := &AuthProviders{
+		password: map[string]AuthProvider{},
+		redirect: map[string]RedirectProvider{},
+	}
+	p.password[localProviderName] = &localProvider{dbClient: dbClient, passwords: passwords}
+
+	if cfg.LDAP.Enabled {
+		ldap := NewLDAPProvider(cfg.LDAP)
+		p.password[ldap.Name()] = ldap
+		p.provisioning = append(p.provisioning, ldap)
+		log.Printf("已启用 LDAP 认证: %s", cfg.LDAP.URL)
+	}
+	if cfg.OIDC.Enabled {
+		oidc := NewOIDCProvider(cfg.OIDC)
+		p.redirect[oidc.Name()] = oidc
+		log.Printf("已启用 OIDC 认证: %s", cfg.OIDC.IssuerURL)
+	}
+	return p
+}
+
+// forUser 返回已存在用户对应的认证提供方，用户不存在时返回支持自动开通的提供方
+func (p *AuthProviders) forUser(user *models.User) []AuthProvider {
+	if user == nil {
+		return p.provisioning
+	}
+	if provider, ok := p.password[accountProvider(user)]; ok {
+		return []AuthProvider{provider}
+	}
+	return nil
+}
+
+// Redirect 返回跳转登录的认证提供方
+func (p *AuthProviders) Redirect(name string) (RedirectProvider, error) {
+	provider, ok := p.redirect[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// List 返回登录页可用的认证方式
+func (p *AuthProviders) List() []models.AuthProviderInfo {
+	infos := []models.AuthProviderInfo{{Name: localProviderName, DisplayName: "用户名密码"}}
+	if _, ok := p.password[ldapProviderName]; ok {
+		infos[0].DisplayName = "用户名密码（支持域账号）"
+	}
+	names := make([]string, 0, len(p.redirect))
+	for name := range p.redirect {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		infos = append(infos, models.AuthProviderInfo{Name: name, DisplayName: p.redirect[name].DisplayName(), Redirect: true})
+	}
+	return infos
+}
+
+// accountProvider 返回账号的认证提供方，旧数据为空时视为本地账号
+func accountProvider(user *models.User) string {
+	if user.AuthProvider == "" {
+		return localProviderName
+	}
+	return user.AuthProvider
+}
+
+// isLocalAccount 判断是否为本地密码账号
+func isLocalAccount(user *models.User) bool {
+	return accountProvider(user) == localProviderName
+}
+
+// roleRank 角色权限高低，多个组匹配时取权限最高的角色
+var roleRank = map[string]int{"viewer": 1, "operator": 2, "admin": 3}
+
+// mapGroupsToRole 按组映射角色，组名不区分大小写，LDAP 组 DN 也可用其 CN 匹配
+func mapGroupsToRole(groups []string, groupRoles map[string]string, defaultRole string) (string, error) {
+	mapping := make(map[string]string, len(groupRoles))
+	for group, role := range groupRoles {
+		mapping[strings.ToLower(strings.TrimSpace(group))] = role
+	}
+
+	role := ""
+	for _, group := range groups {
+		for _, name := range groupNames(group) {
+			if r, ok := mapping[name]; ok && roleRank[r] > roleRank[role] {
+				role = r
+			}
+		}
+	}
+	if role == "" {
+		role = defaultRole
+	}
+	if roleRank[role] == 0 {
+		return "", ErrNoMappedRole
+	}
+	return role, nil
+}
+
+// groupNames 返回组的匹配名称：完整名称，以及 DN 形式时第一个 RDN 的值
+func groupNames(group string) []string {
+	group = strings.ToLower(strings.TrimSpace(group))
+	names := []string{group}
+	if first, _, found := strings.Cut(group, ","); found {
+		if _, value, ok := strings.Cut(first, "="); ok {
+			names = append(names, strings.TrimSpace(value))
+		}
+	}
+	return names
+}
+
+// localProvider 本地密码认证
+type localProvider struct {
+	dbClient  *database.Client
+	passwords *helper.PasswordManager
+}
+
+func (p *localProvider) Name() string {
+	return localProviderName
+}
+
+// Authenticate 校验本地密码哈希（根据存储的哈希格式选择算法），旧格式或弱参数的哈希校验通过后透明升级
+func (p *localProvider) Authenticate(username, password string) (*ExternalIdentity, error) {
+	var user models.User
+	if err := p.dbClient.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if !isLocalAccount(&user) {
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, needsRehash, err := p.passwords.Verify(password, user.PasswordHash)
+	if err != nil {
+		log.Printf("用户ID %d 密码哈希无法校验: %v", user.ID, err)
+	}
+	if err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		p.rehashPassword(&user, password)
+	}
+	return &ExternalIdentity{Provider: localProviderName, Subject: user.Username, Username: user.Username, Role: user.Role}, nil
+}
+
+// rehashPassword 使用当前算法重新计算并保存用户密码哈希，失败不影响本次登录
+func (p *localProvider) rehashPassword(user *models.User, password string) {
+	hash, err := p.passwords.Hash(password)
+	if err != nil {
+		log.Printf("用户 %s 密码哈希升级失败: %v", user.Username, err)
+		return
+	}
+	// 仅在哈希未被并发修改时更新
+	result := p.dbClient.DB.Model(&models.User{}).
+		Where("id = ? AND password_hash = ?", user.ID, user.PasswordHash).
+		Update("password_hash", hash)
+	if result.Error != nil {
+		log.Printf("用户 %s 密码哈希升级失败: %v", user.Username, result.Error)
+		return
+	}
+	user.PasswordHash = hash
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMapGroupsToRole(t *testing.T) {
+	groupRoles := map[string]string{
+		"Cert-Admins": "admin",
+		"cn=operators,ou=groups,dc=example,dc=com": "operator",
+		"staff":    "viewer",
+		"unmapped": "superuser", // 不存在的角色不能授予
+	}
+
+	tests := []struct {
+		name        string
+		groups      []string
+		defaultRole string
+		want        string
+		wantErr     error
+	}{
+		{name: "组名不区分大小写", groups: []string{"cert-admins"}, want: "admin"},
+		{name: "多个组取权限最高的角色", groups: []string{"staff", "CERT-ADMINS", "operators"}, want: "admin"},
+		{name: "按完整 DN 匹配", groups: []string{"CN=Operators,OU=Groups,DC=example,DC=com"}, want: "operator"},
+		{name: "按 DN 的 CN 匹配", groups: []string{"cn=staff,ou=groups,dc=example,dc=com"}, want: "viewer"},
+		{name: "未匹配时使用默认角色", groups: []string{"others"}, defaultRole: "viewer", want: "viewer"},
+		{name: "未匹配且无默认角色时拒绝", groups: []string{"others"}, wantErr: ErrNoMappedRole},
+		{name: "没有任何组时拒绝", groups: nil, wantErr: ErrNoMappedRole},
+		{name: "映射到未知角色时拒绝", groups: []string{"unmapped"}, wantErr: ErrNoMappedRole},
+		{name: "默认角色无效时拒绝", groups: nil, defaultRole: "root", wantErr: ErrNoMappedRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := mapGroupsToRole(tt.groups, groupRoles, tt.defaultRole)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("期望错误 %v，实际 role=%q err=%v", tt.wantErr, role, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("意外错误: %v", err)
+			}
+			if role != tt.want {
+				t.Fatalf("期望角色 %q，实际 %q", tt.want, role)
+			}
+		})
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	if got := claimStrings("admins"); len(got) != 1 || got[0] != "admins" {
+		t.Fatalf("字符串声明: %v", got)
+	}
+	if got := claimStrings([]interface{}{"a", 1, "b"}); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("数组声明: %v", got)
+	}
+	if got := claimStrings(nil); got != nil {
+		t.Fatalf("缺少声明: %v", got)
+	}
+}
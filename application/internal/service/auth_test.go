@@ -0,0 +1,140 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword 测试用户的初始密码，满足默认密码策略
+const testPassword = "Init-Passw0rd"
+
+// newTestAuthService 创建使用 HS256 令牌和最低成本 bcrypt 的认证服务，login 为登录防护配置
+func newTestAuthService(t *testing.T, client *database.Client, login config.LoginProtectionConfig) *AuthService {
+	t.Helper()
+	passwords := helper.NewPasswordManager(helper.NewBcryptHasher(bcrypt.MinCost), helper.LegacySHA256Hasher{})
+	tokens, err := helper.NewJWTManager(config.JWTConfig{Secret: "test-secret"})
+	if err != nil {
+		t.Fatalf("初始化JWT密钥失败: %v", err)
+	}
+	return NewAuthService(client, passwords, NewPasswordPolicy(config.PasswordConfig{}), NewLoginProtection(client, login),
+		tokens, time.Hour, config.TwoFactorConfig{}, NewAuthProviders(client, passwords, config.SSOConfig{}))
+}
+
+// createTestUser 创建密码为 testPassword 的本地用户
+func createTestUser(t *testing.T, s *AuthService, username, role string) *models.User {
+	t.Helper()
+	hash, err := s.passwords.Hash(testPassword)
+	if err != nil {
+		t.Fatalf("计算密码哈希失败: %v", err)
+	}
+	user := &models.User{Username: username, PasswordHash: hash, Role: role, Status: "active", PasswordChangedAt: time.Now()}
+	if err := s.dbClient.DB.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	client := newTestDB(t)
+	// 不设退避，第 3 次连续失败时锁定
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 3, LockDuration: "30m"})
+	user := createTestUser(t, s, "alice", "operator")
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.ChangePassword(user.ID, "wrong-password", "New-Passw0rd!", "10.0.0.1", "test"); !errors.Is(err, ErrWrongPassword) {
+			t.Fatalf("第 %d 次期望 ErrWrongPassword，实际 %v", i+1, err)
+		}
+	}
+
+	// 锁定后即使当前密码正确也拒绝修改
+	var throttled *LoginThrottledError
+	if _, err := s.ChangePassword(user.ID, testPassword, "New-Passw0rd!", "10.0.0.1", "test"); !errors.As(err, &throttled) {
+		t.Fatalf("期望账号锁定，实际 %v", err)
+	}
+
+	var attempts []models.LoginAttempt
+	if err := client.DB.Where("user_id = ?", user.ID).Order("id ASC").Find(&attempts).Error; err != nil {
+		t.Fatalf("查询登录审计记录失败: %v", err)
+	}
+	if len(attempts) != 4 || attempts[0].Reason != loginReasonBadCredentials || attempts[3].Reason != loginReasonLocked {
+		t.Fatalf("审计记录不符: %+v", attempts)
+	}
+}
+
+func TestChangePasswordResetsFailures(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 5})
+	user := createTestUser(t, s, "bob", "operator")
+
+	if _, err := s.ChangePassword(user.ID, "wrong-password", "New-Passw0rd!", "10.0.0.1", "test"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("期望 ErrWrongPassword，实际 %v", err)
+	}
+	if _, err := s.ChangePassword(user.ID, testPassword, "New-Passw0rd!", "10.0.0.1", "test"); err != nil {
+		t.Fatalf("修改密码失败: %v", err)
+	}
+
+	var stored models.User
+	if err := client.DB.First(&stored, user.ID).Error; err != nil {
+		t.Fatalf("查询用户失败: %v", err)
+	}
+	if stored.FailedLoginCount != 0 || stored.LastFailedLoginAt != nil {
+		t.Fatalf("修改成功后应清除失败计数: %+v", stored)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	legacyHash, _ := helper.LegacySHA256Hasher{}.Hash(testPassword)
+	weakBcrypt, _ := helper.NewBcryptHasher(bcrypt.MinCost).Hash(testPassword)
+	tests := []struct {
+		name       string
+		storedHash string
+		password   string
+		wantErr    error
+		// wantUpgrade 为 true 时期望登录后哈希升级为当前算法（cost 为 MinCost+1 的 bcrypt）
+		wantUpgrade bool
+	}{
+		{name: "旧版 SHA-256 登录后升级", storedHash: legacyHash, password: testPassword, wantUpgrade: true},
+		{name: "bcrypt 成本较低时升级", storedHash: weakBcrypt, password: testPassword, wantUpgrade: true},
+		{name: "密码错误时不升级", storedHash: legacyHash, password: "wrong-password", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 100})
+			s.passwords = helper.NewPasswordManager(helper.NewBcryptHasher(bcrypt.MinCost+1), helper.LegacySHA256Hasher{})
+			s.providers = NewAuthProviders(client, s.passwords, config.SSOConfig{})
+			user := createTestUser(t, s, "alice", "operator")
+			if err := client.DB.Model(user).Update("password_hash", tt.storedHash).Error; err != nil {
+				t.Fatalf("设置密码哈希失败: %v", err)
+			}
+
+			if _, err := s.Login("alice", tt.password, "10.0.0.1", "test"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			var stored models.User
+			if err := client.DB.First(&stored, user.ID).Error; err != nil {
+				t.Fatalf("查询用户失败: %v", err)
+			}
+			if !tt.wantUpgrade {
+				if stored.PasswordHash != tt.storedHash {
+					t.Fatalf("密码错误时不应修改哈希: %s", stored.PasswordHash)
+				}
+				return
+			}
+			if cost, err := bcrypt.Cost([]byte(stored.PasswordHash)); err != nil || cost != bcrypt.MinCost+1 {
+				t.Fatalf("期望升级为当前 bcrypt 参数，实际 %s", stored.PasswordHash)
+			}
+			// 升级后的哈希仍可用原密码登录
+			if _, err := s.Login("alice", testPassword, "10.0.0.1", "test"); err != nil {
+				t.Fatalf("升级后登录失败: %v", err)
+			}
+		})
+	}
+}
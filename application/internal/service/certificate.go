@@ -2,71 +2,117 @@ package service
 
 import (
 	"cert-system/internal/database"
+	"cert-system/internal/fabric"
 	"cert-system/internal/models"
 	"gorm.io/gorm"
-	"crypto/sha256"
-	"encoding/hex"
+	"gorm.io/gorm/clause"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"strings"
 	"time"
 )
 
+// ErrLedgerUnavailable 未配置Fabric客户端时访问账本数据返回的错误
+var ErrLedgerUnavailable = errors.New("区块链账本未启用")
+
+// ErrVersionNotFound 账本中不存在指定交易ID对应的证书版本
+var ErrVersionNotFound = errors.New("证书版本不存在")
+
+// ErrInvalidVersionRange 比较的起始版本不早于结束版本
+var ErrInvalidVersionRange = errors.New("起始版本必须早于结束版本")
+
+// ErrVersionConflict 证书已被他人修改，提交的版本号不是最新版本
+var ErrVersionConflict = errors.New("证书已被修改，请刷新后重试")
+
+// ErrCertificateLocked 已签发或已撤销的证书不允许重新评定不确定度或添加测试数据
+var ErrCertificateLocked = errors.New("证书已签发或已撤销")
+
+// ErrUncertaintyStale 不确定度评定后又新增了测试数据，重新评定前不能签发
+var ErrUncertaintyStale = errors.New("测量不确定度评定后新增了测试数据，请重新评定后再签发")
+
+// ErrCertNumberTaken 证书编号已被其他证书使用
+var ErrCertNumberTaken = errors.New("证书编号已存在")
+
+// ErrCertNumberImmutable 证书已上链，账本以证书编号为键，不允许再修改证书编号
+var ErrCertNumberImmutable = errors.New("证书已上链，不能修改证书编号")
+
 // CertificateService 证书服务
 type CertificateService struct {
-	dbClient *database.Client
+	dbClient     *database.Client
+	fabricClient *fabric.Client // 为 nil 时不与账本同步
+	evaluator    *ResultEvaluator
+	uncertainty  *UncertaintyCalculator
 }
 
 // NewCertificateService 创建新的 CertificateService
-func NewCertificateService(dbClient *database.Client) *CertificateService {
+func NewCertificateService(dbClient *database.Client, fabricClient *fabric.Client, evaluator *ResultEvaluator, uncertainty *UncertaintyCalculator) *CertificateService {
 	return &CertificateService{
-		dbClient: dbClient,
+		dbClient:     dbClient,
+		fabricClient: fabricClient,
+		evaluator:    evaluator,
+		uncertainty:  uncertainty,
 	}
 }
 
-// CreateCertificate 创建证书
 // CreateCertificate 创建证书
 func (s *CertificateService) CreateCertificate(cert *models.Certificate) error {
-	// 先保存到数据库
-	result := s.dbClient.DB.Create(cert)
-	if result.Error != nil {
-		return result.Error
-	}
-	
-	// 生成区块链哈希（使用证书数据计算SHA256）
-	hashData := fmt.Sprintf("%s|%d|%s|%s|%s", 
-		cert.CertNumber, 
-		cert.CustomerID, 
-		cert.InstrumentName,
-		cert.TestDate.Format("2006-01-02"),
-		cert.TestResult)
-	
-	hash := sha256.Sum256([]byte(hashData))
-	cert.BlockchainTxID = hex.EncodeToString(hash[:16]) // 使用前16字节作为简短ID
-	cert.BlockchainHash = hex.EncodeToString(hash[:])   // 完整哈希
-	
-	// 更新数据库中的区块链信息
-	updates := map[string]interface{}{
-		"blockchain_tx_id": cert.BlockchainTxID,
-		"blockchain_hash": cert.BlockchainHash,
-	}
-	
-	s.dbClient.DB.Model(cert).Updates(updates)
-	
-	// 如果有Fabric客户端，这里调用链码
-	// if s.fabricClient != nil {
-	//     txID, err := s.fabricClient.CreateCertificate(cert)
-	//     if err == nil {
-	//         cert.BlockchainTxID = txID
-	//         s.dbClient.DB.Model(cert).Update("blockchain_tx_id", txID)
-	//     }
-	// }
-	
-	return nil
+	cert.Version = 1
+	return s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := loadCertificateCustomer(tx, cert); err != nil {
+			return err
+		}
+
+		// 先保存到数据库
+		if err := tx.Omit(clause.Associations).Create(cert).Error; err != nil {
+			return err
+		}
+
+		// 生成区块链哈希（使用证书数据计算SHA256）
+		cert.BlockchainHash = certificateHash(cert)
+		cert.BlockchainTxID = cert.BlockchainHash[:32] // 使用前16字节作为简短ID
+
+		// 如果有Fabric客户端，调用链码上链并使用真实交易ID
+		if s.fabricClient != nil {
+			blockchainCert, err := s.toBlockchainCertificate(tx, cert)
+			if err != nil {
+				return err
+			}
+			txID, err := s.fabricClient.CreateCertificate(blockchainCert)
+			if err != nil {
+				return fmt.Errorf("证书上链失败: %w", err)
+			}
+			cert.BlockchainTxID = txID
+		}
+
+		// 更新数据库中的区块链信息
+		updates := map[string]interface{}{
+			"blockchain_tx_id": cert.BlockchainTxID,
+			"blockchain_hash":  cert.BlockchainHash,
+		}
+		if err := tx.Model(cert).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// 记录创建操作，新值为证书的全部业务字段
+		changes, err := diffFields(struct{}{}, cert, certificateDiffIgnored...)
+		if err != nil {
+			return err
+		}
+		history, err := newCertificateHistory(cert, "create", changes, cert.CreatedBy, cert.BlockchainTxID)
+		if err != nil {
+			return err
+		}
+		return tx.Create(history).Error
+	})
 }
 
 // GetCertificateByNumber 根据证书编号获取证书
 func (s *CertificateService) GetCertificateByNumber(certNumber string) (*models.Certificate, error) {
 	var cert models.Certificate
-	result := s.dbClient.DB.Where("cert_number = ?", certNumber).First(&cert)
+	result := s.dbClient.DB.Preload("Customer").Where("cert_number = ?", certNumber).First(&cert)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -85,14 +131,288 @@ func (s *CertificateService) GetAllCertificates(page, pageSize int) ([]*models.C
 	}
 
 	// Fetch paginated records
-	result := s.dbClient.DB.Offset(offset).Limit(pageSize).Find(&certificates)
+	result := s.dbClient.DB.Preload("Customer").Offset(offset).Limit(pageSize).Find(&certificates)
 	return certificates, total, result.Error
 }
 
-// UpdateCertificate 更新证书信息
-func (s *CertificateService) UpdateCertificate(cert *models.Certificate) error {
-	result := s.dbClient.DB.Save(cert)
-	return result.Error
+// UpdateCertificate 更新证书信息，并在同一事务中记录字段级变更
+// expectedVersion 为客户端读取时的版本号，与当前版本不一致时返回 ErrVersionConflict
+func (s *CertificateService) UpdateCertificate(cert *models.Certificate, operatorID int64, expectedVersion int64) error {
+	return s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		// 锁定当前记录，确保版本检查和差异计算基于最新版本
+		var old models.Certificate
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&old, cert.ID).Error; err != nil {
+			return err
+		}
+		if old.Version != expectedVersion {
+			return ErrVersionConflict
+		}
+		// 账本记录以证书编号为键，改号后账本历史、版本差异和后续账本更新都将找不到原记录
+		if s.fabricClient != nil && cert.CertNumber != old.CertNumber {
+			return ErrCertNumberImmutable
+		}
+		if cert.CertNumber != old.CertNumber {
+			var count int64
+			if err := tx.Model(&models.Certificate{}).Where("cert_number = ? AND id <> ?", cert.CertNumber, cert.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: %s", ErrCertNumberTaken, cert.CertNumber)
+			}
+		}
+
+		// 评定结果及其是否过时只能由评定和测试数据入库修改
+		cert.UncertaintyBudget = old.UncertaintyBudget
+		cert.UncertaintyStale = old.UncertaintyStale
+
+		changes, err := diffFields(&old, cert, certificateDiffIgnored...)
+		if err != nil {
+			return err
+		}
+		// 没有实际变更时不产生新版本，保持与账本版本一致
+		if len(changes) == 0 {
+			cert.Version = old.Version
+			return nil
+		}
+
+		if cert.CustomerID != old.CustomerID {
+			if err := loadCertificateCustomer(tx, cert); err != nil {
+				return err
+			}
+		}
+
+		// 哈希覆盖的字段可能已变更，重新计算区块链哈希并计入变更记录
+		cert.BlockchainHash = certificateHash(cert)
+		if cert.BlockchainHash != old.BlockchainHash {
+			if changes, err = diffFields(&old, cert, certificateDiffIgnored...); err != nil {
+				return err
+			}
+		}
+
+		// 评定后新增的测试数据未计入评定结果，须重新评定后才能签发
+		if cert.Status == "issued" && old.Status != "issued" && cert.UncertaintyBudget != nil && cert.UncertaintyStale {
+			return ErrUncertaintyStale
+		}
+
+		// 签发时（或修改已签发证书的结论、器具、等级时）人工检定结果必须与自动判定一致
+		if cert.Status == "issued" && (old.Status != "issued" || cert.TestResult != old.TestResult ||
+			cert.InstrumentName != old.InstrumentName || cert.InstrumentAccuracy != old.InstrumentAccuracy) {
+			var data []*models.TestData
+			if err := tx.Where("cert_id = ?", cert.ID).Order("id ASC").Find(&data).Error; err != nil {
+				return err
+			}
+			if err := s.evaluator.CheckIssuance(cert, data); err != nil {
+				return err
+			}
+		}
+
+		cert.Version = old.Version + 1
+		if err := tx.Omit(clause.Associations).Save(cert).Error; err != nil {
+			return err
+		}
+
+		// 同步账本（携带新版本号，链码据此检测并发更新），账本写入失败时回滚数据库修改
+		var txID string
+		if s.fabricClient != nil {
+			blockchainCert, err := s.toBlockchainCertificate(tx, cert)
+			if err != nil {
+				return err
+			}
+			txID, err = s.fabricClient.UpdateCertificate(old.CertNumber, blockchainCert)
+			if err != nil {
+				// 链码以"版本冲突"报告账本上的并发修改
+				if strings.Contains(err.Error(), "版本冲突") {
+					return fmt.Errorf("%w: %v", ErrVersionConflict, err)
+				}
+				return fmt.Errorf("证书账本更新失败: %w", err)
+			}
+		}
+
+		history, err := newCertificateHistory(cert, historyOperationType(&old, cert), changes, operatorID, txID)
+		if err != nil {
+			return err
+		}
+		return tx.Create(history).Error
+	})
+}
+
+// EvaluateCertificateResult 根据证书的测试数据和误差限值表给出建议的检定结果及各测试点的判定说明
+func (s *CertificateService) EvaluateCertificateResult(certNumber string) (*models.ResultEvaluation, error) {
+	cert, err := s.GetCertificateByNumber(certNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []*models.TestData
+	if err := s.dbClient.DB.Where("cert_id = ?", cert.ID).Order("id ASC").Find(&data).Error; err != nil {
+		return nil, err
+	}
+	return s.evaluator.Evaluate(cert, data), nil
+}
+
+// CalculateUncertainty 根据证书的测试数据和所用检测设备评定测量不确定度，保存评定结果并重新计算区块链哈希。
+// 证书签发后评定结果随证书锁定，已签发或已撤销的证书返回 ErrCertificateLocked
+func (s *CertificateService) CalculateUncertainty(certNumber string, operatorID int64) (*models.Certificate, error) {
+	var cert models.Certificate
+	err := s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("cert_number = ?", certNumber).First(&cert).Error; err != nil {
+			return err
+		}
+		if cert.Status == "issued" || cert.Status == "revoked" {
+			return fmt.Errorf("%w，不能重新评定不确定度", ErrCertificateLocked)
+		}
+		old := cert
+
+		var data []*models.TestData
+		if err := tx.Where("cert_id = ?", cert.ID).Order("id ASC").Find(&data).Error; err != nil {
+			return err
+		}
+		addrs := make([]string, 0, len(data))
+		for _, d := range data {
+			addrs = append(addrs, d.DeviceAddr)
+		}
+		var registered []*models.Device
+		if err := tx.Where("device_addr IN ?", addrs).Find(&registered).Error; err != nil {
+			return err
+		}
+		devices := make(map[string]*models.Device, len(registered))
+		for _, d := range registered {
+			devices[d.DeviceAddr] = d
+		}
+
+		budget, err := s.uncertainty.Calculate(data, devices)
+		if err != nil {
+			return err
+		}
+		cert.UncertaintyBudget = budget
+		cert.UncertaintyStale = false
+		cert.BlockchainHash = certificateHash(&cert)
+		cert.Version = old.Version + 1
+
+		changes, err := diffFields(&old, &cert, certificateDiffIgnored...)
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Save(&cert).Error; err != nil {
+			return err
+		}
+
+		var txID string
+		if s.fabricClient != nil {
+			blockchainCert, err := s.toBlockchainCertificate(tx, &cert)
+			if err != nil {
+				return err
+			}
+			txID, err = s.fabricClient.UpdateCertificate(cert.CertNumber, blockchainCert)
+			if err != nil {
+				if strings.Contains(err.Error(), "版本冲突") {
+					return fmt.Errorf("%w: %v", ErrVersionConflict, err)
+				}
+				return fmt.Errorf("证书账本更新失败: %w", err)
+			}
+		}
+
+		history, err := newCertificateHistory(&cert, "uncertainty", changes, operatorID, txID)
+		if err != nil {
+			return err
+		}
+		return tx.Create(history).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := loadCertificateCustomer(s.dbClient.DB, &cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// loadCertificateCustomer 加载证书的委托方，委托方不存在时返回 ErrCustomerNotFound
+func loadCertificateCustomer(db *gorm.DB, cert *models.Certificate) error {
+	cert.Customer = models.Customer{}
+	if err := db.First(&cert.Customer, cert.CustomerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCustomerNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// historyOperationType 根据状态变化确定变更记录的操作类型
+func historyOperationType(old, cert *models.Certificate) string {
+	if old.Status != cert.Status {
+		switch cert.Status {
+		case "issued":
+			return "issue"
+		case "revoked":
+			return "revoke"
+		}
+	}
+	return "update"
+}
+
+// newCertificateHistory 根据字段变更构造证书变更记录
+func newCertificateHistory(cert *models.Certificate, operationType string, changes []models.FieldChange, operatorID int64, txID string) (*models.CertificateHistory, error) {
+	changedFields := make([]string, 0, len(changes))
+	oldValues := make(map[string]interface{}, len(changes))
+	newValues := make(map[string]interface{}, len(changes))
+	for _, change := range changes {
+		changedFields = append(changedFields, change.Field)
+		if operationType != "create" {
+			oldValues[change.Field] = change.OldValue
+		}
+		newValues[change.Field] = change.NewValue
+	}
+
+	history := &models.CertificateHistory{
+		CertID:         cert.ID,
+		CertNumber:     cert.CertNumber,
+		OperationType:  operationType,
+		BlockchainTxID: txID,
+		OperatorID:     operatorID,
+		OperationTime:  time.Now(),
+	}
+
+	var err error
+	if history.ChangedFields, err = json.Marshal(changedFields); err != nil {
+		return nil, err
+	}
+	if history.OldValues, err = json.Marshal(oldValues); err != nil {
+		return nil, err
+	}
+	if history.NewValues, err = json.Marshal(newValues); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// toBlockchainCertificate 将数据库证书转换为链上证书结构，委托方查询失败时返回错误，避免以空的委托方信息上链
+func (s *CertificateService) toBlockchainCertificate(db *gorm.DB, cert *models.Certificate) (*models.BlockchainCertificate, error) {
+	var customer models.Customer
+	if err := db.First(&customer, cert.CustomerID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrCustomerNotFound, cert.CustomerID)
+		}
+		return nil, fmt.Errorf("查询委托方 %d 失败: %w", cert.CustomerID, err)
+	}
+
+	return &models.BlockchainCertificate{
+		CertNumber:         cert.CertNumber,
+		CustomerName:       customer.CustomerName,
+		CustomerAddress:    customer.CustomerAddress,
+		InstrumentName:     cert.InstrumentName,
+		Manufacturer:       cert.Manufacturer,
+		ModelSpec:          cert.ModelSpec,
+		InstrumentNumber:   cert.InstrumentNumber,
+		InstrumentAccuracy: cert.InstrumentAccuracy,
+		TestDate:           cert.TestDate.Format("2006-01-02"),
+		ExpireDate:         cert.ExpireDate.Format("2006-01-02"),
+		TestResult:         cert.TestResult,
+		Status:             cert.Status,
+		UncertaintyHash:    uncertaintyBudgetHash(cert.UncertaintyBudget),
+		Version:            cert.Version,
+	}, nil
 }
 
 // DeleteCertificateByNumber 删除证书
@@ -128,17 +448,8 @@ func (s *CertificateService) VerifyCertificate(certNumber string) (*models.Certi
         message = "证书已过期"
     }
     
-    // 验证区块链哈希
-    hashData := fmt.Sprintf("%s|%d|%s|%s|%s", 
-        cert.CertNumber, 
-        cert.CustomerID, 
-        cert.InstrumentName,
-        cert.TestDate.Format("2006-01-02"),
-        cert.TestResult)
-    
-    hash := sha256.Sum256([]byte(hashData))
-    currentHash := hex.EncodeToString(hash[:])
-    isHashValid := (currentHash == cert.BlockchainHash)
+    // 验证区块链哈希（包含测量不确定度评定）
+    isHashValid := certificateHash(&cert) == cert.BlockchainHash
     
     return &models.CertificateVerification{
         Certificate:    &cert,
@@ -151,9 +462,211 @@ func (s *CertificateService) VerifyCertificate(certNumber string) (*models.Certi
     }, nil
 }
 
-// GetCertificateHistory 获取证书历史（占位符，需要根据实际业务逻辑实现）
+// ledgerHistoryRecord 链码 GetCertificateHistory 返回的单条记录
+type ledgerHistoryRecord struct {
+	TxID      string          `json:"TxId"`
+	Value     json.RawMessage `json:"Value"`
+	Timestamp string          `json:"Timestamp"`
+	IsDelete  bool            `json:"IsDelete"`
+}
+
+// GetCertificateHistory 获取证书历史，合并数据库变更记录与账本历史为一条时间线
 func (s *CertificateService) GetCertificateHistory(certNumber string) ([]*models.HistoryRecord, error) {
-	// 这里是占位符，实际逻辑需要从区块链或数据库中查询历史记录
-	// 暂时返回一个空切片，以保证编译通过
-	return []*models.HistoryRecord{}, nil
-}
\ No newline at end of file
+	cert, err := s.GetCertificateByNumber(certNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*models.CertificateHistory
+	err = s.dbClient.DB.Table("certificate_history AS h").
+		Select("h.*, u.username AS operator_name").
+		Joins("LEFT JOIN users u ON u.id = h.operator_id").
+		Where("h.cert_id = ?", cert.ID).
+		Order("h.operation_time ASC, h.id ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 账本不可用时仍返回数据库中的变更记录
+	ledgerRecords, err := s.getLedgerHistory(cert.CertNumber)
+	if err != nil {
+		log.Printf("查询证书 %s 账本历史失败: %v", cert.CertNumber, err)
+	}
+	return mergeCertificateHistory(rows, ledgerRecords)
+}
+
+// mergeCertificateHistory 按交易ID合并数据库变更记录与账本历史，两边都有的记录以账本的值和时间为准，结果按时间排序
+func mergeCertificateHistory(rows []*models.CertificateHistory, ledgerRecords []*ledgerHistoryRecord) ([]*models.HistoryRecord, error) {
+	records := make([]*models.HistoryRecord, 0, len(rows)+len(ledgerRecords))
+	byTxID := make(map[string]*models.HistoryRecord)
+	for _, row := range rows {
+		record := &models.HistoryRecord{
+			TxID:          row.BlockchainTxID,
+			Timestamp:     row.OperationTime.Format(time.RFC3339),
+			Source:        "database",
+			OperationType: row.OperationType,
+			OperatorID:    row.OperatorID,
+			OperatorName:  row.OperatorName,
+		}
+		if len(row.ChangedFields) > 0 {
+			if err := json.Unmarshal(row.ChangedFields, &record.ChangedFields); err != nil {
+				return nil, fmt.Errorf("解析变更字段失败: %w", err)
+			}
+		}
+		if len(row.OldValues) > 0 {
+			if err := json.Unmarshal(row.OldValues, &record.OldValues); err != nil {
+				return nil, fmt.Errorf("解析旧值失败: %w", err)
+			}
+		}
+		if len(row.NewValues) > 0 {
+			if err := json.Unmarshal(row.NewValues, &record.NewValues); err != nil {
+				return nil, fmt.Errorf("解析新值失败: %w", err)
+			}
+		}
+		records = append(records, record)
+		if record.TxID != "" {
+			byTxID[record.TxID] = record
+		}
+	}
+
+	for _, lr := range ledgerRecords {
+		if record, ok := byTxID[lr.TxID]; ok {
+			record.Source = "both"
+			record.Value = lr.Value
+			record.Timestamp = lr.Timestamp
+			record.IsDelete = lr.IsDelete
+			continue
+		}
+		records = append(records, &models.HistoryRecord{
+			TxID:      lr.TxID,
+			Value:     lr.Value,
+			Timestamp: lr.Timestamp,
+			IsDelete:  lr.IsDelete,
+			Source:    "ledger",
+		})
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339, records[i].Timestamp)
+		tj, _ := time.Parse(time.RFC3339, records[j].Timestamp)
+		return ti.Before(tj)
+	})
+	return records, nil
+}
+
+// getLedgerHistory 从账本查询证书历史，未配置Fabric客户端时返回空
+func (s *CertificateService) getLedgerHistory(certNumber string) ([]*ledgerHistoryRecord, error) {
+	if s.fabricClient == nil {
+		return nil, nil
+	}
+
+	payload, err := s.fabricClient.GetCertificateHistory(certNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*ledgerHistoryRecord
+	if len(payload) == 0 {
+		return records, nil
+	}
+	if err := json.Unmarshal(payload, &records); err != nil {
+		return nil, fmt.Errorf("解析账本历史失败: %w", err)
+	}
+	return records, nil
+}
+
+// DiffCertificateVersions 比较证书两个账本版本（按交易ID）之间的字段差异
+// fromTxID 为空时取 toTxID 的上一个版本，toTxID 为空时取最新版本，fromTxID 须早于 toTxID
+func (s *CertificateService) DiffCertificateVersions(certNumber, fromTxID, toTxID string) (*models.CertificateDiff, error) {
+	if s.fabricClient == nil {
+		return nil, ErrLedgerUnavailable
+	}
+
+	versions, err := s.getLedgerHistory(certNumber)
+	if err != nil {
+		return nil, err
+	}
+	return diffLedgerVersions(certNumber, versions, fromTxID, toTxID)
+}
+
+// diffLedgerVersions 在账本历史版本中定位起止版本并比较字段差异，规则同 DiffCertificateVersions
+func diffLedgerVersions(certNumber string, versions []*ledgerHistoryRecord, fromTxID, toTxID string) (*models.CertificateDiff, error) {
+	// 链码历史按提交顺序返回，这里按时间排序以确定"上一个版本"
+	sort.SliceStable(versions, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339, versions[i].Timestamp)
+		tj, _ := time.Parse(time.RFC3339, versions[j].Timestamp)
+		return ti.Before(tj)
+	})
+	if len(versions) == 0 {
+		return nil, ErrVersionNotFound
+	}
+
+	toIndex := len(versions) - 1
+	if toTxID != "" {
+		if toIndex = findLedgerVersion(versions, toTxID); toIndex < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, toTxID)
+		}
+	}
+	fromIndex := toIndex - 1
+	if fromTxID != "" {
+		if fromIndex = findLedgerVersion(versions, fromTxID); fromIndex < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, fromTxID)
+		}
+	}
+	if fromIndex < 0 {
+		return nil, fmt.Errorf("%w: 证书 %s 没有更早的版本", ErrVersionNotFound, certNumber)
+	}
+	if fromIndex >= toIndex {
+		return nil, fmt.Errorf("%w: %s 不早于 %s", ErrInvalidVersionRange, versions[fromIndex].TxID, versions[toIndex].TxID)
+	}
+
+	from, to := versions[fromIndex], versions[toIndex]
+	changes, err := diffFields(from.Value, to.Value, ledgerDiffIgnored...)
+	if err != nil {
+		return nil, fmt.Errorf("比较证书版本失败: %w", err)
+	}
+
+	var fromCert models.BlockchainCertificate
+	if len(from.Value) > 0 {
+		if err := json.Unmarshal(from.Value, &fromCert); err != nil {
+			return nil, fmt.Errorf("解析证书版本失败: %w", err)
+		}
+	}
+	issued := fromCert.Status == "issued" || fromCert.Status == "revoked"
+
+	diff := &models.CertificateDiff{
+		CertNumber:          certNumber,
+		FromTxID:            from.TxID,
+		ToTxID:              to.TxID,
+		FromTimestamp:       from.Timestamp,
+		ToTimestamp:         to.Timestamp,
+		FromStatus:          fromCert.Status,
+		Changes:             changes,
+		ImmutableViolations: []string{},
+	}
+	for i := range diff.Changes {
+		change := &diff.Changes[i]
+		if change.Field == "testDataHash" {
+			diff.TestDataHashChanged = true
+		}
+		if issued && immutableAfterIssue[change.Field] {
+			change.Immutable = true
+			diff.ImmutableViolations = append(diff.ImmutableViolations, change.Field)
+		}
+	}
+	if diff.Changes == nil {
+		diff.Changes = []models.FieldChange{}
+	}
+	return diff, nil
+}
+
+// findLedgerVersion 返回指定交易ID在历史版本中的下标，不存在时返回 -1
+func findLedgerVersion(versions []*ledgerHistoryRecord, txID string) int {
+	for i, v := range versions {
+		if v.TxID == txID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestCertificateService 创建不连接账本的证书服务，使用默认误差限值表和不确定度配置
+func newTestCertificateService(client *database.Client) *CertificateService {
+	return NewCertificateService(client, nil, NewResultEvaluator(config.ErrorLimitConfig{}), NewUncertaintyCalculator(config.UncertaintyConfig{}))
+}
+
+// createTestCertificate 登记委托方并创建指定状态的证书
+func createTestCertificate(t *testing.T, client *database.Client, certNumber, status string) *models.Certificate {
+	t.Helper()
+	customer := models.Customer{CustomerName: "测试委托方", CustomerAddress: "测试地址"}
+	if err := client.DB.Create(&customer).Error; err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+	cert := &models.Certificate{
+		CertNumber:         certNumber,
+		CustomerID:         customer.ID,
+		InstrumentName:     "电流互感器",
+		InstrumentAccuracy: "0.2S",
+		TestDate:           time.Now(),
+		ExpireDate:         time.Now().AddDate(1, 0, 0),
+		TestResult:         "qualified",
+		Status:             status,
+		Version:            1,
+	}
+	if err := client.DB.Create(cert).Error; err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+	cert.Customer = customer
+	return cert
+}
+
+// addTestReadings 为证书写入 dev-std 在额定 100% 点的读数
+func addTestReadings(t *testing.T, client *database.Client, cert *models.Certificate, ratioErrors ...float64) {
+	t.Helper()
+	for _, ratio := range ratioErrors {
+		data := &models.TestData{
+			CertID:           cert.ID,
+			DeviceAddr:       "dev-std",
+			DataType:         TestDataTypeCurrent,
+			TestPoint:        "100%",
+			PercentageValue:  100,
+			ActualPercentage: 100.02,
+			RatioError:       ratio,
+			AngleError:       2,
+			CurrentValue:     5,
+			TestTimestamp:    time.Now(),
+		}
+		if err := client.DB.Create(data).Error; err != nil {
+			t.Fatalf("写入测试数据失败: %v", err)
+		}
+	}
+}
+
+func TestCalculateUncertaintyRecordsHistory(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestCertificateService(client)
+	cert := createTestCertificate(t, client, "CT-U-001", "testing")
+	if err := client.DB.Create(&models.Device{DeviceAddr: "dev-std", AccuracyClass: "0.05", Status: DeviceStatusActive}).Error; err != nil {
+		t.Fatalf("登记设备失败: %v", err)
+	}
+	addTestReadings(t, client, cert, 0.05, 0.06, 0.04)
+
+	got, err := s.CalculateUncertainty(cert.CertNumber, 1)
+	if err != nil {
+		t.Fatalf("评定不确定度失败: %v", err)
+	}
+	if got.UncertaintyBudget == nil || len(got.UncertaintyBudget.Points) != 1 || got.Version != 2 {
+		t.Fatalf("评定结果不符: %+v", got)
+	}
+	if got.BlockchainHash != certificateHash(got) {
+		t.Fatal("评定后未重新计算证书哈希")
+	}
+
+	var history []models.CertificateHistory
+	if err := client.DB.Where("cert_id = ?", cert.ID).Find(&history).Error; err != nil {
+		t.Fatalf("查询变更记录失败: %v", err)
+	}
+	if len(history) != 1 || history[0].OperationType != "uncertainty" {
+		t.Fatalf("期望一条 uncertainty 变更记录，实际 %+v", history)
+	}
+}
+
+func TestCalculateUncertaintyLockedCertificate(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestCertificateService(client)
+	cert := createTestCertificate(t, client, "CT-U-002", "issued")
+
+	if _, err := s.CalculateUncertainty(cert.CertNumber, 1); !errors.Is(err, ErrCertificateLocked) {
+		t.Fatalf("期望 ErrCertificateLocked，实际 %v", err)
+	}
+}
+
+func TestSchemaEnumsEnforced(t *testing.T) {
+	client := newTestDB(t)
+	cert := createTestCertificate(t, client, "CT-E-001", "draft")
+
+	// 测试库须与 MySQL 严格模式一致地拒绝 init.sql 未声明的枚举值
+	err := client.DB.Create(&models.CertificateHistory{CertID: cert.ID, CertNumber: cert.CertNumber, OperationType: "bogus", OperationTime: time.Now()}).Error
+	if err == nil {
+		t.Fatal("写入未声明的操作类型应失败")
+	}
+	if err := client.DB.Model(cert).Update("status", "archived").Error; err == nil {
+		t.Fatal("更新为未声明的证书状态应失败")
+	}
+}
+
+func TestCertificateHistoryRecordsDiff(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestCertificateService(client)
+	operator := models.User{Username: "op1", Role: "operator", Status: "active"}
+	if err := client.DB.Create(&operator).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	customer := models.Customer{CustomerName: "测试委托方"}
+	if err := client.DB.Create(&customer).Error; err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+	cert := &models.Certificate{
+		CertNumber:     "CT-H-001",
+		CustomerID:     customer.ID,
+		InstrumentName: "电流互感器",
+		TestDate:       time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		ExpireDate:     time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC),
+		TestResult:     "qualified",
+		Status:         "draft",
+		CreatedBy:      operator.ID,
+	}
+	if err := s.CreateCertificate(cert); err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+	if cert.BlockchainHash != certificateHash(cert) || cert.BlockchainTxID != cert.BlockchainHash[:32] {
+		t.Fatalf("创建时的证书哈希不符: %s / %s", cert.BlockchainHash, cert.BlockchainTxID)
+	}
+
+	cert.InstrumentName = "电压互感器"
+	cert.Status = "testing"
+	if err := s.UpdateCertificate(cert, operator.ID, 1); err != nil {
+		t.Fatalf("更新证书失败: %v", err)
+	}
+	// 无实际变更的更新不产生变更记录
+	if err := s.UpdateCertificate(cert, operator.ID, 2); err != nil {
+		t.Fatalf("重复更新证书失败: %v", err)
+	}
+
+	history, err := s.GetCertificateHistory(cert.CertNumber)
+	if err != nil {
+		t.Fatalf("查询证书历史失败: %v", err)
+	}
+	if len(history) != 2 || history[0].OperationType != "create" || history[1].OperationType != "update" {
+		t.Fatalf("期望创建和更新两条记录，实际 %+v", history)
+	}
+	update := history[1]
+	if update.Source != "database" || update.OperatorName != "op1" {
+		t.Fatalf("更新记录的来源或操作人不符: %+v", update)
+	}
+	for _, field := range []string{"instrumentName", "status", "blockchainHash"} {
+		if !slices.Contains(update.ChangedFields, field) {
+			t.Fatalf("变更字段缺少 %s: %v", field, update.ChangedFields)
+		}
+	}
+	if update.OldValues["instrumentName"] != "电流互感器" || update.NewValues["instrumentName"] != "电压互感器" {
+		t.Fatalf("变更前后的值不符: %v -> %v", update.OldValues, update.NewValues)
+	}
+	if slices.Contains(update.ChangedFields, "version") || slices.Contains(update.ChangedFields, "updatedAt") {
+		t.Fatalf("系统维护的字段不应计入变更: %v", update.ChangedFields)
+	}
+	if len(history[0].OldValues) != 0 || history[0].NewValues["certNumber"] != cert.CertNumber {
+		t.Fatalf("创建记录应只有新值: %+v", history[0])
+	}
+}
+
+func TestMergeCertificateHistory(t *testing.T) {
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	changed, _ := json.Marshal([]string{"status"})
+	rows := []*models.CertificateHistory{
+		{OperationType: "create", BlockchainTxID: "tx-1", OperationTime: base, ChangedFields: changed},
+		{OperationType: "update", OperationTime: base.Add(3 * time.Hour), ChangedFields: changed},
+	}
+	ledger := []*ledgerHistoryRecord{
+		{TxID: "tx-2", Value: json.RawMessage(`{"status":"testing"}`), Timestamp: base.Add(2 * time.Hour).Format(time.RFC3339)},
+		{TxID: "tx-1", Value: json.RawMessage(`{"status":"draft"}`), Timestamp: base.Add(time.Minute).Format(time.RFC3339)},
+	}
+
+	records, err := mergeCertificateHistory(rows, ledger)
+	if err != nil {
+		t.Fatalf("合并历史失败: %v", err)
+	}
+	want := []struct {
+		txID   string
+		source string
+	}{{"tx-1", "both"}, {"tx-2", "ledger"}, {"", "database"}}
+	if len(records) != len(want) {
+		t.Fatalf("期望 %d 条记录，实际 %d 条", len(want), len(records))
+	}
+	for i, w := range want {
+		if records[i].TxID != w.txID || records[i].Source != w.source {
+			t.Fatalf("第 %d 条记录期望 %s/%s，实际 %s/%s", i, w.txID, w.source, records[i].TxID, records[i].Source)
+		}
+	}
+	// 两边都有的记录以账本时间和值为准，并保留数据库中的变更字段
+	if records[0].Timestamp != ledger[1].Timestamp || records[0].Value == nil || records[0].ChangedFields[0] != "status" {
+		t.Fatalf("合并后的记录不符: %+v", records[0])
+	}
+
+	if _, err := mergeCertificateHistory([]*models.CertificateHistory{{ChangedFields: []byte("{")}}, nil); err == nil {
+		t.Fatal("变更字段无法解析时应返回错误")
+	}
+}
+
+func TestDiffLedgerVersions(t *testing.T) {
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	version := func(txID string, offset time.Duration, value string) *ledgerHistoryRecord {
+		return &ledgerHistoryRecord{TxID: txID, Value: json.RawMessage(value), Timestamp: base.Add(offset).Format(time.RFC3339)}
+	}
+	// 链码返回顺序与提交时间不一致，比较前须按时间排序
+	versions := func() []*ledgerHistoryRecord {
+		return []*ledgerHistoryRecord{
+			version("tx-3", 3*time.Hour, `{"status":"issued","testResult":"unqualified","testDataHash":"b","version":3}`),
+			version("tx-1", time.Hour, `{"status":"draft","testResult":"qualified","testDataHash":"a","version":1}`),
+			version("tx-2", 2*time.Hour, `{"status":"issued","testResult":"qualified","testDataHash":"a","version":2}`),
+		}
+	}
+
+	tests := []struct {
+		name           string
+		versions       []*ledgerHistoryRecord
+		from, to       string
+		wantErr        error
+		wantFrom       string
+		wantTo         string
+		wantFields     []string
+		wantViolations []string
+		wantHashChange bool
+	}{
+		{name: "默认比较最新版本与上一版本", versions: versions(), wantFrom: "tx-2", wantTo: "tx-3",
+			wantFields: []string{"testDataHash", "testResult"}, wantViolations: []string{"testDataHash", "testResult"}, wantHashChange: true},
+		{name: "指定结束版本", versions: versions(), to: "tx-2", wantFrom: "tx-1", wantTo: "tx-2", wantFields: []string{"status"}, wantViolations: []string{}},
+		{name: "指定起止版本", versions: versions(), from: "tx-1", to: "tx-3", wantFrom: "tx-1", wantTo: "tx-3",
+			wantFields: []string{"status", "testDataHash", "testResult"}, wantViolations: []string{}, wantHashChange: true},
+		{name: "起始版本不早于结束版本", versions: versions(), from: "tx-3", to: "tx-2", wantErr: ErrInvalidVersionRange},
+		{name: "交易ID不存在", versions: versions(), to: "tx-9", wantErr: ErrVersionNotFound},
+		{name: "没有更早的版本", versions: versions()[1:2], wantErr: ErrVersionNotFound},
+		{name: "没有账本历史", wantErr: ErrVersionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := diffLedgerVersions("CT-D-001", tt.versions, tt.from, tt.to)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("期望 %v，实际 %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("比较版本失败: %v", err)
+			}
+			if diff.FromTxID != tt.wantFrom || diff.ToTxID != tt.wantTo {
+				t.Fatalf("期望比较 %s..%s，实际 %s..%s", tt.wantFrom, tt.wantTo, diff.FromTxID, diff.ToTxID)
+			}
+			var fields []string
+			for _, change := range diff.Changes {
+				fields = append(fields, change.Field)
+			}
+			if !slices.Equal(fields, tt.wantFields) || !slices.Equal(diff.ImmutableViolations, tt.wantViolations) {
+				t.Fatalf("变更字段 %v、不可变字段 %v 不符", fields, diff.ImmutableViolations)
+			}
+			if diff.TestDataHashChanged != tt.wantHashChange {
+				t.Fatalf("期望 testDataHashChanged=%v", tt.wantHashChange)
+			}
+		})
+	}
+}
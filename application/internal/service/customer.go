@@ -0,0 +1,149 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrCustomerNotFound 委托方不存在
+var ErrCustomerNotFound = errors.New("委托方不存在")
+
+// ErrCustomerInUse 委托方已被证书引用，不能删除
+var ErrCustomerInUse = errors.New("委托方已被证书引用，不能删除")
+
+// DuplicateCustomerError 已存在同名委托方，Existing 为已存在的记录
+type DuplicateCustomerError struct {
+	Existing *models.Customer
+}
+
+func (e *DuplicateCustomerError) Error() string {
+	return fmt.Sprintf("委托方 %s 已存在（ID %d）", e.Existing.CustomerName, e.Existing.ID)
+}
+
+// CustomerService 委托方管理服务
+type CustomerService struct {
+	dbClient *database.Client
+}
+
+// NewCustomerService 创建新的 CustomerService
+func NewCustomerService(dbClient *database.Client) *CustomerService {
+	return &CustomerService{
+		dbClient: dbClient,
+	}
+}
+
+// ListCustomers 分页获取委托方列表，keyword 不为空时按名称、联系人或联系电话模糊搜索
+func (s *CustomerService) ListCustomers(page, pageSize int, keyword string) ([]*models.Customer, int64, error) {
+	var customers []*models.Customer
+	var total int64
+
+	query := s.dbClient.DB.Model(&models.Customer{})
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		like := "%" + escapeLike(keyword) + "%"
+		query = query.Where("customer_name LIKE ? OR contact_person LIKE ? OR contact_phone LIKE ?", like, like, like)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := query.Order("customer_name ASC, id ASC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&customers)
+	return customers, total, result.Error
+}
+
+// GetCustomer 根据ID获取委托方
+func (s *CustomerService) GetCustomer(id int64) (*models.Customer, error) {
+	var customer models.Customer
+	if err := s.dbClient.DB.First(&customer, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCustomerNotFound
+		}
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// CreateCustomer 创建委托方，已存在同名委托方时返回 DuplicateCustomerError
+func (s *CustomerService) CreateCustomer(customer *models.Customer) error {
+	normalizeCustomer(customer)
+	if err := s.checkDuplicate(customer.CustomerName, 0); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	customer.ID = 0
+	customer.CreatedAt = now
+	customer.UpdatedAt = now
+	return s.dbClient.DB.Create(customer).Error
+}
+
+// UpdateCustomer 更新委托方信息，改名后与其他委托方重名时返回 DuplicateCustomerError
+func (s *CustomerService) UpdateCustomer(id int64, input *models.Customer) (*models.Customer, error) {
+	customer, err := s.GetCustomer(id)
+	if err != nil {
+		return nil, err
+	}
+	normalizeCustomer(input)
+	if err := s.checkDuplicate(input.CustomerName, id); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"customer_name":    input.CustomerName,
+		"customer_address": input.CustomerAddress,
+		"contact_person":   input.ContactPerson,
+		"contact_phone":    input.ContactPhone,
+		"updated_at":       time.Now(),
+	}
+	if err := s.dbClient.DB.Model(customer).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return s.GetCustomer(id)
+}
+
+// DeleteCustomer 删除委托方，已被证书引用时返回 ErrCustomerInUse
+func (s *CustomerService) DeleteCustomer(id int64) error {
+	if _, err := s.GetCustomer(id); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.dbClient.DB.Model(&models.Certificate{}).Where("customer_id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w（%d 张证书）", ErrCustomerInUse, count)
+	}
+	return s.dbClient.DB.Delete(&models.Customer{}, id).Error
+}
+
+// checkDuplicate 检查是否已存在同名委托方（忽略首尾空格和大小写），excludeID 为正在修改的委托方
+func (s *CustomerService) checkDuplicate(name string, excludeID int64) error {
+	var existing models.Customer
+	err := s.dbClient.DB.Where("LOWER(customer_name) = LOWER(?) AND id <> ?", name, excludeID).First(&existing).Error
+	if err == nil {
+		return &DuplicateCustomerError{Existing: &existing}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// normalizeCustomer 去除委托方字段首尾空格，名称中的连续空白合并为一个空格
+func normalizeCustomer(customer *models.Customer) {
+	customer.CustomerName = strings.Join(strings.Fields(customer.CustomerName), " ")
+	customer.CustomerAddress = strings.TrimSpace(customer.CustomerAddress)
+	customer.ContactPerson = strings.TrimSpace(customer.ContactPerson)
+	customer.ContactPhone = strings.TrimSpace(customer.ContactPhone)
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
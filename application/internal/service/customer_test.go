@@ -0,0 +1,157 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+)
+
+func TestCreateCustomer(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       models.Customer
+		wantName    string
+		wantContact string
+		wantDup     bool
+	}{
+		{name: "创建", input: models.Customer{CustomerName: "华东电力试验研究院", ContactPerson: " 张工 "}, wantName: "华东电力试验研究院", wantContact: "张工"},
+		{name: "合并名称中的空白", input: models.Customer{CustomerName: "  North   Grid  Lab "}, wantName: "North Grid Lab"},
+		{name: "同名", input: models.Customer{CustomerName: "国网计量中心"}, wantDup: true},
+		{name: "忽略大小写和空白的同名", input: models.Customer{CustomerName: " state   GRID lab "}, wantDup: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewCustomerService(newTestDB(t))
+			for _, name := range []string{"国网计量中心", "State Grid Lab"} {
+				if err := s.CreateCustomer(&models.Customer{CustomerName: name}); err != nil {
+					t.Fatalf("创建委托方失败: %v", err)
+				}
+			}
+
+			input := tt.input
+			err := s.CreateCustomer(&input)
+			var dup *DuplicateCustomerError
+			if errors.As(err, &dup) != tt.wantDup {
+				t.Fatalf("期望重名=%v，实际 %v", tt.wantDup, err)
+			}
+			if tt.wantDup {
+				if dup.Existing == nil || dup.Existing.ID == 0 {
+					t.Fatalf("重名错误应携带已存在的委托方: %+v", dup)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("创建委托方失败: %v", err)
+			}
+			stored, err := s.GetCustomer(input.ID)
+			if err != nil {
+				t.Fatalf("查询委托方失败: %v", err)
+			}
+			if stored.CustomerName != tt.wantName || stored.ContactPerson != tt.wantContact {
+				t.Fatalf("规范化结果不符: %+v", stored)
+			}
+		})
+	}
+}
+
+func TestUpdateCustomer(t *testing.T) {
+	s := NewCustomerService(newTestDB(t))
+	first := &models.Customer{CustomerName: "国网计量中心"}
+	second := &models.Customer{CustomerName: "华东电力试验研究院"}
+	for _, c := range []*models.Customer{first, second} {
+		if err := s.CreateCustomer(c); err != nil {
+			t.Fatalf("创建委托方失败: %v", err)
+		}
+	}
+
+	// 保留自身名称只修改其他字段不视为重名
+	updated, err := s.UpdateCustomer(first.ID, &models.Customer{CustomerName: "国网计量中心", ContactPhone: " 010-12345678 "})
+	if err != nil || updated.ContactPhone != "010-12345678" {
+		t.Fatalf("更新委托方失败: %+v（%v）", updated, err)
+	}
+	var dup *DuplicateCustomerError
+	if _, err := s.UpdateCustomer(first.ID, &models.Customer{CustomerName: "华东电力试验研究院"}); !errors.As(err, &dup) || dup.Existing.ID != second.ID {
+		t.Fatalf("改为其他委托方的名称期望重名，实际 %v", err)
+	}
+	if _, err := s.UpdateCustomer(999, &models.Customer{CustomerName: "不存在"}); !errors.Is(err, ErrCustomerNotFound) {
+		t.Fatalf("期望 ErrCustomerNotFound，实际 %v", err)
+	}
+}
+
+func TestListCustomers(t *testing.T) {
+	s := NewCustomerService(newTestDB(t))
+	for _, c := range []models.Customer{
+		{CustomerName: "国网计量中心", ContactPerson: "李工", ContactPhone: "010-1111"},
+		{CustomerName: "华东电力试验研究院", ContactPerson: "张工", ContactPhone: "021-2222"},
+		{CustomerName: "华北电网计量所", ContactPerson: "王工", ContactPhone: "010-3333"},
+	} {
+		c := c
+		if err := s.CreateCustomer(&c); err != nil {
+			t.Fatalf("创建委托方失败: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		keyword   string
+		page      int
+		pageSize  int
+		wantTotal int64
+		wantNames []string
+	}{
+		{name: "按名称搜索", keyword: "华", page: 1, pageSize: 10, wantTotal: 2, wantNames: []string{"华东电力试验研究院", "华北电网计量所"}},
+		{name: "按联系人搜索", keyword: "张工", page: 1, pageSize: 10, wantTotal: 1, wantNames: []string{"华东电力试验研究院"}},
+		{name: "按电话搜索", keyword: " 010- ", page: 1, pageSize: 10, wantTotal: 2, wantNames: []string{"华北电网计量所", "国网计量中心"}},
+		{name: "分页", page: 2, pageSize: 2, wantTotal: 3, wantNames: []string{"国网计量中心"}},
+		{name: "无匹配", keyword: "不存在", page: 1, pageSize: 10, wantTotal: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			customers, total, err := s.ListCustomers(tt.page, tt.pageSize, tt.keyword)
+			if err != nil {
+				t.Fatalf("查询委托方失败: %v", err)
+			}
+			var names []string
+			for _, c := range customers {
+				names = append(names, c.CustomerName)
+			}
+			if total != tt.wantTotal || len(names) != len(tt.wantNames) {
+				t.Fatalf("期望共 %d 条 %v，实际共 %d 条 %v", tt.wantTotal, tt.wantNames, total, names)
+			}
+			for i := range names {
+				if names[i] != tt.wantNames[i] {
+					t.Fatalf("期望 %v，实际 %v", tt.wantNames, names)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteCustomer(t *testing.T) {
+	client := newTestDB(t)
+	s := NewCustomerService(client)
+	cert := createTestCertificate(t, client, "CT-C-001", "draft")
+	unused := &models.Customer{CustomerName: "未委托的客户"}
+	if err := s.CreateCustomer(unused); err != nil {
+		t.Fatalf("创建委托方失败: %v", err)
+	}
+
+	if err := s.DeleteCustomer(cert.CustomerID); !errors.Is(err, ErrCustomerInUse) {
+		t.Fatalf("被证书引用时期望 ErrCustomerInUse，实际 %v", err)
+	}
+	if err := s.DeleteCustomer(unused.ID); err != nil {
+		t.Fatalf("删除委托方失败: %v", err)
+	}
+	if _, err := s.GetCustomer(unused.ID); !errors.Is(err, ErrCustomerNotFound) {
+		t.Fatalf("删除后期望 ErrCustomerNotFound，实际 %v", err)
+	}
+	if err := s.DeleteCustomer(unused.ID); !errors.Is(err, ErrCustomerNotFound) {
+		t.Fatalf("重复删除期望 ErrCustomerNotFound，实际 %v", err)
+	}
+
+	// 证书查询时带出委托方
+	stored, err := newTestCertificateService(client).GetCertificateByNumber(cert.CertNumber)
+	if err != nil || stored.Customer.ID != cert.CustomerID || stored.Customer.CustomerName != "测试委托方" {
+		t.Fatalf("证书应带出委托方: %+v（%v）", stored, err)
+	}
+}
@@ -0,0 +1,326 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/fabric"
+	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 设备状态
+const (
+	DeviceStatusActive      = "active"      // 在用
+	DeviceStatusMaintenance = "maintenance" // 维修中
+	DeviceStatusRetired     = "retired"     // 已停用
+)
+
+// deviceStatusLabels 设备状态的中文名称，用于错误提示
+var deviceStatusLabels = map[string]string{
+	DeviceStatusActive:      "在用",
+	DeviceStatusMaintenance: "维修中",
+	DeviceStatusRetired:     "已停用",
+}
+
+// ErrDeviceNotFound 设备不存在
+var ErrDeviceNotFound = errors.New("设备不存在")
+
+// ErrDeviceAddrTaken 设备地址已被登记
+var ErrDeviceAddrTaken = errors.New("设备地址已存在")
+
+// ErrInvalidDeviceStatus 设备状态取值无效
+var ErrInvalidDeviceStatus = errors.New("设备状态无效，可选值: active, maintenance, retired")
+
+// ErrDeviceUnavailable 设备维修中或已停用，不能上传测试数据
+var ErrDeviceUnavailable = errors.New("设备不可用")
+
+// ErrDeviceOutOfCalibration 设备未登记校准证书、校准证书未签发、不合格或已撤销，或已超出校准有效期
+var ErrDeviceOutOfCalibration = errors.New("设备不在校准有效期内")
+
+// ErrInvalidCalibrationRef 设备的校准证书信息无效
+var ErrInvalidCalibrationRef = errors.New("设备校准证书无效")
+
+// DeviceService 检测设备管理服务
+type DeviceService struct {
+	dbClient     *database.Client
+	fabricClient *fabric.Client // 为 nil 时设备公钥不登记到账本
+}
+
+// NewDeviceService 创建新的 DeviceService
+func NewDeviceService(dbClient *database.Client, fabricClient *fabric.Client) *DeviceService {
+	return &DeviceService{
+		dbClient:     dbClient,
+		fabricClient: fabricClient,
+	}
+}
+
+// ListDevices 分页获取设备列表，可按状态过滤，keyword 不为空时按地址、名称或型号模糊搜索
+func (s *DeviceService) ListDevices(page, pageSize int, status, keyword string) ([]*models.Device, int64, error) {
+	var devices []*models.Device
+	var total int64
+
+	query := s.dbClient.DB.Model(&models.Device{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		like := "%" + escapeLike(keyword) + "%"
+		query = query.Where("device_addr LIKE ? OR device_name LIKE ? OR model LIKE ?", like, like, like)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := query.Order("device_addr ASC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&devices)
+	return devices, total, result.Error
+}
+
+// GetDevice 根据ID获取设备
+func (s *DeviceService) GetDevice(id int64) (*models.Device, error) {
+	var device models.Device
+	if err := s.dbClient.DB.First(&device, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return &device, nil
+}
+
+// CreateDevice 登记设备，状态为空时默认为在用
+func (s *DeviceService) CreateDevice(device *models.Device) error {
+	device.DeviceAddr = strings.TrimSpace(device.DeviceAddr)
+	device.DeviceName = strings.TrimSpace(device.DeviceName)
+	if device.Status == "" {
+		device.Status = DeviceStatusActive
+	}
+	if _, ok := deviceStatusLabels[device.Status]; !ok {
+		return ErrInvalidDeviceStatus
+	}
+	if err := resolveCalibration(s.dbClient.DB, device); err != nil {
+		return err
+	}
+
+	var count int64
+	if err := s.dbClient.DB.Model(&models.Device{}).Where("device_addr = ?", device.DeviceAddr).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s", ErrDeviceAddrTaken, device.DeviceAddr)
+	}
+
+	now := time.Now()
+	device.ID = 0
+	device.CreatedAt = now
+	device.UpdatedAt = now
+	return s.dbClient.DB.Create(device).Error
+}
+
+// UpdateDevice 更新设备信息、状态和校准证书，设备地址不可修改
+func (s *DeviceService) UpdateDevice(id int64, input *models.Device) (*models.Device, error) {
+	if _, ok := deviceStatusLabels[input.Status]; !ok {
+		return nil, ErrInvalidDeviceStatus
+	}
+	device, err := s.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveCalibration(s.dbClient.DB, input); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"device_name":              strings.TrimSpace(input.DeviceName),
+		"manufacturer":             input.Manufacturer,
+		"model":                    input.Model,
+		"accuracy_class":           input.AccuracyClass,
+		"status":                   input.Status,
+		"calibration_cert_number":  input.CalibrationCertNumber,
+		"calibration_external_ref": input.CalibrationExternalRef,
+		"calibration_due_date":     input.CalibrationDueDate,
+		"updated_at":               time.Now(),
+	}
+	if err := s.dbClient.DB.Model(device).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return s.GetDevice(id)
+}
+
+// SetDevicePublicKey 登记或更换设备签名公钥，启用账本时同时登记到账本，上链失败则不保存。
+// 更换公钥不影响已入库数据的校验，已入库数据保存了签名时的公钥快照
+func (s *DeviceService) SetDevicePublicKey(id int64, publicKeyPEM string) (*models.Device, error) {
+	algorithm, publicKey, err := ParseDevicePublicKey(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	device, err := s.GetDevice(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(device).Updates(map[string]interface{}{
+			"public_key":    publicKey,
+			"key_algorithm": algorithm,
+			"updated_at":    time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if s.fabricClient != nil {
+			_, err := s.fabricClient.RegisterDeviceKey(&models.BlockchainDeviceKey{
+				DeviceAddr: device.DeviceAddr,
+				PublicKey:  publicKey,
+				Algorithm:  algorithm,
+			})
+			if err != nil {
+				return fmt.Errorf("设备公钥上链失败: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetDevice(id)
+}
+
+// DeleteDevice 删除设备，已上传过测试数据或绑定了 API Key 的设备改为停用，返回是否为停用
+func (s *DeviceService) DeleteDevice(id int64) (bool, error) {
+	device, err := s.GetDevice(id)
+	if err != nil {
+		return false, err
+	}
+
+	referenced, err := s.isDeviceReferenced(device.DeviceAddr)
+	if err != nil {
+		return false, err
+	}
+	if referenced {
+		err := s.dbClient.DB.Model(device).Updates(map[string]interface{}{
+			"status":     DeviceStatusRetired,
+			"updated_at": time.Now(),
+		}).Error
+		return true, err
+	}
+
+	return false, s.dbClient.DB.Delete(device).Error
+}
+
+// isDeviceReferenced 检查设备是否被测试数据或 API Key 引用
+func (s *DeviceService) isDeviceReferenced(deviceAddr string) (bool, error) {
+	for _, model := range []interface{}{&models.TestData{}, &models.APIKey{}} {
+		var count int64
+		if err := s.dbClient.DB.Model(model).Where("device_addr = ?", deviceAddr).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// resolveCalibration 校验设备的校准证书：内部证书必须存在、已签发且结论合格，有效期取该证书的有效期；
+// 外部证书必须填写有效期；两者不能同时填写
+func resolveCalibration(db *gorm.DB, device *models.Device) error {
+	device.CalibrationExternalRef = strings.TrimSpace(device.CalibrationExternalRef)
+	if device.CalibrationCertNumber != nil {
+		if certNumber := strings.TrimSpace(*device.CalibrationCertNumber); certNumber != "" {
+			device.CalibrationCertNumber = &certNumber
+		} else {
+			device.CalibrationCertNumber = nil
+		}
+	}
+
+	switch {
+	case device.CalibrationCertNumber != nil && device.CalibrationExternalRef != "":
+		return fmt.Errorf("%w: 本系统证书编号和外部证书只能填写一个", ErrInvalidCalibrationRef)
+	case device.CalibrationCertNumber != nil:
+		var cert models.Certificate
+		if err := db.Where("cert_number = ?", *device.CalibrationCertNumber).First(&cert).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: 证书 %s 不存在", ErrInvalidCalibrationRef, *device.CalibrationCertNumber)
+			}
+			return err
+		}
+		if defect := calibrationCertDefect(&cert); defect != "" {
+			return fmt.Errorf("%w: 证书 %s %s", ErrInvalidCalibrationRef, cert.CertNumber, defect)
+		}
+		expireDate := cert.ExpireDate
+		device.CalibrationDueDate = &expireDate
+	case device.CalibrationExternalRef != "" && device.CalibrationDueDate == nil:
+		return fmt.Errorf("%w: 外部校准证书须填写有效期", ErrInvalidCalibrationRef)
+	}
+	return nil
+}
+
+// calibrationCertDefect 返回本系统证书不能作为校准依据的原因：证书必须已签发且检定结论为合格，满足时返回空串
+func calibrationCertDefect(cert *models.Certificate) string {
+	switch {
+	case cert.Status == "revoked":
+		return "已撤销"
+	case cert.Status != "issued":
+		return fmt.Sprintf("尚未签发（当前状态 %s）", cert.Status)
+	case cert.TestResult != TestResultQualified:
+		return "检定结论为不合格"
+	}
+	return ""
+}
+
+// checkDeviceUsable 检查设备能否上传测试数据：设备必须已登记、处于在用状态、登记了校准证书，
+// 且测试时间和当前时间都在校准有效期内（截止日当天仍有效）。内部校准证书按当前状态、检定结论和有效期校验
+func checkDeviceUsable(db *gorm.DB, deviceAddr string, testTime time.Time) (*models.Device, error) {
+	var device models.Device
+	if err := db.Where("device_addr = ?", deviceAddr).First(&device).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceAddr)
+		}
+		return nil, err
+	}
+
+	if device.Status != DeviceStatusActive {
+		label := deviceStatusLabels[device.Status]
+		if label == "" {
+			label = device.Status
+		}
+		return nil, fmt.Errorf("%w: %s（%s）", ErrDeviceUnavailable, deviceAddr, label)
+	}
+
+	dueDate := device.CalibrationDueDate
+	switch {
+	case device.CalibrationCertNumber != nil:
+		var cert models.Certificate
+		if err := db.Where("cert_number = ?", *device.CalibrationCertNumber).First(&cert).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: %s 的校准证书 %s 不存在", ErrDeviceOutOfCalibration, deviceAddr, *device.CalibrationCertNumber)
+			}
+			return nil, err
+		}
+		if defect := calibrationCertDefect(&cert); defect != "" {
+			return nil, fmt.Errorf("%w: %s 的校准证书 %s %s", ErrDeviceOutOfCalibration, deviceAddr, cert.CertNumber, defect)
+		}
+		dueDate = &cert.ExpireDate
+	case device.CalibrationExternalRef == "":
+		return nil, fmt.Errorf("%w: %s 未登记校准证书", ErrDeviceOutOfCalibration, deviceAddr)
+	}
+	if dueDate == nil {
+		return nil, fmt.Errorf("%w: %s 未登记校准有效期", ErrDeviceOutOfCalibration, deviceAddr)
+	}
+
+	due := *dueDate
+	validUntil := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+	latest := time.Now()
+	if testTime.After(latest) {
+		latest = testTime
+	}
+	if !latest.Before(validUntil) {
+		return nil, fmt.Errorf("%w: %s 校准有效期截止于 %s", ErrDeviceOutOfCalibration, deviceAddr, due.Format("2006-01-02"))
+	}
+	return &device, nil
+}
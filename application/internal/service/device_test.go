@@ -0,0 +1,218 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+// dateOffset 返回今天零点偏移 days 天的日期
+func dateOffset(days int) *time.Time {
+	now := time.Now()
+	d := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, days)
+	return &d
+}
+
+// stringPtr 返回字符串指针
+func stringPtr(s string) *string {
+	return &s
+}
+
+// createCalibrationCertificates 创建用作校准依据的证书：CAL-OK 已签发且合格，CAL-DRAFT 为草稿，
+// CAL-FAIL 已签发但不合格，CAL-REVOKED 已撤销
+func createCalibrationCertificates(t *testing.T, client *database.Client) {
+	t.Helper()
+	createTestCertificate(t, client, "CAL-OK", "issued")
+	createTestCertificate(t, client, "CAL-DRAFT", "draft")
+	failed := createTestCertificate(t, client, "CAL-FAIL", "issued")
+	if err := client.DB.Model(failed).Update("test_result", TestResultUnqualified).Error; err != nil {
+		t.Fatalf("设置检定结论失败: %v", err)
+	}
+	createTestCertificate(t, client, "CAL-REVOKED", "revoked")
+}
+
+func TestCreateDevice(t *testing.T) {
+	tests := []struct {
+		name       string
+		device     models.Device
+		wantErr    error
+		wantStatus string
+		// wantDueFromCert 为 true 时期望校准有效期取自 CAL-OK 证书
+		wantDueFromCert bool
+	}{
+		{name: "默认在用", device: models.Device{DeviceAddr: " DEV002 ", CalibrationExternalRef: "省计量院 JZ-0002", CalibrationDueDate: dateOffset(30)}, wantStatus: DeviceStatusActive},
+		{name: "维修中", device: models.Device{DeviceAddr: "DEV002", Status: DeviceStatusMaintenance}, wantStatus: DeviceStatusMaintenance},
+		{name: "状态无效", device: models.Device{DeviceAddr: "DEV002", Status: "broken"}, wantErr: ErrInvalidDeviceStatus},
+		{name: "地址已存在", device: models.Device{DeviceAddr: " DEV001"}, wantErr: ErrDeviceAddrTaken},
+		{name: "本系统校准证书", device: models.Device{DeviceAddr: "DEV002", CalibrationCertNumber: stringPtr(" CAL-OK ")}, wantStatus: DeviceStatusActive, wantDueFromCert: true},
+		{name: "校准证书不存在", device: models.Device{DeviceAddr: "DEV002", CalibrationCertNumber: stringPtr("CAL-404")}, wantErr: ErrInvalidCalibrationRef},
+		{name: "校准证书未签发", device: models.Device{DeviceAddr: "DEV002", CalibrationCertNumber: stringPtr("CAL-DRAFT")}, wantErr: ErrInvalidCalibrationRef},
+		{name: "校准证书不合格", device: models.Device{DeviceAddr: "DEV002", CalibrationCertNumber: stringPtr("CAL-FAIL")}, wantErr: ErrInvalidCalibrationRef},
+		{name: "校准证书已撤销", device: models.Device{DeviceAddr: "DEV002", CalibrationCertNumber: stringPtr("CAL-REVOKED")}, wantErr: ErrInvalidCalibrationRef},
+		{name: "同时填写两种校准证书", device: models.Device{DeviceAddr: "DEV002", CalibrationCertNumber: stringPtr("CAL-OK"), CalibrationExternalRef: "JZ-0002"}, wantErr: ErrInvalidCalibrationRef},
+		{name: "外部校准证书缺少有效期", device: models.Device{DeviceAddr: "DEV002", CalibrationExternalRef: "JZ-0002"}, wantErr: ErrInvalidCalibrationRef},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			createCalibrationCertificates(t, client)
+			s := NewDeviceService(client, nil)
+			if err := s.CreateDevice(&models.Device{DeviceAddr: "DEV001"}); err != nil {
+				t.Fatalf("登记设备失败: %v", err)
+			}
+
+			device := tt.device
+			if err := s.CreateDevice(&device); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			stored, err := s.GetDevice(device.ID)
+			if err != nil {
+				t.Fatalf("查询设备失败: %v", err)
+			}
+			if stored.DeviceAddr != "DEV002" || stored.Status != tt.wantStatus {
+				t.Fatalf("登记结果不符: %+v", stored)
+			}
+			if tt.wantDueFromCert {
+				var cert models.Certificate
+				if err := client.DB.Where("cert_number = ?", "CAL-OK").First(&cert).Error; err != nil {
+					t.Fatalf("查询校准证书失败: %v", err)
+				}
+				if stored.CalibrationCertNumber == nil || *stored.CalibrationCertNumber != "CAL-OK" ||
+					stored.CalibrationDueDate == nil || !stored.CalibrationDueDate.Equal(cert.ExpireDate) {
+					t.Fatalf("校准有效期应取自证书: %+v", stored)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckDeviceUsable(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   models.Device
+		testTime time.Time
+		// prepare 在登记设备后调整校准证书
+		prepare func(t *testing.T, client *database.Client)
+		wantErr error
+	}{
+		{name: "外部校准证书有效", device: models.Device{CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(30)}},
+		{name: "有效期截止当天", device: models.Device{CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(0)}},
+		{name: "已超出有效期", device: models.Device{CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(-1)}, wantErr: ErrDeviceOutOfCalibration},
+		{name: "测试时间超出有效期", device: models.Device{CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(1)}, testTime: dateOffset(2).Add(time.Hour), wantErr: ErrDeviceOutOfCalibration},
+		{name: "未登记校准证书", device: models.Device{}, wantErr: ErrDeviceOutOfCalibration},
+		{name: "维修中", device: models.Device{Status: DeviceStatusMaintenance, CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(30)}, wantErr: ErrDeviceUnavailable},
+		{name: "已停用", device: models.Device{Status: DeviceStatusRetired, CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(30)}, wantErr: ErrDeviceUnavailable},
+		{name: "本系统校准证书有效", device: models.Device{CalibrationCertNumber: stringPtr("CAL-OK")}},
+		{
+			name:   "本系统校准证书登记后被撤销",
+			device: models.Device{CalibrationCertNumber: stringPtr("CAL-OK")},
+			prepare: func(t *testing.T, client *database.Client) {
+				if err := client.DB.Model(&models.Certificate{}).Where("cert_number = ?", "CAL-OK").Update("status", "revoked").Error; err != nil {
+					t.Fatalf("撤销校准证书失败: %v", err)
+				}
+			},
+			wantErr: ErrDeviceOutOfCalibration,
+		},
+		{
+			name:   "本系统校准证书已过期",
+			device: models.Device{CalibrationCertNumber: stringPtr("CAL-OK")},
+			prepare: func(t *testing.T, client *database.Client) {
+				if err := client.DB.Model(&models.Certificate{}).Where("cert_number = ?", "CAL-OK").Update("expire_date", *dateOffset(-1)).Error; err != nil {
+					t.Fatalf("调整校准证书有效期失败: %v", err)
+				}
+			},
+			wantErr: ErrDeviceOutOfCalibration,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			createCalibrationCertificates(t, client)
+			device := tt.device
+			device.DeviceAddr = "DEV001"
+			if err := NewDeviceService(client, nil).CreateDevice(&device); err != nil {
+				t.Fatalf("登记设备失败: %v", err)
+			}
+			if tt.prepare != nil {
+				tt.prepare(t, client)
+			}
+
+			testTime := tt.testTime
+			if testTime.IsZero() {
+				testTime = time.Now().Add(-time.Minute)
+			}
+			if _, err := checkDeviceUsable(client.DB, "DEV001", testTime); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := checkDeviceUsable(newTestDB(t).DB, "DEV404", time.Now()); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("未登记的设备期望 ErrDeviceNotFound，实际 %v", err)
+	}
+}
+
+func TestDeleteDevice(t *testing.T) {
+	tests := []struct {
+		name        string
+		reference   func(t *testing.T, client *database.Client)
+		wantRetired bool
+	}{
+		{name: "无引用时删除"},
+		{
+			name: "上传过测试数据时改为停用",
+			reference: func(t *testing.T, client *database.Client) {
+				cert := createTestCertificate(t, client, "CT-D-001", "testing")
+				if err := client.DB.Create(newLedgerReading(cert, "DEV001")).Error; err != nil {
+					t.Fatalf("写入测试数据失败: %v", err)
+				}
+			},
+			wantRetired: true,
+		},
+		{
+			name: "绑定了 API Key 时改为停用",
+			reference: func(t *testing.T, client *database.Client) {
+				if err := client.DB.Create(&models.APIKey{Name: "station", KeyPrefix: "ck_test", KeyHash: "hash", DeviceAddr: stringPtr("DEV001")}).Error; err != nil {
+					t.Fatalf("创建 API Key 失败: %v", err)
+				}
+			},
+			wantRetired: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := NewDeviceService(client, nil)
+			device := &models.Device{DeviceAddr: "DEV001", CalibrationExternalRef: "JZ-0001", CalibrationDueDate: dateOffset(30)}
+			if err := s.CreateDevice(device); err != nil {
+				t.Fatalf("登记设备失败: %v", err)
+			}
+			if tt.reference != nil {
+				tt.reference(t, client)
+			}
+
+			retired, err := s.DeleteDevice(device.ID)
+			if err != nil {
+				t.Fatalf("删除设备失败: %v", err)
+			}
+			if retired != tt.wantRetired {
+				t.Fatalf("期望停用=%v，实际 %v", tt.wantRetired, retired)
+			}
+			stored, err := s.GetDevice(device.ID)
+			if tt.wantRetired {
+				if err != nil || stored.Status != DeviceStatusRetired {
+					t.Fatalf("期望设备改为停用: %+v（%v）", stored, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrDeviceNotFound) {
+				t.Fatalf("删除后期望 ErrDeviceNotFound，实际 %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// certificateDiffIgnored 比较证书版本时忽略的字段（由系统维护，不属于业务变更）
+var certificateDiffIgnored = []string{"id", "version", "createdAt", "updatedAt", "customer"}
+
+// ledgerDiffIgnored 比较账本版本时忽略的字段
+var ledgerDiffIgnored = []string{"version", "updatedAt"}
+
+// immutableAfterIssue 证书签发后不允许再变更的账本字段
+var immutableAfterIssue = map[string]bool{
+	"certNumber":         true,
+	"customerName":       true,
+	"customerAddress":    true,
+	"instrumentName":     true,
+	"manufacturer":       true,
+	"modelSpec":          true,
+	"instrumentNumber":   true,
+	"instrumentAccuracy": true,
+	"testDate":           true,
+	"expireDate":         true,
+	"testResult":         true,
+	"testDataHash":       true,
+	"uncertaintyHash":    true,
+}
+
+// toFieldMap 将结构体按 JSON 字段名展开为 map，便于逐字段比较
+func toFieldMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// diffFields 逐字段比较两个对象，返回按字段名排序的变更列表
+func diffFields(oldObj, newObj interface{}, ignored ...string) ([]models.FieldChange, error) {
+	oldFields, err := toFieldMap(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := toFieldMap(newObj)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range ignored {
+		delete(oldFields, name)
+		delete(newFields, name)
+	}
+
+	names := make(map[string]struct{}, len(oldFields)+len(newFields))
+	for name := range oldFields {
+		names[name] = struct{}{}
+	}
+	for name := range newFields {
+		names[name] = struct{}{}
+	}
+
+	var changes []models.FieldChange
+	for name := range names {
+		if !reflect.DeepEqual(oldFields[name], newFields[name]) {
+			changes = append(changes, models.FieldChange{
+				Field:    name,
+				OldValue: oldFields[name],
+				NewValue: newFields[name],
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
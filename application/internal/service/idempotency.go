@@ -0,0 +1,100 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"time"
+)
+
+// ErrIdempotencyKeyMismatch 同一幂等键被用于不同的请求体
+var ErrIdempotencyKeyMismatch = errors.New("Idempotency-Key 已被用于不同的请求内容")
+
+// ErrIdempotencyInProgress 同一幂等键的首次请求仍在处理中
+var ErrIdempotencyInProgress = errors.New("相同 Idempotency-Key 的请求正在处理中，请稍后重试")
+
+// IdempotencyService 幂等键服务
+type IdempotencyService struct {
+	dbClient *database.Client
+	window   time.Duration
+	lease    time.Duration // 处理中记录的占用时长
+}
+
+// NewIdempotencyService 创建新的 IdempotencyService
+func NewIdempotencyService(dbClient *database.Client, window, lease time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		dbClient: dbClient,
+		window:   window,
+		lease:    lease,
+	}
+}
+
+// Begin 占用幂等键。首次请求返回新建的记录；已完成的重试请求返回首次的记录（StatusCode 非 0）供重放。
+// 处理中的记录超过占用时长仍未完成（处理请求的进程已中断）时由本次请求重新占用
+// 幂等键按用户和 API Key 隔离，同一用户创建的多个 API Key 之间以及与用户本人的请求互不影响
+func (s *IdempotencyService) Begin(key string, userID, apiKeyID int64, method, path, requestHash string) (*models.IdempotencyRecord, bool, error) {
+	now := time.Now()
+	// 顺带清理已过期的记录
+	if err := s.dbClient.DB.Where("expires_at < ?", now).Delete(&models.IdempotencyRecord{}).Error; err != nil {
+		return nil, false, err
+	}
+
+	record := &models.IdempotencyRecord{
+		Key:         key,
+		UserID:      userID,
+		APIKeyID:    apiKeyID,
+		Method:      method,
+		Path:        path,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.window),
+	}
+	createErr := s.dbClient.DB.Create(record).Error
+	if createErr == nil {
+		return record, false, nil
+	}
+
+	// 插入失败时检查是否因为幂等键已存在（唯一索引冲突）
+	var existing models.IdempotencyRecord
+	err := s.dbClient.DB.Where("idem_key = ? AND user_id = ? AND api_key_id = ? AND method = ? AND path = ?", key, userID, apiKeyID, method, path).
+		First(&existing).Error
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, false, createErr
+		}
+		return nil, false, err
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, false, ErrIdempotencyKeyMismatch
+	}
+	if existing.StatusCode == 0 {
+		// 条件更新保证并发重试中只有一个请求能重新占用
+		result := s.dbClient.DB.Model(&existing).
+			Where("status_code = 0 AND created_at < ?", now.Add(-s.lease)).
+			Updates(map[string]interface{}{"created_at": now, "expires_at": now.Add(s.window)})
+		if result.Error != nil {
+			return nil, false, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil, false, ErrIdempotencyInProgress
+		}
+		existing.CreatedAt = now
+		existing.ExpiresAt = now.Add(s.window)
+		return &existing, false, nil
+	}
+	return &existing, true, nil
+}
+
+// Complete 保存首次请求的响应，供后续重试重放
+func (s *IdempotencyService) Complete(record *models.IdempotencyRecord, statusCode int, body string) error {
+	return s.dbClient.DB.Model(record).Updates(map[string]interface{}{
+		"status_code":   statusCode,
+		"response_body": body,
+	}).Error
+}
+
+// Release 释放幂等键（首次请求失败时调用），允许客户端使用同一键重试
+func (s *IdempotencyService) Release(record *models.IdempotencyRecord) error {
+	return s.dbClient.DB.Delete(record).Error
+}
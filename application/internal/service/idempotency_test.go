@@ -0,0 +1,123 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyBegin(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 在首次请求占用幂等键后调整记录状态
+		prepare    func(s *IdempotencyService, record *models.IdempotencyRecord) error
+		userID     int64
+		apiKeyID   int64
+		hash       string
+		wantErr    error
+		wantReplay bool
+		wantNew    bool
+	}{
+		{
+			name: "已完成的重试重放首次响应",
+			prepare: func(s *IdempotencyService, record *models.IdempotencyRecord) error {
+				return s.Complete(record, http.StatusCreated, `{"code":200}`)
+			},
+			userID: 1, hash: "h1", wantReplay: true,
+		},
+		{
+			name: "请求体不同",
+			prepare: func(s *IdempotencyService, record *models.IdempotencyRecord) error {
+				return s.Complete(record, http.StatusCreated, `{"code":200}`)
+			},
+			userID: 1, hash: "h2", wantErr: ErrIdempotencyKeyMismatch,
+		},
+		{
+			name:   "首次请求处理中",
+			userID: 1, hash: "h1", wantErr: ErrIdempotencyInProgress,
+		},
+		{
+			name: "占用超时后重新占用",
+			prepare: func(s *IdempotencyService, record *models.IdempotencyRecord) error {
+				return s.dbClient.DB.Model(record).Update("created_at", time.Now().Add(-2*time.Minute)).Error
+			},
+			userID: 1, hash: "h1",
+		},
+		{
+			name: "释放后可重新占用",
+			prepare: func(s *IdempotencyService, record *models.IdempotencyRecord) error {
+				return s.Release(record)
+			},
+			userID: 1, hash: "h1", wantNew: true,
+		},
+		{
+			name: "过期记录被清理",
+			prepare: func(s *IdempotencyService, record *models.IdempotencyRecord) error {
+				return s.dbClient.DB.Model(record).Updates(map[string]interface{}{
+					"status_code": http.StatusCreated, "expires_at": time.Now().Add(-time.Second),
+				}).Error
+			},
+			userID: 1, hash: "h2", wantNew: true,
+		},
+		{
+			name:   "其他用户互不影响",
+			userID: 2, hash: "h2", wantNew: true,
+		},
+		{
+			name:   "API Key 与用户本人互不影响",
+			userID: 1, apiKeyID: 7, hash: "h2", wantNew: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewIdempotencyService(newTestDB(t), time.Hour, time.Minute)
+			first, replay, err := s.Begin("key-1", 1, 0, http.MethodPost, "/api/v1/certificates", "h1")
+			if err != nil || replay {
+				t.Fatalf("首次占用失败: replay=%v err=%v", replay, err)
+			}
+			if tt.prepare != nil {
+				if err := tt.prepare(s, first); err != nil {
+					t.Fatalf("调整记录失败: %v", err)
+				}
+			}
+
+			record, replay, err := s.Begin("key-1", tt.userID, tt.apiKeyID, http.MethodPost, "/api/v1/certificates", tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if replay != tt.wantReplay {
+				t.Fatalf("期望 replay=%v，实际 %v", tt.wantReplay, replay)
+			}
+			if (record.ID != first.ID) != tt.wantNew {
+				t.Fatalf("期望新建记录=%v，首次 %d，本次 %d", tt.wantNew, first.ID, record.ID)
+			}
+			if tt.wantReplay && (record.StatusCode != http.StatusCreated || record.ResponseBody != `{"code":200}`) {
+				t.Fatalf("重放内容不符: %+v", record)
+			}
+		})
+	}
+}
+
+func TestIdempotencyLeaseTakeoverOnce(t *testing.T) {
+	s := NewIdempotencyService(newTestDB(t), time.Hour, time.Minute)
+	first, _, err := s.Begin("key-1", 1, 0, http.MethodPost, "/api/v1/certificates", "h1")
+	if err != nil {
+		t.Fatalf("首次占用失败: %v", err)
+	}
+	if err := s.dbClient.DB.Model(first).Update("created_at", time.Now().Add(-2*time.Minute)).Error; err != nil {
+		t.Fatalf("调整记录失败: %v", err)
+	}
+
+	// 占用超时的记录只能被一个重试请求重新占用，随后的重试仍视为处理中
+	if _, _, err := s.Begin("key-1", 1, 0, http.MethodPost, "/api/v1/certificates", "h1"); err != nil {
+		t.Fatalf("重新占用失败: %v", err)
+	}
+	if _, _, err := s.Begin("key-1", 1, 0, http.MethodPost, "/api/v1/certificates", "h1"); !errors.Is(err, ErrIdempotencyInProgress) {
+		t.Fatalf("期望 ErrIdempotencyInProgress，实际 %v", err)
+	}
+}
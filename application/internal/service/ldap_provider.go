@@ -0,0 +1,156 @@
+package service
+
+import (
+	"cert-system/config"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPProvider LDAP 绑定认证：用服务账号查找用户条目，再以用户 DN 和密码绑定校验
+type LDAPProvider struct {
+	cfg config.LDAPConfig
+}
+
+// NewLDAPProvider 创建新的 LDAPProvider
+func NewLDAPProvider(cfg config.LDAPConfig) *LDAPProvider {
+	return &LDAPProvider{cfg: cfg}
+}
+
+func (p *LDAPProvider) Name() string {
+	return ldapProviderName
+}
+
+// Authenticate 校验 LDAP 用户名和密码，并按所属组映射角色
+func (p *LDAPProvider) Authenticate(username, password string) (*ExternalIdentity, error) {
+	// 空密码绑定在多数 LDAP 服务器上会被当作匿名绑定而成功，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, fmt.Errorf("连接 LDAP 服务器失败: %v", err)
+	}
+	defer conn.Close()
+
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP 服务账号绑定失败: %v", err)
+		}
+	}
+
+	entry, err := p.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP 用户绑定失败: %v", err)
+	}
+
+	groups, err := p.userGroups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+	role, err := mapGroupsToRole(groups, p.cfg.GroupRoles, p.cfg.DefaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExternalIdentity{
+		Provider: ldapProviderName,
+		Subject:  entry.DN,
+		Username: username,
+		Role:     role,
+	}, nil
+}
+
+// dial 建立 LDAP 连接，ldap:// 连接按配置升级为 TLS
+func (p *LDAPProvider) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.cfg.InsecureSkipVerify}
+	timeout := p.cfg.TimeoutDuration()
+
+	conn, err := ldap.DialURL(p.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	if p.cfg.StartTLS && strings.HasPrefix(strings.ToLower(p.cfg.URL), "ldap://") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// findUser 按用户名查找唯一的用户条目，不存在或不唯一时视为用户名或密码错误
+func (p *LDAPProvider) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	attributes := []string{"dn"}
+	if p.cfg.GroupAttribute != "" {
+		attributes = append(attributes, p.cfg.GroupAttribute)
+	}
+	req := ldap.NewSearchRequest(
+		p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(p.cfg.TimeoutDuration().Seconds()), false,
+		fmt.Sprintf(p.cfg.UserFilter, ldap.EscapeFilter(username)),
+		attributes, nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP 查找用户失败: %v", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// userGroups 返回用户所属的组：优先读取用户条目的组属性，配置了组过滤器时再按组查找
+func (p *LDAPProvider) userGroups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	var groups []string
+	if p.cfg.GroupAttribute != "" {
+		groups = append(groups, entry.GetAttributeValues(p.cfg.GroupAttribute)...)
+	}
+	if p.cfg.GroupFilter == "" {
+		return groups, nil
+	}
+
+	// 以服务账号身份查找组，匿名查找时沿用用户绑定
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP 服务账号绑定失败: %v", err)
+		}
+	}
+	baseDN := p.cfg.GroupBaseDN
+	if baseDN == "" {
+		baseDN = p.cfg.BaseDN
+	}
+	req := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(p.cfg.TimeoutDuration().Seconds()), false,
+		fmt.Sprintf(p.cfg.GroupFilter, ldap.EscapeFilter(entry.DN)),
+		[]string{"dn"}, nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP 查找用户组失败: %v", err)
+	}
+	for _, group := range result.Entries {
+		groups = append(groups, group.DN)
+	}
+	return groups, nil
+}
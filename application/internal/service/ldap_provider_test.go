@@ -0,0 +1,253 @@
+package service
+
+import (
+	"cert-system/config"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapEntry 测试目录中的条目
+type ldapEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// ldapStandIn 进程内的最小 LDAP 服务，只支持简单绑定和单个等值过滤器的查找
+type ldapStandIn struct {
+	listener net.Listener
+	entries  map[string]*ldapEntry // DN -> 条目
+
+	mu    sync.Mutex
+	binds []string // 绑定成功的 DN，按顺序记录
+}
+
+func newLDAPStandIn(t *testing.T, entries map[string]*ldapEntry) *ldapStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	s := &ldapStandIn{listener: listener, entries: entries}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *ldapStandIn) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStandIn) boundDNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+func (s *ldapStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := string(op.Children[2].Data.Bytes())
+			code := ldap.LDAPResultInvalidCredentials
+			if entry, ok := s.entries[strings.ToLower(dn)]; ok && password != "" && entry.password == password {
+				code = ldap.LDAPResultSuccess
+				s.mu.Lock()
+				s.binds = append(s.binds, dn)
+				s.mu.Unlock()
+			}
+			s.reply(conn, messageID, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			baseDN := strings.ToLower(op.Children[0].Value.(string))
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				s.reply(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultFilterError))
+				continue
+			}
+			var requested []string
+			for _, attr := range op.Children[7].Children {
+				requested = append(requested, attr.Value.(string))
+			}
+			for dn, entry := range s.entries {
+				if strings.HasSuffix(dn, baseDN) && entry.matches(filter) {
+					s.reply(conn, messageID, searchEntry(dn, entry, requested))
+				}
+			}
+			s.reply(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// matches 判断条目是否满足形如 (attr=value) 的过滤器，属性值不区分大小写
+func (e *ldapEntry) matches(filter string) bool {
+	attr, value, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(filter, "("), ")"), "=")
+	if !ok {
+		return false
+	}
+	for _, v := range e.attributes[attr] {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ldapStandIn) reply(conn net.Conn, messageID int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	envelope.AppendChild(op)
+	conn.Write(envelope.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return op
+}
+
+func searchEntry(dn string, entry *ldapEntry, requested []string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for _, name := range requested {
+		values, ok := entry.attributes[name]
+		if !ok {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, v := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		}
+		attr.AppendChild(vals)
+		attributes.AppendChild(attr)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+const (
+	testServiceDN = "cn=svc,dc=example,dc=com"
+	testAliceDN   = "uid=alice,ou=people,dc=example,dc=com"
+	testBobDN     = "uid=bob,ou=people,dc=example,dc=com"
+)
+
+func newTestLDAPProvider(t *testing.T) (*LDAPProvider, *ldapStandIn) {
+	t.Helper()
+	server := newLDAPStandIn(t, map[string]*ldapEntry{
+		testServiceDN: {password: "svc-secret"},
+		testAliceDN: {password: "alice-pw", attributes: map[string][]string{
+			"uid":      {"alice"},
+			"memberOf": {"cn=operators,ou=groups,dc=example,dc=com"},
+		}},
+		testBobDN: {password: "bob-pw", attributes: map[string][]string{
+			"uid":      {"bob"},
+			"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+		}},
+		"cn=cert-admins,ou=groups,dc=example,dc=com": {attributes: map[string][]string{
+			"member": {testAliceDN},
+		}},
+	})
+	provider := NewLDAPProvider(config.LDAPConfig{
+		URL:            server.url(),
+		BindDN:         testServiceDN,
+		BindPassword:   "svc-secret",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(uid=%s)",
+		GroupAttribute: "memberOf",
+		GroupBaseDN:    "ou=groups,dc=example,dc=com",
+		GroupFilter:    "(member=%s)",
+		GroupRoles: map[string]string{
+			"operators":   "operator",
+			"cert-admins": "admin",
+		},
+		Timeout: "2s",
+	})
+	return provider, server
+}
+
+func TestLDAPProviderAuthenticate(t *testing.T) {
+	provider, server := newTestLDAPProvider(t)
+
+	identity, err := provider.Authenticate("alice", "alice-pw")
+	if err != nil {
+		t.Fatalf("认证失败: %v", err)
+	}
+	if identity.Provider != ldapProviderName || identity.Subject != testAliceDN || identity.Username != "alice" {
+		t.Fatalf("身份不符: %+v", identity)
+	}
+	// memberOf 映射为 operator，按组查找得到的 cert-admins 映射为 admin，取较高者
+	if identity.Role != "admin" {
+		t.Fatalf("期望角色 admin，实际 %q", identity.Role)
+	}
+
+	// 服务账号查找用户，再以用户 DN 绑定校验密码，查找组前切回服务账号
+	want := []string{testServiceDN, testAliceDN, testServiceDN}
+	if got := server.boundDNs(); strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Fatalf("绑定顺序不符: %v", got)
+	}
+}
+
+func TestLDAPProviderRejects(t *testing.T) {
+	provider, _ := newTestLDAPProvider(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{name: "密码错误", username: "alice", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "用户不存在", username: "mallory", password: "x", wantErr: ErrInvalidCredentials},
+		{name: "空密码不能匿名绑定", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+		{name: "过滤器注入", username: "*", password: "alice-pw", wantErr: ErrInvalidCredentials},
+		{name: "没有映射角色的组", username: "bob", password: "bob-pw", wantErr: ErrNoMappedRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := provider.Authenticate(tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 identity=%+v err=%v", tt.wantErr, identity, err)
+			}
+		})
+	}
+}
+
+func TestLDAPProviderServiceAccountFailure(t *testing.T) {
+	provider, _ := newTestLDAPProvider(t)
+	provider.cfg.BindPassword = "wrong"
+
+	_, err := provider.Authenticate("alice", "alice-pw")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("服务账号绑定失败应报告为服务错误而非凭据错误，实际 %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidCredentials 用户名或密码错误（不区分用户是否存在）
+var ErrInvalidCredentials = errors.New("用户名或密码错误")
+
+// ErrAccountDisabled 账号已被禁用
+var ErrAccountDisabled = errors.New("账号已被禁用，请联系管理员")
+
+// 登录审计记录的失败原因
+const (
+	loginReasonBadCredentials  = "bad_credentials"
+	loginReasonLocked          = "locked"
+	loginReasonThrottled       = "throttled"
+	loginReasonIPThrottled     = "ip_throttled"
+	loginReasonDisabled        = "disabled"
+	loginReasonBadSecondFactor = "bad_second_factor"
+	loginReasonNotAuthorized   = "not_authorized"
+	loginReasonAccountConflict = "account_conflict"
+)
+
+// LoginThrottledError 登录尝试过于频繁或账号被锁定，RetryAfter 为建议的重试等待时长
+type LoginThrottledError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return e.Message
+}
+
+// LoginProtection 登录防暴力破解：按账号和IP统计连续失败次数，超过阈值后指数退避，按账号锁定
+type LoginProtection struct {
+	dbClient *database.Client
+	cfg      config.LoginProtectionConfig
+}
+
+// NewLoginProtection 创建新的 LoginProtection
+func NewLoginProtection(dbClient *database.Client, cfg config.LoginProtectionConfig) *LoginProtection {
+	return &LoginProtection{
+		dbClient: dbClient,
+		cfg:      cfg,
+	}
+}
+
+// backoff 计算第 failures 次连续失败后需要等待的时长，free 次以内不退避
+func (p *LoginProtection) backoff(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+	base := p.cfg.BaseDelayDuration()
+	maxDelay := p.cfg.MaxDelayDuration()
+	exp := failures - free
+	if exp > 30 {
+		return maxDelay
+	}
+	delay := time.Duration(float64(base) * math.Pow(2, float64(exp)))
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// ipFailureReasons 计入IP失败次数的原因。因限流或锁定被拒绝的请求不计入，
+// 否则共享出口IP的客户端每次重试都会推迟退避，导致长期无法登录
+var ipFailureReasons = []string{loginReasonBadCredentials, loginReasonBadSecondFactor}
+
+// CheckIP 检查该IP在统计窗口内校验凭据失败的次数，超过上限后按指数退避拒绝
+func (p *LoginProtection) CheckIP(ip string, now time.Time) error {
+	if p.cfg.IPMaxFailures <= 0 {
+		return nil
+	}
+
+	query := p.dbClient.DB.Model(&models.LoginAttempt{}).
+		Where("ip = ? AND success = ? AND reason IN ? AND created_at > ?", ip, false, ipFailureReasons, now.Add(-p.cfg.IPWindowDuration())).
+		Session(&gorm.Session{})
+	var failures int64
+	if err := query.Count(&failures).Error; err != nil {
+		return err
+	}
+	if failures == 0 {
+		return nil
+	}
+	// 取最近一次失败的时间，不使用 MAX 聚合以保留列的时间类型
+	var last models.LoginAttempt
+	if err := query.Order("created_at DESC").Limit(1).Find(&last).Error; err != nil {
+		return err
+	}
+
+	if wait := last.CreatedAt.Add(p.backoff(int(failures), p.cfg.IPMaxFailures)).Sub(now); wait > 0 {
+		return &LoginThrottledError{Message: "登录失败次数过多，请稍后再试", RetryAfter: wait}
+	}
+	return nil
+}
+
+// CheckUser 检查账号是否处于锁定期或退避期
+func (p *LoginProtection) CheckUser(user *models.User, now time.Time) (reason string, err error) {
+	if user.LockedUntil != nil && user.LockedUntil.After(now) {
+		return loginReasonLocked, &LoginThrottledError{
+			Message:    fmt.Sprintf("账号已被临时锁定，请于 %s 后重试或联系管理员解锁", user.LockedUntil.Format("15:04:05")),
+			RetryAfter: user.LockedUntil.Sub(now),
+		}
+	}
+	if user.LastFailedLoginAt == nil {
+		return "", nil
+	}
+	if wait := user.LastFailedLoginAt.Add(p.backoff(user.FailedLoginCount, p.cfg.FreeAttempts)).Sub(now); wait > 0 {
+		return loginReasonThrottled, &LoginThrottledError{Message: "登录失败次数过多，请稍后再试", RetryAfter: wait}
+	}
+	return "", nil
+}
+
+// RecordUserFailure 累加账号的连续失败次数，达到阈值时锁定账号并清零计数
+func (p *LoginProtection) RecordUserFailure(userID int64, now time.Time) error {
+	return p.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"failed_login_count":   user.FailedLoginCount + 1,
+			"last_failed_login_at": now,
+		}
+		if p.cfg.LockThreshold > 0 && user.FailedLoginCount+1 >= p.cfg.LockThreshold {
+			updates["failed_login_count"] = 0
+			updates["locked_until"] = now.Add(p.cfg.LockDurationValue())
+			log.Printf("用户ID %d 连续登录失败 %d 次，账号已锁定", userID, user.FailedLoginCount+1)
+		}
+		return tx.Model(&user).Updates(updates).Error
+	})
+}
+
+// ResetUser 清除账号的失败计数和锁定状态
+func (p *LoginProtection) ResetUser(userID int64) error {
+	return resetLoginFailures(p.dbClient.DB, userID)
+}
+
+// RecordAttempt 写入登录审计记录，写入失败只记录日志不影响登录流程
+func (p *LoginProtection) RecordAttempt(username string, userID *int64, ip, userAgent string, success bool, reason string) {
+	if len(username) > 100 {
+		username = username[:100]
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	attempt := &models.LoginAttempt{
+		Username:  username,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Success:   success,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if err := p.dbClient.DB.Create(attempt).Error; err != nil {
+		log.Printf("写入登录审计记录失败: %v", err)
+	}
+}
+
+// resetLoginFailures 清除账号的失败计数和锁定状态
+func resetLoginFailures(db *gorm.DB, userID int64) error {
+	return db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	}).Error
+}
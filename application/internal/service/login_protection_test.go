@@ -0,0 +1,155 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	p := NewLoginProtection(nil, config.LoginProtectionConfig{BaseDelay: "1s", MaxDelay: "1m"})
+	tests := []struct {
+		name     string
+		failures int
+		free     int
+		want     time.Duration
+	}{
+		{name: "免退避次数以内", failures: 2, free: 3, want: 0},
+		{name: "达到免退避次数", failures: 3, free: 3, want: time.Second},
+		{name: "每多失败一次翻倍", failures: 5, free: 3, want: 4 * time.Second},
+		{name: "不超过最长时长", failures: 20, free: 3, want: time.Minute},
+		{name: "次数极大时不溢出", failures: 1000, free: 3, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.backoff(tt.failures, tt.free); got != tt.want {
+				t.Fatalf("期望 %v，实际 %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoginCheckUser(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+	p := NewLoginProtection(nil, config.LoginProtectionConfig{FreeAttempts: 3, BaseDelay: "10s", MaxDelay: "1m"})
+	tests := []struct {
+		name       string
+		user       models.User
+		wantReason string
+		wantRetry  time.Duration
+	}{
+		{name: "无失败记录", user: models.User{}},
+		{name: "锁定中", user: models.User{LockedUntil: at(5 * time.Minute)}, wantReason: loginReasonLocked, wantRetry: 5 * time.Minute},
+		{name: "锁定已到期", user: models.User{LockedUntil: at(-time.Second)}},
+		{name: "免退避次数以内", user: models.User{FailedLoginCount: 2, LastFailedLoginAt: at(0)}},
+		{name: "退避期内", user: models.User{FailedLoginCount: 4, LastFailedLoginAt: at(-5 * time.Second)}, wantReason: loginReasonThrottled, wantRetry: 15 * time.Second},
+		{name: "退避期已过", user: models.User{FailedLoginCount: 4, LastFailedLoginAt: at(-20 * time.Second)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := p.CheckUser(&tt.user, now)
+			if reason != tt.wantReason {
+				t.Fatalf("期望原因 %q，实际 %q", tt.wantReason, reason)
+			}
+			var throttled *LoginThrottledError
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("不应拒绝: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &throttled) || throttled.RetryAfter != tt.wantRetry {
+				t.Fatalf("期望等待 %v，实际 %v", tt.wantRetry, err)
+			}
+		})
+	}
+}
+
+func TestLoginCheckIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		reasons []string
+		ago     time.Duration
+		wantErr bool
+	}{
+		{name: "未超过上限", reasons: []string{loginReasonBadCredentials, loginReasonBadCredentials}, ago: time.Second},
+		{name: "超过上限后退避", reasons: []string{loginReasonBadCredentials, loginReasonBadCredentials, loginReasonBadSecondFactor}, ago: time.Second, wantErr: true},
+		{name: "退避期已过", reasons: []string{loginReasonBadCredentials, loginReasonBadCredentials, loginReasonBadCredentials}, ago: 5 * time.Second},
+		{name: "统计窗口外的失败不计入", reasons: []string{loginReasonBadCredentials, loginReasonBadCredentials, loginReasonBadCredentials}, ago: 2 * time.Hour},
+		{name: "被限流或锁定的请求不计入", reasons: []string{loginReasonBadCredentials, loginReasonIPThrottled, loginReasonLocked, loginReasonThrottled}, ago: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			p := NewLoginProtection(client, config.LoginProtectionConfig{IPMaxFailures: 3, IPWindow: "15m", BaseDelay: "2s"})
+			now := time.Now()
+			for _, reason := range tt.reasons {
+				attempt := &models.LoginAttempt{Username: "alice", IP: "10.0.0.1", Reason: reason, CreatedAt: now.Add(-tt.ago)}
+				if err := client.DB.Create(attempt).Error; err != nil {
+					t.Fatalf("写入登录记录失败: %v", err)
+				}
+			}
+
+			err := p.CheckIP("10.0.0.1", now)
+			var throttled *LoginThrottledError
+			if tt.wantErr != errors.As(err, &throttled) {
+				t.Fatalf("期望限流=%v，实际 %v", tt.wantErr, err)
+			}
+			// 其他IP不受影响
+			if err := p.CheckIP("10.0.0.2", now); err != nil {
+				t.Fatalf("其他IP不应被限流: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 3, LockDuration: "30m"})
+	user := createTestUser(t, s, "alice", "operator")
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Login("alice", "wrong-password", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("第 %d 次期望 ErrInvalidCredentials，实际 %v", i+1, err)
+		}
+	}
+	// 锁定期内正确密码也被拒绝，且不区分用户是否存在的提示不变
+	var throttled *LoginThrottledError
+	if _, err := s.Login("alice", testPassword, "10.0.0.1", "test"); !errors.As(err, &throttled) || throttled.RetryAfter <= 29*time.Minute {
+		t.Fatalf("期望账号锁定约 30 分钟，实际 %v", err)
+	}
+	if _, err := s.Login("nobody", "wrong-password", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("不存在的用户期望 ErrInvalidCredentials，实际 %v", err)
+	}
+
+	users := NewUserService(client, s.passwords, s.policy)
+	if _, err := users.UnlockUser(user.ID); err != nil {
+		t.Fatalf("解锁失败: %v", err)
+	}
+	if _, err := s.Login("alice", testPassword, "10.0.0.1", "test"); err != nil {
+		t.Fatalf("解锁后登录失败: %v", err)
+	}
+
+	var attempts []models.LoginAttempt
+	if err := client.DB.Order("id ASC").Find(&attempts).Error; err != nil {
+		t.Fatalf("查询登录审计记录失败: %v", err)
+	}
+	wantReasons := []string{loginReasonBadCredentials, loginReasonBadCredentials, loginReasonBadCredentials, loginReasonLocked, loginReasonBadCredentials, ""}
+	if len(attempts) != len(wantReasons) {
+		t.Fatalf("期望 %d 条审计记录，实际 %+v", len(wantReasons), attempts)
+	}
+	for i, attempt := range attempts {
+		if attempt.Reason != wantReasons[i] || attempt.IP != "10.0.0.1" || attempt.UserAgent != "test" {
+			t.Fatalf("第 %d 条审计记录不符: %+v", i+1, attempt)
+		}
+	}
+	if last := attempts[len(attempts)-1]; !last.Success || last.UserID == nil || *last.UserID != user.ID {
+		t.Fatalf("成功登录的审计记录不符: %+v", last)
+	}
+}
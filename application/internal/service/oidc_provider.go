@@ -0,0 +1,95 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/helper"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OIDCProvider OpenID Connect 认证（授权码模式 + PKCE）
+type OIDCProvider struct {
+	cfg    config.OIDCConfig
+	client *helper.OIDCClient
+}
+
+// NewOIDCProvider 创建新的 OIDCProvider
+func NewOIDCProvider(cfg config.OIDCConfig) *OIDCProvider {
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &OIDCProvider{cfg: cfg, client: helper.NewOIDCClient(cfg)}
+}
+
+func (p *OIDCProvider) Name() string {
+	return oidcProviderName
+}
+
+func (p *OIDCProvider) DisplayName() string {
+	if p.cfg.DisplayName == "" {
+		return "统一身份认证"
+	}
+	return p.cfg.DisplayName
+}
+
+func (p *OIDCProvider) PostLoginRedirect() string {
+	return p.cfg.PostLoginRedirect
+}
+
+func (p *OIDCProvider) StateTTL() time.Duration {
+	return p.cfg.StateTTLDuration()
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	return p.client.AuthCodeURL(state, nonce, codeChallenge)
+}
+
+// Exchange 换取并校验 ID Token，从声明中取用户名和组
+func (p *OIDCProvider) Exchange(code, codeVerifier, nonce string) (*ExternalIdentity, error) {
+	claims, err := p.client.Exchange(code, codeVerifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("ID Token 缺少 sub")
+	}
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("ID Token 缺少用户名声明 %s", p.cfg.UsernameClaim)
+	}
+
+	role, err := mapGroupsToRole(claimStrings(claims[p.cfg.GroupsClaim]), p.cfg.GroupRoles, p.cfg.DefaultRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExternalIdentity{
+		Provider: oidcProviderName,
+		Subject:  subject,
+		Username: username,
+		Role:     role,
+	}, nil
+}
+
+// claimStrings 将字符串或字符串数组形式的声明转换为字符串切片
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
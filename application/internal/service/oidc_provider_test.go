@@ -0,0 +1,208 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/helper"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// oidcStandIn 进程内的 OIDC 身份提供方：发现文档、JWKS 和令牌端点，令牌端点校验 PKCE 后签发 ID Token
+type oidcStandIn struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	// 按授权码保存授权请求中的 code_challenge 和要签发的 ID Token 声明
+	challenges map[string]string
+	claims     map[string]jwt.MapClaims
+	// signer 不为 nil 时用它代替 JWKS 中的密钥签名，模拟伪造的令牌
+	signer *rsa.PrivateKey
+}
+
+func newOIDCStandIn(t *testing.T) *oidcStandIn {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	s := &oidcStandIn{key: key, kid: "k1", challenges: map[string]string{}, claims: map[string]jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 s.server.URL,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+			"jwks_uri":               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(helper.JWKS{Keys: []helper.JWK{{
+			Kty: "RSA",
+			Kid: s.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		code := r.PostForm.Get("code")
+		claims, ok := s.claims[code]
+		if !ok || helper.PKCEChallenge(r.PostForm.Get("code_verifier")) != s.challenges[code] {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(s.claims, code)
+
+		signer := s.key
+		if s.signer != nil {
+			signer = s.signer
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = s.kid
+		idToken, err := token.SignedString(signer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// authorize 模拟用户在身份提供方登录：记录授权请求的 code_challenge，返回授权码
+func (s *oidcStandIn) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("授权地址无效: %v", err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("授权请求未使用 PKCE S256: %s", authURL)
+	}
+	code := "code-" + u.Query().Get("state")
+	s.challenges[code] = u.Query().Get("code_challenge")
+	s.claims[code] = claims
+	return code
+}
+
+// validClaims 返回能通过校验的 ID Token 声明
+func (s *oidcStandIn) validClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                s.server.URL,
+		"aud":                "cert-system",
+		"sub":                "u-123",
+		"preferred_username": "carol",
+		"groups":             []string{"Cert-Operators", "staff"},
+		"nonce":              nonce,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func newTestOIDCProvider(issuer *oidcStandIn) *OIDCProvider {
+	return NewOIDCProvider(config.OIDCConfig{
+		IssuerURL:   issuer.server.URL,
+		ClientID:    "cert-system",
+		RedirectURL: "http://localhost/api/v1/auth/sso/oidc/callback",
+		GroupRoles:  map[string]string{"cert-operators": "operator", "staff": "viewer"},
+	})
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	issuer := newOIDCStandIn(t)
+	provider := newTestOIDCProvider(issuer)
+
+	authURL, err := provider.AuthCodeURL("state-1", "nonce-1", helper.PKCEChallenge("verifier-1"))
+	if err != nil {
+		t.Fatalf("生成授权地址失败: %v", err)
+	}
+	code := issuer.authorize(t, authURL, issuer.validClaims("nonce-1"))
+
+	identity, err := provider.Exchange(code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("换取身份失败: %v", err)
+	}
+	if identity.Provider != oidcProviderName || identity.Subject != "u-123" || identity.Username != "carol" {
+		t.Fatalf("身份不符: %+v", identity)
+	}
+	if identity.Role != "operator" {
+		t.Fatalf("期望角色 operator，实际 %q", identity.Role)
+	}
+}
+
+func TestOIDCProviderRejectsInvalidIDToken(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(claims jwt.MapClaims)
+		verifier string
+		forged   bool
+		wantErr  error
+	}{
+		{name: "nonce 不匹配", modify: func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{name: "受众不匹配", modify: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "签发方不匹配", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "已过期", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "缺少 sub", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "缺少用户名", modify: func(c jwt.MapClaims) { delete(c, "preferred_username") }},
+		{name: "签名密钥不在 JWKS 中", forged: true},
+		{name: "PKCE 校验码错误", verifier: "wrong-verifier"},
+		{name: "没有映射角色的组", modify: func(c jwt.MapClaims) { c["groups"] = []string{"others"} }, wantErr: ErrNoMappedRole},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newOIDCStandIn(t)
+			provider := newTestOIDCProvider(issuer)
+			if tt.forged {
+				issuer.signer = otherKey
+			}
+
+			authURL, err := provider.AuthCodeURL("state-1", "nonce-1", helper.PKCEChallenge("verifier-1"))
+			if err != nil {
+				t.Fatalf("生成授权地址失败: %v", err)
+			}
+			claims := issuer.validClaims("nonce-1")
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			code := issuer.authorize(t, authURL, claims)
+
+			verifier := "verifier-1"
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			identity, err := provider.Exchange(code, verifier, "nonce-1")
+			if err == nil {
+				t.Fatalf("期望校验失败，实际通过: %+v", identity)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// ErrWeakPassword 密码不满足密码策略
+var ErrWeakPassword = errors.New("密码不符合安全策略")
+
+// ErrPasswordReused 新密码与最近使用过的密码相同
+var ErrPasswordReused = errors.New("新密码不能与最近使用过的密码相同")
+
+// ErrWrongPassword 当前密码错误
+var ErrWrongPassword = errors.New("当前密码错误")
+
+// PasswordPolicy 密码策略
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	HistoryCount  int           // 不允许重复使用的最近密码数量
+	MaxAge        time.Duration // 密码最长使用时间，0 表示不过期
+}
+
+// NewPasswordPolicy 根据配置创建密码策略
+func NewPasswordPolicy(cfg config.PasswordConfig) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:     cfg.MinLength,
+		RequireUpper:  cfg.RequireUpper,
+		RequireLower:  cfg.RequireLower,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+		HistoryCount:  cfg.HistoryCount,
+		MaxAge:        time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+	}
+	if policy.MinLength < 8 {
+		policy.MinLength = 8
+	}
+	return policy
+}
+
+// Validate 检查密码是否满足长度和字符类别要求
+func (p *PasswordPolicy) Validate(password string) error {
+	var problems []string
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("长度至少为%d位", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		problems = append(problems, "必须包含大写字母")
+	}
+	if p.RequireLower && !hasLower {
+		problems = append(problems, "必须包含小写字母")
+	}
+	if p.RequireDigit && !hasDigit {
+		problems = append(problems, "必须包含数字")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		problems = append(problems, "必须包含特殊字符")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrWeakPassword, strings.Join(problems, "，"))
+	}
+	return nil
+}
+
+// IsExpired 判断密码是否超过最长使用时间
+func (p *PasswordPolicy) IsExpired(changedAt time.Time) bool {
+	return p.MaxAge > 0 && !changedAt.IsZero() && time.Since(changedAt) > p.MaxAge
+}
+
+// GenerateTemporary 生成满足密码策略的随机临时密码
+func (p *PasswordPolicy) GenerateTemporary() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789!@#$%&*"
+	length := p.MinLength + 4
+	for {
+		buf := make([]byte, length)
+		for i := range buf {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return "", err
+			}
+			buf[i] = alphabet[n.Int64()]
+		}
+		password := string(buf)
+		if p.Validate(password) == nil {
+			return password, nil
+		}
+	}
+}
+
+// setPassword 校验策略后为用户设置新密码，旧密码哈希写入历史记录
+// 管理员重置（mustChange 为 true）时不检查历史密码
+func setPassword(db *gorm.DB, passwords *helper.PasswordManager, policy *PasswordPolicy, user *models.User, newPassword string, mustChange bool) error {
+	if err := policy.Validate(newPassword); err != nil {
+		return err
+	}
+
+	if !mustChange && policy.HistoryCount > 0 {
+		if ok, _, _ := passwords.Verify(newPassword, user.PasswordHash); ok {
+			return ErrPasswordReused
+		}
+		var history []*models.PasswordHistory
+		err := db.Where("user_id = ?", user.ID).
+			Order("created_at DESC, id DESC").
+			Limit(policy.HistoryCount - 1).
+			Find(&history).Error
+		if err != nil {
+			return err
+		}
+		for _, h := range history {
+			if ok, _, _ := passwords.Verify(newPassword, h.PasswordHash); ok {
+				return ErrPasswordReused
+			}
+		}
+	}
+
+	hash, err := passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.PasswordHistory{
+			UserID:       user.ID,
+			PasswordHash: user.PasswordHash,
+			CreatedAt:    now,
+		}).Error; err != nil {
+			return err
+		}
+
+		// 只保留策略需要的历史记录
+		if policy.HistoryCount > 0 {
+			var keepIDs []int64
+			if err := tx.Model(&models.PasswordHistory{}).
+				Where("user_id = ?", user.ID).
+				Order("created_at DESC, id DESC").
+				Limit(policy.HistoryCount).
+				Pluck("id", &keepIDs).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ? AND id NOT IN ?", user.ID, keepIDs).
+				Delete(&models.PasswordHistory{}).Error; err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{
+			"password_hash":        hash,
+			"must_change_password": mustChange,
+			"password_changed_at":  now,
+			"updated_at":           now,
+		}
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+		user.MustChangePassword = mustChange
+		user.PasswordChangedAt = now
+		return nil
+	})
+}
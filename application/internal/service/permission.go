@@ -0,0 +1,112 @@
+package service
+
+import (
+	"cert-system/config"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// 系统权限
+const (
+	PermCertRead      = "cert:read"      // 查看证书、历史和版本差异
+	PermCertCreate    = "cert:create"    // 创建证书
+	PermCertUpdate    = "cert:update"    // 修改证书内容
+	PermCertRenumber  = "cert:renumber"  // 修改证书编号
+	PermCertStatus    = "cert:status"    // 修改证书状态（草稿、检测中、已完成之间流转）
+	PermCertIssue     = "cert:issue"     // 签发证书（状态改为 issued）
+	PermCertRevoke    = "cert:revoke"    // 撤销证书（状态改为 revoked）
+	PermCertDelete    = "cert:delete"    // 删除证书
+	PermCertVerify    = "cert:verify"    // 校验证书链上哈希
+	PermCustomerRead  = "customer:read"  // 查看委托方
+	PermCustomerWrite = "customer:write" // 创建、修改和删除委托方
+	PermDeviceRead    = "device:read"    // 查看检测设备
+	PermDeviceWrite   = "device:write"   // 登记、修改和停用检测设备
+	PermTestDataRead  = "testdata:read"  // 查看测试数据
+	PermTestDataWrite = "testdata:write" // 上传测试数据
+	PermUserManage    = "user:manage"    // 用户管理
+	PermAuditRead     = "audit:read"     // 查看登录审计记录
+)
+
+// AllPermissions 系统定义的全部权限，用于展开通配符
+var AllPermissions = []string{
+	PermCertRead, PermCertCreate, PermCertUpdate, PermCertRenumber, PermCertStatus, PermCertIssue, PermCertRevoke,
+	PermCertDelete, PermCertVerify, PermCustomerRead, PermCustomerWrite, PermDeviceRead, PermDeviceWrite,
+	PermTestDataRead, PermTestDataWrite, PermUserManage, PermAuditRead,
+}
+
+// RolePermissions 角色到权限的映射
+type RolePermissions struct {
+	roles map[string]map[string]bool
+}
+
+// NewRolePermissions 根据配置创建角色权限映射，未配置时使用默认映射
+func NewRolePermissions(cfg config.PermissionConfig) *RolePermissions {
+	roleConfig := cfg.Roles
+	if len(roleConfig) == 0 {
+		roleConfig = config.DefaultRolePermissions()
+	}
+
+	rp := &RolePermissions{roles: make(map[string]map[string]bool)}
+	for role, patterns := range roleConfig {
+		granted := make(map[string]bool)
+		for _, pattern := range patterns {
+			for _, perm := range AllPermissions {
+				if matchPermission(pattern, perm) {
+					granted[perm] = true
+				}
+			}
+		}
+		rp.roles[role] = granted
+	}
+	return rp
+}
+
+// matchPermission 判断权限模式是否匹配，"*" 匹配全部，"cert:*" 匹配同一前缀的权限
+func matchPermission(pattern, perm string) bool {
+	if pattern == "*" || pattern == perm {
+		return true
+	}
+	if strings.HasSuffix(pattern, ":*") {
+		return strings.HasPrefix(perm, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+// Has 判断角色是否拥有指定权限
+func (rp *RolePermissions) Has(role, perm string) bool {
+	return rp.roles[role][perm]
+}
+
+// For 返回角色拥有的全部权限（按名称排序）
+func (rp *RolePermissions) For(role string) []string {
+	perms := make([]string, 0, len(rp.roles[role]))
+	for perm := range rp.roles[role] {
+		perms = append(perms, perm)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// StatusChangePermissions 返回将证书状态从 from 改为 to 需要的权限，状态未变化时返回空。
+// 任何状态变化都需要 cert:status；签发、撤销另需对应权限，将已签发或已撤销的证书改回其他状态
+// 相当于撤回签发或撤销，同样需要对应权限
+func StatusChangePermissions(from, to string) []string {
+	if from == to {
+		return nil
+	}
+	perms := []string{PermCertStatus}
+	for _, status := range []string{to, from} {
+		perm := ""
+		switch status {
+		case "issued":
+			perm = PermCertIssue
+		case "revoked":
+			perm = PermCertRevoke
+		}
+		if perm != "" && !slices.Contains(perms, perm) {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
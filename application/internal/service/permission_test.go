@@ -0,0 +1,93 @@
+package service
+
+import (
+	"cert-system/config"
+	"slices"
+	"testing"
+)
+
+func TestRolePermissions(t *testing.T) {
+	rp := NewRolePermissions(config.PermissionConfig{Roles: map[string][]string{
+		"admin":    {"*"},
+		"operator": {"cert:*", "testdata:write"},
+		"auditor":  {"audit:read", "cert:unknown", "unknown:*"},
+	}})
+	tests := []struct {
+		name string
+		role string
+		perm string
+		want bool
+	}{
+		{name: "通配符匹配全部权限", role: "admin", perm: PermUserManage, want: true},
+		{name: "前缀通配符匹配同类权限", role: "operator", perm: PermCertRevoke, want: true},
+		{name: "前缀通配符不匹配其他类别", role: "operator", perm: PermCustomerWrite},
+		{name: "精确匹配", role: "operator", perm: PermTestDataWrite, want: true},
+		{name: "未授予的同类权限", role: "operator", perm: PermTestDataRead},
+		{name: "未定义的权限被忽略", role: "auditor", perm: "cert:unknown"},
+		{name: "未配置的角色", role: "viewer", perm: PermCertRead},
+		{name: "空角色", role: "", perm: PermCertRead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rp.Has(tt.role, tt.perm); got != tt.want {
+				t.Fatalf("期望 %v，实际 %v", tt.want, got)
+			}
+		})
+	}
+
+	if got := rp.For("auditor"); !slices.Equal(got, []string{PermAuditRead}) {
+		t.Fatalf("auditor 的有效权限不符: %v", got)
+	}
+	if got := rp.For("admin"); len(got) != len(AllPermissions) || !slices.IsSorted(got) {
+		t.Fatalf("admin 应按名称排序拥有全部权限: %v", got)
+	}
+}
+
+func TestDefaultRolePermissions(t *testing.T) {
+	rp := NewRolePermissions(config.PermissionConfig{})
+	tests := []struct {
+		role string
+		perm string
+		want bool
+	}{
+		{role: "admin", perm: PermCertIssue, want: true},
+		{role: "operator", perm: PermCertCreate, want: true},
+		{role: "operator", perm: PermTestDataWrite, want: true},
+		{role: "operator", perm: PermCertStatus},
+		{role: "operator", perm: PermCertIssue},
+		{role: "operator", perm: PermCertDelete},
+		{role: "operator", perm: PermUserManage},
+		{role: "viewer", perm: PermCertRead, want: true},
+		{role: "viewer", perm: PermCertCreate},
+		{role: "viewer", perm: PermTestDataWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role+" "+tt.perm, func(t *testing.T) {
+			if got := rp.Has(tt.role, tt.perm); got != tt.want {
+				t.Fatalf("期望 %v，实际 %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStatusChangePermissions(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want []string
+	}{
+		{from: "draft", to: "draft", want: nil},
+		{from: "draft", to: "testing", want: []string{PermCertStatus}},
+		{from: "completed", to: "issued", want: []string{PermCertStatus, PermCertIssue}},
+		{from: "issued", to: "revoked", want: []string{PermCertStatus, PermCertRevoke, PermCertIssue}},
+		{from: "issued", to: "completed", want: []string{PermCertStatus, PermCertIssue}},
+		{from: "revoked", to: "draft", want: []string{PermCertStatus, PermCertRevoke}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			if got := StatusChangePermissions(tt.from, tt.to); !slices.Equal(got, tt.want) {
+				t.Fatalf("期望 %v，实际 %v", tt.want, got)
+			}
+		})
+	}
+}
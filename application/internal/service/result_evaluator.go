@@ -0,0 +1,240 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 检定结果
+const (
+	TestResultQualified   = "qualified"
+	TestResultUnqualified = "unqualified"
+)
+
+// limitEpsilon 比较误差与限值时容许的浮点误差
+const limitEpsilon = 1e-9
+
+// ErrResultMismatch 签发时人工检定结果与测试数据的自动判定不一致或无法判定
+var ErrResultMismatch = errors.New("检定结果与测试数据自动判定不一致")
+
+// ResultEvaluator 按检定规程的误差限值表判定互感器测试数据是否合格
+type ResultEvaluator struct {
+	standards      []config.ErrorLimitStandard
+	tolerance      float64
+	enforceOnIssue bool
+}
+
+// NewResultEvaluator 根据配置创建判定器，未配置限值表时使用 JJG 313 / JJG 314 默认限值
+func NewResultEvaluator(cfg config.ErrorLimitConfig) *ResultEvaluator {
+	standards := cfg.Standards
+	if len(standards) == 0 {
+		standards = config.DefaultErrorLimitStandards()
+	}
+	// 等级名称统一归一化，配置中写 "0.2级"、"0.2s" 也能匹配
+	normalized := make([]config.ErrorLimitStandard, 0, len(standards))
+	for _, std := range standards {
+		classes := make(map[string][]config.ErrorLimitPoint, len(std.Classes))
+		for class, points := range std.Classes {
+			sorted := append([]config.ErrorLimitPoint(nil), points...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i].Percent < sorted[j].Percent })
+			classes[normalizeAccuracyClass(class)] = sorted
+		}
+		std.Classes = classes
+		normalized = append(normalized, std)
+	}
+
+	return &ResultEvaluator{
+		standards:      normalized,
+		tolerance:      cfg.PointToleranceOrDefault(),
+		enforceOnIssue: cfg.EnforceOnIssue,
+	}
+}
+
+// Evaluate 判定证书的全部测试点：存在无法匹配到限值表百分点的测试点时无法判定；否则任一测试点
+// 超差即判定不合格；全部合格且覆盖限值表的所有百分点时判定合格；其余情况无法判定，需补充测试数据
+func (e *ResultEvaluator) Evaluate(cert *models.Certificate, data []*models.TestData) *models.ResultEvaluation {
+	result := &models.ResultEvaluation{
+		CertNumber:     cert.CertNumber,
+		InstrumentName: cert.InstrumentName,
+		AccuracyClass:  normalizeAccuracyClass(cert.InstrumentAccuracy),
+		ManualResult:   cert.TestResult,
+		Points:         []*models.PointEvaluation{},
+		MissingPoints:  []float64{},
+		Messages:       []string{},
+	}
+
+	std := e.findStandard(cert.InstrumentName)
+	if std == nil {
+		result.Messages = append(result.Messages, fmt.Sprintf("器具 %s 没有适用的误差限值表，需人工判定", cert.InstrumentName))
+		return result
+	}
+	result.Standard = std.Name
+	result.Applicable = true
+
+	limits, ok := std.Classes[result.AccuracyClass]
+	if !ok {
+		result.Messages = append(result.Messages, fmt.Sprintf("%s 未定义准确度等级 %q 的误差限值", std.Name, cert.InstrumentAccuracy))
+		return result
+	}
+	if len(data) == 0 {
+		result.Messages = append(result.Messages, "证书没有测试数据，无法判定")
+		return result
+	}
+
+	covered := make(map[float64]bool)
+	failed, unmatched := 0, 0
+	for _, d := range data {
+		point := e.evaluatePoint(d, limits)
+		result.Points = append(result.Points, point)
+		if !point.Evaluated {
+			unmatched++
+			continue
+		}
+		covered[point.LimitPercent] = true
+		if !point.Passed {
+			failed++
+		}
+	}
+	for _, limit := range limits {
+		if !covered[limit.Percent] {
+			result.MissingPoints = append(result.MissingPoints, limit.Percent)
+		}
+	}
+
+	switch {
+	case unmatched > 0:
+		result.Messages = append(result.Messages, fmt.Sprintf("%d 个测试点的额定百分点不在 %s %s级 限值表中，无法判定", unmatched, std.Name, result.AccuracyClass))
+	case failed > 0:
+		result.SuggestedResult = TestResultUnqualified
+		result.Messages = append(result.Messages, fmt.Sprintf("%d 个测试点超出 %s %s级 误差限值，判定不合格", failed, std.Name, result.AccuracyClass))
+	case len(result.MissingPoints) > 0:
+		result.Messages = append(result.Messages, fmt.Sprintf("缺少 %s 百分点的测试数据，无法判定", formatPercents(result.MissingPoints)))
+	default:
+		result.SuggestedResult = TestResultQualified
+		result.Messages = append(result.Messages, fmt.Sprintf("全部测试点符合 %s %s级 误差限值，判定合格", std.Name, result.AccuracyClass))
+	}
+	result.Agrees = result.SuggestedResult != "" && result.SuggestedResult == cert.TestResult
+	return result
+}
+
+// CheckIssuance 签发前检查人工检定结果与自动判定是否一致，没有适用限值表的器具不做检查
+func (e *ResultEvaluator) CheckIssuance(cert *models.Certificate, data []*models.TestData) error {
+	if !e.enforceOnIssue {
+		return nil
+	}
+	evaluation := e.Evaluate(cert, data)
+	if !evaluation.Applicable || evaluation.Agrees {
+		return nil
+	}
+	if evaluation.SuggestedResult == "" {
+		return fmt.Errorf("%w: %s", ErrResultMismatch, strings.Join(evaluation.Messages, "；"))
+	}
+	return fmt.Errorf("%w: 人工结论为 %s，自动判定为 %s（%s）", ErrResultMismatch,
+		cert.TestResult, evaluation.SuggestedResult, strings.Join(evaluation.Messages, "；"))
+}
+
+// findStandard 按器具名称关键字查找适用的检定规程
+func (e *ResultEvaluator) findStandard(instrumentName string) *config.ErrorLimitStandard {
+	for i := range e.standards {
+		for _, keyword := range e.standards[i].Keywords {
+			if keyword != "" && strings.Contains(instrumentName, keyword) {
+				return &e.standards[i]
+			}
+		}
+	}
+	return nil
+}
+
+// evaluatePoint 按测试数据的额定百分点匹配限值表百分点并判定比值差和相位差，
+// 未记录额定百分点或匹配不到限值表百分点的测试点无法判定
+func (e *ResultEvaluator) evaluatePoint(d *models.TestData, limits []config.ErrorLimitPoint) *models.PointEvaluation {
+	point := &models.PointEvaluation{
+		TestDataID:       d.ID,
+		DeviceAddr:       d.DeviceAddr,
+		TestPoint:        d.TestPoint,
+		PercentageValue:  d.PercentageValue,
+		ActualPercentage: d.ActualPercentage,
+		RatioError:       d.RatioError,
+		AngleError:       d.AngleError,
+	}
+	if d.PercentageValue == 0 {
+		point.Explanation = "测试数据未记录额定百分点，无法判定"
+		return point
+	}
+
+	var limit *config.ErrorLimitPoint
+	for i := range limits {
+		distance := math.Abs(limits[i].Percent - d.PercentageValue)
+		if distance <= e.tolerance && (limit == nil || distance < math.Abs(limit.Percent-d.PercentageValue)) {
+			limit = &limits[i]
+		}
+	}
+	if limit == nil {
+		point.Explanation = fmt.Sprintf("额定百分点 %s%% 不在限值表中（%s），无法判定",
+			formatNumber(d.PercentageValue), formatPercents(limitPercents(limits)))
+		return point
+	}
+
+	point.Evaluated = true
+	point.LimitPercent = limit.Percent
+	point.RatioLimit = limit.RatioError
+	point.AngleLimit = limit.AngleError
+	ratioOK := math.Abs(d.RatioError) <= limit.RatioError+limitEpsilon
+	angleOK := math.Abs(d.AngleError) <= limit.AngleError+limitEpsilon
+	point.Passed = ratioOK && angleOK
+
+	verdict := "合格"
+	if !point.Passed {
+		verdict = "不合格"
+	}
+	point.Explanation = fmt.Sprintf("%s%% 点：比值差 %s%% %s ±%s%%，相位差 %s′ %s ±%s′，%s",
+		formatNumber(limit.Percent),
+		formatNumber(d.RatioError), compareSymbol(ratioOK), formatNumber(limit.RatioError),
+		formatNumber(d.AngleError), compareSymbol(angleOK), formatNumber(limit.AngleError),
+		verdict)
+	return point
+}
+
+// limitPercents 返回限值表的全部百分点
+func limitPercents(limits []config.ErrorLimitPoint) []float64 {
+	percents := make([]float64, len(limits))
+	for i, limit := range limits {
+		percents[i] = limit.Percent
+	}
+	return percents
+}
+
+// normalizeAccuracyClass 归一化准确度等级，如 "0.2级"、"0.2s 级"、"Class 0.2S" 均归一化为 "0.2"/"0.2S"
+func normalizeAccuracyClass(class string) string {
+	class = strings.ToUpper(strings.TrimSpace(class))
+	class = strings.TrimPrefix(class, "CLASS")
+	class = strings.TrimSuffix(class, "级")
+	return strings.ReplaceAll(class, " ", "")
+}
+
+// compareSymbol 返回判定说明中的比较符号
+func compareSymbol(ok bool) string {
+	if ok {
+		return "≤"
+	}
+	return ">"
+}
+
+// formatNumber 格式化数值，去掉多余的零
+func formatNumber(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.4f", v), "0"), ".")
+}
+
+// formatPercents 格式化百分点列表，如 "5%、20%"
+func formatPercents(percents []float64) string {
+	parts := make([]string, len(percents))
+	for i, p := range percents {
+		parts[i] = formatNumber(p) + "%"
+	}
+	return strings.Join(parts, "、")
+}
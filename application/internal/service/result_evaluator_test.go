@@ -0,0 +1,163 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newEvaluatedReading 构造额定百分点 percent 的读数
+func newEvaluatedReading(percent, ratioError, angleError float64) *models.TestData {
+	return &models.TestData{DeviceAddr: "dev-std", DataType: TestDataTypeCurrent, PercentageValue: percent, ActualPercentage: percent, RatioError: ratioError, AngleError: angleError}
+}
+
+// fullCoverageReadings 构造覆盖 JJG 313 0.2 级全部百分点的合格读数
+func fullCoverageReadings() []*models.TestData {
+	return []*models.TestData{
+		newEvaluatedReading(5, 0.3, 12),
+		newEvaluatedReading(20, 0.15, 8),
+		newEvaluatedReading(100, 0.05, 2),
+		newEvaluatedReading(120, -0.1, -5),
+	}
+}
+
+func TestEvaluatePoint(t *testing.T) {
+	limits := []config.ErrorLimitPoint{{Percent: 5, RatioError: 0.75, AngleError: 30}, {Percent: 20, RatioError: 0.35, AngleError: 15}, {Percent: 100, RatioError: 0.2, AngleError: 10}}
+	tests := []struct {
+		name          string
+		tolerance     float64
+		reading       *models.TestData
+		wantEvaluated bool
+		wantPassed    bool
+		wantLimit     float64
+	}{
+		{name: "与限值表百分点一致", reading: newEvaluatedReading(100, 0.1, 5), wantEvaluated: true, wantPassed: true, wantLimit: 100},
+		{name: "在默认容差内", reading: newEvaluatedReading(98.5, 0.1, 5), wantEvaluated: true, wantPassed: true, wantLimit: 100},
+		{name: "恰好等于容差", reading: newEvaluatedReading(22, 0.1, 5), wantEvaluated: true, wantPassed: true, wantLimit: 20},
+		{name: "超出默认容差", reading: newEvaluatedReading(50, 0.1, 5)},
+		{name: "配置的容差更大", tolerance: 5, reading: newEvaluatedReading(96, 0.1, 5), wantEvaluated: true, wantPassed: true, wantLimit: 100},
+		{name: "同时接近两个百分点时取最近的", tolerance: 10, reading: newEvaluatedReading(13, 0.5, 5), wantEvaluated: true, wantPassed: false, wantLimit: 20},
+		{name: "未记录额定百分点", reading: newEvaluatedReading(0, 0.1, 5)},
+		{name: "误差等于限值", reading: newEvaluatedReading(100, 0.2, -10), wantEvaluated: true, wantPassed: true, wantLimit: 100},
+		{name: "比值差超差", reading: newEvaluatedReading(100, -0.21, 5), wantEvaluated: true, wantLimit: 100},
+		{name: "相位差超差", reading: newEvaluatedReading(5, 0.1, -31), wantEvaluated: true, wantLimit: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewResultEvaluator(config.ErrorLimitConfig{PointTolerance: tt.tolerance})
+			point := e.evaluatePoint(tt.reading, limits)
+			if point.Evaluated != tt.wantEvaluated || point.Passed != tt.wantPassed {
+				t.Fatalf("期望 evaluated=%v passed=%v，实际 %+v", tt.wantEvaluated, tt.wantPassed, point)
+			}
+			if point.LimitPercent != tt.wantLimit || point.Explanation == "" {
+				t.Fatalf("期望匹配 %v%% 点并给出说明，实际 %+v", tt.wantLimit, point)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name           string
+		instrumentName string
+		accuracy       string
+		manual         string
+		data           []*models.TestData
+		wantApplicable bool
+		wantSuggested  string
+		wantAgrees     bool
+		wantMissing    []float64
+		wantMessage    string
+	}{
+		{name: "全部合格", instrumentName: "电流互感器", accuracy: "0.2级", manual: TestResultQualified, data: fullCoverageReadings(), wantApplicable: true, wantSuggested: TestResultQualified, wantAgrees: true, wantMessage: "判定合格"},
+		{
+			name: "任一测试点超差即不合格", instrumentName: "电流互感器", accuracy: "0.2", manual: TestResultQualified,
+			data:           append(fullCoverageReadings(), newEvaluatedReading(100, 0.3, 2)),
+			wantApplicable: true, wantSuggested: TestResultUnqualified, wantMessage: "判定不合格",
+		},
+		{
+			name: "缺少百分点时无法判定", instrumentName: "电流互感器", accuracy: "0.2", manual: TestResultQualified,
+			data:           fullCoverageReadings()[2:],
+			wantApplicable: true, wantMissing: []float64{5, 20}, wantMessage: "缺少 5%、20% 百分点",
+		},
+		{
+			name: "缺少百分点但已超差", instrumentName: "电流互感器", accuracy: "0.2", manual: TestResultUnqualified,
+			data:           []*models.TestData{newEvaluatedReading(100, 0.5, 2)},
+			wantApplicable: true, wantSuggested: TestResultUnqualified, wantAgrees: true, wantMissing: []float64{5, 20, 120},
+		},
+		{
+			name: "存在无法匹配的测试点", instrumentName: "电流互感器", accuracy: "0.2", manual: TestResultQualified,
+			data:           append(fullCoverageReadings(), newEvaluatedReading(50, 0.5, 2)),
+			wantApplicable: true, wantMessage: "不在 JJG 313-2010 0.2级 限值表中",
+		},
+		{name: "S 级等级名称归一化", instrumentName: "电流互感器", accuracy: "0.2s 级", manual: TestResultUnqualified, data: fullCoverageReadings(), wantApplicable: true, wantMissing: []float64{1}},
+		{name: "电压互感器", instrumentName: "电压互感器", accuracy: "Class 0.5", manual: TestResultQualified, data: []*models.TestData{newEvaluatedReading(80, 0.4, 15), newEvaluatedReading(100, 0.1, 5), newEvaluatedReading(120, -0.2, 10)}, wantApplicable: true, wantSuggested: TestResultQualified, wantAgrees: true},
+		{name: "没有适用的规程", instrumentName: "电能表", accuracy: "0.2", manual: TestResultQualified, data: fullCoverageReadings(), wantMessage: "没有适用的误差限值表"},
+		{name: "未定义的准确度等级", instrumentName: "电流互感器", accuracy: "3", manual: TestResultQualified, data: fullCoverageReadings(), wantApplicable: true, wantMessage: "未定义准确度等级"},
+		{name: "没有测试数据", instrumentName: "电流互感器", accuracy: "0.2", manual: TestResultQualified, wantApplicable: true, wantMessage: "没有测试数据"},
+	}
+	e := NewResultEvaluator(config.ErrorLimitConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &models.Certificate{CertNumber: "CT-E-001", InstrumentName: tt.instrumentName, InstrumentAccuracy: tt.accuracy, TestResult: tt.manual}
+			result := e.Evaluate(cert, tt.data)
+			if result.Applicable != tt.wantApplicable || result.SuggestedResult != tt.wantSuggested || result.Agrees != tt.wantAgrees {
+				t.Fatalf("期望 applicable=%v suggested=%q agrees=%v，实际 %+v", tt.wantApplicable, tt.wantSuggested, tt.wantAgrees, result)
+			}
+			if tt.wantMissing != nil && !slices.Equal(result.MissingPoints, tt.wantMissing) {
+				t.Fatalf("期望缺少 %v，实际 %v", tt.wantMissing, result.MissingPoints)
+			}
+			if messages := strings.Join(result.Messages, "；"); !strings.Contains(messages, tt.wantMessage) {
+				t.Fatalf("期望说明包含 %q，实际 %s", tt.wantMessage, messages)
+			}
+		})
+	}
+}
+
+func TestCheckIssuance(t *testing.T) {
+	tests := []struct {
+		name           string
+		enforce        bool
+		instrumentName string
+		manual         string
+		data           []*models.TestData
+		wantErr        error
+	}{
+		{name: "未启用检查", instrumentName: "电流互感器", manual: TestResultQualified, data: []*models.TestData{newEvaluatedReading(100, 0.5, 2)}},
+		{name: "结论一致", enforce: true, instrumentName: "电流互感器", manual: TestResultQualified, data: fullCoverageReadings()},
+		{name: "结论不一致", enforce: true, instrumentName: "电流互感器", manual: TestResultQualified, data: []*models.TestData{newEvaluatedReading(100, 0.5, 2)}, wantErr: ErrResultMismatch},
+		{name: "无法判定", enforce: true, instrumentName: "电流互感器", manual: TestResultQualified, data: fullCoverageReadings()[1:], wantErr: ErrResultMismatch},
+		{name: "没有适用的规程时不检查", enforce: true, instrumentName: "电能表", manual: TestResultQualified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewResultEvaluator(config.ErrorLimitConfig{EnforceOnIssue: tt.enforce})
+			cert := &models.Certificate{CertNumber: "CT-E-001", InstrumentName: tt.instrumentName, InstrumentAccuracy: "0.2", TestResult: tt.manual}
+			if err := e.CheckIssuance(cert, tt.data); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeAccuracyClass(t *testing.T) {
+	tests := []struct {
+		class string
+		want  string
+	}{
+		{class: "0.2", want: "0.2"},
+		{class: "0.2级", want: "0.2"},
+		{class: " 0.2s 级 ", want: "0.2S"},
+		{class: "Class 0.5S", want: "0.5S"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			if got := normalizeAccuracyClass(tt.class); got != tt.want {
+				t.Fatalf("期望 %q，实际 %q", tt.want, got)
+			}
+		})
+	}
+}
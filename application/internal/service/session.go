@@ -0,0 +1,236 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidRefreshToken 刷新令牌不存在、已过期或所属会话已吊销
+var ErrInvalidRefreshToken = errors.New("刷新令牌无效或已过期，请重新登录")
+
+// ErrRefreshTokenReused 已使用过的刷新令牌被再次使用，整个会话已被吊销
+var ErrRefreshTokenReused = errors.New("刷新令牌已被使用，会话已注销，请重新登录")
+
+// ErrSessionRevoked 访问令牌所属会话已注销
+var ErrSessionRevoked = errors.New("会话已失效，请重新登录")
+
+// 会话吊销原因
+const (
+	revokeReasonLogout           = "logout"
+	revokeReasonRefreshReuse     = "refresh_reuse"
+	revokeReasonPasswordChanged  = "password_changed"
+	revokeReasonPasswordReset    = "password_reset"
+	revokeReasonUserDisabled     = "user_disabled"
+	revokeReasonUserDeleted      = "user_deleted"
+	revokeReasonRoleChanged      = "role_changed"
+	revokeReasonTwoFactorChanged = "two_factor_changed"
+)
+
+// randomToken 生成 URL 安全的随机令牌
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken 计算刷新令牌或 API Key 的哈希，数据库中只保存哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken 为会话生成新的刷新令牌
+func (s *AuthService) issueRefreshToken(tx *gorm.DB, sessionID string, now time.Time) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := now.Add(s.refreshTTL)
+	record := &models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashToken(token),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// startSession 创建登录会话并签发访问令牌和刷新令牌
+func (s *AuthService) startSession(user *models.User, ip, userAgent string) (*models.LoginResponse, error) {
+	sessionID, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	now := time.Now()
+	var refreshToken string
+	var refreshExpiresAt time.Time
+	err = s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		session := &models.AuthSession{
+			ID:        sessionID,
+			UserID:    user.ID,
+			IP:        ip,
+			UserAgent: userAgent,
+			CreatedAt: now,
+			ExpiresAt: now.Add(s.refreshTTL),
+		}
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, refreshExpiresAt, err = s.issueRefreshToken(tx, sessionID, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueLoginResponse(user, sessionID, refreshToken, refreshExpiresAt)
+}
+
+// Refresh 使用刷新令牌换取新的访问令牌，刷新令牌随之轮换。
+// 已使用过的刷新令牌再次出现说明令牌可能被盗用，吊销整个会话
+func (s *AuthService) Refresh(refreshToken string) (*models.LoginResponse, error) {
+	now := time.Now()
+	var (
+		user             models.User
+		sessionID        string
+		newRefreshToken  string
+		refreshExpiresAt time.Time
+		revokedReason    string
+	)
+
+	err := s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).
+			First(&token).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		var session models.AuthSession
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", token.SessionID).
+			First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrSessionRevoked
+			}
+			return err
+		}
+		if session.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		// 吊销会话需要提交事务，因此只记录原因，事务外返回错误
+		if token.UsedAt != nil {
+			revokedReason = revokeReasonRefreshReuse
+			return revokeSession(tx, session.ID, revokedReason, now)
+		}
+		if now.After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.First(&user, session.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				revokedReason = revokeReasonUserDeleted
+				return revokeSession(tx, session.ID, revokedReason, now)
+			}
+			return err
+		}
+		if user.Status == "disabled" {
+			revokedReason = revokeReasonUserDisabled
+			return revokeSession(tx, session.ID, revokedReason, now)
+		}
+
+		if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+			return err
+		}
+		var err error
+		newRefreshToken, refreshExpiresAt, err = s.issueRefreshToken(tx, session.ID, now)
+		if err != nil {
+			return err
+		}
+		sessionID = session.ID
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"expires_at":   refreshExpiresAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch revokedReason {
+	case revokeReasonRefreshReuse:
+		log.Printf("检测到刷新令牌重放，会话已吊销")
+		return nil, ErrRefreshTokenReused
+	case revokeReasonUserDisabled:
+		return nil, ErrAccountDisabled
+	case revokeReasonUserDeleted:
+		return nil, ErrSessionRevoked
+	}
+
+	resp, err := s.issueLoginResponse(&user, sessionID, newRefreshToken, refreshExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	resp.Message = "令牌刷新成功"
+	return resp, nil
+}
+
+// Logout 吊销访问令牌所属的会话，会话内的访问令牌和刷新令牌立即失效
+func (s *AuthService) Logout(sessionID string) error {
+	return revokeSession(s.dbClient.DB, sessionID, revokeReasonLogout, time.Now())
+}
+
+// ValidateSession 检查访问令牌所属会话是否仍然有效
+func (s *AuthService) ValidateSession(claims *models.JWTClaims) error {
+	if claims.SessionID == "" {
+		return ErrSessionRevoked
+	}
+	var count int64
+	err := s.dbClient.DB.Model(&models.AuthSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", claims.SessionID, claims.UserID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+// revokeSession 吊销单个会话
+func revokeSession(db *gorm.DB, sessionID, reason string, now time.Time) error {
+	return db.Model(&models.AuthSession{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": now, "revoke_reason": reason}).Error
+}
+
+// revokeUserSessions 吊销用户的所有会话（修改密码、重置密码、禁用账号、变更角色时使用）
+func revokeUserSessions(db *gorm.DB, userID int64, reason string) error {
+	return db.Model(&models.AuthSession{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoke_reason": reason}).Error
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+// loginTestUser 以 alice 登录，返回登录响应和访问令牌的声明
+func loginTestUser(t *testing.T, s *AuthService) (*models.LoginResponse, *models.JWTClaims) {
+	t.Helper()
+	resp, err := s.Login("alice", testPassword, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	claims, err := s.tokens.Parse(resp.Data.Token)
+	if err != nil {
+		t.Fatalf("解析访问令牌失败: %v", err)
+	}
+	return resp, claims
+}
+
+// sessionRevokeReason 返回会话的吊销原因，未吊销时为空
+func sessionRevokeReason(t *testing.T, client *database.Client, sessionID string) string {
+	t.Helper()
+	var session models.AuthSession
+	if err := client.DB.Where("id = ?", sessionID).First(&session).Error; err != nil {
+		t.Fatalf("查询会话失败: %v", err)
+	}
+	if session.RevokedAt == nil {
+		return ""
+	}
+	return session.RevokeReason
+}
+
+func TestRefreshRotation(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{})
+	createTestUser(t, s, "alice", "operator")
+	login, claims := loginTestUser(t, s)
+
+	refreshed, err := s.Refresh(login.Data.RefreshToken)
+	if err != nil {
+		t.Fatalf("刷新失败: %v", err)
+	}
+	if refreshed.Data.RefreshToken == "" || refreshed.Data.RefreshToken == login.Data.RefreshToken {
+		t.Fatal("刷新后应返回新的刷新令牌")
+	}
+	newClaims, err := s.tokens.Parse(refreshed.Data.Token)
+	if err != nil {
+		t.Fatalf("解析新访问令牌失败: %v", err)
+	}
+	if newClaims.SessionID != claims.SessionID || s.ValidateSession(newClaims) != nil {
+		t.Fatalf("新访问令牌应属于同一有效会话: %+v", newClaims)
+	}
+
+	// 新刷新令牌可继续轮换
+	if _, err := s.Refresh(refreshed.Data.RefreshToken); err != nil {
+		t.Fatalf("使用新刷新令牌失败: %v", err)
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 在登录后调整状态，返回用于刷新的令牌
+		prepare    func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string
+		wantErr    error
+		wantReason string
+	}{
+		{
+			name: "令牌不存在",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string {
+				return "unknown-token"
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "重放已使用的令牌时吊销会话",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string {
+				if _, err := s.Refresh(login.Data.RefreshToken); err != nil {
+					t.Fatalf("首次刷新失败: %v", err)
+				}
+				return login.Data.RefreshToken
+			},
+			wantErr:    ErrRefreshTokenReused,
+			wantReason: revokeReasonRefreshReuse,
+		},
+		{
+			name: "令牌已过期",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string {
+				if err := client.DB.Model(&models.RefreshToken{}).Where("session_id = ?", sessionID).
+					Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+					t.Fatalf("调整过期时间失败: %v", err)
+				}
+				return login.Data.RefreshToken
+			},
+			wantErr: ErrInvalidRefreshToken,
+		},
+		{
+			name: "已注销的会话",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string {
+				if err := s.Logout(sessionID); err != nil {
+					t.Fatalf("注销失败: %v", err)
+				}
+				return login.Data.RefreshToken
+			},
+			wantErr:    ErrInvalidRefreshToken,
+			wantReason: revokeReasonLogout,
+		},
+		{
+			name: "账号已禁用",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string {
+				if err := client.DB.Model(&models.User{}).Where("username = ?", "alice").Update("status", "disabled").Error; err != nil {
+					t.Fatalf("禁用账号失败: %v", err)
+				}
+				return login.Data.RefreshToken
+			},
+			wantErr:    ErrAccountDisabled,
+			wantReason: revokeReasonUserDisabled,
+		},
+		{
+			name: "账号已删除",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, login *models.LoginResponse, sessionID string) string {
+				if err := client.DB.Where("username = ?", "alice").Delete(&models.User{}).Error; err != nil {
+					t.Fatalf("删除账号失败: %v", err)
+				}
+				return login.Data.RefreshToken
+			},
+			wantErr:    ErrSessionRevoked,
+			wantReason: revokeReasonUserDeleted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := newTestAuthService(t, client, config.LoginProtectionConfig{})
+			createTestUser(t, s, "alice", "operator")
+			login, claims := loginTestUser(t, s)
+
+			token := tt.prepare(t, client, s, login, claims.SessionID)
+			if _, err := s.Refresh(token); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if got := sessionRevokeReason(t, client, claims.SessionID); got != tt.wantReason {
+				t.Fatalf("期望会话吊销原因 %q，实际 %q", tt.wantReason, got)
+			}
+			// 会话被吊销后访问令牌立即失效
+			if tt.wantReason != "" && !errors.Is(s.ValidateSession(claims), ErrSessionRevoked) {
+				t.Fatal("会话吊销后访问令牌应失效")
+			}
+		})
+	}
+}
+
+func TestRefreshReuseRevokesRotatedToken(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{})
+	createTestUser(t, s, "alice", "operator")
+	login, _ := loginTestUser(t, s)
+
+	// 攻击者重放旧令牌后，合法用户持有的新令牌也随会话一起失效
+	refreshed, err := s.Refresh(login.Data.RefreshToken)
+	if err != nil {
+		t.Fatalf("刷新失败: %v", err)
+	}
+	if _, err := s.Refresh(login.Data.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("期望 ErrRefreshTokenReused，实际 %v", err)
+	}
+	if _, err := s.Refresh(refreshed.Data.RefreshToken); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("会话吊销后新令牌应失效，实际 %v", err)
+	}
+}
+
+func TestLogoutRevokesOnlyCurrentSession(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{})
+	createTestUser(t, s, "alice", "operator")
+	_, first := loginTestUser(t, s)
+	_, second := loginTestUser(t, s)
+
+	if err := s.Logout(first.SessionID); err != nil {
+		t.Fatalf("注销失败: %v", err)
+	}
+	if !errors.Is(s.ValidateSession(first), ErrSessionRevoked) {
+		t.Fatal("注销后访问令牌应失效")
+	}
+	if err := s.ValidateSession(second); err != nil {
+		t.Fatalf("其他会话不应受影响: %v", err)
+	}
+	// 缺少会话ID的令牌（如旧版本签发）一律视为无效
+	if !errors.Is(s.ValidateSession(&models.JWTClaims{UserID: second.UserID}), ErrSessionRevoked) {
+		t.Fatal("缺少会话ID的令牌应视为无效")
+	}
+}
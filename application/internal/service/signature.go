@@ -0,0 +1,165 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+// 设备签名算法
+const (
+	SignatureAlgorithmECDSA = "ECDSA-P256-SHA256" // ECDSA P-256 曲线，SHA-256 摘要
+	SignatureAlgorithmSM2   = "SM2-SM3"           // SM2 曲线，SM3 摘要，默认用户标识 1234567812345678
+)
+
+// ErrInvalidPublicKey 设备公钥格式错误或算法不受支持
+var ErrInvalidPublicKey = errors.New("设备公钥无效")
+
+// ErrInvalidSignature 测试数据缺少设备签名或签名校验失败
+var ErrInvalidSignature = errors.New("设备签名无效")
+
+// oidSM2Curve SM2 曲线的对象标识符
+var oidSM2Curve = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+
+// ParseDevicePublicKey 解析 PEM 格式（PUBLIC KEY）的设备公钥，支持 ECDSA P-256 和 SM2，
+// 返回签名算法和规范化后的 PEM
+func ParseDevicePublicKey(publicKeyPEM string) (string, string, error) {
+	block, _ := pem.Decode([]byte(strings.TrimSpace(publicKeyPEM)))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return "", "", fmt.Errorf("%w: 应为 PEM 格式的 PUBLIC KEY", ErrInvalidPublicKey)
+	}
+
+	algorithm := ""
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return "", "", fmt.Errorf("%w: 仅支持 ECDSA P-256 或 SM2 公钥", ErrInvalidPublicKey)
+		}
+		algorithm = SignatureAlgorithmECDSA
+	} else if _, err := parseSM2PublicKey(block.Bytes); err == nil {
+		algorithm = SignatureAlgorithmSM2
+	} else {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+
+	normalized := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: block.Bytes})
+	return algorithm, string(normalized), nil
+}
+
+// parseSM2PublicKey 解析 SM2 公钥，要求曲线参数为 SM2 曲线且公钥点在曲线上
+func parseSM2PublicKey(der []byte) (*sm2.PublicKey, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidSM2Curve) {
+		return nil, errors.New("不是 SM2 公钥")
+	}
+	key, err := gmx509.ParseSm2PublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	if key.X == nil || !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("SM2 公钥点无效")
+	}
+	return key, nil
+}
+
+// CanonicalTestDataPayload 返回设备签名的规范化读数：各字段按固定顺序以 | 连接，
+// 电流、电压为换算后的 A、V 并保留 3 位小数，百分比和误差保留 6 位小数（与数据库精度一致），
+// 测试时间为 UTC 秒级 RFC3339。链码中的 canonicalTestDataPayload 须与此保持一致
+func CanonicalTestDataPayload(d *models.TestData) string {
+	fixed := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	return strings.Join([]string{
+		d.CertNumber,
+		d.DeviceAddr,
+		d.DataType,
+		d.TestPoint,
+		fixed(d.PercentageValue, 6),
+		fixed(d.ActualPercentage, 6),
+		fixed(d.RatioError, 6),
+		fixed(d.AngleError, 6),
+		fixed(d.CurrentValue, 3),
+		fixed(d.VoltageValue, 3),
+		d.WorkstationNumber,
+		d.TestTimestamp.UTC().Truncate(time.Second).Format(time.RFC3339),
+	}, "|")
+}
+
+// VerifyDeviceSignature 用公钥校验 base64 编码的 ASN.1 签名，ECDSA 对载荷的 SHA-256 摘要签名，SM2 对载荷签名
+func VerifyDeviceSignature(algorithm, publicKeyPEM, payload, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: 签名不是有效的 base64 编码", ErrInvalidSignature)
+	}
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return fmt.Errorf("%w: 公钥格式错误", ErrInvalidPublicKey)
+	}
+
+	valid := false
+	switch algorithm {
+	case SignatureAlgorithmECDSA:
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: 不是 ECDSA 公钥", ErrInvalidPublicKey)
+		}
+		digest := sha256.Sum256([]byte(payload))
+		valid = ecdsa.VerifyASN1(key, digest[:], sig)
+	case SignatureAlgorithmSM2:
+		key, err := parseSM2PublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		valid = key.Verify([]byte(payload), sig)
+	default:
+		return fmt.Errorf("%w: 不支持的签名算法 %q", ErrInvalidSignature, algorithm)
+	}
+	if !valid {
+		return fmt.Errorf("%w: 签名与读数内容不符", ErrInvalidSignature)
+	}
+	return nil
+}
+
+// verifyTestDataSignature 按设备登记的公钥校验测试数据签名并记录签名算法和公钥快照。
+// 登记了公钥的设备必须签名；未登记公钥的设备提交的签名无法校验，予以拒绝
+func verifyTestDataSignature(device *models.Device, d *models.TestData) error {
+	if device.PublicKey == "" {
+		if d.Signature != "" {
+			return fmt.Errorf("%w: 设备 %s 未登记公钥，无法校验签名", ErrInvalidSignature, device.DeviceAddr)
+		}
+		return nil
+	}
+	if d.Signature == "" {
+		return fmt.Errorf("%w: 设备 %s 已登记公钥，测试点 %s 须附带设备签名", ErrInvalidSignature, device.DeviceAddr, d.TestPoint)
+	}
+
+	if err := VerifyDeviceSignature(device.KeyAlgorithm, device.PublicKey, CanonicalTestDataPayload(d), d.Signature); err != nil {
+		return fmt.Errorf("测试点 %s: %w", d.TestPoint, err)
+	}
+	d.SignatureAlgorithm = device.KeyAlgorithm
+	d.SignerPublicKey = device.PublicKey
+	return nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidSSOState 授权请求不存在、已使用或已过期
+var ErrInvalidSSOState = errors.New("登录请求已过期，请重新登录")
+
+// AuthProviders 返回登录页可用的认证方式
+func (s *AuthService) AuthProviders() []models.AuthProviderInfo {
+	return s.providers.List()
+}
+
+// SSOPostLoginRedirect 返回认证提供方登录完成后跳转的前端页面，未配置时返回空字符串
+func (s *AuthService) SSOPostLoginRedirect(providerName string) string {
+	provider, err := s.providers.Redirect(providerName)
+	if err != nil {
+		return ""
+	}
+	return provider.PostLoginRedirect()
+}
+
+// StartSSO 生成 state、nonce 和 PKCE 校验码并保存，返回跳转到身份提供方的授权地址，
+// 以及须保存在发起登录的浏览器中（Cookie）、回调时原样提交的 state 绑定值
+func (s *AuthService) StartSSO(providerName string) (string, string, error) {
+	provider, err := s.providers.Redirect(providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(48)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, helper.PKCEChallenge(verifier))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	record := &models.SSOLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider.Name(),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    now.Add(provider.StateTTL()),
+		CreatedAt:    now,
+	}
+	if err := s.dbClient.DB.Create(record).Error; err != nil {
+		return "", "", err
+	}
+	return authURL, record.StateHash, nil
+}
+
+// FinishSSO 处理身份提供方的回调：校验 state 及其与浏览器的绑定，换取身份，开通或同步本地用户后完成登录。
+// state 须由同一浏览器发起，防止攻击者让受害者的浏览器以攻击者的身份完成登录（登录 CSRF）
+func (s *AuthService) FinishSSO(providerName, state, stateBinding, code, ip, userAgent string) (*models.LoginResponse, error) {
+	provider, err := s.providers.Redirect(providerName)
+	if err != nil {
+		return nil, err
+	}
+	if stateBinding == "" || subtle.ConstantTimeCompare([]byte(hashToken(state)), []byte(stateBinding)) != 1 {
+		return nil, ErrInvalidSSOState
+	}
+
+	// state 只能使用一次，查到后立即删除
+	var record models.SSOLoginState
+	err = s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ? AND provider = ?", hashToken(state), provider.Name()).First(&record).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&record).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSSOState
+		}
+		return nil, err
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidSSOState
+	}
+
+	identity, err := provider.Exchange(code, record.CodeVerifier, record.Nonce)
+	if err != nil {
+		if errors.Is(err, ErrNoMappedRole) {
+			s.protection.RecordAttempt(providerName, nil, ip, userAgent, false, loginReasonNotAuthorized)
+			return nil, err
+		}
+		log.Printf("%s 登录失败: %v", providerName, err)
+		return nil, err
+	}
+
+	user, err := s.syncExternalUser(identity, nil)
+	if err != nil {
+		if errors.Is(err, ErrAccountConflict) {
+			s.protection.RecordAttempt(identity.Username, nil, ip, userAgent, false, loginReasonAccountConflict)
+		}
+		return nil, err
+	}
+	return s.completeLogin(user, ip, userAgent)
+}
+
+// syncExternalUser 根据外部身份查找本地用户，首次登录时自动创建，之后同步角色。
+// 角色变化时注销该用户已有的会话，使旧令牌中的角色失效
+func (s *AuthService) syncExternalUser(identity *ExternalIdentity, user *models.User) (*models.User, error) {
+	if len(identity.Username) > 50 {
+		return nil, ErrAccountConflict
+	}
+
+	if user == nil {
+		var existing models.User
+		err := s.dbClient.DB.Where("auth_provider = ? AND external_id = ?", identity.Provider, identity.Subject).First(&existing).Error
+		if err == nil {
+			user = &existing
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		var count int64
+		if err := s.dbClient.DB.Model(&models.User{}).Where("username = ?", identity.Username).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrAccountConflict
+		}
+
+		subject := identity.Subject
+		user = &models.User{
+			Username:          identity.Username,
+			PasswordHash:      "",
+			Role:              identity.Role,
+			Status:            "active",
+			PasswordChangedAt: time.Now(),
+			AuthProvider:      identity.Provider,
+			ExternalID:        &subject,
+		}
+		if err := s.dbClient.DB.Create(user).Error; err != nil {
+			return nil, err
+		}
+		log.Printf("外部账号 %s（%s）首次登录，已创建用户，角色 %s", identity.Username, identity.Provider, identity.Role)
+		return user, nil
+	}
+
+	// 不允许外部账号接管同名的其他认证方式账号
+	if accountProvider(user) != identity.Provider {
+		return nil, ErrAccountConflict
+	}
+
+	updates := map[string]interface{}{}
+	if user.ExternalID == nil || *user.ExternalID != identity.Subject {
+		updates["external_id"] = identity.Subject
+	}
+	if !strings.EqualFold(user.Username, identity.Username) {
+		var count int64
+		if err := s.dbClient.DB.Model(&models.User{}).Where("username = ? AND id <> ?", identity.Username, user.ID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			updates["username"] = identity.Username
+		}
+	}
+	roleChanged := user.Role != identity.Role
+	if roleChanged {
+		updates["role"] = identity.Role
+	}
+	if len(updates) == 0 {
+		return user, nil
+	}
+
+	err := s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		if roleChanged {
+			return revokeUserSessions(tx, user.ID, revokeReasonRoleChanged)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if roleChanged {
+		log.Printf("外部账号 %s 的角色由 %s 同步为 %s", user.Username, user.Role, identity.Role)
+		user.Role = identity.Role
+	}
+	if username, ok := updates["username"]; ok {
+		user.Username = username.(string)
+	}
+	subject := identity.Subject
+	user.ExternalID = &subject
+	return user, nil
+}
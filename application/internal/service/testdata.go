@@ -2,42 +2,451 @@ package service
 
 import (
 	"cert-system/internal/database"
+	"cert-system/internal/fabric"
 	"cert-system/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 测试数据类型
+const (
+	TestDataTypeCurrent = "current" // 电流互感器测试点
+	TestDataTypeVoltage = "voltage" // 电压互感器测试点
 )
 
+// ErrInvalidTestData 测试点数据不完整或与数据类型不符
+var ErrInvalidTestData = errors.New("测试数据无效")
+
+// ErrTestDataNotFound 测试数据不存在
+var ErrTestDataNotFound = errors.New("测试数据不存在")
+
+// ErrDeviceMismatch 读数的设备与 API Key 或 MQTT 主题绑定的设备不一致
+var ErrDeviceMismatch = errors.New("读数设备与绑定设备不一致")
+
+// testDataPercentRange 各数据类型额定百分点的允许范围，JJG 313 电流互感器为 1%~120%，
+// JJG 314 电压互感器为 20%~120%
+var testDataPercentRange = map[string][2]float64{
+	TestDataTypeCurrent: {1, 120},
+	TestDataTypeVoltage: {20, 120},
+}
+
+// maxActualPercentage 实测百分比上限
+const maxActualPercentage = 150
+
+// currentUnits 电流单位换算为 A 的系数
+var currentUnits = map[string]float64{"": 1, "A": 1, "MA": 0.001, "KA": 1000}
+
+// voltageUnits 电压单位换算为 V 的系数
+var voltageUnits = map[string]float64{"": 1, "V": 1, "KV": 1000}
+
+// 测试数据来源，随实时事件推送
+const (
+	TestDataSourceBatch  = "batch"
+	TestDataSourceStream = "stream"
+	TestDataSourceMQTT   = "mqtt"
+)
+
+// 测试数据上链状态
+const (
+	LedgerStatusNone      = "none"      // 账本未启用
+	LedgerStatusPending   = "pending"   // 已入库，等待上链
+	LedgerStatusConfirmed = "confirmed" // 已上链
+	LedgerStatusFailed    = "failed"    // 重试次数用尽，需人工处理
+)
+
+// ledgerRetryBatchSize 每轮重试上链的最多记录数
+const ledgerRetryBatchSize = 100
+
+// maxLedgerErrorLength 记录的上链失败原因的最大字符数
+const maxLedgerErrorLength = 500
+
+// testDataLedger 测试数据上链接口，由 fabric.Client 实现
+type testDataLedger interface {
+	AddTestData(testData interface{}) error
+}
+
 // TestDataService 测试数据服务
 type TestDataService struct {
 	dbClient *database.Client
+	ledger   testDataLedger // 为 nil 时测试数据不上链
+	hub      *TestDataHub   // 入库后向实时查看者广播，为 nil 时不广播
 }
 
 // NewTestDataService 创建新的 TestDataService
-func NewTestDataService(dbClient *database.Client) *TestDataService {
-	return &TestDataService{
+func NewTestDataService(dbClient *database.Client, fabricClient *fabric.Client, hub *TestDataHub) *TestDataService {
+	s := &TestDataService{
 		dbClient: dbClient,
+		hub:      hub,
 	}
+	if fabricClient != nil {
+		s.ledger = fabricClient
+	}
+	return s
+}
+
+// SubscribeTestData 订阅证书新入库的测试数据
+func (s *TestDataService) SubscribeTestData(certNumber string) (<-chan *models.TestDataEvent, func()) {
+	return s.hub.Subscribe(certNumber)
 }
 
-// AddTestData 添加单条测试数据
-func (s *TestDataService) AddTestData(data *models.TestData) error {
-	result := s.dbClient.DB.Create(data)
-	return result.Error
+// NewTestData 校验测试点请求并转换为测试数据，电流换算为 A、电压换算为 V，
+// 数值和测试时间按数据库精度取整，保证设备签名可以用入库后的数据重新校验
+func NewTestData(certID int64, certNumber string, p *models.TestDataPointRequest) (*models.TestData, error) {
+	t, err := time.Parse(time.RFC3339, p.TestTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: testTimestamp 格式错误: %v", ErrInvalidTestData, err)
+	}
+
+	currentFactor, ok := currentUnits[strings.ToUpper(strings.TrimSpace(p.CurrentUnit))]
+	if !ok {
+		return nil, fmt.Errorf("%w: 不支持的电流单位 %q", ErrInvalidTestData, p.CurrentUnit)
+	}
+	voltageFactor, ok := voltageUnits[strings.ToUpper(strings.TrimSpace(p.VoltageUnit))]
+	if !ok {
+		return nil, fmt.Errorf("%w: 不支持的电压单位 %q", ErrInvalidTestData, p.VoltageUnit)
+	}
+
+	data := &models.TestData{
+		CertID:            certID,
+		CertNumber:        certNumber,
+		DeviceAddr:        p.DeviceAddr,
+		DataType:          p.DataType,
+		PercentageValue:   roundTo(p.PercentageValue, 6),
+		RatioError:        roundTo(p.RatioError, 6),
+		AngleError:        roundTo(p.AngleError, 6),
+		CurrentValue:      roundTo(p.CurrentValue*currentFactor, 3),
+		VoltageValue:      roundTo(p.VoltageValue*voltageFactor, 3),
+		WorkstationNumber: p.WorkstationNumber,
+		TestPoint:         p.TestPoint,
+		ActualPercentage:  roundTo(p.ActualPercentage, 6),
+		TestTimestamp:     t.Truncate(time.Second),
+		Signature:         strings.TrimSpace(p.Signature),
+	}
+	if err := validateTestData(data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
-// BatchAddTestData 批量添加测试数据
+// validateTestData 按数据类型校验测试点：电流互感器须有一次电流且不带电压，电压互感器反之，
+// 额定百分点和实测百分比须在规程范围内
+func validateTestData(d *models.TestData) error {
+	if strings.TrimSpace(d.DeviceAddr) == "" || strings.TrimSpace(d.TestPoint) == "" {
+		return fmt.Errorf("%w: deviceAddr 和 testPoint 不能为空", ErrInvalidTestData)
+	}
+	if len(d.WorkstationNumber) > 20 {
+		return fmt.Errorf("%w: 工位号不能超过 20 个字符", ErrInvalidTestData)
+	}
+
+	switch d.DataType {
+	case TestDataTypeCurrent:
+		if d.CurrentValue <= 0 {
+			return fmt.Errorf("%w: 测试点 %s 为电流互感器数据，currentValue 必须大于 0", ErrInvalidTestData, d.TestPoint)
+		}
+		if d.VoltageValue != 0 {
+			return fmt.Errorf("%w: 测试点 %s 为电流互感器数据，不能填写 voltageValue", ErrInvalidTestData, d.TestPoint)
+		}
+	case TestDataTypeVoltage:
+		if d.VoltageValue <= 0 {
+			return fmt.Errorf("%w: 测试点 %s 为电压互感器数据，voltageValue 必须大于 0", ErrInvalidTestData, d.TestPoint)
+		}
+		if d.CurrentValue != 0 {
+			return fmt.Errorf("%w: 测试点 %s 为电压互感器数据，不能填写 currentValue", ErrInvalidTestData, d.TestPoint)
+		}
+	default:
+		return fmt.Errorf("%w: dataType 必须为 %s 或 %s", ErrInvalidTestData, TestDataTypeCurrent, TestDataTypeVoltage)
+	}
+
+	percentRange := testDataPercentRange[d.DataType]
+	if d.PercentageValue != 0 && (d.PercentageValue < percentRange[0] || d.PercentageValue > percentRange[1]) {
+		return fmt.Errorf("%w: 测试点 %s 的额定百分点 %s%% 超出 %s%%~%s%% 范围", ErrInvalidTestData, d.TestPoint,
+			formatNumber(d.PercentageValue), formatNumber(percentRange[0]), formatNumber(percentRange[1]))
+	}
+	if d.ActualPercentage <= 0 || d.ActualPercentage > maxActualPercentage {
+		return fmt.Errorf("%w: 测试点 %s 的实测百分比 %s%% 无效", ErrInvalidTestData, d.TestPoint, formatNumber(d.ActualPercentage))
+	}
+	return nil
+}
+
+// AddTestData 添加单条测试数据，设备必须已登记、在用且在校准有效期内，登记了公钥的设备须附带有效签名，
+// MQTT 上报的读数要求设备登记公钥并签名。source 为实时事件中的数据来源
+func (s *TestDataService) AddTestData(data *models.TestData, source string) error {
+	if err := validateTestData(data); err != nil {
+		return err
+	}
+	device, err := checkDeviceUsable(s.dbClient.DB, data.DeviceAddr, data.TestTimestamp)
+	if err != nil {
+		return err
+	}
+	// 能连接 Broker 的客户端可以向任意设备的主题发布，MQTT 读数的来源只能由设备签名证明
+	if source == TestDataSourceMQTT && device.PublicKey == "" {
+		return fmt.Errorf("%w: 设备 %s 未登记公钥，不接受 MQTT 上报的读数", ErrInvalidSignature, device.DeviceAddr)
+	}
+	if err := verifyTestDataSignature(device, data); err != nil {
+		return err
+	}
+	data.CalibrationCertNumber = device.CalibrationCertNumber
+	data.CalibrationExternalRef = device.CalibrationExternalRef
+	data.LedgerStatus = s.initialLedgerStatus()
+	err = s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := prepareCertificateForTestData(tx, data.CertID); err != nil {
+			return err
+		}
+		return tx.Create(data).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.submitToLedger([]*models.TestData{data}, 0)
+	s.hub.Publish(data.CertNumber, source, []*models.TestData{data})
+	return nil
+}
+
+// BatchAddTestData 批量添加测试数据，任一测试点无效、设备不可用、签名无效或证书已签发时整批拒绝，并记录各设备当时的校准证书。
+// 全部校验通过并入库提交后才上链，上链失败的记录保持待上链状态由后台重试
 func (s *TestDataService) BatchAddTestData(data []*models.TestData) error {
+	for _, d := range data {
+		if err := validateTestData(d); err != nil {
+			return err
+		}
+	}
+	if err := s.checkDevices(data); err != nil {
+		return err
+	}
+
 	tx := s.dbClient.DB.Begin()
 	if tx.Error != nil {
 		return tx.Error
 	}
 
+	prepared := make(map[int64]bool)
 	for _, d := range data {
+		d.LedgerStatus = s.initialLedgerStatus()
+		if !prepared[d.CertID] {
+			if err := prepareCertificateForTestData(tx, d.CertID); err != nil {
+				tx.Rollback()
+				return err
+			}
+			prepared[d.CertID] = true
+		}
 		if err := tx.Create(d).Error; err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	s.submitToLedger(data, 0)
+	if len(data) > 0 {
+		s.hub.Publish(data[0].CertNumber, TestDataSourceBatch, data)
+	}
+	return nil
+}
+
+// initialLedgerStatus 返回新入库测试数据的上链状态
+func (s *TestDataService) initialLedgerStatus() string {
+	if s.ledger == nil {
+		return LedgerStatusNone
+	}
+	return LedgerStatusPending
+}
+
+// submitToLedger 将已入库的测试数据逐条提交到账本并记录上链结果，链码按账本登记的设备公钥再次校验签名。
+// 链码以记录ID为键，重复提交同一记录不会重复上链；maxAttempts 大于 0 时尝试次数用尽的记录标记为失败
+func (s *TestDataService) submitToLedger(data []*models.TestData, maxAttempts int) {
+	if s.ledger == nil {
+		return
+	}
+	for _, d := range data {
+		err := s.ledger.AddTestData(toBlockchainTestData(d))
+		d.LedgerAttempts++
+		if err == nil {
+			d.LedgerStatus, d.LedgerError = LedgerStatusConfirmed, ""
+		} else {
+			log.Printf("测试数据 %d 上链失败（第 %d 次）: %v", d.ID, d.LedgerAttempts, err)
+			d.LedgerError = err.Error()
+			if runes := []rune(d.LedgerError); len(runes) > maxLedgerErrorLength {
+				d.LedgerError = string(runes[:maxLedgerErrorLength])
+			}
+			if maxAttempts > 0 && d.LedgerAttempts >= maxAttempts {
+				d.LedgerStatus = LedgerStatusFailed
+			}
+		}
+		err = s.dbClient.DB.Model(&models.TestData{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+			"ledger_status":   d.LedgerStatus,
+			"ledger_attempts": d.LedgerAttempts,
+			"ledger_error":    d.LedgerError,
+		}).Error
+		if err != nil {
+			log.Printf("记录测试数据 %d 的上链结果失败: %v", d.ID, err)
+		}
+	}
+}
+
+// toBlockchainTestData 将数据库测试数据转换为链上测试数据结构
+func toBlockchainTestData(d *models.TestData) *models.BlockchainTestData {
+	return &models.BlockchainTestData{
+		CertNumber:        d.CertNumber,
+		DeviceAddr:        d.DeviceAddr,
+		DataType:          d.DataType,
+		PercentageValue:   d.PercentageValue,
+		RatioError:        d.RatioError,
+		AngleError:        d.AngleError,
+		CurrentValue:      d.CurrentValue,
+		VoltageValue:      d.VoltageValue,
+		WorkstationNumber: d.WorkstationNumber,
+		TestPoint:         d.TestPoint,
+		ActualPercentage:  d.ActualPercentage,
+		TestTimestamp:     d.TestTimestamp.UTC().Format(time.RFC3339),
+		RecordID:          d.ID,
+		Signature:         d.Signature,
+	}
+}
+
+// RetryPendingLedger 重新提交入库超过 minAge 仍未上链的测试数据，尝试 maxAttempts 次仍失败的标记为失败
+func (s *TestDataService) RetryPendingLedger(minAge time.Duration, maxAttempts int) error {
+	if s.ledger == nil {
+		return nil
+	}
+	var data []*models.TestData
+	err := s.dbClient.DB.Where("ledger_status = ? AND created_at <= ?", LedgerStatusPending, time.Now().Add(-minAge)).
+		Order("id").Limit(ledgerRetryBatchSize).Find(&data).Error
+	if err != nil || len(data) == 0 {
+		return err
+	}
+
+	certIDs := make([]int64, 0, len(data))
+	for _, d := range data {
+		certIDs = append(certIDs, d.CertID)
+	}
+	var certs []models.Certificate
+	if err := s.dbClient.DB.Select("id", "cert_number").Where("id IN ?", certIDs).Find(&certs).Error; err != nil {
+		return err
+	}
+	certNumbers := make(map[int64]string, len(certs))
+	for _, c := range certs {
+		certNumbers[c.ID] = c.CertNumber
+	}
+	for _, d := range data {
+		d.CertNumber = certNumbers[d.CertID]
+	}
+
+	s.submitToLedger(data, maxAttempts)
+	return nil
+}
+
+// StartLedgerRetry 在后台按 interval 重试待上链的测试数据，返回停止函数；账本未启用时不启动
+func (s *TestDataService) StartLedgerRetry(interval time.Duration, maxAttempts int) func() {
+	if s.ledger == nil {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.RetryPendingLedger(interval, maxAttempts); err != nil {
+					log.Printf("重试测试数据上链失败: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// prepareCertificateForTestData 在写入测试数据的事务中锁定证书：已签发或已撤销的证书不能再添加测试数据，
+// 已评定测量不确定度的证书标记评定结果过时，重新评定前不能签发
+func prepareCertificateForTestData(tx *gorm.DB, certID int64) error {
+	var cert models.Certificate
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status", "uncertainty_budget", "uncertainty_stale").
+		First(&cert, certID).Error; err != nil {
+		return err
+	}
+	if cert.Status == "issued" || cert.Status == "revoked" {
+		return fmt.Errorf("%w，不能添加测试数据", ErrCertificateLocked)
+	}
+	if cert.UncertaintyBudget != nil && !cert.UncertaintyStale {
+		return tx.Model(&models.Certificate{}).Where("id = ?", certID).Update("uncertainty_stale", true).Error
+	}
+	return nil
+}
+
+// IngestReading 校验并保存设备实时上报的单条读数（工位 WebSocket、设备 MQTT），
+// boundDevice 非空时读数必须来自该设备，未填写设备地址时使用 boundDevice
+func (s *TestDataService) IngestReading(cert *models.Certificate, boundDevice string, p *models.TestDataPointRequest, source string) (*models.TestData, error) {
+	if boundDevice != "" {
+		if p.DeviceAddr == "" {
+			p.DeviceAddr = boundDevice
+		}
+		if p.DeviceAddr != boundDevice {
+			return nil, fmt.Errorf("%w: 绑定设备 %s，不能提交设备 %s 的测试数据", ErrDeviceMismatch, boundDevice, p.DeviceAddr)
+		}
+	}
+
+	data, err := NewTestData(cert.ID, cert.CertNumber, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.AddTestData(data, source); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// IsRejectedReading 判断读数是否因内容或设备状态被拒绝，重试不会成功；其他错误（如数据库故障）可以重试
+func IsRejectedReading(err error) bool {
+	return errors.Is(err, ErrInvalidTestData) || errors.Is(err, ErrDeviceMismatch) ||
+		errors.Is(err, ErrDeviceNotFound) || errors.Is(err, ErrDeviceUnavailable) || errors.Is(err, ErrDeviceOutOfCalibration) ||
+		errors.Is(err, ErrInvalidSignature) || errors.Is(err, ErrCertificateLocked)
+}
+
+// GetTestDataSignature 用入库的读数、签名和签名时的公钥快照重新校验测试数据的设备签名
+func (s *TestDataService) GetTestDataSignature(id int64) (*models.TestDataSignature, error) {
+	var data models.TestData
+	if err := s.dbClient.DB.First(&data, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrTestDataNotFound, id)
+		}
+		return nil, err
+	}
+	var cert models.Certificate
+	if err := s.dbClient.DB.Select("cert_number").First(&cert, data.CertID).Error; err != nil {
+		return nil, err
+	}
+	data.CertNumber = cert.CertNumber
+
+	result := &models.TestDataSignature{
+		TestDataID:         data.ID,
+		CertNumber:         data.CertNumber,
+		DeviceAddr:         data.DeviceAddr,
+		Payload:            CanonicalTestDataPayload(&data),
+		Signature:          data.Signature,
+		SignatureAlgorithm: data.SignatureAlgorithm,
+		PublicKey:          data.SignerPublicKey,
+	}
+	if data.Signature == "" {
+		result.Message = "该测试数据未附带设备签名"
+		return result, nil
+	}
+	if err := VerifyDeviceSignature(data.SignatureAlgorithm, data.SignerPublicKey, result.Payload, data.Signature); err != nil {
+		result.Message = err.Error()
+		return result, nil
+	}
+	result.Verified = true
+	return result, nil
 }
 
 // GetTestDataByCertId 根据证书ID获取所有测试数据
@@ -48,4 +457,37 @@ func (s *TestDataService) GetTestDataByCertId(certId int64) ([]*models.TestData,
 		return nil, result.Error
 	}
 	return testData, nil
-}
\ No newline at end of file
+}
+
+// checkDevices 按设备检查本批测试数据，同一设备取最晚的测试时间校验校准有效期，
+// 通过后校验设备签名，并为每条数据记录设备的校准证书快照
+func (s *TestDataService) checkDevices(data []*models.TestData) error {
+	latest := make(map[string]time.Time)
+	var order []string
+	for _, d := range data {
+		t, seen := latest[d.DeviceAddr]
+		if !seen {
+			order = append(order, d.DeviceAddr)
+		}
+		if !seen || d.TestTimestamp.After(t) {
+			latest[d.DeviceAddr] = d.TestTimestamp
+		}
+	}
+
+	devices := make(map[string]*models.Device, len(order))
+	for _, addr := range order {
+		device, err := checkDeviceUsable(s.dbClient.DB, addr, latest[addr])
+		if err != nil {
+			return err
+		}
+		devices[addr] = device
+	}
+	for _, d := range data {
+		if err := verifyTestDataSignature(devices[d.DeviceAddr], d); err != nil {
+			return err
+		}
+		d.CalibrationCertNumber = devices[d.DeviceAddr].CalibrationCertNumber
+		d.CalibrationExternalRef = devices[d.DeviceAddr].CalibrationExternalRef
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeLedger 记录提交到账本的测试数据，failures 大于 0 时前若干次提交失败，为负数时始终失败
+type fakeLedger struct {
+	submitted []*models.BlockchainTestData
+	failures  int
+}
+
+func (l *fakeLedger) AddTestData(testData interface{}) error {
+	if l.failures != 0 {
+		if l.failures > 0 {
+			l.failures--
+		}
+		return errors.New("peer unavailable")
+	}
+	l.submitted = append(l.submitted, testData.(*models.BlockchainTestData))
+	return nil
+}
+
+// newTestDataFixture 创建草稿证书和在校准有效期内的设备 dev-std，返回接入 ledger 的测试数据服务
+func newTestDataFixture(t *testing.T, ledger testDataLedger) (*database.Client, *TestDataService, *models.Certificate) {
+	t.Helper()
+	client := newTestDB(t)
+	cert := createTestCertificate(t, client, "CT-L-001", "testing")
+	due := time.Now().AddDate(1, 0, 0)
+	device := &models.Device{DeviceAddr: "dev-std", Status: DeviceStatusActive, CalibrationExternalRef: "省计量院 JZ-0001", CalibrationDueDate: &due}
+	if err := client.DB.Create(device).Error; err != nil {
+		t.Fatalf("登记设备失败: %v", err)
+	}
+	s := NewTestDataService(client, nil, nil)
+	s.ledger = ledger
+	return client, s, cert
+}
+
+// newLedgerReading 构造 dev-std 在额定 100% 点的读数
+func newLedgerReading(cert *models.Certificate, deviceAddr string) *models.TestData {
+	return &models.TestData{
+		CertID:           cert.ID,
+		CertNumber:       cert.CertNumber,
+		DeviceAddr:       deviceAddr,
+		DataType:         TestDataTypeCurrent,
+		TestPoint:        "100%",
+		PercentageValue:  100,
+		ActualPercentage: 100.02,
+		RatioError:       0.05,
+		AngleError:       2,
+		CurrentValue:     5,
+		TestTimestamp:    time.Now().Add(-time.Minute).Truncate(time.Second),
+	}
+}
+
+// storedLedgerState 读取入库记录的上链状态
+func storedLedgerState(t *testing.T, client *database.Client, id int64) models.TestData {
+	t.Helper()
+	var stored models.TestData
+	if err := client.DB.First(&stored, id).Error; err != nil {
+		t.Fatalf("查询测试数据失败: %v", err)
+	}
+	return stored
+}
+
+func TestAddTestDataSubmitsToLedgerAfterCommit(t *testing.T) {
+	ledger := &fakeLedger{}
+	client, s, cert := newTestDataFixture(t, ledger)
+
+	data := newLedgerReading(cert, "dev-std")
+	if err := s.AddTestData(data, TestDataSourceBatch); err != nil {
+		t.Fatalf("添加测试数据失败: %v", err)
+	}
+	if len(ledger.submitted) != 1 || ledger.submitted[0].RecordID != data.ID || ledger.submitted[0].CertNumber != cert.CertNumber {
+		t.Fatalf("上链数据不符: %+v", ledger.submitted)
+	}
+	if stored := storedLedgerState(t, client, data.ID); stored.LedgerStatus != LedgerStatusConfirmed || stored.LedgerAttempts != 1 {
+		t.Fatalf("期望已上链，实际 %s（%d 次）", stored.LedgerStatus, stored.LedgerAttempts)
+	}
+}
+
+func TestAddTestDataLedgerRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		maxAttempts  int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{name: "重试后上链", failures: 1, maxAttempts: 3, wantStatus: LedgerStatusConfirmed, wantAttempts: 2},
+		{name: "尝试次数用尽", failures: -1, maxAttempts: 2, wantStatus: LedgerStatusFailed, wantAttempts: 2},
+		{name: "未用尽时保持待上链", failures: -1, maxAttempts: 3, wantStatus: LedgerStatusPending, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := &fakeLedger{failures: tt.failures}
+			client, s, cert := newTestDataFixture(t, ledger)
+
+			// 上链失败不影响入库，记录保持待上链状态
+			data := newLedgerReading(cert, "dev-std")
+			if err := s.AddTestData(data, TestDataSourceBatch); err != nil {
+				t.Fatalf("上链失败不应导致入库失败: %v", err)
+			}
+			stored := storedLedgerState(t, client, data.ID)
+			if stored.LedgerStatus != LedgerStatusPending || stored.LedgerError == "" {
+				t.Fatalf("期望待上链并记录失败原因，实际 %+v", stored)
+			}
+
+			if err := s.RetryPendingLedger(0, tt.maxAttempts); err != nil {
+				t.Fatalf("重试上链失败: %v", err)
+			}
+			stored = storedLedgerState(t, client, data.ID)
+			if stored.LedgerStatus != tt.wantStatus || stored.LedgerAttempts != tt.wantAttempts {
+				t.Fatalf("期望 %s（%d 次），实际 %s（%d 次）", tt.wantStatus, tt.wantAttempts, stored.LedgerStatus, stored.LedgerAttempts)
+			}
+			if tt.wantStatus == LedgerStatusConfirmed && ledger.submitted[0].CertNumber != cert.CertNumber {
+				t.Fatalf("重试时未填写证书编号: %+v", ledger.submitted[0])
+			}
+		})
+	}
+}
+
+func TestBatchAddTestDataValidatesBeforeLedger(t *testing.T) {
+	ledger := &fakeLedger{}
+	client, s, cert := newTestDataFixture(t, ledger)
+
+	// 第二条读数的设备未登记，整批拒绝且不触碰账本
+	batch := []*models.TestData{newLedgerReading(cert, "dev-std"), newLedgerReading(cert, "dev-missing")}
+	if err := s.BatchAddTestData(batch); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("期望 ErrDeviceNotFound，实际 %v", err)
+	}
+	var count int64
+	if err := client.DB.Model(&models.TestData{}).Count(&count).Error; err != nil {
+		t.Fatalf("统计测试数据失败: %v", err)
+	}
+	if count != 0 || len(ledger.submitted) != 0 {
+		t.Fatalf("整批拒绝时不应入库或上链: 入库 %d 条，上链 %d 条", count, len(ledger.submitted))
+	}
+
+	batch = []*models.TestData{newLedgerReading(cert, "dev-std"), newLedgerReading(cert, "dev-std")}
+	if err := s.BatchAddTestData(batch); err != nil {
+		t.Fatalf("批量添加失败: %v", err)
+	}
+	if len(ledger.submitted) != 2 || ledger.submitted[0].RecordID == ledger.submitted[1].RecordID {
+		t.Fatalf("期望按记录逐条上链: %+v", ledger.submitted)
+	}
+}
+
+func TestAddTestDataWithoutLedger(t *testing.T) {
+	client, s, cert := newTestDataFixture(t, nil)
+
+	data := newLedgerReading(cert, "dev-std")
+	if err := s.AddTestData(data, TestDataSourceBatch); err != nil {
+		t.Fatalf("添加测试数据失败: %v", err)
+	}
+	if stored := storedLedgerState(t, client, data.ID); stored.LedgerStatus != LedgerStatusNone {
+		t.Fatalf("账本未启用时期望 %s，实际 %s", LedgerStatusNone, stored.LedgerStatus)
+	}
+}
+
+func TestNewTestData(t *testing.T) {
+	timestamp := "2024-05-01T08:30:00.750+08:00"
+	current := func(modify func(p *models.TestDataPointRequest)) *models.TestDataPointRequest {
+		p := &models.TestDataPointRequest{DeviceAddr: "dev-std", DataType: TestDataTypeCurrent, TestPoint: "100%", PercentageValue: 100,
+			ActualPercentage: 100.02, RatioError: 0.05, AngleError: 2, CurrentValue: 5, TestTimestamp: timestamp}
+		if modify != nil {
+			modify(p)
+		}
+		return p
+	}
+	voltage := func(modify func(p *models.TestDataPointRequest)) *models.TestDataPointRequest {
+		p := &models.TestDataPointRequest{DeviceAddr: "dev-std", DataType: TestDataTypeVoltage, TestPoint: "80%", PercentageValue: 80,
+			ActualPercentage: 80.1, RatioError: 0.05, AngleError: 2, VoltageValue: 10, VoltageUnit: "kV", TestTimestamp: timestamp}
+		if modify != nil {
+			modify(p)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name        string
+		req         *models.TestDataPointRequest
+		wantErr     string
+		wantCurrent float64
+		wantVoltage float64
+	}{
+		{name: "电流互感器默认单位 A", req: current(nil), wantCurrent: 5},
+		{name: "电流单位 mA", req: current(func(p *models.TestDataPointRequest) { p.CurrentValue, p.CurrentUnit = 1500, "mA" }), wantCurrent: 1.5},
+		{name: "电流单位 kA 不区分大小写", req: current(func(p *models.TestDataPointRequest) { p.CurrentValue, p.CurrentUnit = 1.2, " KA " }), wantCurrent: 1200},
+		{name: "电压单位 kV", req: voltage(nil), wantVoltage: 10000},
+		{name: "不支持的电流单位", req: current(func(p *models.TestDataPointRequest) { p.CurrentUnit = "uA" }), wantErr: "不支持的电流单位"},
+		{name: "不支持的电压单位", req: voltage(func(p *models.TestDataPointRequest) { p.VoltageUnit = "mV" }), wantErr: "不支持的电压单位"},
+		{name: "数据类型无效", req: current(func(p *models.TestDataPointRequest) { p.DataType = "power" }), wantErr: "dataType 必须为"},
+		{name: "电流互感器缺少一次电流", req: current(func(p *models.TestDataPointRequest) { p.CurrentValue = 0 }), wantErr: "currentValue 必须大于 0"},
+		{name: "电流互感器填写了电压", req: current(func(p *models.TestDataPointRequest) { p.VoltageValue = 10 }), wantErr: "不能填写 voltageValue"},
+		{name: "电压互感器缺少一次电压", req: voltage(func(p *models.TestDataPointRequest) { p.VoltageValue = 0 }), wantErr: "voltageValue 必须大于 0"},
+		{name: "电压互感器填写了电流", req: voltage(func(p *models.TestDataPointRequest) { p.CurrentValue = 5 }), wantErr: "不能填写 currentValue"},
+		{name: "电流互感器 1% 点", req: current(func(p *models.TestDataPointRequest) { p.PercentageValue, p.ActualPercentage = 1, 1.01 }), wantCurrent: 5},
+		{name: "电压互感器额定百分点低于下限", req: voltage(func(p *models.TestDataPointRequest) { p.PercentageValue = 5 }), wantErr: "超出 20%~120% 范围"},
+		{name: "额定百分点超出上限", req: current(func(p *models.TestDataPointRequest) { p.PercentageValue = 150 }), wantErr: "超出 1%~120% 范围"},
+		{name: "未记录额定百分点", req: current(func(p *models.TestDataPointRequest) { p.PercentageValue = 0 }), wantCurrent: 5},
+		{name: "实测百分比无效", req: current(func(p *models.TestDataPointRequest) { p.ActualPercentage = 151 }), wantErr: "实测百分比"},
+		{name: "缺少测试点", req: current(func(p *models.TestDataPointRequest) { p.TestPoint = " " }), wantErr: "不能为空"},
+		{name: "工位号过长", req: current(func(p *models.TestDataPointRequest) { p.WorkstationNumber = "WS-0123456789-0123456" }), wantErr: "工位号"},
+		{name: "测试时间格式错误", req: current(func(p *models.TestDataPointRequest) { p.TestTimestamp = "2024-05-01 08:30:00" }), wantErr: "testTimestamp 格式错误"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := NewTestData(7, "CT-V-001", tt.req)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidTestData) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("期望 ErrInvalidTestData 且包含 %q，实际 %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("转换测试数据失败: %v", err)
+			}
+			if data.CertID != 7 || data.CertNumber != "CT-V-001" || data.DataType != tt.req.DataType {
+				t.Fatalf("证书或数据类型不符: %+v", data)
+			}
+			if data.CurrentValue != tt.wantCurrent || data.VoltageValue != tt.wantVoltage {
+				t.Fatalf("期望电流 %v A、电压 %v V，实际 %v A、%v V", tt.wantCurrent, tt.wantVoltage, data.CurrentValue, data.VoltageValue)
+			}
+			// 测试时间按数据库精度截断到秒
+			if want := time.Date(2024, 5, 1, 0, 30, 0, 0, time.UTC); !data.TestTimestamp.Equal(want) {
+				t.Fatalf("期望测试时间 %v，实际 %v", want, data.TestTimestamp)
+			}
+		})
+	}
+}
+
+func TestNewTestDataRoundsToColumnPrecision(t *testing.T) {
+	data, err := NewTestData(1, "CT-V-001", &models.TestDataPointRequest{DeviceAddr: "dev-std", DataType: TestDataTypeCurrent, TestPoint: "5%",
+		PercentageValue: 5, ActualPercentage: 5.01234567, RatioError: 0.12345678, AngleError: -1.23456789, CurrentValue: 0.25012345,
+		TestTimestamp: "2024-05-01T00:30:00Z"})
+	if err != nil {
+		t.Fatalf("转换测试数据失败: %v", err)
+	}
+	if data.ActualPercentage != 5.012346 || data.RatioError != 0.123457 || data.AngleError != -1.234568 || data.CurrentValue != 0.25 {
+		t.Fatalf("数值未按数据库精度取整: %+v", data)
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// initSQLPath 生产库建表脚本，测试库按其中的 ENUM 定义和唯一约束限制取值
+const initSQLPath = "../../../database/init.sql"
+
+var (
+	createTablePattern  = regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS (\w+) \((.*?)\n\);`)
+	enumColumnPattern   = regexp.MustCompile(`(?m)^\s*(\w+)\s+ENUM\(([^)]*)\)`)
+	uniqueColumnPattern = regexp.MustCompile(`(?m)^\s*(\w+)\s+[^,\n]*\bUNIQUE\b`)
+	uniqueKeyPattern    = regexp.MustCompile(`(?m)^\s*UNIQUE KEY (\w+) \(([^)]*)\)`)
+)
+
+// newTestDB 打开内存 SQLite 测试库并按模型建表。init.sql 中的 ENUM 列以触发器约束取值，
+// 与 MySQL 严格模式下写入非法枚举值失败、整个事务回滚的行为一致；唯一约束同样建立唯一索引
+func newTestDB(t *testing.T) *database.Client {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&models.User{}, &models.PasswordHistory{}, &models.AuthSession{}, &models.RefreshToken{},
+		&models.RecoveryCode{}, &models.LoginChallenge{}, &models.SSOLoginState{}, &models.LoginAttempt{},
+		&models.Device{}, &models.APIKey{}, &models.Customer{}, &models.Certificate{}, &models.TestData{},
+		&models.CertificateHistory{}, &models.BlockchainTransaction{}, &models.IdempotencyRecord{})
+	if err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	enforceSchemaConstraints(t, db)
+	return &database.Client{DB: db}
+}
+
+// enforceSchemaConstraints 为 init.sql 中声明为 ENUM 的列创建插入和更新触发器，取值不在枚举内时中止写入；
+// 为声明了 UNIQUE 的列和 UNIQUE KEY 创建唯一索引
+func enforceSchemaConstraints(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	schema, err := os.ReadFile(initSQLPath)
+	if err != nil {
+		t.Fatalf("读取建表脚本失败: %v", err)
+	}
+	for _, table := range createTablePattern.FindAllStringSubmatch(string(schema), -1) {
+		name, body := table[1], table[2]
+		if !db.Migrator().HasTable(name) {
+			continue
+		}
+		for _, column := range enumColumnPattern.FindAllStringSubmatch(body, -1) {
+			col, values := column[1], column[2]
+			for _, event := range []string{"INSERT", "UPDATE"} {
+				trigger := fmt.Sprintf(`CREATE TRIGGER enum_%s_%s_%s BEFORE %s ON %s
+WHEN NEW.%s IS NOT NULL AND NEW.%s NOT IN (%s)
+BEGIN SELECT RAISE(ABORT, 'Data truncated for column ''%s'''); END`,
+					name, col, strings.ToLower(event), event, name, col, col, values, col)
+				if err := db.Exec(trigger).Error; err != nil {
+					t.Fatalf("创建 %s.%s 枚举约束失败: %v", name, col, err)
+				}
+			}
+		}
+		for _, column := range uniqueColumnPattern.FindAllStringSubmatch(body, -1) {
+			col := column[1]
+			if !db.Migrator().HasColumn(name, col) {
+				continue
+			}
+			if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS uk_%s_%s ON %s (%s)", name, col, name, col)).Error; err != nil {
+				t.Fatalf("创建 %s.%s 唯一约束失败: %v", name, col, err)
+			}
+		}
+		for _, key := range uniqueKeyPattern.FindAllStringSubmatch(body, -1) {
+			if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)", key[1], name, key[2])).Error; err != nil {
+				t.Fatalf("创建 %s 唯一约束 %s 失败: %v", name, key[1], err)
+			}
+		}
+	}
+}
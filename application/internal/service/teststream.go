@@ -0,0 +1,72 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// testDataSubscriberBuffer 每个订阅者缓存的事件数，读取过慢的订阅者会丢弃超出部分
+const testDataSubscriberBuffer = 64
+
+// TestDataHub 按证书编号分发新入库测试数据的进程内广播中心，供实时查看测试进度的客户端订阅
+type TestDataHub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan *models.TestDataEvent]struct{}
+}
+
+// NewTestDataHub 创建新的 TestDataHub
+func NewTestDataHub() *TestDataHub {
+	return &TestDataHub{
+		subscribers: make(map[string]map[chan *models.TestDataEvent]struct{}),
+	}
+}
+
+// Subscribe 订阅证书的测试数据事件，返回事件通道和取消订阅函数，取消后通道被关闭
+func (h *TestDataHub) Subscribe(certNumber string) (<-chan *models.TestDataEvent, func()) {
+	ch := make(chan *models.TestDataEvent, testDataSubscriberBuffer)
+
+	h.mu.Lock()
+	if h.subscribers[certNumber] == nil {
+		h.subscribers[certNumber] = make(map[chan *models.TestDataEvent]struct{})
+	}
+	h.subscribers[certNumber][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[certNumber], ch)
+			if len(h.subscribers[certNumber]) == 0 {
+				delete(h.subscribers, certNumber)
+			}
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish 向证书的全部订阅者广播已入库的测试数据，不阻塞写入方
+func (h *TestDataHub) Publish(certNumber string, source string, data []*models.TestData) {
+	if h == nil || certNumber == "" || len(data) == 0 {
+		return
+	}
+	event := &models.TestDataEvent{
+		CertNumber: certNumber,
+		Source:     source,
+		Data:       data,
+		Timestamp:  time.Now(),
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subscribers[certNumber] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("证书 %s 的测试数据订阅者读取过慢，丢弃 %d 条实时数据", certNumber, len(data))
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"cert-system/internal/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxTraceabilityDepth 溯源链的最大层数，防止异常数据导致无限追溯
+const maxTraceabilityDepth = 10
+
+// ErrTraceabilityIncomplete 溯源链不完整或存在无效环节，不能锚定到账本
+var ErrTraceabilityIncomplete = errors.New("溯源链不完整")
+
+// traceabilityUsage 证书测试数据中某台设备及其当时校准证书的使用记录
+type traceabilityUsage struct {
+	DeviceAddr             string
+	CalibrationCertNumber  *string
+	CalibrationExternalRef string
+	FirstUsedAt            time.Time
+	LastUsedAt             time.Time
+}
+
+// traceabilityWalker 一次溯源遍历的状态
+type traceabilityWalker struct {
+	db      *gorm.DB
+	service *CertificateService
+	issues  []string
+}
+
+// GetCertificateTraceability 从证书出发，经测试数据所用的检测设备追溯到设备的校准证书，
+// 本系统签发的上级证书继续向上追溯，外部证书作为溯源链终点。
+// 账本可用时同时校验各证书是否已上链，并与账本上的锚定记录比对
+func (s *CertificateService) GetCertificateTraceability(certNumber string) (*models.CertificateTraceability, error) {
+	cert, err := s.GetCertificateByNumber(certNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	walker := &traceabilityWalker{db: s.dbClient.DB, service: s}
+	root, chainHash, err := walker.walk(cert, map[string]bool{}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	trace := &models.CertificateTraceability{
+		Root:        root,
+		Issues:      walker.issues,
+		ChainHash:   chainHash,
+		GeneratedAt: time.Now(),
+	}
+	if trace.Issues == nil {
+		trace.Issues = []string{}
+	}
+	trace.Complete = len(trace.Issues) == 0
+
+	anchor, err := s.getTraceabilityAnchor(certNumber)
+	if err != nil {
+		log.Printf("查询证书 %s 溯源锚定记录失败: %v", certNumber, err)
+	}
+	trace.Anchor = anchor
+	trace.AnchorValid = anchor != nil && anchor.ChainHash == chainHash
+	return trace, nil
+}
+
+// AnchorCertificateTraceability 将证书当前的溯源链哈希及直接上级证书锚定到账本，
+// 溯源链不完整时返回 ErrTraceabilityIncomplete
+func (s *CertificateService) AnchorCertificateTraceability(certNumber string) (*models.TraceabilityAnchor, error) {
+	if s.fabricClient == nil {
+		return nil, ErrLedgerUnavailable
+	}
+
+	trace, err := s.GetCertificateTraceability(certNumber)
+	if err != nil {
+		return nil, err
+	}
+	if !trace.Complete {
+		return nil, fmt.Errorf("%w: %s", ErrTraceabilityIncomplete, strings.Join(trace.Issues, "；"))
+	}
+
+	anchor := &models.TraceabilityAnchor{
+		CertNumber: trace.Root.CertNumber,
+		ChainHash:  trace.ChainHash,
+		Upstream:   make([]*models.TraceabilityLink, 0, len(trace.Root.Devices)),
+	}
+	for _, device := range trace.Root.Devices {
+		anchor.Upstream = append(anchor.Upstream, &models.TraceabilityLink{
+			DeviceAddr:             device.DeviceAddr,
+			CalibrationCertNumber:  device.CalibrationCertNumber,
+			CalibrationExternalRef: device.CalibrationExternalRef,
+		})
+	}
+
+	txID, err := s.fabricClient.AnchorTraceability(anchor)
+	if err != nil {
+		return nil, fmt.Errorf("溯源链上链失败: %w", err)
+	}
+	anchor.TxID = txID
+	anchor.AnchoredAt = time.Now().UTC().Format(time.RFC3339)
+	return anchor, nil
+}
+
+// getTraceabilityAnchor 从账本查询证书的溯源锚定记录，未配置Fabric客户端或未锚定时返回空
+func (s *CertificateService) getTraceabilityAnchor(certNumber string) (*models.TraceabilityAnchor, error) {
+	if s.fabricClient == nil {
+		return nil, nil
+	}
+
+	payload, err := s.fabricClient.GetTraceabilityAnchor(certNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 || string(payload) == "null" {
+		return nil, nil
+	}
+
+	var anchor models.TraceabilityAnchor
+	if err := json.Unmarshal(payload, &anchor); err != nil {
+		return nil, fmt.Errorf("解析溯源锚定记录失败: %w", err)
+	}
+	return &anchor, nil
+}
+
+// walk 构建证书的溯源节点并返回节点哈希。节点哈希覆盖证书的区块链哈希以及所用设备、
+// 校准证书和上级节点哈希，任一环节变化都会导致根节点的溯源链哈希变化
+func (w *traceabilityWalker) walk(cert *models.Certificate, path map[string]bool, depth int) (*models.TraceabilityNode, string, error) {
+	node := &models.TraceabilityNode{
+		CertNumber:     cert.CertNumber,
+		InstrumentName: cert.InstrumentName,
+		Status:         cert.Status,
+		TestDate:       cert.TestDate,
+		ExpireDate:     cert.ExpireDate,
+		BlockchainTxID: cert.BlockchainTxID,
+		BlockchainHash: cert.BlockchainHash,
+		IsHashValid:    certificateHash(cert) == cert.BlockchainHash,
+		Devices:        []*models.TraceabilityDevice{},
+	}
+	if !node.IsHashValid {
+		w.addIssue("证书 %s 的数据与区块链哈希不一致", cert.CertNumber)
+	}
+	if w.service.fabricClient != nil {
+		_, err := w.service.fabricClient.GetCertificate(cert.CertNumber)
+		onLedger := err == nil
+		node.OnLedger = &onLedger
+		if !onLedger {
+			w.addIssue("证书 %s 未在账本上找到", cert.CertNumber)
+		}
+	}
+
+	usages, err := w.loadUsages(cert.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(usages) == 0 {
+		w.addIssue("证书 %s 没有测试数据，无法追溯所用的测量标准", cert.CertNumber)
+	}
+
+	path[cert.CertNumber] = true
+	defer delete(path, cert.CertNumber)
+
+	var parts []string
+	parts = append(parts, cert.CertNumber, cert.BlockchainHash)
+	for _, usage := range usages {
+		device, upstreamHash, err := w.walkDevice(usage, path, depth)
+		if err != nil {
+			return nil, "", err
+		}
+		node.Devices = append(node.Devices, device)
+		parts = append(parts, device.DeviceAddr, device.CalibrationCertNumber, device.CalibrationExternalRef, upstreamHash)
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return node, hex.EncodeToString(hash[:]), nil
+}
+
+// loadUsages 按设备及当时的校准证书汇总证书测试数据的使用时间，按设备地址和首次使用时间排序。
+// 在应用侧汇总而不是使用 MIN/MAX 聚合，避免不同数据库驱动对聚合结果的时间类型处理不一致
+func (w *traceabilityWalker) loadUsages(certID int64) ([]*traceabilityUsage, error) {
+	var readings []*models.TestData
+	err := w.db.Select("device_addr, calibration_cert_number, calibration_external_ref, test_timestamp").
+		Where("cert_id = ?", certID).
+		Order("device_addr ASC, test_timestamp ASC").
+		Find(&readings).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var usages []*traceabilityUsage
+	index := make(map[string]*traceabilityUsage)
+	for _, reading := range readings {
+		calibrationCertNumber := ""
+		if reading.CalibrationCertNumber != nil {
+			calibrationCertNumber = *reading.CalibrationCertNumber
+		}
+		key := strings.Join([]string{reading.DeviceAddr, calibrationCertNumber, reading.CalibrationExternalRef}, "|")
+		usage, ok := index[key]
+		if !ok {
+			usage = &traceabilityUsage{
+				DeviceAddr:             reading.DeviceAddr,
+				CalibrationCertNumber:  reading.CalibrationCertNumber,
+				CalibrationExternalRef: reading.CalibrationExternalRef,
+				FirstUsedAt:            reading.TestTimestamp,
+			}
+			index[key] = usage
+			usages = append(usages, usage)
+		}
+		// 读数按时间升序，最后一条即为最后使用时间
+		usage.LastUsedAt = reading.TestTimestamp
+	}
+	return usages, nil
+}
+
+// walkDevice 构建设备节点，本系统签发的校准证书继续向上追溯，并检查使用期间校准证书是否有效
+func (w *traceabilityWalker) walkDevice(usage *traceabilityUsage, path map[string]bool, depth int) (*models.TraceabilityDevice, string, error) {
+	device := &models.TraceabilityDevice{
+		DeviceAddr:             usage.DeviceAddr,
+		CalibrationExternalRef: usage.CalibrationExternalRef,
+		FirstUsedAt:            usage.FirstUsedAt,
+		LastUsedAt:             usage.LastUsedAt,
+	}
+	if usage.CalibrationCertNumber != nil {
+		device.CalibrationCertNumber = *usage.CalibrationCertNumber
+	}
+
+	var registered models.Device
+	err := w.db.Where("device_addr = ?", usage.DeviceAddr).First(&registered).Error
+	switch {
+	case err == nil:
+		device.DeviceName = registered.DeviceName
+		device.AccuracyClass = registered.AccuracyClass
+		// 早期测试数据未记录校准证书快照，使用设备当前登记的校准证书
+		if device.CalibrationCertNumber == "" && device.CalibrationExternalRef == "" {
+			if registered.CalibrationCertNumber != nil {
+				device.CalibrationCertNumber = *registered.CalibrationCertNumber
+			}
+			device.CalibrationExternalRef = registered.CalibrationExternalRef
+			if device.CalibrationCertNumber != "" || device.CalibrationExternalRef != "" {
+				w.addIssue("设备 %s 的测试数据未记录当时的校准证书，已按设备当前登记信息追溯", usage.DeviceAddr)
+			}
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		w.addIssue("设备 %s 未登记", usage.DeviceAddr)
+	default:
+		return nil, "", err
+	}
+
+	if device.CalibrationCertNumber == "" {
+		if device.CalibrationExternalRef == "" {
+			w.addIssue("设备 %s 没有校准证书，溯源链中断", usage.DeviceAddr)
+		}
+		return device, "", nil
+	}
+
+	certNumber := device.CalibrationCertNumber
+	if path[certNumber] {
+		w.addIssue("设备 %s 的校准证书 %s 形成循环引用", usage.DeviceAddr, certNumber)
+		return device, "", nil
+	}
+	if depth+1 >= maxTraceabilityDepth {
+		w.addIssue("溯源链超过 %d 层，证书 %s 未继续追溯", maxTraceabilityDepth, certNumber)
+		return device, "", nil
+	}
+
+	var upstream models.Certificate
+	if err := w.db.Where("cert_number = ?", certNumber).First(&upstream).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			w.addIssue("设备 %s 的校准证书 %s 不存在", usage.DeviceAddr, certNumber)
+			return device, "", nil
+		}
+		return nil, "", err
+	}
+	if defect := calibrationCertDefect(&upstream); defect != "" {
+		w.addIssue("设备 %s 的校准证书 %s %s", usage.DeviceAddr, certNumber, defect)
+	}
+	if !usage.LastUsedAt.Before(upstream.ExpireDate.AddDate(0, 0, 1)) {
+		w.addIssue("设备 %s 在 %s 使用时校准证书 %s 已过期（有效期至 %s）", usage.DeviceAddr,
+			usage.LastUsedAt.Format("2006-01-02"), certNumber, upstream.ExpireDate.Format("2006-01-02"))
+	}
+
+	upstreamNode, upstreamHash, err := w.walk(&upstream, path, depth+1)
+	if err != nil {
+		return nil, "", err
+	}
+	device.Upstream = upstreamNode
+	return device, upstreamHash, nil
+}
+
+// addIssue 记录溯源链中的问题
+func (w *traceabilityWalker) addIssue(format string, args ...interface{}) {
+	w.issues = append(w.issues, fmt.Sprintf(format, args...))
+}
+
+// certificateHash 按创建证书时的规则计算证书数据哈希，已评定测量不确定度的证书追加评定结果的哈希
+func certificateHash(cert *models.Certificate) string {
+	hashData := fmt.Sprintf("%s|%d|%s|%s|%s",
+		cert.CertNumber,
+		cert.CustomerID,
+		cert.InstrumentName,
+		cert.TestDate.Format("2006-01-02"),
+		cert.TestResult)
+	if cert.UncertaintyBudget != nil {
+		hashData += "|" + uncertaintyBudgetHash(cert.UncertaintyBudget)
+	}
+	hash := sha256.Sum256([]byte(hashData))
+	return hex.EncodeToString(hash[:])
+}
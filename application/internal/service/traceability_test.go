@@ -0,0 +1,249 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sealTestCertificate 按证书当前数据写入区块链哈希，模拟已上链的证书
+func sealTestCertificate(t *testing.T, client *database.Client, cert *models.Certificate) {
+	t.Helper()
+	cert.BlockchainHash = certificateHash(cert)
+	if err := client.DB.Model(cert).Update("blockchain_hash", cert.BlockchainHash).Error; err != nil {
+		t.Fatalf("写入区块链哈希失败: %v", err)
+	}
+}
+
+// addTraceabilityReading 写入设备对证书的一条读数，calibrationCertNumber 和 externalRef 为读数记录的校准证书快照
+func addTraceabilityReading(t *testing.T, client *database.Client, cert *models.Certificate, deviceAddr, calibrationCertNumber, externalRef string, testTime time.Time) {
+	t.Helper()
+	data := newLedgerReading(cert, deviceAddr)
+	data.TestTimestamp = testTime
+	data.CalibrationExternalRef = externalRef
+	if calibrationCertNumber != "" {
+		data.CalibrationCertNumber = &calibrationCertNumber
+	}
+	if err := client.DB.Create(data).Error; err != nil {
+		t.Fatalf("写入测试数据失败: %v", err)
+	}
+}
+
+// registerTraceabilityDevice 登记设备及其当前校准证书
+func registerTraceabilityDevice(t *testing.T, client *database.Client, deviceAddr, calibrationCertNumber, externalRef string) {
+	t.Helper()
+	device := &models.Device{DeviceAddr: deviceAddr, Status: DeviceStatusActive, CalibrationExternalRef: externalRef, CalibrationDueDate: dateOffset(365)}
+	if calibrationCertNumber != "" {
+		device.CalibrationCertNumber = &calibrationCertNumber
+	}
+	if err := client.DB.Create(device).Error; err != nil {
+		t.Fatalf("登记设备失败: %v", err)
+	}
+}
+
+func TestGetCertificateTraceability(t *testing.T) {
+	usedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name string
+		// prepare 在证书 CT-T-001 及上级证书 CAL-T-001 创建后准备设备和测试数据
+		prepare    func(t *testing.T, client *database.Client, cert, upstream *models.Certificate)
+		wantIssue  string
+		wantDepth  int
+		wantDevice string
+	}{
+		{
+			name: "外部校准证书作为终点",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "", "省计量院 JZ-0001")
+				addTraceabilityReading(t, client, cert, "dev-std", "", "省计量院 JZ-0001", usedAt)
+			},
+			wantDepth:  1,
+			wantDevice: "dev-std",
+		},
+		{
+			name: "逐级追溯到本系统上级证书",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "CAL-T-001", "")
+				registerTraceabilityDevice(t, client, "dev-ref", "", "国家计量院 GJ-0001")
+				addTraceabilityReading(t, client, cert, "dev-std", "CAL-T-001", "", usedAt)
+				addTraceabilityReading(t, client, upstream, "dev-ref", "", "国家计量院 GJ-0001", usedAt)
+			},
+			wantDepth:  2,
+			wantDevice: "dev-std",
+		},
+		{
+			name:      "没有测试数据",
+			prepare:   func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {},
+			wantIssue: "没有测试数据",
+			wantDepth: 1,
+		},
+		{
+			name: "设备未登记",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				addTraceabilityReading(t, client, cert, "dev-unknown", "", "省计量院 JZ-0001", usedAt)
+			},
+			wantIssue:  "未登记",
+			wantDepth:  1,
+			wantDevice: "dev-unknown",
+		},
+		{
+			name: "设备没有校准证书",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "", "")
+				addTraceabilityReading(t, client, cert, "dev-std", "", "", usedAt)
+			},
+			wantIssue:  "溯源链中断",
+			wantDepth:  1,
+			wantDevice: "dev-std",
+		},
+		{
+			name: "测试数据未记录校准证书快照",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "", "省计量院 JZ-0001")
+				addTraceabilityReading(t, client, cert, "dev-std", "", "", usedAt)
+			},
+			wantIssue:  "已按设备当前登记信息追溯",
+			wantDepth:  1,
+			wantDevice: "dev-std",
+		},
+		{
+			name: "使用时上级证书已过期",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "CAL-T-001", "")
+				registerTraceabilityDevice(t, client, "dev-ref", "", "国家计量院 GJ-0001")
+				addTraceabilityReading(t, client, cert, "dev-std", "CAL-T-001", "", upstream.ExpireDate.AddDate(0, 0, 2))
+				addTraceabilityReading(t, client, upstream, "dev-ref", "", "国家计量院 GJ-0001", usedAt)
+			},
+			wantIssue:  "已过期",
+			wantDepth:  2,
+			wantDevice: "dev-std",
+		},
+		{
+			name: "上级证书已撤销",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "CAL-T-001", "")
+				registerTraceabilityDevice(t, client, "dev-ref", "", "国家计量院 GJ-0001")
+				addTraceabilityReading(t, client, cert, "dev-std", "CAL-T-001", "", usedAt)
+				addTraceabilityReading(t, client, upstream, "dev-ref", "", "国家计量院 GJ-0001", usedAt)
+				if err := client.DB.Model(upstream).Update("status", "revoked").Error; err != nil {
+					t.Fatalf("撤销上级证书失败: %v", err)
+				}
+			},
+			wantIssue:  "CAL-T-001",
+			wantDepth:  2,
+			wantDevice: "dev-std",
+		},
+		{
+			name: "证书数据被篡改",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "", "省计量院 JZ-0001")
+				addTraceabilityReading(t, client, cert, "dev-std", "", "省计量院 JZ-0001", usedAt)
+				if err := client.DB.Model(cert).Update("instrument_name", "电压互感器").Error; err != nil {
+					t.Fatalf("修改证书失败: %v", err)
+				}
+			},
+			wantIssue:  "区块链哈希不一致",
+			wantDepth:  1,
+			wantDevice: "dev-std",
+		},
+		{
+			name: "校准证书循环引用",
+			prepare: func(t *testing.T, client *database.Client, cert, upstream *models.Certificate) {
+				registerTraceabilityDevice(t, client, "dev-std", "CAL-T-001", "")
+				registerTraceabilityDevice(t, client, "dev-ref", "CT-T-001", "")
+				addTraceabilityReading(t, client, cert, "dev-std", "CAL-T-001", "", usedAt)
+				addTraceabilityReading(t, client, upstream, "dev-ref", "CT-T-001", "", usedAt)
+			},
+			wantIssue:  "循环引用",
+			wantDepth:  2,
+			wantDevice: "dev-std",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := newTestCertificateService(client)
+			cert := createTestCertificate(t, client, "CT-T-001", "issued")
+			upstream := createTestCertificate(t, client, "CAL-T-001", "issued")
+			sealTestCertificate(t, client, cert)
+			sealTestCertificate(t, client, upstream)
+			tt.prepare(t, client, cert, upstream)
+
+			trace, err := s.GetCertificateTraceability("CT-T-001")
+			if err != nil {
+				t.Fatalf("查询溯源链失败: %v", err)
+			}
+			issues := strings.Join(trace.Issues, "；")
+			if tt.wantIssue == "" {
+				if !trace.Complete || len(trace.Issues) != 0 {
+					t.Fatalf("期望溯源链完整，实际问题: %s", issues)
+				}
+			} else if trace.Complete || !strings.Contains(issues, tt.wantIssue) {
+				t.Fatalf("期望问题包含 %q，实际 complete=%v 问题: %s", tt.wantIssue, trace.Complete, issues)
+			}
+			if len(trace.ChainHash) != 64 || trace.Anchor != nil || trace.AnchorValid {
+				t.Fatalf("未启用账本时溯源链哈希或锚定状态不符: %+v", trace)
+			}
+
+			depth := 0
+			for node := trace.Root; node != nil; depth++ {
+				var next *models.TraceabilityNode
+				for _, device := range node.Devices {
+					if device.Upstream != nil {
+						next = device.Upstream
+					}
+				}
+				node = next
+			}
+			if depth != tt.wantDepth {
+				t.Fatalf("期望溯源 %d 层，实际 %d 层", tt.wantDepth, depth)
+			}
+			if tt.wantDevice != "" && (len(trace.Root.Devices) != 1 || trace.Root.Devices[0].DeviceAddr != tt.wantDevice) {
+				t.Fatalf("期望根证书使用设备 %s，实际 %+v", tt.wantDevice, trace.Root.Devices)
+			}
+		})
+	}
+}
+
+func TestTraceabilityChainHash(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestCertificateService(client)
+	cert := createTestCertificate(t, client, "CT-T-001", "issued")
+	upstream := createTestCertificate(t, client, "CAL-T-001", "issued")
+	sealTestCertificate(t, client, cert)
+	sealTestCertificate(t, client, upstream)
+	registerTraceabilityDevice(t, client, "dev-std", "CAL-T-001", "")
+	registerTraceabilityDevice(t, client, "dev-ref", "", "国家计量院 GJ-0001")
+	usedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	addTraceabilityReading(t, client, cert, "dev-std", "CAL-T-001", "", usedAt)
+	addTraceabilityReading(t, client, upstream, "dev-ref", "", "国家计量院 GJ-0001", usedAt)
+
+	first, err := s.GetCertificateTraceability("CT-T-001")
+	if err != nil {
+		t.Fatalf("查询溯源链失败: %v", err)
+	}
+	second, _ := s.GetCertificateTraceability("CT-T-001")
+	if first.ChainHash != second.ChainHash {
+		t.Fatal("溯源链未变化时哈希应保持一致")
+	}
+
+	// 上级证书的溯源环节变化也会改变根证书的溯源链哈希
+	addTraceabilityReading(t, client, upstream, "dev-ref", "", "国家计量院 GJ-0002", usedAt)
+	changed, err := s.GetCertificateTraceability("CT-T-001")
+	if err != nil {
+		t.Fatalf("查询溯源链失败: %v", err)
+	}
+	if changed.ChainHash == first.ChainHash {
+		t.Fatal("上级证书的溯源环节变化后根证书的溯源链哈希应变化")
+	}
+
+	if _, err := s.GetCertificateTraceability("CT-404"); !errors.Is(err, database.ErrRecordNotFound) {
+		t.Fatalf("期望 ErrRecordNotFound，实际 %v", err)
+	}
+	if _, err := s.AnchorCertificateTraceability("CT-T-001"); !errors.Is(err, ErrLedgerUnavailable) {
+		t.Fatalf("未启用账本时期望 ErrLedgerUnavailable，实际 %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package service
+
+import (
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"crypto/rand"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount 启用两步验证时生成的恢复码数量
+const recoveryCodeCount = 10
+
+// maxChallengeAttempts 每个登录挑战允许提交验证码的次数
+const maxChallengeAttempts = 5
+
+// ErrInvalidChallenge 挑战令牌不存在或已过期
+var ErrInvalidChallenge = errors.New("登录验证已过期，请重新输入用户名和密码")
+
+// ErrInvalidTwoFactorCode 两步验证码或恢复码错误
+var ErrInvalidTwoFactorCode = errors.New("验证码错误")
+
+// ErrTwoFactorAlreadyEnabled 两步验证已启用
+var ErrTwoFactorAlreadyEnabled = errors.New("两步验证已启用")
+
+// ErrTwoFactorNotEnrolled 尚未开始启用两步验证
+var ErrTwoFactorNotEnrolled = errors.New("请先获取两步验证密钥")
+
+// ErrTwoFactorRequired 当前角色必须启用两步验证，不能关闭
+var ErrTwoFactorRequired = errors.New("当前角色必须启用两步验证，不能关闭")
+
+// twoFactorRequiredFor 判断角色是否必须启用两步验证
+func (s *AuthService) twoFactorRequiredFor(role string) bool {
+	for _, r := range s.twoFactor.RequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// startTwoFactorChallenge 密码校验通过后创建登录挑战，客户端需携带挑战令牌提交验证码
+func (s *AuthService) startTwoFactorChallenge(user *models.User, ip, userAgent string) (*models.LoginResponse, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	now := time.Now()
+	challenge := &models.LoginChallenge{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		IP:        ip,
+		UserAgent: userAgent,
+		ExpiresAt: now.Add(s.twoFactor.ChallengeTTLDuration()),
+		CreatedAt: now,
+	}
+	if err := s.dbClient.DB.Create(challenge).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.LoginResponse{
+		Code:    200,
+		Message: "请输入两步验证码",
+		Data: models.LoginData{
+			UserID:            user.ID,
+			Username:          user.Username,
+			TwoFactorRequired: true,
+			ChallengeToken:    token,
+			ExpiresAt:         challenge.ExpiresAt.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+// LoginTwoFactor 登录第二步：校验挑战令牌和验证码（或恢复码），通过后创建会话
+func (s *AuthService) LoginTwoFactor(challengeToken, code, ip, userAgent string) (*models.LoginResponse, error) {
+	now := time.Now()
+
+	var challenge models.LoginChallenge
+	err := s.dbClient.DB.Where("token_hash = ?", hashToken(challengeToken)).First(&challenge).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	if now.After(challenge.ExpiresAt) || challenge.Attempts >= maxChallengeAttempts {
+		s.dbClient.DB.Delete(&challenge)
+		return nil, ErrInvalidChallenge
+	}
+
+	var user models.User
+	if err := s.dbClient.DB.First(&user, challenge.UserID).Error; err != nil {
+		return nil, err
+	}
+	if reason, err := s.protection.CheckUser(&user, now); err != nil {
+		s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, false, reason)
+		return nil, err
+	}
+	if user.Status == "disabled" {
+		s.dbClient.DB.Delete(&challenge)
+		return nil, ErrAccountDisabled
+	}
+
+	ok, err := s.verifySecondFactor(&user, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.dbClient.DB.Model(&challenge).Update("attempts", gorm.Expr("attempts + 1"))
+		if err := s.protection.RecordUserFailure(user.ID, now); err != nil {
+			log.Printf("记录登录失败次数失败: %v", err)
+		}
+		s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, false, loginReasonBadSecondFactor)
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := s.dbClient.DB.Delete(&challenge).Error; err != nil {
+		return nil, err
+	}
+	resp, err := s.startSession(&user, ip, userAgent)
+	if err != nil {
+		log.Printf("生成JWT令牌失败: %v", err)
+		return nil, errors.New("登录失败，请稍后再试")
+	}
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := s.protection.ResetUser(user.ID); err != nil {
+			log.Printf("重置登录失败次数失败: %v", err)
+		}
+	}
+	s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, true, "")
+	return resp, nil
+}
+
+// verifySecondFactor 校验 TOTP 验证码或恢复码。验证码同一时间步只能使用一次，恢复码使用后作废
+func (s *AuthService) verifySecondFactor(user *models.User, code string, now time.Time) (bool, error) {
+	code = strings.TrimSpace(code)
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	if counter, ok := helper.ValidateTOTP(*user.TOTPSecret, code, now, 1); ok {
+		result := s.dbClient.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+			Update("totp_last_counter", counter)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		// 已使用过的验证码视为无效，防止重放
+		return result.RowsAffected == 1, nil
+	}
+
+	result := s.dbClient.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// EnrollTwoFactor 生成新的 TOTP 密钥（待确认），返回密钥和扫码 URI
+func (s *AuthService) EnrollTwoFactor(userID int64) (*models.TwoFactorEnrollment, error) {
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = s.dbClient.DB.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":       secret,
+		"totp_last_counter": 0,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: helper.TOTPProvisioningURI(s.twoFactor.Issuer, user.Username, secret),
+	}, nil
+}
+
+// ActivateTwoFactor 使用验证码确认启用两步验证，生成恢复码并开启新会话（旧会话全部注销）
+func (s *AuthService) ActivateTwoFactor(userID int64, code, ip, userAgent string) (*models.LoginResponse, error) {
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	counter, ok := helper.ValidateTOTP(*user.TOTPSecret, strings.TrimSpace(code), time.Now(), 1)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	err := s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"totp_enabled":      true,
+			"totp_last_counter": counter,
+		}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, revokeReasonTwoFactorChanged)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.startSession(&user, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	resp.Message = "两步验证已启用，请妥善保存恢复码"
+	resp.Data.RecoveryCodes = codes
+	return resp, nil
+}
+
+// DisableTwoFactor 关闭两步验证，需要当前密码和验证码。角色要求启用时不允许关闭。
+// 密码或验证码错误计入账号的连续失败次数；关闭后注销全部旧会话并开启新会话
+func (s *AuthService) DisableTwoFactor(userID int64, password, code, ip, userAgent string) (*models.LoginResponse, error) {
+	now := time.Now()
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if s.twoFactorRequiredFor(user.Role) {
+		return nil, ErrTwoFactorRequired
+	}
+	if reason, err := s.protection.CheckUser(&user, now); err != nil {
+		s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, false, reason)
+		return nil, err
+	}
+
+	ok, _, err := s.passwords.Verify(password, user.PasswordHash)
+	if err != nil || !ok {
+		s.recordReauthFailure(&user, ip, userAgent, loginReasonBadCredentials, now)
+		return nil, ErrWrongPassword
+	}
+	ok, err = s.verifySecondFactor(&user, code, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.recordReauthFailure(&user, ip, userAgent, loginReasonBadSecondFactor, now)
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	err = s.dbClient.DB.Transaction(func(tx *gorm.DB) error {
+		if err := clearTwoFactor(tx, user.ID); err != nil {
+			return err
+		}
+		return revokeUserSessions(tx, user.ID, revokeReasonTwoFactorChanged)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := s.protection.ResetUser(user.ID); err != nil {
+			log.Printf("重置登录失败次数失败: %v", err)
+		}
+	}
+
+	resp, err := s.startSession(&user, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	resp.Message = "两步验证已关闭"
+	return resp, nil
+}
+
+// recordReauthFailure 记录修改密码、关闭两步验证等操作重新验证身份时的密码或验证码错误，与登录失败共用退避和锁定策略
+func (s *AuthService) recordReauthFailure(user *models.User, ip, userAgent, reason string, now time.Time) {
+	if err := s.protection.RecordUserFailure(user.ID, now); err != nil {
+		log.Printf("记录登录失败次数失败: %v", err)
+	}
+	s.protection.RecordAttempt(user.Username, &user.ID, ip, userAgent, false, reason)
+}
+
+// replaceRecoveryCodes 作废旧恢复码并生成新的一组，返回明文
+func replaceRecoveryCodes(tx *gorm.DB, userID int64) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		code := raw[:4] + "-" + raw[4:]
+		record := &models.RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code)), CreatedAt: now}
+		if err := tx.Create(record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// recoveryCodeAlphabet 恢复码字符集（去掉易混淆的 0/o、1/l/i）
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// randomRecoveryCode 生成 8 位随机恢复码
+func randomRecoveryCode() (string, error) {
+	buf := make([]byte, 8)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		buf[i] = recoveryCodeAlphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
+
+// normalizeRecoveryCode 去除恢复码中的分隔符和空格并统一小写，便于用户输入
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// clearTwoFactor 清除用户的两步验证配置和恢复码
+func clearTwoFactor(db *gorm.DB, userID int64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":       nil,
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.LoginChallenge{}).Error
+	})
+}
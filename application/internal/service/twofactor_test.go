@@ -0,0 +1,289 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// totpCodeAt 返回当前时间偏移 offset 个时间步的验证码
+func totpCodeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := helper.TOTPCode(secret, helper.TOTPCounter(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("计算验证码失败: %v", err)
+	}
+	return code
+}
+
+// enableTestTwoFactor 为用户启用两步验证，返回密钥和恢复码。启用时使用当前时间步的验证码
+func enableTestTwoFactor(t *testing.T, s *AuthService, user *models.User) (string, []string) {
+	t.Helper()
+	enrollment, err := s.EnrollTwoFactor(user.ID)
+	if err != nil {
+		t.Fatalf("获取两步验证密钥失败: %v", err)
+	}
+	resp, err := s.ActivateTwoFactor(user.ID, totpCodeAt(t, enrollment.Secret, 0), "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("启用两步验证失败: %v", err)
+	}
+	return enrollment.Secret, resp.Data.RecoveryCodes
+}
+
+// startTestChallenge 以密码登录并返回两步验证的挑战令牌
+func startTestChallenge(t *testing.T, s *AuthService) string {
+	t.Helper()
+	resp, err := s.Login("alice", testPassword, "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+	if !resp.Data.TwoFactorRequired || resp.Data.ChallengeToken == "" || resp.Data.Token != "" {
+		t.Fatalf("期望返回登录挑战而不是令牌: %+v", resp.Data)
+	}
+	return resp.Data.ChallengeToken
+}
+
+func TestActivateTwoFactor(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{})
+	s.twoFactor.Issuer = "计量证书系统"
+	user := createTestUser(t, s, "alice", "admin")
+	if _, err := s.startSession(user, "10.0.0.1", "test"); err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+
+	if _, err := s.ActivateTwoFactor(user.ID, "123456", "10.0.0.1", "test"); !errors.Is(err, ErrTwoFactorNotEnrolled) {
+		t.Fatalf("未获取密钥时期望 ErrTwoFactorNotEnrolled，实际 %v", err)
+	}
+	enrollment, err := s.EnrollTwoFactor(user.ID)
+	if err != nil {
+		t.Fatalf("获取两步验证密钥失败: %v", err)
+	}
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") || !strings.Contains(enrollment.ProvisioningURI, enrollment.Secret) {
+		t.Fatalf("扫码 URI 不符: %s", enrollment.ProvisioningURI)
+	}
+	if _, err := s.ActivateTwoFactor(user.ID, totpCodeAt(t, enrollment.Secret, 3), "10.0.0.1", "test"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("期望 ErrInvalidTwoFactorCode，实际 %v", err)
+	}
+
+	resp, err := s.ActivateTwoFactor(user.ID, totpCodeAt(t, enrollment.Secret, 0), "10.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("启用两步验证失败: %v", err)
+	}
+	if len(resp.Data.RecoveryCodes) != recoveryCodeCount || resp.Data.Token == "" {
+		t.Fatalf("期望返回新令牌和 %d 个恢复码: %+v", recoveryCodeCount, resp.Data)
+	}
+	var stored []models.RecoveryCode
+	if err := client.DB.Where("user_id = ?", user.ID).Find(&stored).Error; err != nil {
+		t.Fatalf("查询恢复码失败: %v", err)
+	}
+	hashes := make(map[string]bool, len(stored))
+	for _, code := range stored {
+		hashes[code.CodeHash] = true
+	}
+	// 恢复码只保存规范化后的哈希
+	for _, code := range resp.Data.RecoveryCodes {
+		if !hashes[hashToken(normalizeRecoveryCode(code))] {
+			t.Fatalf("恢复码 %s 未按哈希保存", code)
+		}
+	}
+	// 启用后旧会话全部注销，只保留本次返回的新会话
+	if got := activeSessionCount(t, client, user.ID); got != 1 {
+		t.Fatalf("期望只保留 1 个新会话，实际 %d 个", got)
+	}
+	if _, err := s.EnrollTwoFactor(user.ID); !errors.Is(err, ErrTwoFactorAlreadyEnabled) {
+		t.Fatalf("已启用时期望 ErrTwoFactorAlreadyEnabled，实际 %v", err)
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	tests := []struct {
+		name string
+		// code 根据密钥和恢复码返回第二步提交的验证码
+		code    func(t *testing.T, secret string, recovery []string) string
+		wantErr error
+	}{
+		{
+			name:    "验证码",
+			code:    func(t *testing.T, secret string, recovery []string) string { return totpCodeAt(t, secret, 1) },
+			wantErr: nil,
+		},
+		{
+			name:    "启用时已使用的验证码不能重放",
+			code:    func(t *testing.T, secret string, recovery []string) string { return totpCodeAt(t, secret, 0) },
+			wantErr: ErrInvalidTwoFactorCode,
+		},
+		{
+			name:    "恢复码",
+			code:    func(t *testing.T, secret string, recovery []string) string { return recovery[0] },
+			wantErr: nil,
+		},
+		{
+			name: "恢复码忽略大小写和分隔符",
+			code: func(t *testing.T, secret string, recovery []string) string {
+				return " " + strings.ToUpper(strings.ReplaceAll(recovery[1], "-", "")) + " "
+			},
+			wantErr: nil,
+		},
+		{
+			name:    "错误验证码",
+			code:    func(t *testing.T, secret string, recovery []string) string { return "abcd-efgh" },
+			wantErr: ErrInvalidTwoFactorCode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 100})
+			user := createTestUser(t, s, "alice", "admin")
+			secret, recovery := enableTestTwoFactor(t, s, user)
+
+			challenge := startTestChallenge(t, s)
+			resp, err := s.LoginTwoFactor(challenge, tt.code(t, secret, recovery), "10.0.0.1", "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if err != nil {
+				var stored models.User
+				if err := client.DB.First(&stored, user.ID).Error; err != nil {
+					t.Fatalf("查询用户失败: %v", err)
+				}
+				if stored.FailedLoginCount != 1 {
+					t.Fatalf("验证码错误应计入连续失败次数，实际 %d", stored.FailedLoginCount)
+				}
+				return
+			}
+			if resp.Data.Token == "" || resp.Data.RefreshToken == "" {
+				t.Fatalf("期望返回令牌: %+v", resp.Data)
+			}
+			// 挑战令牌只能使用一次
+			if _, err := s.LoginTwoFactor(challenge, tt.code(t, secret, recovery), "10.0.0.1", "test"); !errors.Is(err, ErrInvalidChallenge) {
+				t.Fatalf("挑战令牌重复使用期望 ErrInvalidChallenge，实际 %v", err)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 100})
+	user := createTestUser(t, s, "alice", "admin")
+	_, recovery := enableTestTwoFactor(t, s, user)
+
+	if _, err := s.LoginTwoFactor(startTestChallenge(t, s), recovery[0], "10.0.0.1", "test"); err != nil {
+		t.Fatalf("使用恢复码登录失败: %v", err)
+	}
+	if _, err := s.LoginTwoFactor(startTestChallenge(t, s), recovery[0], "10.0.0.1", "test"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("已使用的恢复码期望 ErrInvalidTwoFactorCode，实际 %v", err)
+	}
+}
+
+func TestLoginTwoFactorChallengeLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, client *database.Client, s *AuthService, challenge string)
+	}{
+		{
+			name: "挑战已过期",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, challenge string) {
+				if err := client.DB.Model(&models.LoginChallenge{}).Where("token_hash = ?", hashToken(challenge)).
+					Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+					t.Fatalf("调整过期时间失败: %v", err)
+				}
+			},
+		},
+		{
+			name: "错误次数用尽",
+			prepare: func(t *testing.T, client *database.Client, s *AuthService, challenge string) {
+				for i := 0; i < maxChallengeAttempts; i++ {
+					if _, err := s.LoginTwoFactor(challenge, "000000", "10.0.0.1", "test"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+						t.Fatalf("第 %d 次期望 ErrInvalidTwoFactorCode，实际 %v", i+1, err)
+					}
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 100})
+			user := createTestUser(t, s, "alice", "admin")
+			secret, _ := enableTestTwoFactor(t, s, user)
+
+			challenge := startTestChallenge(t, s)
+			tt.prepare(t, client, s, challenge)
+			// 正确的验证码也不能再使用该挑战
+			if _, err := s.LoginTwoFactor(challenge, totpCodeAt(t, secret, 1), "10.0.0.1", "test"); !errors.Is(err, ErrInvalidChallenge) {
+				t.Fatalf("期望 ErrInvalidChallenge，实际 %v", err)
+			}
+		})
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	tests := []struct {
+		name          string
+		requiredRoles []string
+		password      string
+		useCode       bool
+		wantErr       error
+	}{
+		{name: "关闭", password: testPassword, useCode: true},
+		{name: "角色要求启用", requiredRoles: []string{"admin"}, password: testPassword, useCode: true, wantErr: ErrTwoFactorRequired},
+		{name: "密码错误", password: "wrong-password", useCode: true, wantErr: ErrWrongPassword},
+		{name: "验证码错误", password: testPassword, wantErr: ErrInvalidTwoFactorCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestDB(t)
+			s := newTestAuthService(t, client, config.LoginProtectionConfig{FreeAttempts: 100, LockThreshold: 100})
+			user := createTestUser(t, s, "alice", "admin")
+			secret, _ := enableTestTwoFactor(t, s, user)
+			s.twoFactor.RequiredRoles = tt.requiredRoles
+
+			code := "000000"
+			if tt.useCode {
+				code = totpCodeAt(t, secret, 1)
+			}
+			if _, err := s.DisableTwoFactor(user.ID, tt.password, code, "10.0.0.1", "test"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+
+			var stored models.User
+			if err := client.DB.First(&stored, user.ID).Error; err != nil {
+				t.Fatalf("查询用户失败: %v", err)
+			}
+			var codes int64
+			if err := client.DB.Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&codes).Error; err != nil {
+				t.Fatalf("统计恢复码失败: %v", err)
+			}
+			if disabled := !stored.TOTPEnabled && stored.TOTPSecret == nil && codes == 0; disabled != (tt.wantErr == nil) {
+				t.Fatalf("期望关闭=%v，实际 %+v（恢复码 %d 个）", tt.wantErr == nil, stored, codes)
+			}
+		})
+	}
+}
+
+func TestResetTwoFactor(t *testing.T) {
+	client := newTestDB(t)
+	s := newTestAuthService(t, client, config.LoginProtectionConfig{})
+	user := createTestUser(t, s, "alice", "admin")
+	enableTestTwoFactor(t, s, user)
+
+	// 管理员重置后可直接以密码登录，旧会话全部注销
+	if _, err := NewUserService(client, s.passwords, s.policy).ResetTwoFactor(user.ID); err != nil {
+		t.Fatalf("重置两步验证失败: %v", err)
+	}
+	if got := activeSessionCount(t, client, user.ID); got != 0 {
+		t.Fatalf("重置后应注销全部会话，实际剩余 %d 个", got)
+	}
+	resp, err := s.Login("alice", testPassword, "10.0.0.1", "test")
+	if err != nil || resp.Data.TwoFactorRequired || resp.Data.Token == "" {
+		t.Fatalf("重置后应直接登录: %+v（%v）", resp, err)
+	}
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUncertaintyUnavailable 缺少测试数据或标准器信息，无法评定不确定度
+var ErrUncertaintyUnavailable = errors.New("无法评定测量不确定度")
+
+// distributionDivisors B 类分量各分布对应的除数
+var distributionDivisors = map[string]float64{
+	"uniform":    math.Sqrt(3),
+	"triangular": math.Sqrt(6),
+	"arcsine":    math.Sqrt(2),
+	"normal":     3,
+}
+
+// UncertaintyCalculator 按测试点评定比值差和相位差的测量不确定度
+type UncertaintyCalculator struct {
+	method          string
+	coverageFactor  float64
+	standardClasses map[string]config.UncertaintyStandardClass
+	components      []config.UncertaintyComponentConfig
+}
+
+// NewUncertaintyCalculator 根据配置创建不确定度评定器，未配置标准器等级时使用默认值
+func NewUncertaintyCalculator(cfg config.UncertaintyConfig) *UncertaintyCalculator {
+	classes := cfg.StandardClasses
+	if len(classes) == 0 {
+		classes = config.DefaultUncertaintyStandardClasses()
+	}
+	normalized := make(map[string]config.UncertaintyStandardClass, len(classes))
+	for class, limits := range classes {
+		normalized[normalizeAccuracyClass(class)] = limits
+	}
+
+	method := cfg.Method
+	if method == "" {
+		method = "JJF 1059.1-2012"
+	}
+	return &UncertaintyCalculator{
+		method:          method,
+		coverageFactor:  cfg.CoverageFactorOrDefault(),
+		standardClasses: normalized,
+		components:      cfg.Components,
+	}
+}
+
+// Calculate 按测试点分组评定不确定度：A 类为重复读数平均值的实验标准偏差，B 类为标准器准确度等级
+// 及配置的其他分量，合成后乘以包含因子得到扩展不确定度（保留两位有效数字）。
+// devices 为测试数据所用检测设备，按设备地址索引
+func (u *UncertaintyCalculator) Calculate(data []*models.TestData, devices map[string]*models.Device) (*models.UncertaintyBudget, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: 证书没有测试数据", ErrUncertaintyUnavailable)
+	}
+
+	budget := &models.UncertaintyBudget{
+		Method:         u.method,
+		CoverageFactor: u.coverageFactor,
+		Points:         []*models.PointUncertainty{},
+		Notes:          []string{},
+		CalculatedAt:   time.Now().UTC().Format(time.RFC3339),
+	}
+
+	groups, order := groupReadings(data)
+	for _, key := range order {
+		point, err := u.calculatePoint(key, groups[key], devices)
+		if err != nil {
+			return nil, err
+		}
+		if point.Readings < 2 {
+			budget.Notes = append(budget.Notes, fmt.Sprintf("测试点 %s 只有 1 次读数，未评定 A 类分量", key))
+		}
+		budget.Points = append(budget.Points, point)
+	}
+	return budget, nil
+}
+
+// calculatePoint 评定单个测试点的不确定度
+func (u *UncertaintyCalculator) calculatePoint(testPoint string, readings []*models.TestData, devices map[string]*models.Device) (*models.PointUncertainty, error) {
+	n := float64(len(readings))
+	ratios := make([]float64, len(readings))
+	angles := make([]float64, len(readings))
+	var percentSum float64
+	for i, r := range readings {
+		ratios[i] = r.RatioError
+		angles[i] = r.AngleError
+		percentSum += r.ActualPercentage
+	}
+
+	point := &models.PointUncertainty{
+		TestPoint:       testPoint,
+		Percent:         roundTo(percentSum/n, 4),
+		Readings:        len(readings),
+		MeanRatioError:  roundTo(mean(ratios), 6),
+		MeanAngleError:  roundTo(mean(angles), 6),
+		RatioComponents: []*models.UncertaintyComponent{},
+		AngleComponents: []*models.UncertaintyComponent{},
+	}
+
+	// A 类：平均值的实验标准偏差 s/√n
+	if len(readings) >= 2 {
+		point.RatioComponents = append(point.RatioComponents, &models.UncertaintyComponent{
+			Name: "测量重复性", Type: "A", Value: sampleStdDev(ratios) / math.Sqrt(n),
+		})
+		point.AngleComponents = append(point.AngleComponents, &models.UncertaintyComponent{
+			Name: "测量重复性", Type: "A", Value: sampleStdDev(angles) / math.Sqrt(n),
+		})
+	}
+
+	// B 类：标准器准确度等级，同一测试点使用多台标准器时取误差半宽最大的一台
+	ratioStd, angleStd, err := u.standardComponents(readings, devices)
+	if err != nil {
+		return nil, err
+	}
+	point.RatioComponents = append(point.RatioComponents, ratioStd)
+	point.AngleComponents = append(point.AngleComponents, angleStd)
+
+	// B 类：配置的其他分量
+	for _, c := range u.components {
+		divisor, distribution := componentDivisor(c)
+		if c.RatioHalfWidth > 0 {
+			point.RatioComponents = append(point.RatioComponents, newTypeBComponent(c.Name, distribution, c.RatioHalfWidth, divisor))
+		}
+		if c.AngleHalfWidth > 0 {
+			point.AngleComponents = append(point.AngleComponents, newTypeBComponent(c.Name, distribution, c.AngleHalfWidth, divisor))
+		}
+	}
+
+	point.RatioCombined = combine(point.RatioComponents)
+	point.AngleCombined = combine(point.AngleComponents)
+	point.RatioExpanded = roundSignificant(u.coverageFactor*point.RatioCombined, 2)
+	point.AngleExpanded = roundSignificant(u.coverageFactor*point.AngleCombined, 2)
+	point.RatioCombined = roundSignificant(point.RatioCombined, 3)
+	point.AngleCombined = roundSignificant(point.AngleCombined, 3)
+	for _, c := range append(point.RatioComponents, point.AngleComponents...) {
+		c.Value = roundSignificant(c.Value, 3)
+	}
+	return point, nil
+}
+
+// standardComponents 根据标准器准确度等级生成比值差和相位差的 B 类分量
+func (u *UncertaintyCalculator) standardComponents(readings []*models.TestData, devices map[string]*models.Device) (*models.UncertaintyComponent, *models.UncertaintyComponent, error) {
+	var chosen *models.Device
+	var limits config.UncertaintyStandardClass
+	for _, r := range readings {
+		device := devices[r.DeviceAddr]
+		if device == nil {
+			return nil, nil, fmt.Errorf("%w: 检测设备 %s 未登记", ErrUncertaintyUnavailable, r.DeviceAddr)
+		}
+		class, ok := u.standardClasses[normalizeAccuracyClass(device.AccuracyClass)]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: 未配置标准器准确度等级 %q（设备 %s）", ErrUncertaintyUnavailable, device.AccuracyClass, device.DeviceAddr)
+		}
+		if chosen == nil || class.RatioError > limits.RatioError {
+			chosen, limits = device, class
+		}
+	}
+
+	name := fmt.Sprintf("标准器 %s（%s）", chosen.DeviceAddr, chosen.AccuracyClass)
+	divisor := distributionDivisors["uniform"]
+	return newTypeBComponent(name, "uniform", limits.RatioError, divisor),
+		newTypeBComponent(name, "uniform", limits.AngleError, divisor), nil
+}
+
+// uncertaintyBudgetHash 计算不确定度评定结果的哈希，未评定时返回空字符串
+func uncertaintyBudgetHash(budget *models.UncertaintyBudget) string {
+	if budget == nil {
+		return ""
+	}
+	data, err := json.Marshal(budget)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// groupReadings 按测试点分组读数，测试点名称为空时按实测百分比分组，保持首次出现的顺序
+func groupReadings(data []*models.TestData) (map[string][]*models.TestData, []string) {
+	groups := make(map[string][]*models.TestData)
+	var order []string
+	for _, d := range data {
+		key := strings.TrimSpace(d.TestPoint)
+		if key == "" {
+			key = formatNumber(d.ActualPercentage) + "%"
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], d)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return groups[order[i]][0].ActualPercentage < groups[order[j]][0].ActualPercentage
+	})
+	return groups, order
+}
+
+// componentDivisor 返回 B 类分量的除数和分布名称
+func componentDivisor(c config.UncertaintyComponentConfig) (float64, string) {
+	distribution := c.Distribution
+	if distribution == "" {
+		distribution = "uniform"
+	}
+	if c.Divisor > 0 {
+		return c.Divisor, distribution
+	}
+	if divisor, ok := distributionDivisors[distribution]; ok {
+		return divisor, distribution
+	}
+	return distributionDivisors["uniform"], "uniform"
+}
+
+// newTypeBComponent 由区间半宽和除数生成 B 类分量
+func newTypeBComponent(name, distribution string, halfWidth, divisor float64) *models.UncertaintyComponent {
+	return &models.UncertaintyComponent{
+		Name:         name,
+		Type:         "B",
+		Distribution: distribution,
+		HalfWidth:    halfWidth,
+		Divisor:      roundTo(divisor, 4),
+		Value:        halfWidth / divisor,
+	}
+}
+
+// combine 计算合成标准不确定度（各分量互不相关）
+func combine(components []*models.UncertaintyComponent) float64 {
+	var sum float64
+	for _, c := range components {
+		sum += c.Value * c.Value
+	}
+	return math.Sqrt(sum)
+}
+
+// mean 计算平均值
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// sampleStdDev 计算实验标准偏差（贝塞尔公式）
+func sampleStdDev(values []float64) float64 {
+	m := mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// roundTo 保留指定位数的小数
+func roundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// roundSignificant 保留指定位数的有效数字
+func roundSignificant(v float64, digits int) float64 {
+	if v == 0 {
+		return 0
+	}
+	exponent := int(math.Floor(math.Log10(math.Abs(v))))
+	return roundTo(v, digits-1-exponent)
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"cert-system/internal/database"
+	"cert-system/internal/helper"
+	"cert-system/internal/models"
+	"errors"
+	"time"
+)
+
+// ValidRoles 系统定义的用户角色
+var ValidRoles = map[string]bool{
+	"admin":    true,
+	"operator": true,
+	"viewer":   true,
+}
+
+// ErrUsernameTaken 用户名已存在
+var ErrUsernameTaken = errors.New("用户名已存在")
+
+// ErrInvalidRole 角色不在系统定义的范围内
+var ErrInvalidRole = errors.New("角色必须是 admin、operator 或 viewer")
+
+// ErrInvalidUserStatus 用户状态取值无效
+var ErrInvalidUserStatus = errors.New("用户状态必须是 active 或 disabled")
+
+// ErrSelfModification 管理员不能禁用、删除或降级自己的账号
+var ErrSelfModification = errors.New("不能禁用、删除或降级当前登录的管理员账号")
+
+// UserService 用户管理服务
+type UserService struct {
+	dbClient  *database.Client
+	passwords *helper.PasswordManager
+	policy    *PasswordPolicy
+}
+
+// NewUserService 创建新的 UserService
+func NewUserService(dbClient *database.Client, passwords *helper.PasswordManager, policy *PasswordPolicy) *UserService {
+	return &UserService{
+		dbClient:  dbClient,
+		passwords: passwords,
+		policy:    policy,
+	}
+}
+
+// ListUsers 分页获取用户列表，role 为空时不过滤
+func (s *UserService) ListUsers(page, pageSize int, role string) ([]*models.User, int64, error) {
+	var users []*models.User
+	var total int64
+
+	query := s.dbClient.DB.Model(&models.User{})
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := query.Order("id ASC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users)
+	return users, total, result.Error
+}
+
+// CreateUser 创建用户
+func (s *UserService) CreateUser(username, password, role string) (*models.User, error) {
+	if !ValidRoles[role] {
+		return nil, ErrInvalidRole
+	}
+	if err := s.policy.Validate(password); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.dbClient.DB.Model(&models.User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrUsernameTaken
+	}
+
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// 密码由管理员设置，用户首次登录时必须修改
+	now := time.Now()
+	user := &models.User{
+		Username:           username,
+		PasswordHash:       hash,
+		Role:               role,
+		Status:             "active",
+		MustChangePassword: true,
+		PasswordChangedAt:  now,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if err := s.dbClient.DB.Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUser 更新用户角色或状态，operatorID 为执行操作的管理员
+func (s *UserService) UpdateUser(operatorID, userID int64, role, status string) (*models.User, error) {
+	if role != "" && !ValidRoles[role] {
+		return nil, ErrInvalidRole
+	}
+	if status != "" && status != "active" && status != "disabled" {
+		return nil, ErrInvalidUserStatus
+	}
+
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.ID == operatorID && ((role != "" && role != "admin") || status == "disabled") {
+		return nil, ErrSelfModification
+	}
+
+	previousRole := user.Role
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if role != "" {
+		updates["role"] = role
+	}
+	if status != "" {
+		updates["status"] = status
+	}
+	if err := s.dbClient.DB.Model(&user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	// 禁用账号或变更角色时注销其所有会话，已签发的令牌（携带旧角色）立即失效
+	switch {
+	case status == "disabled":
+		if err := revokeUserSessions(s.dbClient.DB, user.ID, revokeReasonUserDisabled); err != nil {
+			return nil, err
+		}
+	case role != "" && role != previousRole:
+		if err := revokeUserSessions(s.dbClient.DB, user.ID, revokeReasonRoleChanged); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// DeleteUser 删除用户。已创建证书、API Key 或有操作记录的用户改为禁用，返回值表示是否被禁用而非删除
+func (s *UserService) DeleteUser(operatorID, userID int64) (bool, error) {
+	if userID == operatorID {
+		return false, ErrSelfModification
+	}
+
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return false, err
+	}
+
+	referenced, err := s.isUserReferenced(userID)
+	if err != nil {
+		return false, err
+	}
+	if referenced {
+		err := s.dbClient.DB.Model(&user).Updates(map[string]interface{}{
+			"status":     "disabled",
+			"updated_at": time.Now(),
+		}).Error
+		if err == nil {
+			err = revokeUserSessions(s.dbClient.DB, user.ID, revokeReasonUserDisabled)
+		}
+		return true, err
+	}
+
+	return false, s.dbClient.DB.Delete(&user).Error
+}
+
+// isUserReferenced 检查用户是否被证书或操作记录引用（外键约束）
+func (s *UserService) isUserReferenced(userID int64) (bool, error) {
+	checks := []struct {
+		model  interface{}
+		column string
+	}{
+		{&models.Certificate{}, "created_by"},
+		{&models.CertificateHistory{}, "operator_id"},
+		{&models.BlockchainTransaction{}, "operator_id"},
+		{&models.APIKey{}, "created_by"},
+	}
+	for _, check := range checks {
+		var count int64
+		if err := s.dbClient.DB.Model(check.model).Where(check.column+" = ?", userID).Count(&count).Error; err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ResetPassword 管理员重置用户密码，newPassword 为空时生成临时密码并返回
+// 重置后用户下次登录必须修改密码
+func (s *UserService) ResetPassword(userID int64, newPassword string) (string, error) {
+	if newPassword == "" {
+		var err error
+		if newPassword, err = s.policy.GenerateTemporary(); err != nil {
+			return "", err
+		}
+	}
+
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return "", err
+	}
+	if !isLocalAccount(&user) {
+		return "", ErrExternalAccount
+	}
+	if err := setPassword(s.dbClient.DB, s.passwords, s.policy, &user, newPassword, true); err != nil {
+		return "", err
+	}
+	if err := revokeUserSessions(s.dbClient.DB, user.ID, revokeReasonPasswordReset); err != nil {
+		return "", err
+	}
+	return newPassword, nil
+}
+
+// UnlockUser 解除账号锁定并清零登录失败次数
+func (s *UserService) UnlockUser(userID int64) (*models.User, error) {
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if err := resetLoginFailures(s.dbClient.DB, userID); err != nil {
+		return nil, err
+	}
+	user.FailedLoginCount = 0
+	user.LastFailedLoginAt = nil
+	user.LockedUntil = nil
+	return &user, nil
+}
+
+// ResetTwoFactor 清除用户的两步验证配置（用于丢失验证器的情况），并注销其全部会话
+func (s *UserService) ResetTwoFactor(userID int64) (*models.User, error) {
+	var user models.User
+	if err := s.dbClient.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if err := clearTwoFactor(s.dbClient.DB, userID); err != nil {
+		return nil, err
+	}
+	if err := revokeUserSessions(s.dbClient.DB, userID, revokeReasonTwoFactorChanged); err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = nil
+	user.TOTPEnabled = false
+	user.TOTPLastCounter = 0
+	return &user, nil
+}
+
+// ListLoginAttempts 分页获取登录审计记录（按时间倒序），username、ip 为空时不过滤
+func (s *UserService) ListLoginAttempts(page, pageSize int, username, ip string) ([]*models.LoginAttempt, int64, error) {
+	var attempts []*models.LoginAttempt
+	var total int64
+
+	query := s.dbClient.DB.Model(&models.LoginAttempt{})
+	if username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	result := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&attempts)
+	return attempts, total, result.Error
+}
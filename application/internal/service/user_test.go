@@ -0,0 +1,254 @@
+package service
+
+import (
+	"cert-system/config"
+	"cert-system/internal/database"
+	"cert-system/internal/models"
+	"errors"
+	"testing"
+)
+
+// newTestUserService 创建与 newTestAuthService 共用密码哈希的用户管理服务
+func newTestUserService(t *testing.T) (*database.Client, *AuthService, *UserService) {
+	t.Helper()
+	client := newTestDB(t)
+	// BlockchainTransaction 模型未映射 init.sql 中的 operator_id 列，删除用户时按该列检查引用
+	if err := client.DB.Exec("ALTER TABLE blockchain_transactions ADD COLUMN operator_id INTEGER").Error; err != nil {
+		t.Fatalf("补充 operator_id 列失败: %v", err)
+	}
+	auth := newTestAuthService(t, client, config.LoginProtectionConfig{})
+	return client, auth, NewUserService(client, auth.passwords, auth.policy)
+}
+
+// activeSessionCount 统计用户未吊销的会话数
+func activeSessionCount(t *testing.T, client *database.Client, userID int64) int64 {
+	t.Helper()
+	var count int64
+	if err := client.DB.Model(&models.AuthSession{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error; err != nil {
+		t.Fatalf("统计会话失败: %v", err)
+	}
+	return count
+}
+
+func TestCreateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		role     string
+		wantErr  error
+	}{
+		{name: "创建操作员", username: "carol", password: "Carol-Passw0rd", role: "operator"},
+		{name: "角色无效", username: "carol", password: "Carol-Passw0rd", role: "supervisor", wantErr: ErrInvalidRole},
+		{name: "密码过短", username: "carol", password: "short", role: "viewer", wantErr: ErrWeakPassword},
+		{name: "用户名已存在", username: "alice", password: "Carol-Passw0rd", role: "viewer", wantErr: ErrUsernameTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, auth, s := newTestUserService(t)
+			createTestUser(t, auth, "alice", "admin")
+
+			user, err := s.CreateUser(tt.username, tt.password, tt.role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			// 管理员设置的密码须在首次登录时修改
+			if !user.MustChangePassword || user.Status != "active" || user.Role != tt.role {
+				t.Fatalf("新建用户状态不符: %+v", user)
+			}
+			if ok, _, _ := auth.passwords.Verify(tt.password, user.PasswordHash); !ok {
+				t.Fatal("密码哈希与设置的密码不符")
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name string
+		// self 为 true 时管理员修改自己的账号
+		self         bool
+		role         string
+		status       string
+		wantErr      error
+		wantRole     string
+		wantStatus   string
+		wantSessions int64
+	}{
+		{name: "变更角色", role: "viewer", wantRole: "viewer", wantStatus: "active", wantSessions: 0},
+		{name: "角色不变", role: "operator", wantRole: "operator", wantStatus: "active", wantSessions: 1},
+		{name: "禁用账号", status: "disabled", wantRole: "operator", wantStatus: "disabled", wantSessions: 0},
+		{name: "角色无效", role: "root", wantErr: ErrInvalidRole, wantRole: "operator", wantStatus: "active", wantSessions: 1},
+		{name: "状态无效", status: "locked", wantErr: ErrInvalidUserStatus, wantRole: "operator", wantStatus: "active", wantSessions: 1},
+		{name: "降级自己", self: true, role: "viewer", wantErr: ErrSelfModification, wantRole: "admin", wantStatus: "active", wantSessions: 1},
+		{name: "禁用自己", self: true, status: "disabled", wantErr: ErrSelfModification, wantRole: "admin", wantStatus: "active", wantSessions: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, auth, s := newTestUserService(t)
+			admin := createTestUser(t, auth, "alice", "admin")
+			target := createTestUser(t, auth, "bob", "operator")
+			if tt.self {
+				target = admin
+			}
+			if _, err := auth.startSession(target, "10.0.0.1", "test"); err != nil {
+				t.Fatalf("创建会话失败: %v", err)
+			}
+
+			if _, err := s.UpdateUser(admin.ID, target.ID, tt.role, tt.status); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			var stored models.User
+			if err := client.DB.First(&stored, target.ID).Error; err != nil {
+				t.Fatalf("查询用户失败: %v", err)
+			}
+			if stored.Role != tt.wantRole || stored.Status != tt.wantStatus {
+				t.Fatalf("期望 %s/%s，实际 %s/%s", tt.wantRole, tt.wantStatus, stored.Role, stored.Status)
+			}
+			if got := activeSessionCount(t, client, target.ID); got != tt.wantSessions {
+				t.Fatalf("期望 %d 个有效会话，实际 %d", tt.wantSessions, got)
+			}
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	tests := []struct {
+		name string
+		// reference 为被删除用户创建关联记录
+		reference    func(t *testing.T, client *database.Client, userID int64)
+		self         bool
+		wantErr      error
+		wantDisabled bool
+		wantDeleted  bool
+	}{
+		{name: "无引用时删除", wantDeleted: true},
+		{
+			name: "创建过证书时改为禁用",
+			reference: func(t *testing.T, client *database.Client, userID int64) {
+				cert := createTestCertificate(t, client, "CT-U-001", "draft")
+				if err := client.DB.Model(cert).Update("created_by", userID).Error; err != nil {
+					t.Fatalf("设置创建人失败: %v", err)
+				}
+			},
+			wantDisabled: true,
+		},
+		{
+			name: "有上链操作记录时改为禁用",
+			reference: func(t *testing.T, client *database.Client, userID int64) {
+				if err := client.DB.Exec("INSERT INTO blockchain_transactions (tx_id, operator_id) VALUES (?, ?)", "tx-1", userID).Error; err != nil {
+					t.Fatalf("写入上链记录失败: %v", err)
+				}
+			},
+			wantDisabled: true,
+		},
+		{
+			name: "创建过 API Key 时改为禁用",
+			reference: func(t *testing.T, client *database.Client, userID int64) {
+				if err := client.DB.Create(&models.APIKey{Name: "ci", KeyPrefix: "ck_test", KeyHash: "hash", CreatedBy: userID}).Error; err != nil {
+					t.Fatalf("创建 API Key 失败: %v", err)
+				}
+			},
+			wantDisabled: true,
+		},
+		{name: "删除自己", self: true, wantErr: ErrSelfModification},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, auth, s := newTestUserService(t)
+			admin := createTestUser(t, auth, "alice", "admin")
+			target := createTestUser(t, auth, "bob", "operator")
+			if tt.self {
+				target = admin
+			}
+			if tt.reference != nil {
+				tt.reference(t, client, target.ID)
+			}
+			if _, err := auth.startSession(target, "10.0.0.1", "test"); err != nil {
+				t.Fatalf("创建会话失败: %v", err)
+			}
+
+			disabled, err := s.DeleteUser(admin.ID, target.ID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if disabled != tt.wantDisabled {
+				t.Fatalf("期望禁用=%v，实际 %v", tt.wantDisabled, disabled)
+			}
+
+			var stored models.User
+			err = client.DB.First(&stored, target.ID).Error
+			if deleted := errors.Is(err, database.ErrRecordNotFound); deleted != tt.wantDeleted {
+				t.Fatalf("期望删除=%v，实际查询结果 %v", tt.wantDeleted, err)
+			}
+			if tt.wantDisabled {
+				if stored.Status != "disabled" {
+					t.Fatalf("期望禁用，实际状态 %s", stored.Status)
+				}
+				if got := activeSessionCount(t, client, target.ID); got != 0 {
+					t.Fatalf("禁用后应吊销全部会话，实际剩余 %d 个", got)
+				}
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		name        string
+		newPassword string
+		external    bool
+		wantErr     error
+	}{
+		{name: "生成临时密码"},
+		{name: "指定新密码", newPassword: "Reset-Passw0rd"},
+		{name: "新密码不符合策略", newPassword: "short", wantErr: ErrWeakPassword},
+		{name: "外部认证账号", external: true, wantErr: ErrExternalAccount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, auth, s := newTestUserService(t)
+			user := createTestUser(t, auth, "bob", "operator")
+			if tt.external {
+				if err := client.DB.Model(user).Updates(map[string]interface{}{"auth_provider": "ldap", "external_id": "uid=bob"}).Error; err != nil {
+					t.Fatalf("设置外部账号失败: %v", err)
+				}
+			}
+			if _, err := auth.startSession(user, "10.0.0.1", "test"); err != nil {
+				t.Fatalf("创建会话失败: %v", err)
+			}
+
+			password, err := s.ResetPassword(user.ID, tt.newPassword)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			var stored models.User
+			if err := client.DB.First(&stored, user.ID).Error; err != nil {
+				t.Fatalf("查询用户失败: %v", err)
+			}
+			if err != nil {
+				// 重置失败时保留原密码和会话
+				if ok, _, _ := auth.passwords.Verify(testPassword, stored.PasswordHash); !ok || activeSessionCount(t, client, user.ID) != 1 {
+					t.Fatal("重置失败时不应修改密码或吊销会话")
+				}
+				return
+			}
+
+			if tt.newPassword != "" && password != tt.newPassword {
+				t.Fatalf("期望返回指定的密码，实际 %q", password)
+			}
+			if err := auth.policy.Validate(password); err != nil {
+				t.Fatalf("临时密码不满足密码策略: %v", err)
+			}
+			if ok, _, _ := auth.passwords.Verify(password, stored.PasswordHash); !ok || !stored.MustChangePassword {
+				t.Fatalf("重置后密码或强制修改标记不符: %+v", stored)
+			}
+			if got := activeSessionCount(t, client, user.ID); got != 0 {
+				t.Fatalf("重置后应吊销全部会话，实际剩余 %d 个", got)
+			}
+		})
+	}
+}
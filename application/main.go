@@ -3,6 +3,9 @@ package main
 import (
 	"cert-system/internal/api"
 	"cert-system/internal/database"
+	"cert-system/internal/fabric"
+	"cert-system/internal/helper"
+	"cert-system/internal/ingest"
 	"cert-system/internal/service"
 	"cert-system/config" // 导入 config 包
 	"log"
@@ -23,19 +26,84 @@ func main() {
 	}
 	log.Println("数据库连接成功")
 	
-	// 这里可以添加数据库迁移逻辑，例如:
-	// database.AutoMigrate(dbClient.DB)
+	// 执行数据库升级脚本，按旧版 init.sql 创建的数据库升级到当前结构
+	migrated, err := dbClient.Migrate()
+	if err != nil {
+		log.Fatalf("数据库升级失败: %v", err)
+	}
+	for _, version := range migrated {
+		log.Printf("已执行数据库升级脚本 %s", version)
+	}
+
+	// 初始化Fabric客户端（可选）
+	var fabricClient *fabric.Client
+	if cfg.Fabric.Enabled {
+		fabricClient, err = fabric.NewClient(cfg.Fabric)
+		if err != nil {
+			log.Fatalf("无法连接到Fabric网络: %v", err)
+		}
+		defer fabricClient.Close()
+		log.Println("Fabric网络连接成功")
+	}
+
+	// 初始化密码哈希：新密码使用配置的算法，其他格式（含旧版SHA-256）仍可校验并在登录时升级
+	argon2Hasher := helper.NewArgon2idHasher(cfg.Password.Argon2Memory, cfg.Password.Argon2Iterations, cfg.Password.Argon2Parallelism)
+	bcryptHasher := helper.NewBcryptHasher(cfg.Password.BcryptCost)
+	passwords := helper.NewPasswordManager(argon2Hasher, bcryptHasher, helper.LegacySHA256Hasher{})
+	if cfg.Password.Algorithm == "bcrypt" {
+		passwords = helper.NewPasswordManager(bcryptHasher, argon2Hasher, helper.LegacySHA256Hasher{})
+	}
+
+	// 初始化JWT签名密钥
+	if len(cfg.JWT.Keys) == 0 && cfg.JWT.Secret == "your_super_secret_key" {
+		log.Println("警告: 正在使用默认JWT密钥，生产环境请通过 jwt.secret 或环境变量 JWT_SECRET 配置")
+	}
+	jwtManager, err := helper.NewJWTManager(cfg.JWT)
+	if err != nil {
+		log.Fatalf("无法初始化JWT密钥: %v", err)
+	}
 
 	// 初始化服务层
-	authService := service.NewAuthService(dbClient)
-	certService := service.NewCertificateService(dbClient)
-	testDataService := service.NewTestDataService(dbClient)
+	passwordPolicy := service.NewPasswordPolicy(cfg.Password)
+	loginProtection := service.NewLoginProtection(dbClient, cfg.Login)
+	authProviders := service.NewAuthProviders(dbClient, passwords, cfg.SSO)
+	authService := service.NewAuthService(dbClient, passwords, passwordPolicy, loginProtection, jwtManager, cfg.JWT.RefreshTTLDuration(), cfg.TwoFactor, authProviders)
+	userService := service.NewUserService(dbClient, passwords, passwordPolicy)
+	certService := service.NewCertificateService(dbClient, fabricClient,
+		service.NewResultEvaluator(cfg.ErrorLimits), service.NewUncertaintyCalculator(cfg.Uncertainty))
+	testDataService := service.NewTestDataService(dbClient, fabricClient, service.NewTestDataHub())
+	stopLedgerRetry := testDataService.StartLedgerRetry(cfg.Fabric.RetryIntervalDuration(), cfg.Fabric.MaxAttemptsOrDefault())
+	defer stopLedgerRetry()
+	idemService := service.NewIdempotencyService(dbClient, cfg.Idempotency.WindowDuration(), cfg.Idempotency.LeaseDuration())
+	permissions := service.NewRolePermissions(cfg.Permissions)
+	apiKeyService := service.NewAPIKeyService(dbClient)
+	customerService := service.NewCustomerService(dbClient)
+	deviceService := service.NewDeviceService(dbClient, fabricClient)
+	analyticsService := service.NewAnalyticsService(dbClient, cfg.Analytics)
+
+	// 初始化 MQTT 测试数据接入（可选）
+	if cfg.MQTT.EmbeddedBroker.Enabled {
+		broker, err := ingest.StartEmbeddedBroker(cfg.MQTT.EmbeddedBroker)
+		if err != nil {
+			log.Fatalf("无法启动内置MQTT Broker: %v", err)
+		}
+		defer broker.Close()
+		log.Printf("内置MQTT Broker 在 %s 上运行", cfg.MQTT.EmbeddedBroker.Address)
+	}
+	if cfg.MQTT.Enabled {
+		bridge := ingest.NewMQTTBridge(cfg.MQTT, certService, testDataService)
+		if err := bridge.Start(); err != nil {
+			log.Fatalf("无法连接到MQTT Broker: %v", err)
+		}
+		defer bridge.Stop()
+		log.Println("MQTT测试数据接入已启动")
+	}
 	
 	// 初始化 Gin 路由器
 	router := gin.Default()
 	
 	// 设置路由
-	api.SetupRoutes(router, certService, testDataService, authService)
+	api.SetupRoutes(router, certService, testDataService, authService, userService, idemService, permissions, apiKeyService, customerService, deviceService, analyticsService)
 
 	// 启动服务器
 	log.Printf("服务器在端口 %s 上运行", cfg.Server.Port)
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"github.com/tjfoc/gmsm/sm3"
 	"github.com/tjfoc/gmsm/sm4"
+	gmx509 "github.com/tjfoc/gmsm/x509"
 )
 
 // CertChaincode 计量证书链码结构
@@ -33,22 +42,73 @@ type Certificate struct {
 	CreatedAt         string    `json:"createdAt"`         // 创建时间
 	UpdatedAt         string    `json:"updatedAt"`         // 更新时间
 	TestDataHash      string    `json:"testDataHash"`      // 测试数据哈希
+	UncertaintyHash   string    `json:"uncertaintyHash,omitempty"` // 测量不确定度评定哈希
+	Version           int64     `json:"version"`           // 版本号（乐观锁）
 	BlockchainTxID    string    `json:"blockchainTxId"`    // 区块链交易ID
 	BlockchainHash    string    `json:"blockchainHash"`     // 区块链哈希
 }
 
 // TestData 测试数据结构体
 type TestData struct {
-	CertNumber       string  `json:"certNumber"`
-	DeviceAddr       string  `json:"deviceAddr"`
-	TestPoint        string  `json:"testPoint"`
-	ActualPercentage float64 `json:"actualPercentage"`
-	RatioError       float64 `json:"ratioError"`
-	AngleError       float64 `json:"angleError"`
-	TestTimestamp    string  `json:"testTimestamp"`
-	EncryptedData    string  `json:"encryptedData"`
+	CertNumber        string  `json:"certNumber"`
+	DeviceAddr        string  `json:"deviceAddr"`
+	DataType          string  `json:"dataType"`          // current 电流互感器 / voltage 电压互感器
+	TestPoint         string  `json:"testPoint"`
+	PercentageValue   float64 `json:"percentageValue"`   // 额定百分点（%）
+	ActualPercentage  float64 `json:"actualPercentage"`  // 实测百分比（%）
+	RatioError        float64 `json:"ratioError"`        // 比值差（%）
+	AngleError        float64 `json:"angleError"`        // 相位差（′）
+	CurrentValue      float64 `json:"currentValue"`      // 一次电流（A）
+	VoltageValue      float64 `json:"voltageValue"`      // 一次电压（V）
+	WorkstationNumber string  `json:"workstationNumber"` // 工位号
+	TestTimestamp     string  `json:"testTimestamp"`
+	RecordID          int64   `json:"recordId"`          // 应用层数据库记录ID，作为账本键的一部分
+	Signature          string `json:"signature,omitempty"`          // 设备对规范化读数的签名（base64）
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"` // 校验时使用的算法
+	EncryptedData     string  `json:"encryptedData"`
 }
 
+// DeviceKey 设备签名公钥，登记后该设备上链的测试数据须附带有效签名
+type DeviceKey struct {
+	DeviceAddr   string `json:"deviceAddr"`
+	PublicKey    string `json:"publicKey"` // PEM 格式（PUBLIC KEY）
+	Algorithm    string `json:"algorithm"` // ECDSA-P256-SHA256 / SM2-SM3
+	TxID         string `json:"txId"`
+	RegisteredAt string `json:"registeredAt"`
+}
+
+// 设备签名算法
+const (
+	signatureAlgorithmECDSA = "ECDSA-P256-SHA256"
+	signatureAlgorithmSM2   = "SM2-SM3"
+)
+
+// deviceKeyObjectType 设备公钥的复合键类型，更换公钥的历史可通过账本历史查询
+const deviceKeyObjectType = "deviceKey"
+
+// deviceKeyRegistrarMSPs 可以登记和更换设备公钥的组织（出具证书的实验室组织）。
+// 背书策略要求多个组织的节点背书，各节点须得出相同结论，因此按固定名单而不是节点所属组织判断
+var deviceKeyRegistrarMSPs = map[string]bool{"Org1MSP": true}
+
+// TraceabilityLink 证书与所用设备校准证书之间的一条溯源关系
+type TraceabilityLink struct {
+	DeviceAddr             string `json:"deviceAddr"`
+	CalibrationCertNumber  string `json:"calibrationCertNumber,omitempty"`  // 本系统签发的上级证书
+	CalibrationExternalRef string `json:"calibrationExternalRef,omitempty"` // 外部机构出具的证书
+}
+
+// TraceabilityAnchor 证书溯源链锚定记录
+type TraceabilityAnchor struct {
+	CertNumber string              `json:"certNumber"`
+	ChainHash  string              `json:"chainHash"` // 应用层计算的整条溯源链哈希
+	Upstream   []*TraceabilityLink `json:"upstream"`
+	TxID       string              `json:"txId"`
+	AnchoredAt string              `json:"anchoredAt"`
+}
+
+// traceabilityObjectType 溯源锚定记录的复合键类型，复合键不会出现在证书范围查询中
+const traceabilityObjectType = "traceability"
+
 // QueryResult 查询结果结构体
 type QueryResult struct {
 	Key    string      `json:"Key"`
@@ -89,11 +149,17 @@ func (c *CertChaincode) CreateCertificate(ctx contractapi.TransactionContextInte
 	// 获取交易ID
 	txID := ctx.GetStub().GetTxID()
 	
-	// 设置创建时间和区块链交易ID
-	cert.CreatedAt = time.Now().Format(time.RFC3339)
+	// 设置创建时间和区块链交易ID，时间取自交易而不是节点本地时钟，各背书节点结果一致
+	cert.CreatedAt, err = transactionTime(ctx)
+	if err != nil {
+		return "", err
+	}
 	cert.UpdatedAt = cert.CreatedAt
 	cert.Status = "created"
 	cert.BlockchainTxID = txID  // 添加这个字段
+	if cert.Version == 0 {
+		cert.Version = 1
+	}
 
 	certJSON, err := json.Marshal(cert)
 	if err != nil {
@@ -139,13 +205,10 @@ func (c *CertChaincode) GetCertificate(ctx contractapi.TransactionContextInterfa
 
 // UpdateCertificate 更新证书
 func (c *CertChaincode) UpdateCertificate(ctx contractapi.TransactionContextInterface, certNumber string, certData string) error {
-	exists, err := c.CertificateExists(ctx, certNumber)
+	existing, err := c.GetCertificate(ctx, certNumber)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("证书 %s 不存在", certNumber)
-	}
 
 	var cert Certificate
 	err = json.Unmarshal([]byte(certData), &cert)
@@ -153,8 +216,24 @@ func (c *CertChaincode) UpdateCertificate(ctx contractapi.TransactionContextInte
 		return fmt.Errorf("证书数据解析失败: %v", err)
 	}
 
+	// 证书编号是账本键，改号会使账本记录与业务记录脱节
+	if cert.CertNumber != "" && cert.CertNumber != certNumber {
+		return fmt.Errorf("证书 %s 已上链，不能修改证书编号为 %s", certNumber, cert.CertNumber)
+	}
+
+	// 乐观锁：提交的版本必须恰好是账本版本的下一个版本（旧记录无版本号时跳过检查）
+	if existing.Version > 0 && cert.Version != existing.Version+1 {
+		return fmt.Errorf("证书 %s 版本冲突: 账本版本 %d, 提交版本 %d", certNumber, existing.Version, cert.Version)
+	}
+
+	// 保留由链码维护的字段，避免被业务更新覆盖
 	cert.CertNumber = certNumber
-	cert.UpdatedAt = time.Now().Format(time.RFC3339)
+	cert.CreatedAt = existing.CreatedAt
+	cert.TestDataHash = existing.TestDataHash
+	cert.BlockchainTxID = existing.BlockchainTxID
+	if cert.UpdatedAt, err = transactionTime(ctx); err != nil {
+		return err
+	}
 
 	certJSON, err := json.Marshal(cert)
 	if err != nil {
@@ -164,7 +243,8 @@ func (c *CertChaincode) UpdateCertificate(ctx contractapi.TransactionContextInte
 	return ctx.GetStub().PutState(certNumber, certJSON)
 }
 
-// AddTestData 添加测试数据
+// AddTestData 添加测试数据。以证书编号和应用层记录ID为键，应用层重试时重复提交内容相同的记录视为已上链，
+// 不重复写入也不重复计入测试数据哈希；同一记录ID提交不同内容时拒绝
 func (c *CertChaincode) AddTestData(ctx contractapi.TransactionContextInterface, testDataStr string) error {
 	var testData TestData
 	err := json.Unmarshal([]byte(testDataStr), &testData)
@@ -172,6 +252,10 @@ func (c *CertChaincode) AddTestData(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("测试数据解析失败: %v", err)
 	}
 
+	if err := validateTestData(&testData); err != nil {
+		return err
+	}
+
 	// 验证证书是否存在
 	exists, err := c.CertificateExists(ctx, testData.CertNumber)
 	if err != nil {
@@ -181,11 +265,40 @@ func (c *CertChaincode) AddTestData(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("证书 %s 不存在", testData.CertNumber)
 	}
 
-	// 生成测试数据的唯一键
-	testDataKey := fmt.Sprintf("TESTDATA_%s_%d", testData.CertNumber, time.Now().UnixNano())
-	
-	// 设置测试时间
-	testData.TestTimestamp = time.Now().Format(time.RFC3339)
+	if testData.RecordID <= 0 {
+		return fmt.Errorf("测试点 %s 缺少记录ID", testData.TestPoint)
+	}
+
+	// 各背书节点须写入相同的读写集，键和时间取自请求和交易而不是节点本地时钟
+	txTime, err := transactionTime(ctx)
+	if err != nil {
+		return err
+	}
+	testDataKey := fmt.Sprintf("TESTDATA_%s_%d", testData.CertNumber, testData.RecordID)
+
+	// 设置测试时间，设备上报的测试时间在签名范围内，须保留
+	if testData.TestTimestamp == "" {
+		testData.TestTimestamp = txTime
+	}
+
+	if err := c.verifyTestDataSignature(ctx, &testData); err != nil {
+		return err
+	}
+
+	existingJSON, err := ctx.GetStub().GetState(testDataKey)
+	if err != nil {
+		return fmt.Errorf("读取账本失败: %v", err)
+	}
+	if existingJSON != nil {
+		var existing TestData
+		if err := json.Unmarshal(existingJSON, &existing); err != nil {
+			return fmt.Errorf("测试数据解析失败: %v", err)
+		}
+		if sameTestData(&existing, &testData) {
+			return nil
+		}
+		return fmt.Errorf("测试数据记录 %d 已上链且内容不同", testData.RecordID)
+	}
 
 	// 对敏感数据进行国密SM4加密（这里使用示例密钥，实际应用中应使用安全的密钥管理）
 	sensitiveData := fmt.Sprintf("%.6f|%.6f|%s", 
@@ -209,7 +322,156 @@ func (c *CertChaincode) AddTestData(ctx contractapi.TransactionContextInterface,
 	}
 
 	// 更新证书的测试数据哈希
-	return c.updateCertificateTestDataHash(ctx, testData.CertNumber, testDataKey)
+	return c.updateCertificateTestDataHash(ctx, testData.CertNumber, testDataKey, txTime)
+}
+
+// sameTestData 判断两条测试数据的读数和签名是否相同
+func sameTestData(a, b *TestData) bool {
+	pa, errA := canonicalTestDataPayload(a)
+	pb, errB := canonicalTestDataPayload(b)
+	return errA == nil && errB == nil && pa == pb && a.Signature == b.Signature
+}
+
+// transactionTime 返回交易时间（RFC3339），各背书节点结果一致
+func transactionTime(ctx contractapi.TransactionContextInterface) (string, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(time.RFC3339), nil
+}
+
+// validateTestData 按数据类型校验测试点：电流互感器须有一次电流且不带电压，电压互感器反之
+func validateTestData(testData *TestData) error {
+	switch testData.DataType {
+	case "current":
+		if testData.CurrentValue <= 0 || testData.VoltageValue != 0 {
+			return fmt.Errorf("测试点 %s 为电流互感器数据，须填写一次电流且不能填写电压", testData.TestPoint)
+		}
+	case "voltage":
+		if testData.VoltageValue <= 0 || testData.CurrentValue != 0 {
+			return fmt.Errorf("测试点 %s 为电压互感器数据，须填写一次电压且不能填写电流", testData.TestPoint)
+		}
+	default:
+		return fmt.Errorf("测试数据类型 %q 无效，应为 current 或 voltage", testData.DataType)
+	}
+	if testData.ActualPercentage <= 0 {
+		return fmt.Errorf("测试点 %s 的实测百分比无效", testData.TestPoint)
+	}
+	return nil
+}
+
+// verifyTestDataSignature 按账本登记的设备公钥校验测试数据签名：登记了公钥的设备必须签名，
+// 未登记公钥的设备提交的签名无法校验，予以拒绝
+func (c *CertChaincode) verifyTestDataSignature(ctx contractapi.TransactionContextInterface, testData *TestData) error {
+	key, err := c.GetDeviceKey(ctx, testData.DeviceAddr)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		if testData.Signature != "" {
+			return fmt.Errorf("设备 %s 未登记公钥，无法校验签名", testData.DeviceAddr)
+		}
+		testData.SignatureAlgorithm = ""
+		return nil
+	}
+	if testData.Signature == "" {
+		return fmt.Errorf("设备 %s 已登记公钥，测试点 %s 须附带设备签名", testData.DeviceAddr, testData.TestPoint)
+	}
+
+	payload, err := canonicalTestDataPayload(testData)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(key.Algorithm, key.PublicKey, payload, testData.Signature); err != nil {
+		return fmt.Errorf("测试点 %s 的设备签名无效: %v", testData.TestPoint, err)
+	}
+	testData.SignatureAlgorithm = key.Algorithm
+	return nil
+}
+
+// canonicalTestDataPayload 返回设备签名的规范化读数，须与应用层 service.CanonicalTestDataPayload 保持一致
+func canonicalTestDataPayload(testData *TestData) (string, error) {
+	t, err := time.Parse(time.RFC3339, testData.TestTimestamp)
+	if err != nil {
+		return "", fmt.Errorf("测试时间格式错误: %v", err)
+	}
+	fixed := func(v float64, prec int) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	return strings.Join([]string{
+		testData.CertNumber,
+		testData.DeviceAddr,
+		testData.DataType,
+		testData.TestPoint,
+		fixed(testData.PercentageValue, 6),
+		fixed(testData.ActualPercentage, 6),
+		fixed(testData.RatioError, 6),
+		fixed(testData.AngleError, 6),
+		fixed(testData.CurrentValue, 3),
+		fixed(testData.VoltageValue, 3),
+		testData.WorkstationNumber,
+		t.UTC().Truncate(time.Second).Format(time.RFC3339),
+	}, "|"), nil
+}
+
+// parsePublicKey 按算法解析 PEM 格式的设备公钥
+func parsePublicKey(algorithm, publicKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("公钥应为 PEM 格式的 PUBLIC KEY")
+	}
+
+	switch algorithm {
+	case signatureAlgorithmECDSA:
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("不是 ECDSA P-256 公钥")
+		}
+		return key, nil
+	case signatureAlgorithmSM2:
+		// 标准库不识别 SM2 曲线，能被标准库解析的是其他曲线的公钥
+		if _, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+			return nil, fmt.Errorf("不是 SM2 公钥")
+		}
+		key, err := gmx509.ParseSm2PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if key.X == nil || !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("SM2 公钥点无效")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("不支持的签名算法 %q", algorithm)
+	}
+}
+
+// verifySignature 校验 base64 编码的 ASN.1 签名，ECDSA 对载荷的 SHA-256 摘要签名，SM2 对载荷签名
+func verifySignature(algorithm, publicKeyPEM, payload, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("签名不是有效的 base64 编码")
+	}
+	pub, err := parsePublicKey(algorithm, publicKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	valid := false
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256([]byte(payload))
+		valid = ecdsa.VerifyASN1(key, digest[:], sig)
+	case interface{ Verify(msg, sign []byte) bool }:
+		valid = key.Verify([]byte(payload), sig)
+	}
+	if !valid {
+		return fmt.Errorf("签名与读数内容不符")
+	}
+	return nil
 }
 
 // 国密SM4加密函数
@@ -226,17 +488,17 @@ func (c *CertChaincode) encryptWithSM4(plaintext, key string) (string, error) {
 }
 
 // 更新证书的测试数据哈希
-func (c *CertChaincode) updateCertificateTestDataHash(ctx contractapi.TransactionContextInterface, certNumber string, testDataKey string) error {
+func (c *CertChaincode) updateCertificateTestDataHash(ctx contractapi.TransactionContextInterface, certNumber string, testDataKey string, txTime string) error {
 	cert, err := c.GetCertificate(ctx, certNumber)
 	if err != nil {
 		return err
 	}
 
 	// 使用国密SM3算法计算哈希
-	hashData := fmt.Sprintf("%s|%s|%s", cert.TestDataHash, testDataKey, time.Now().Format(time.RFC3339))
+	hashData := fmt.Sprintf("%s|%s|%s", cert.TestDataHash, testDataKey, txTime)
 	hash := sm3.Sm3Sum([]byte(hashData))
 	cert.TestDataHash = fmt.Sprintf("%x", hash)
-	cert.UpdatedAt = time.Now().Format(time.RFC3339)
+	cert.UpdatedAt = txTime
 
 	certJSON, err := json.Marshal(cert)
 	if err != nil {
@@ -363,6 +625,144 @@ func (c *CertChaincode) GetCertificateHistory(ctx contractapi.TransactionContext
 	return results, nil
 }
 
+// AnchorTraceability 锚定证书的溯源链，证书及其引用的上级证书必须已在账本上且未撤销，
+// 重复锚定时覆盖之前的记录，历史可通过账本历史查询
+func (c *CertChaincode) AnchorTraceability(ctx contractapi.TransactionContextInterface, anchorData string) (string, error) {
+	var anchor TraceabilityAnchor
+	if err := json.Unmarshal([]byte(anchorData), &anchor); err != nil {
+		return "", fmt.Errorf("溯源数据解析失败: %v", err)
+	}
+	if anchor.ChainHash == "" {
+		return "", fmt.Errorf("溯源链哈希不能为空")
+	}
+	if _, err := c.GetCertificate(ctx, anchor.CertNumber); err != nil {
+		return "", err
+	}
+
+	for _, link := range anchor.Upstream {
+		if link.CalibrationCertNumber == "" {
+			continue
+		}
+		if link.CalibrationCertNumber == anchor.CertNumber {
+			return "", fmt.Errorf("证书 %s 不能作为自身的上级证书", anchor.CertNumber)
+		}
+		upstream, err := c.GetCertificate(ctx, link.CalibrationCertNumber)
+		if err != nil {
+			return "", fmt.Errorf("设备 %s 的上级证书未上链: %v", link.DeviceAddr, err)
+		}
+		if upstream.Status == "revoked" {
+			return "", fmt.Errorf("设备 %s 的上级证书 %s 已撤销", link.DeviceAddr, upstream.CertNumber)
+		}
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(traceabilityObjectType, []string{anchor.CertNumber})
+	if err != nil {
+		return "", err
+	}
+	txID := ctx.GetStub().GetTxID()
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	anchor.TxID = txID
+	anchor.AnchoredAt = time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(time.RFC3339)
+
+	anchorJSON, err := json.Marshal(anchor)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.GetStub().PutState(key, anchorJSON); err != nil {
+		return "", err
+	}
+	return txID, nil
+}
+
+// GetTraceabilityAnchor 获取证书的溯源链锚定记录，未锚定时返回空
+func (c *CertChaincode) GetTraceabilityAnchor(ctx contractapi.TransactionContextInterface, certNumber string) (*TraceabilityAnchor, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(traceabilityObjectType, []string{certNumber})
+	if err != nil {
+		return nil, err
+	}
+	anchorJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("读取溯源记录失败: %v", err)
+	}
+	if anchorJSON == nil {
+		return nil, nil
+	}
+
+	var anchor TraceabilityAnchor
+	if err := json.Unmarshal(anchorJSON, &anchor); err != nil {
+		return nil, err
+	}
+	return &anchor, nil
+}
+
+// RegisterDeviceKey 登记或更换设备签名公钥，返回交易ID。只有实验室组织的身份可以调用，
+// 否则任何通道成员都能替换设备公钥，使伪造的测试数据通过签名校验
+func (c *CertChaincode) RegisterDeviceKey(ctx contractapi.TransactionContextInterface, keyData string) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("读取调用者身份失败: %v", err)
+	}
+	if !deviceKeyRegistrarMSPs[mspID] {
+		return "", fmt.Errorf("组织 %s 无权登记设备公钥", mspID)
+	}
+
+	var key DeviceKey
+	if err := json.Unmarshal([]byte(keyData), &key); err != nil {
+		return "", fmt.Errorf("设备公钥解析失败: %v", err)
+	}
+	if key.DeviceAddr == "" {
+		return "", fmt.Errorf("设备地址不能为空")
+	}
+	if _, err := parsePublicKey(key.Algorithm, key.PublicKey); err != nil {
+		return "", fmt.Errorf("设备 %s 的公钥无效: %v", key.DeviceAddr, err)
+	}
+
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(deviceKeyObjectType, []string{key.DeviceAddr})
+	if err != nil {
+		return "", err
+	}
+	txID := ctx.GetStub().GetTxID()
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", err
+	}
+	key.TxID = txID
+	key.RegisteredAt = time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC().Format(time.RFC3339)
+
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.GetStub().PutState(compositeKey, keyJSON); err != nil {
+		return "", err
+	}
+	return txID, nil
+}
+
+// GetDeviceKey 获取设备登记的签名公钥，未登记时返回空
+func (c *CertChaincode) GetDeviceKey(ctx contractapi.TransactionContextInterface, deviceAddr string) (*DeviceKey, error) {
+	compositeKey, err := ctx.GetStub().CreateCompositeKey(deviceKeyObjectType, []string{deviceAddr})
+	if err != nil {
+		return nil, err
+	}
+	keyJSON, err := ctx.GetStub().GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("读取设备公钥失败: %v", err)
+	}
+	if keyJSON == nil {
+		return nil, nil
+	}
+
+	var key DeviceKey
+	if err := json.Unmarshal(keyJSON, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
 func main() {
 	chaincode, err := contractapi.NewChaincode(&CertChaincode{})
 	if err != nil {
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testTxTime 测试交易的时间戳，链码写入的时间须取自交易而不是节点本地时钟
+var testTxTime = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+// testLedger 基于 MockStub 的测试账本，每次调用链码时开始一笔新交易
+type testLedger struct {
+	t     *testing.T
+	stub  *shimtest.MockStub
+	txSeq int
+}
+
+func newTestLedger(t *testing.T) *testLedger {
+	return &testLedger{t: t, stub: shimtest.NewMockStub("cert-chaincode", nil)}
+}
+
+// invoke 以 mspID 组织的身份在一笔新交易中执行 fn
+func (l *testLedger) invoke(mspID string, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	l.t.Helper()
+	l.txSeq++
+	txID := fmt.Sprintf("tx-%d", l.txSeq)
+	l.stub.MockTransactionStart(txID)
+	defer l.stub.MockTransactionEnd(txID)
+	l.stub.TxTimestamp = timestamppb.New(testTxTime.Add(time.Duration(l.txSeq) * time.Minute))
+	l.stub.Creator = serializedIdentity(l.t, mspID)
+
+	identity, err := cid.New(l.stub)
+	if err != nil {
+		l.t.Fatalf("解析调用者身份失败: %v", err)
+	}
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(l.stub)
+	ctx.SetClientIdentity(identity)
+	return fn(ctx)
+}
+
+// certificate 读取账本上的证书
+func (l *testLedger) certificate(certNumber string) *Certificate {
+	l.t.Helper()
+	var cert *Certificate
+	err := l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		cert, err = (&CertChaincode{}).GetCertificate(ctx, certNumber)
+		return err
+	})
+	if err != nil {
+		l.t.Fatalf("读取证书失败: %v", err)
+	}
+	return cert
+}
+
+// traceabilityAnchor 读取账本上证书的溯源锚定记录，未锚定时返回 nil
+func (l *testLedger) traceabilityAnchor(certNumber string) *TraceabilityAnchor {
+	l.t.Helper()
+	var anchor *TraceabilityAnchor
+	err := l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		anchor, err = (&CertChaincode{}).GetTraceabilityAnchor(ctx, certNumber)
+		return err
+	})
+	if err != nil {
+		l.t.Fatalf("读取溯源锚定记录失败: %v", err)
+	}
+	return anchor
+}
+
+// serializedIdentity 生成 mspID 组织下自签名证书的序列化身份
+func serializedIdentity(t *testing.T, mspID string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成身份密钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user@" + mspID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成身份证书失败: %v", err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatalf("序列化身份失败: %v", err)
+	}
+	return creator
+}
+
+// mustJSON 将值序列化为链码参数
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+	return string(data)
+}
+
+// newDeviceKey 生成设备签名密钥和待登记的公钥
+func newDeviceKey(t *testing.T, deviceAddr string) (*ecdsa.PrivateKey, *DeviceKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成设备密钥失败: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("编码设备公钥失败: %v", err)
+	}
+	return priv, &DeviceKey{
+		DeviceAddr: deviceAddr,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Algorithm:  signatureAlgorithmECDSA,
+	}
+}
+
+// newTestReading 构造证书在额定 100% 点的读数，key 不为 nil 时按规范化载荷签名
+func newTestReading(t *testing.T, certNumber, deviceAddr string, recordID int64, key *ecdsa.PrivateKey) *TestData {
+	t.Helper()
+	data := &TestData{
+		CertNumber:       certNumber,
+		DeviceAddr:       deviceAddr,
+		DataType:         "current",
+		TestPoint:        "100%",
+		PercentageValue:  100,
+		ActualPercentage: 100.02,
+		RatioError:       0.05,
+		AngleError:       2,
+		CurrentValue:     5,
+		TestTimestamp:    "2024-05-01T07:30:00Z",
+		RecordID:         recordID,
+	}
+	if key != nil {
+		signTestReading(t, data, key)
+	}
+	return data
+}
+
+// signTestReading 以设备密钥对读数的规范化载荷签名
+func signTestReading(t *testing.T, data *TestData, key *ecdsa.PrivateKey) {
+	t.Helper()
+	payload, err := canonicalTestDataPayload(data)
+	if err != nil {
+		t.Fatalf("构造签名载荷失败: %v", err)
+	}
+	digest := sha256.Sum256([]byte(payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+	data.Signature = base64.StdEncoding.EncodeToString(sig)
+}
+
+// setupCertificate 以实验室组织身份创建证书
+func setupCertificate(t *testing.T, l *testLedger, certNumber string) {
+	t.Helper()
+	err := l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+		_, err := (&CertChaincode{}).CreateCertificate(ctx, mustJSON(t, &Certificate{CertNumber: certNumber, InstrumentName: "电流互感器", Version: 1}))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+}
+
+// registerDeviceKey 以 mspID 组织身份登记设备公钥
+func registerDeviceKey(t *testing.T, l *testLedger, mspID string, key *DeviceKey) error {
+	t.Helper()
+	return l.invoke(mspID, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := (&CertChaincode{}).RegisterDeviceKey(ctx, mustJSON(t, key))
+		return err
+	})
+}
+
+// addTestData 以实验室组织身份提交测试数据
+func addTestData(t *testing.T, l *testLedger, data *TestData) error {
+	t.Helper()
+	return l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+		return (&CertChaincode{}).AddTestData(ctx, mustJSON(t, data))
+	})
+}
+
+func TestRegisterDeviceKeyRequiresLabOrg(t *testing.T) {
+	l := newTestLedger(t)
+	_, original := newDeviceKey(t, "dev-1")
+	if err := registerDeviceKey(t, l, "Org1MSP", original); err != nil {
+		t.Fatalf("实验室组织登记设备公钥失败: %v", err)
+	}
+
+	// 其他组织不能替换设备公钥
+	_, forged := newDeviceKey(t, "dev-1")
+	err := registerDeviceKey(t, l, "Org2MSP", forged)
+	if err == nil || !strings.Contains(err.Error(), "无权登记设备公钥") {
+		t.Fatalf("期望其他组织无权登记，实际 %v", err)
+	}
+
+	var stored *DeviceKey
+	err = l.invoke("Org2MSP", func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		stored, err = (&CertChaincode{}).GetDeviceKey(ctx, "dev-1")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("读取设备公钥失败: %v", err)
+	}
+	if stored == nil || stored.PublicKey != original.PublicKey || stored.TxID != "tx-1" {
+		t.Fatalf("设备公钥不应被其他组织替换: %+v", stored)
+	}
+	if stored.RegisteredAt != testTxTime.Add(time.Minute).Format(time.RFC3339) {
+		t.Fatalf("登记时间应取自交易时间，实际 %s", stored.RegisteredAt)
+	}
+}
+
+func TestAddTestDataVerifiesSignature(t *testing.T) {
+	l := newTestLedger(t)
+	setupCertificate(t, l, "CT-C-001")
+	deviceKey, registered := newDeviceKey(t, "dev-1")
+	if err := registerDeviceKey(t, l, "Org1MSP", registered); err != nil {
+		t.Fatalf("登记设备公钥失败: %v", err)
+	}
+	otherKey, _ := newDeviceKey(t, "dev-1")
+
+	tests := []struct {
+		name    string
+		data    *TestData
+		wantErr string
+	}{
+		{name: "有效签名", data: newTestReading(t, "CT-C-001", "dev-1", 1, deviceKey)},
+		{name: "其他密钥签名", data: newTestReading(t, "CT-C-001", "dev-1", 2, otherKey), wantErr: "设备签名无效"},
+		{name: "签名后篡改读数", data: func() *TestData {
+			data := newTestReading(t, "CT-C-001", "dev-1", 3, deviceKey)
+			data.RatioError = 0.01
+			return data
+		}(), wantErr: "设备签名无效"},
+		{name: "签名不是 base64", data: func() *TestData {
+			data := newTestReading(t, "CT-C-001", "dev-1", 4, nil)
+			data.Signature = "!!"
+			return data
+		}(), wantErr: "设备签名无效"},
+		{name: "登记了公钥但未签名", data: newTestReading(t, "CT-C-001", "dev-1", 5, nil), wantErr: "须附带设备签名"},
+		{name: "未登记公钥的设备附带签名", data: newTestReading(t, "CT-C-001", "dev-2", 6, deviceKey), wantErr: "未登记公钥"},
+		{name: "未登记公钥的设备不签名", data: newTestReading(t, "CT-C-001", "dev-2", 7, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := addTestData(t, l, tt.data)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("期望上链成功，实际 %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("期望错误包含 %q，实际 %v", tt.wantErr, err)
+			}
+			// 被拒绝的读数不写入账本
+			key := fmt.Sprintf("TESTDATA_%s_%d", tt.data.CertNumber, tt.data.RecordID)
+			if stored := l.stub.State[key]; (stored != nil) != (tt.wantErr == "") {
+				t.Fatalf("账本记录 %s 与校验结果不符", key)
+			}
+		})
+	}
+}
+
+func TestUpdateCertificateVersion(t *testing.T) {
+	l := newTestLedger(t)
+	setupCertificate(t, l, "CT-C-002")
+	created := l.certificate("CT-C-002")
+	if created.CreatedAt != testTxTime.Add(time.Minute).Format(time.RFC3339) || created.UpdatedAt != created.CreatedAt {
+		t.Fatalf("创建时间应取自交易时间: %+v", created)
+	}
+
+	update := func(version int64, certNumber string) error {
+		return l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+			cert := &Certificate{CertNumber: certNumber, InstrumentName: "电压互感器", Status: "testing", Version: version}
+			return (&CertChaincode{}).UpdateCertificate(ctx, "CT-C-002", mustJSON(t, cert))
+		})
+	}
+
+	tests := []struct {
+		name        string
+		version     int64
+		certNumber  string
+		wantErr     string
+		wantVersion int64
+	}{
+		{name: "下一版本", version: 2, certNumber: "CT-C-002", wantVersion: 2},
+		{name: "重复提交同一版本", version: 2, certNumber: "CT-C-002", wantErr: "版本冲突", wantVersion: 2},
+		{name: "过期版本", version: 1, certNumber: "CT-C-002", wantErr: "版本冲突", wantVersion: 2},
+		{name: "跳过版本", version: 4, certNumber: "CT-C-002", wantErr: "版本冲突", wantVersion: 2},
+		{name: "修改证书编号", version: 3, certNumber: "CT-C-999", wantErr: "不能修改证书编号", wantVersion: 2},
+		{name: "省略证书编号", version: 3, wantVersion: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := update(tt.version, tt.certNumber)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("期望更新成功，实际 %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("期望错误包含 %q，实际 %v", tt.wantErr, err)
+			}
+			if cert := l.certificate("CT-C-002"); cert.Version != tt.wantVersion {
+				t.Fatalf("期望账本版本 %d，实际 %d", tt.wantVersion, cert.Version)
+			}
+		})
+	}
+
+	cert := l.certificate("CT-C-002")
+	if cert.CertNumber != "CT-C-002" || cert.CreatedAt != created.CreatedAt || cert.BlockchainTxID != created.BlockchainTxID {
+		t.Fatalf("链码维护的字段不应被业务更新覆盖: %+v", cert)
+	}
+	if _, err := time.Parse(time.RFC3339, cert.UpdatedAt); err != nil || cert.UpdatedAt == created.UpdatedAt {
+		t.Fatalf("更新时间应取自更新交易的时间，实际 %s", cert.UpdatedAt)
+	}
+}
+
+func TestAddTestDataDuplicateRecord(t *testing.T) {
+	l := newTestLedger(t)
+	setupCertificate(t, l, "CT-C-003")
+
+	data := newTestReading(t, "CT-C-003", "dev-2", 1, nil)
+	if err := addTestData(t, l, data); err != nil {
+		t.Fatalf("上链失败: %v", err)
+	}
+	hash := l.certificate("CT-C-003").TestDataHash
+	if hash == "" {
+		t.Fatal("上链后应更新证书的测试数据哈希")
+	}
+
+	// 应用层重试时重复提交相同记录，视为已上链且不重复计入哈希
+	if err := addTestData(t, l, newTestReading(t, "CT-C-003", "dev-2", 1, nil)); err != nil {
+		t.Fatalf("重复提交相同记录应成功，实际 %v", err)
+	}
+	if got := l.certificate("CT-C-003").TestDataHash; got != hash {
+		t.Fatalf("重复提交不应改变测试数据哈希: %s -> %s", hash, got)
+	}
+
+	changed := newTestReading(t, "CT-C-003", "dev-2", 1, nil)
+	changed.RatioError = 0.2
+	if err := addTestData(t, l, changed); err == nil || !strings.Contains(err.Error(), "内容不同") {
+		t.Fatalf("同一记录提交不同内容应被拒绝，实际 %v", err)
+	}
+
+	if err := addTestData(t, l, newTestReading(t, "CT-C-003", "dev-2", 0, nil)); err == nil || !strings.Contains(err.Error(), "缺少记录ID") {
+		t.Fatalf("缺少记录ID应被拒绝，实际 %v", err)
+	}
+
+	// 新记录正常上链并计入哈希
+	if err := addTestData(t, l, newTestReading(t, "CT-C-003", "dev-2", 2, nil)); err != nil {
+		t.Fatalf("上链失败: %v", err)
+	}
+	if got := l.certificate("CT-C-003").TestDataHash; got == hash {
+		t.Fatal("新记录上链后应更新测试数据哈希")
+	}
+}
+
+func TestAnchorTraceability(t *testing.T) {
+	l := newTestLedger(t)
+	setupCertificate(t, l, "CT-C-004")
+	setupCertificate(t, l, "CAL-C-001")
+	setupCertificate(t, l, "CAL-C-002")
+	err := l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+		cert := &Certificate{CertNumber: "CAL-C-002", InstrumentName: "电流互感器", Status: "revoked", Version: 2}
+		return (&CertChaincode{}).UpdateCertificate(ctx, "CAL-C-002", mustJSON(t, cert))
+	})
+	if err != nil {
+		t.Fatalf("撤销证书失败: %v", err)
+	}
+
+	anchor := func(a *TraceabilityAnchor) (string, error) {
+		var txID string
+		err := l.invoke("Org1MSP", func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			txID, err = (&CertChaincode{}).AnchorTraceability(ctx, mustJSON(t, a))
+			return err
+		})
+		return txID, err
+	}
+
+	tests := []struct {
+		name     string
+		anchor   *TraceabilityAnchor
+		wantErr  string
+		wantHash string
+	}{
+		{name: "缺少溯源链哈希", anchor: &TraceabilityAnchor{CertNumber: "CT-C-004"}, wantErr: "溯源链哈希不能为空"},
+		{name: "证书未上链", anchor: &TraceabilityAnchor{CertNumber: "CT-C-404", ChainHash: "h1"}, wantErr: "CT-C-404"},
+		{
+			name:    "上级证书未上链",
+			anchor:  &TraceabilityAnchor{CertNumber: "CT-C-004", ChainHash: "h1", Upstream: []*TraceabilityLink{{DeviceAddr: "dev-1", CalibrationCertNumber: "CAL-C-404"}}},
+			wantErr: "上级证书未上链",
+		},
+		{
+			name:    "上级证书已撤销",
+			anchor:  &TraceabilityAnchor{CertNumber: "CT-C-004", ChainHash: "h1", Upstream: []*TraceabilityLink{{DeviceAddr: "dev-1", CalibrationCertNumber: "CAL-C-002"}}},
+			wantErr: "已撤销",
+		},
+		{
+			name:    "引用自身",
+			anchor:  &TraceabilityAnchor{CertNumber: "CT-C-004", ChainHash: "h1", Upstream: []*TraceabilityLink{{DeviceAddr: "dev-1", CalibrationCertNumber: "CT-C-004"}}},
+			wantErr: "不能作为自身的上级证书",
+		},
+		{
+			name: "锚定成功",
+			anchor: &TraceabilityAnchor{CertNumber: "CT-C-004", ChainHash: "h1", Upstream: []*TraceabilityLink{
+				{DeviceAddr: "dev-1", CalibrationCertNumber: "CAL-C-001"},
+				{DeviceAddr: "dev-2", CalibrationExternalRef: "省计量院 JZ-0001"},
+			}},
+			wantHash: "h1",
+		},
+		{name: "重新锚定覆盖之前的记录", anchor: &TraceabilityAnchor{CertNumber: "CT-C-004", ChainHash: "h2"}, wantHash: "h2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := l.traceabilityAnchor("CT-C-004")
+			txID, err := anchor(tt.anchor)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("期望错误包含 %q，实际 %v", tt.wantErr, err)
+				}
+				if after := l.traceabilityAnchor("CT-C-004"); mustJSON(t, after) != mustJSON(t, before) {
+					t.Fatalf("锚定失败时不应修改记录: %+v", after)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("锚定失败: %v", err)
+			}
+			stored := l.traceabilityAnchor("CT-C-004")
+			if stored == nil || stored.ChainHash != tt.wantHash || stored.TxID != txID || len(stored.Upstream) != len(tt.anchor.Upstream) {
+				t.Fatalf("锚定记录不符: %+v", stored)
+			}
+			if stored.AnchoredAt != testTxTime.Add(time.Duration(l.txSeq-1)*time.Minute).Format(time.RFC3339) {
+				t.Fatalf("锚定时间应取自交易时间，实际 %s", stored.AnchoredAt)
+			}
+		})
+	}
+}
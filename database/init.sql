@@ -8,12 +8,103 @@ USE cert_system;
 CREATE TABLE IF NOT EXISTS users (
     id BIGINT PRIMARY KEY AUTO_INCREMENT,
     username VARCHAR(50) UNIQUE NOT NULL COMMENT '用户名',
-    password_hash VARCHAR(128) NOT NULL COMMENT '密码哈希',
+    password_hash VARCHAR(255) NOT NULL COMMENT '密码哈希（自描述格式，如 $argon2id$...）',
     role ENUM('admin', 'operator', 'viewer') DEFAULT 'viewer' COMMENT '用户角色',
+    status ENUM('active', 'disabled') DEFAULT 'active' COMMENT '账号状态',
+    must_change_password BOOLEAN NOT NULL DEFAULT FALSE COMMENT '下次登录必须修改密码',
+    password_changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '密码最后修改时间',
+    failed_login_count INT NOT NULL DEFAULT 0 COMMENT '连续登录失败次数',
+    last_failed_login_at TIMESTAMP NULL COMMENT '最近一次登录失败时间',
+    locked_until TIMESTAMP NULL COMMENT '锁定截止时间',
+    totp_secret VARCHAR(64) NULL COMMENT 'TOTP 密钥（base32），启用前为待确认状态',
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE COMMENT '是否已启用两步验证',
+    totp_last_counter BIGINT NOT NULL DEFAULT 0 COMMENT '最近一次使用的 TOTP 时间步，防止验证码重放',
+    auth_provider VARCHAR(20) NOT NULL DEFAULT 'local' COMMENT '认证方式: local / ldap / oidc',
+    external_id VARCHAR(255) NULL COMMENT '外部身份源中的唯一标识（LDAP DN / OIDC sub）',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
 
+-- 密码历史表（用于禁止重复使用最近的密码）
+CREATE TABLE IF NOT EXISTS password_history (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    password_hash VARCHAR(255) NOT NULL COMMENT '历史密码哈希',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 登录会话表（注销或检测到刷新令牌重放时吊销，访问令牌随之失效）
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id VARCHAR(64) PRIMARY KEY COMMENT '会话ID（访问令牌中的 sid）',
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    ip VARCHAR(64) COMMENT '登录IP',
+    user_agent VARCHAR(255) COMMENT '登录 User-Agent',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL COMMENT '最近一次刷新时间',
+    expires_at TIMESTAMP NULL COMMENT '会话过期时间',
+    revoked_at TIMESTAMP NULL COMMENT '吊销时间',
+    revoke_reason VARCHAR(50) COMMENT '吊销原因',
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 刷新令牌表（只保存哈希，每次刷新轮换，同一会话内的令牌构成一个令牌族）
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    session_id VARCHAR(64) NOT NULL COMMENT '所属会话ID',
+    token_hash CHAR(64) NOT NULL UNIQUE COMMENT '刷新令牌 SHA-256 哈希',
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    used_at TIMESTAMP NULL COMMENT '已用于刷新的时间，再次使用视为重放',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES auth_sessions(id) ON DELETE CASCADE
+);
+
+-- 两步验证恢复码表（只保存哈希，每个恢复码只能使用一次）
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    code_hash CHAR(64) NOT NULL COMMENT '恢复码 SHA-256 哈希',
+    used_at TIMESTAMP NULL COMMENT '使用时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 登录挑战表（密码校验通过后等待第二步验证）
+CREATE TABLE IF NOT EXISTS login_challenges (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    token_hash CHAR(64) NOT NULL UNIQUE COMMENT '挑战令牌 SHA-256 哈希',
+    user_id BIGINT NOT NULL COMMENT '用户ID',
+    ip VARCHAR(64) COMMENT '登录IP',
+    user_agent VARCHAR(255) COMMENT '登录 User-Agent',
+    attempts INT NOT NULL DEFAULT 0 COMMENT '已尝试次数',
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- 外部身份认证授权请求表（跳转到身份提供方期间保存 state、PKCE 校验码和 nonce）
+CREATE TABLE IF NOT EXISTS sso_login_states (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    state_hash CHAR(64) NOT NULL UNIQUE COMMENT 'state 参数 SHA-256 哈希',
+    provider VARCHAR(20) NOT NULL COMMENT '认证提供方',
+    code_verifier VARCHAR(128) NOT NULL COMMENT 'PKCE 校验码',
+    nonce VARCHAR(64) NOT NULL COMMENT 'ID Token nonce',
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- 登录审计表（记录成功和失败的登录尝试）
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    username VARCHAR(100) NOT NULL COMMENT '登录时提交的用户名',
+    user_id BIGINT NULL COMMENT '匹配到的用户ID',
+    ip VARCHAR(64) NOT NULL COMMENT '客户端IP',
+    user_agent VARCHAR(255) COMMENT '客户端 User-Agent',
+    success BOOLEAN NOT NULL COMMENT '是否成功',
+    reason VARCHAR(50) COMMENT '失败原因',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 -- 设备信息表
 CREATE TABLE IF NOT EXISTS devices (
     id BIGINT PRIMARY KEY AUTO_INCREMENT,
@@ -22,10 +113,33 @@ CREATE TABLE IF NOT EXISTS devices (
     manufacturer VARCHAR(100) COMMENT '制造厂商',
     model VARCHAR(100) COMMENT '型号规格',
     accuracy_class VARCHAR(50) COMMENT '准确度等级',
+    status VARCHAR(20) NOT NULL DEFAULT 'active' COMMENT '设备状态: active, maintenance, retired',
+    calibration_cert_number VARCHAR(100) NULL COMMENT '本系统签发的设备校准证书编号',
+    calibration_external_ref VARCHAR(200) COMMENT '外部机构出具的设备校准证书',
+    calibration_due_date DATE NULL COMMENT '校准有效期截止日',
+    public_key TEXT COMMENT '设备签名公钥（PEM），登记后测试数据须附带签名',
+    key_algorithm VARCHAR(20) COMMENT '签名算法: ECDSA-P256-SHA256, SM2-SM3',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
 
+-- API Key 表（测试工位等机器客户端使用，只保存哈希）
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(100) NOT NULL COMMENT '名称',
+    key_prefix VARCHAR(16) NOT NULL COMMENT '明文前缀，用于识别',
+    key_hash CHAR(64) NOT NULL UNIQUE COMMENT 'API Key SHA-256 哈希',
+    permissions VARCHAR(500) NOT NULL COMMENT '授予的权限，逗号分隔',
+    device_addr VARCHAR(100) NULL COMMENT '绑定的设备地址，绑定后只能提交该设备的测试数据',
+    created_by BIGINT NOT NULL COMMENT '创建人ID',
+    expires_at TIMESTAMP NULL COMMENT '过期时间，NULL 表示不过期',
+    last_used_at TIMESTAMP NULL COMMENT '最近使用时间',
+    last_used_ip VARCHAR(64) COMMENT '最近使用IP',
+    revoked_at TIMESTAMP NULL COMMENT '吊销时间',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
 -- 委托方信息表
 CREATE TABLE IF NOT EXISTS customers (
     id BIGINT PRIMARY KEY AUTO_INCREMENT,
@@ -52,7 +166,10 @@ CREATE TABLE IF NOT EXISTS certificates (
     test_result ENUM('qualified', 'unqualified') DEFAULT 'qualified' COMMENT '检测结果',
     blockchain_tx_id VARCHAR(128) COMMENT '区块链交易ID',
     blockchain_hash VARCHAR(256) COMMENT '区块链哈希值',
+    uncertainty_budget JSON COMMENT '测量不确定度评定结果',
+    uncertainty_stale BOOLEAN NOT NULL DEFAULT FALSE COMMENT '评定后又新增了测试数据，需重新评定',
     status ENUM('draft', 'testing', 'completed', 'issued', 'revoked') DEFAULT 'draft' COMMENT '证书状态',
+    version INT NOT NULL DEFAULT 1 COMMENT '版本号（乐观锁）',
     created_by BIGINT COMMENT '创建人ID',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
@@ -76,8 +193,17 @@ CREATE TABLE IF NOT EXISTS test_data (
     actual_percentage DECIMAL(10,6) COMMENT '实际值',
     test_timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '测试时间',
     blockchain_hash VARCHAR(128) COMMENT '区块链哈希',
+    calibration_cert_number VARCHAR(100) NULL COMMENT '上传时设备的本系统校准证书编号',
+    calibration_external_ref VARCHAR(200) COMMENT '上传时设备的外部校准证书',
+    signature VARCHAR(256) COMMENT '设备对规范化读数的签名（base64）',
+    signature_algorithm VARCHAR(20) COMMENT '签名算法',
+    signer_public_key TEXT COMMENT '签名时设备登记的公钥快照',
+    ledger_status ENUM('none', 'pending', 'confirmed', 'failed') NOT NULL DEFAULT 'none' COMMENT '上链状态',
+    ledger_attempts INT NOT NULL DEFAULT 0 COMMENT '上链尝试次数',
+    ledger_error VARCHAR(500) COMMENT '最近一次上链失败的原因',
     encrypted_data TEXT COMMENT '国密加密后的敏感数据',
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_test_data_ledger_status (ledger_status),
     FOREIGN KEY (cert_id) REFERENCES certificates(id) ON DELETE CASCADE
 );
 
@@ -104,7 +230,7 @@ CREATE TABLE IF NOT EXISTS certificate_history (
     id BIGINT PRIMARY KEY AUTO_INCREMENT,
     cert_id BIGINT NOT NULL COMMENT '证书ID',
     cert_number VARCHAR(100) NOT NULL COMMENT '证书编号',
-    operation_type ENUM('create', 'update', 'verify', 'issue', 'revoke') COMMENT '操作类型',
+    operation_type ENUM('create', 'update', 'verify', 'issue', 'revoke', 'uncertainty') COMMENT '操作类型',
     changed_fields JSON COMMENT '变更的字段（JSON格式）',
     old_values JSON COMMENT '旧值（JSON格式）',
     new_values JSON COMMENT '新值（JSON格式）',
@@ -115,6 +241,22 @@ CREATE TABLE IF NOT EXISTS certificate_history (
     FOREIGN KEY (operator_id) REFERENCES users(id)
 );
 
+-- 幂等键记录表（用于重放重试请求的首次响应）
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    idem_key VARCHAR(255) NOT NULL COMMENT '客户端提供的 Idempotency-Key',
+    user_id BIGINT NOT NULL COMMENT '请求用户ID',
+    api_key_id BIGINT NOT NULL DEFAULT 0 COMMENT '请求使用的 API Key ID（0 表示用户令牌）',
+    method VARCHAR(10) NOT NULL COMMENT '请求方法',
+    path VARCHAR(255) NOT NULL COMMENT '请求路径',
+    request_hash VARCHAR(64) NOT NULL COMMENT '请求体哈希',
+    status_code INT NOT NULL DEFAULT 0 COMMENT '首次响应状态码（0 表示处理中）',
+    response_body MEDIUMTEXT COMMENT '首次响应内容',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL COMMENT '过期时间',
+    UNIQUE KEY uk_idempotency (idem_key, user_id, api_key_id, method, path)
+);
+
 -- 创建索引以提高查询性能
 CREATE INDEX idx_cert_number ON certificates(cert_number);
 CREATE INDEX idx_cert_status ON certificates(status);
@@ -123,16 +265,37 @@ CREATE INDEX idx_cert_expire_date ON certificates(expire_date);
 CREATE INDEX idx_blockchain_tx_id ON certificates(blockchain_tx_id);
 CREATE INDEX idx_blockchain_hash ON certificates(blockchain_hash);
 CREATE INDEX idx_device_addr ON test_data(device_addr);
+CREATE INDEX idx_devices_calibration_cert ON devices(calibration_cert_number);
 CREATE INDEX idx_test_timestamp ON test_data(test_timestamp);
 CREATE INDEX idx_blockchain_tx ON blockchain_transactions(tx_id);
 CREATE INDEX idx_block_number ON blockchain_transactions(block_number);
 CREATE INDEX idx_cert_history ON certificate_history(cert_id, operation_time);
+CREATE INDEX idx_idempotency_expires ON idempotency_keys(expires_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts(ip, created_at);
+CREATE INDEX idx_login_attempts_username ON login_attempts(username, created_at);
+CREATE INDEX idx_auth_sessions_user ON auth_sessions(user_id);
+CREATE UNIQUE INDEX idx_users_external ON users(auth_provider, external_id);
+
+-- 数据库结构版本表（应用启动时执行 application/internal/database/migrations 中未登记的升级脚本）
+-- 本脚本已包含下列升级脚本的全部修改；新增升级脚本时须同步修改上面的表结构并在此登记版本
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version VARCHAR(100) PRIMARY KEY COMMENT '升级脚本版本（文件名）',
+    applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '执行时间'
+);
+
+INSERT INTO schema_migrations (version) VALUES
+('0001_user_accounts'),
+('0002_auth_sessions'),
+('0003_devices_and_api_keys'),
+('0004_certificates'),
+('0005_test_data');
 
 -- 插入初始管理员用户（密码：admin123，实际使用时应使用强密码）
-INSERT INTO users (username, password_hash, role) VALUES 
-('admin', SHA2('admin123', 256), 'admin'),
-('operator1', SHA2('oper123', 256), 'operator'),
-('viewer1', SHA2('view123', 256), 'viewer');
+-- 初始密码为旧版无盐 SHA-256 格式，首次登录成功后会自动升级为 argon2id，并要求立即修改密码
+INSERT INTO users (username, password_hash, role, must_change_password) VALUES 
+('admin', SHA2('admin123', 256), 'admin', TRUE),
+('operator1', SHA2('oper123', 256), 'operator', TRUE),
+('viewer1', SHA2('view123', 256), 'viewer', TRUE);
 
 -- 插入示例数据（用于测试，可根据需要修改）
 INSERT INTO customers (customer_name, customer_address, contact_person, contact_phone) VALUES 
@@ -140,10 +303,10 @@ INSERT INTO customers (customer_name, customer_address, contact_person, contact_
 ('YY制造企业', '上海市浦东新区YYY街456号', '李四', '13900139002'),
 ('ZZ科技有限公司', '深圳市南山区ZZZ大道789号', '王五', '13700137003');
 
-INSERT INTO devices (device_addr, device_name, manufacturer, model, accuracy_class) VALUES 
-('DEV001', '电流互感器测试装置', 'ABC仪器公司', 'CTT-2000', '0.1级'),
-('DEV002', '电压互感器测试装置', 'XYZ测试设备', 'PTT-1000', '0.2级'),
-('DEV003', '多功能校准仪', 'DEF精密仪器', 'MFC-3000', '0.05级');
+INSERT INTO devices (device_addr, device_name, manufacturer, model, accuracy_class, calibration_external_ref, calibration_due_date) VALUES 
+('DEV001', '电流互感器测试装置', 'ABC仪器公司', 'CTT-2000', '0.1级', '国家高电压计量站 GDJ2026-0101', '2027-06-30'),
+('DEV002', '电压互感器测试装置', 'XYZ测试设备', 'PTT-1000', '0.2级', '国家高电压计量站 GDJ2026-0102', '2027-06-30'),
+('DEV003', '多功能校准仪', 'DEF精密仪器', 'MFC-3000', '0.05级', '中国计量科学研究院 NIM2026-0303', '2027-06-30');
 
 -- 插入示例证书数据（包含区块链信息）
 INSERT INTO certificates (
@@ -1,8 +1,69 @@
 // API配置
 const API_BASE_URL = 'http://192.168.85.129:8080/api/v1';
 let authToken = localStorage.getItem('authToken');
+let refreshToken = localStorage.getItem('refreshToken');
+let refreshPromise = null; // 正在进行的令牌刷新，避免并发请求重复刷新
 let currentUser = null;
 let testDataRowCount = 1;
+let editingCertVersion = null; // 正在编辑的证书版本号，用于 If-Match
+
+// 访问令牌有效期较短，接口返回401时使用刷新令牌换取新令牌后重试一次
+const nativeFetch = window.fetch.bind(window);
+window.fetch = async function(url, options = {}) {
+    const response = await nativeFetch(url, options);
+    const path = String(url);
+    if (response.status !== 401 || !refreshToken || !path.startsWith(API_BASE_URL) ||
+        path.endsWith('/auth/login') || path.endsWith('/auth/login/2fa') || path.endsWith('/auth/refresh') || path.endsWith('/auth/logout')) {
+        return response;
+    }
+    
+    if (!(await refreshAccessToken())) {
+        return response;
+    }
+    const headers = { ...(options.headers || {}), 'Authorization': `Bearer ${authToken}` };
+    return nativeFetch(url, { ...options, headers });
+};
+
+// 刷新访问令牌，成功返回 true
+async function refreshAccessToken() {
+    if (!refreshPromise) {
+        refreshPromise = (async () => {
+            try {
+                const response = await nativeFetch(`${API_BASE_URL}/auth/refresh`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ refreshToken })
+                });
+                const data = await response.json();
+                if (data.code === 200) {
+                    saveTokens(data.data);
+                    return true;
+                }
+            } catch (error) {
+                console.error('Refresh token error:', error);
+            }
+            clearTokens();
+            return false;
+        })().finally(() => { refreshPromise = null; });
+    }
+    return refreshPromise;
+}
+
+// 保存登录或刷新返回的令牌
+function saveTokens(data) {
+    authToken = data.token;
+    refreshToken = data.refreshToken;
+    localStorage.setItem('authToken', authToken);
+    localStorage.setItem('refreshToken', refreshToken);
+}
+
+// 清除本地令牌
+function clearTokens() {
+    authToken = null;
+    refreshToken = null;
+    localStorage.removeItem('authToken');
+    localStorage.removeItem('refreshToken');
+}
 
 // 页面初始化
 document.addEventListener('DOMContentLoaded', function() {
@@ -11,13 +72,83 @@ document.addEventListener('DOMContentLoaded', function() {
 });
 
 // 初始化应用
-function initializeApp() {
+async function initializeApp() {
+    setupNavigation();
+    loadAuthProviders();
+    if (await handleSSOResult()) {
+        return;
+    }
     if (authToken) {
         validateToken();
     } else {
         showLoginModal();
     }
-    setupNavigation();
+}
+
+// 加载可用的统一身份认证方式，在登录框中显示跳转按钮
+async function loadAuthProviders() {
+    try {
+        const response = await fetch(`${API_BASE_URL}/auth/providers`);
+        const data = await response.json();
+        if (data.code !== 200) {
+            return;
+        }
+        const container = document.getElementById('ssoProviders');
+        container.innerHTML = '';
+        data.data.filter(p => p.redirect).forEach(p => {
+            const button = document.createElement('button');
+            button.type = 'button';
+            button.className = 'btn btn-secondary';
+            button.textContent = `使用${p.displayName}登录`;
+            button.addEventListener('click', () => {
+                window.location.href = `${API_BASE_URL}/auth/sso/${encodeURIComponent(p.name)}/login`;
+            });
+            container.appendChild(button);
+        });
+    } catch (error) {
+        console.error('Load auth providers error:', error);
+    }
+}
+
+// 统一身份认证登录完成后跳转回本页，登录结果在 URL 片段中。已处理时返回 true
+async function handleSSOResult() {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    if (!params.has('token') && !params.has('challengeToken') && !params.has('ssoError')) {
+        return false;
+    }
+    history.replaceState(null, '', window.location.pathname + window.location.search);
+    
+    if (params.has('ssoError')) {
+        showLoginModal();
+        showError('loginError', params.get('ssoError'));
+        return true;
+    }
+    
+    let loginData = {
+        token: params.get('token'),
+        refreshToken: params.get('refreshToken'),
+        twoFactorSetupRequired: params.get('twoFactorSetupRequired') === 'true'
+    };
+    if (params.has('challengeToken')) {
+        const data = await submitTwoFactorCode(params.get('challengeToken'));
+        if (!data || data.code !== 200) {
+            showLoginModal();
+            showError('loginError', data ? (data.message || '登录失败') : '已取消两步验证');
+            return true;
+        }
+        loginData = data.data;
+    }
+    
+    saveTokens(loginData);
+    if (loginData.twoFactorSetupRequired && !(await forceTwoFactorSetup())) {
+        clearTokens();
+        showLoginModal();
+        showError('loginError', '必须启用两步验证后才能继续使用系统');
+        return true;
+    }
+    await validateToken();
+    showNotification('登录成功', 'success');
+    return true;
 }
 
 // 设置事件监听器
@@ -83,16 +214,40 @@ async function handleLogin(e) {
             body: JSON.stringify({ username, password })
         });
         
-        const data = await response.json();
+        let data = await response.json();
+        
+        // 已启用两步验证的账号需要再提交验证码
+        if (data.code === 200 && data.data.twoFactorRequired) {
+            data = await submitTwoFactorCode(data.data.challengeToken);
+            if (!data) {
+                showError('loginError', '已取消两步验证');
+                return;
+            }
+        }
         
         if (data.code === 200) {
-            authToken = data.data.token;
-            localStorage.setItem('authToken', authToken);
+            saveTokens(data.data);
+            
+            // 初始密码或密码过期时必须先修改密码
+            if (data.data.passwordChangeRequired && !(await forcePasswordChange(password))) {
+                clearTokens();
+                showError('loginError', '必须修改密码后才能继续使用系统');
+                return;
+            }
+            
+            // 当前角色要求两步验证但尚未启用时必须先启用
+            if (data.data.twoFactorSetupRequired && !(await forceTwoFactorSetup())) {
+                clearTokens();
+                showError('loginError', '必须启用两步验证后才能继续使用系统');
+                return;
+            }
+            
             currentUser = {
                 id: data.data.userId,
                 username: data.data.username,
                 role: data.data.role
             };
+            await loadPermissions();
             
             document.getElementById('username').textContent = currentUser.username;
             document.getElementById('logoutBtn').style.display = 'block';
@@ -107,6 +262,92 @@ async function handleLogin(e) {
     }
 }
 
+// 强制修改密码，成功后使用新令牌
+async function forcePasswordChange(currentPassword) {
+    while (true) {
+        const newPassword = prompt('首次登录或密码已过期，请设置新密码：');
+        if (!newPassword) {
+            return false;
+        }
+        
+        const response = await fetch(`${API_BASE_URL}/auth/password`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${authToken}`
+            },
+            body: JSON.stringify({ currentPassword, newPassword })
+        });
+        const data = await response.json();
+        
+        if (data.code === 200) {
+            saveTokens(data.data);
+            return true;
+        }
+        alert(data.message || '修改密码失败');
+    }
+}
+
+// 登录第二步：输入验证码或恢复码，返回最终的登录响应，用户取消时返回 null
+async function submitTwoFactorCode(challengeToken) {
+    while (true) {
+        const code = prompt('请输入验证器应用中的6位验证码（或恢复码）：');
+        if (!code) {
+            return null;
+        }
+        
+        const response = await fetch(`${API_BASE_URL}/auth/login/2fa`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ challengeToken, code })
+        });
+        const data = await response.json();
+        
+        // 挑战过期或失败次数过多时需要重新登录
+        if (data.code === 200 || response.status !== 401 || data.message !== '验证码错误') {
+            return data;
+        }
+        alert(data.message);
+    }
+}
+
+// 强制启用两步验证，成功后使用新令牌并展示恢复码
+async function forceTwoFactorSetup() {
+    const enrollResponse = await fetch(`${API_BASE_URL}/auth/2fa/enroll`, {
+        method: 'POST',
+        headers: { 'Authorization': `Bearer ${authToken}` }
+    });
+    const enrollData = await enrollResponse.json();
+    if (enrollData.code !== 200) {
+        alert(enrollData.message || '获取两步验证密钥失败');
+        return false;
+    }
+    
+    while (true) {
+        const code = prompt(`当前账号必须启用两步验证。\n请在验证器应用中添加以下链接（或手动输入密钥 ${enrollData.data.secret}），然后输入6位验证码：\n${enrollData.data.provisioningUri}`);
+        if (!code) {
+            return false;
+        }
+        
+        const response = await fetch(`${API_BASE_URL}/auth/2fa/activate`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${authToken}`
+            },
+            body: JSON.stringify({ code })
+        });
+        const data = await response.json();
+        
+        if (data.code === 200) {
+            saveTokens(data.data);
+            alert('两步验证已启用，请妥善保存以下恢复码（每个只能使用一次）：\n' + data.data.recoveryCodes.join('\n'));
+            return true;
+        }
+        alert(data.message || '启用两步验证失败');
+    }
+}
+
 // 验证Token
 async function validateToken() {
     try {
@@ -119,6 +360,7 @@ async function validateToken() {
         if (response.ok) {
             const data = await response.json();
             currentUser = data.data;
+            applyPermissions();
             document.getElementById('username').textContent = currentUser.username;
             document.getElementById('logoutBtn').style.display = 'block';
             loadDashboard();
@@ -130,6 +372,36 @@ async function validateToken() {
     }
 }
 
+// 获取当前用户的权限
+async function loadPermissions() {
+    try {
+        const response = await fetch(`${API_BASE_URL}/auth/profile`, {
+            headers: {
+                'Authorization': `Bearer ${authToken}`
+            }
+        });
+        const data = await response.json();
+        if (data.code === 200) {
+            currentUser.permissions = data.data.permissions || [];
+        }
+    } catch (error) {
+        console.error('Load permissions error:', error);
+    }
+    applyPermissions();
+}
+
+// 判断当前用户是否拥有指定权限
+function hasPermission(permission) {
+    return !!(currentUser && currentUser.permissions && currentUser.permissions.includes(permission));
+}
+
+// 隐藏当前用户无权使用的操作（data-permission 标注所需权限）
+function applyPermissions() {
+    document.querySelectorAll('[data-permission]').forEach(el => {
+        el.style.display = hasPermission(el.dataset.permission) ? '' : 'none';
+    });
+}
+
 // 登出
 async function handleLogout() {
     try {
@@ -143,8 +415,7 @@ async function handleLogout() {
         console.error('Logout error:', error);
     }
     
-    localStorage.removeItem('authToken');
-    authToken = null;
+    clearTokens();
     currentUser = null;
     document.getElementById('username').textContent = '未登录';
     document.getElementById('logoutBtn').style.display = 'none';
@@ -175,9 +446,14 @@ async function handleCreateCert(e) {
     const testDataArray = [];
     const testDataRows = document.querySelectorAll('.test-data-row');
     testDataRows.forEach((row, index) => {
+        const dataType = formData.get(`dataType_${index}`);
+        const quantityValue = parseFloat(formData.get(`quantityValue_${index}`));
         testDataArray.push({
             deviceAddr: formData.get(`deviceAddr_${index}`),
+            dataType: dataType,
             testPoint: formData.get(`testPoint_${index}`),
+            currentValue: dataType === 'current' ? quantityValue : 0,
+            voltageValue: dataType === 'voltage' ? quantityValue : 0,
             actualPercentage: parseFloat(formData.get(`actualPercentage_${index}`)),
             ratioError: parseFloat(formData.get(`ratioError_${index}`)),
             angleError: parseFloat(formData.get(`angleError_${index}`)),
@@ -250,6 +526,17 @@ function addTestDataRow() {
                 <label>测试点</label>
                 <input type="text" name="testPoint_${testDataRowCount}" placeholder="如: P${testDataRowCount + 1}" required>
             </div>
+            <div class="form-group">
+                <label>数据类型</label>
+                <select name="dataType_${testDataRowCount}" required>
+                    <option value="current">电流互感器</option>
+                    <option value="voltage">电压互感器</option>
+                </select>
+            </div>
+            <div class="form-group">
+                <label>一次电流(A)/电压(V)</label>
+                <input type="number" name="quantityValue_${testDataRowCount}" step="0.001" min="0" required>
+            </div>
             <div class="form-group">
                 <label>实际百分比</label>
                 <input type="number" name="actualPercentage_${testDataRowCount}" step="0.01" required>
@@ -292,6 +579,17 @@ function resetTestDataRows() {
                     <label>测试点</label>
                     <input type="text" name="testPoint_0" placeholder="如: P1" required>
                 </div>
+                <div class="form-group">
+                    <label>数据类型</label>
+                    <select name="dataType_0" required>
+                        <option value="current">电流互感器</option>
+                        <option value="voltage">电压互感器</option>
+                    </select>
+                </div>
+                <div class="form-group">
+                    <label>一次电流(A)/电压(V)</label>
+                    <input type="number" name="quantityValue_0" step="0.001" min="0" required>
+                </div>
                 <div class="form-group">
                     <label>实际百分比</label>
                     <input type="number" name="actualPercentage_0" step="0.01" required>
@@ -314,7 +612,7 @@ function resetTestDataRows() {
 async function viewCertificate(certNumber) {
     try {
         // 获取证书信息
-        const certResponse = await fetch(`${API_BASE_URL}/certificates/${certNumber}`, {
+        const certResponse = await fetch(`${API_BASE_URL}/certificates/${certNumber}?anomalies=1`, {
             headers: {
                 'Authorization': `Bearer ${authToken}`
             }
@@ -338,8 +636,8 @@ async function viewCertificate(certNumber) {
             // 生成证书流程HTML
             const workflowHtml = generateWorkflowHtml(cert.status);
             
-            // 生成测试数据表格
-            let testDataHtml = '<h3>测试数据</h3>';
+            // 生成测试数据表格（新入库的测试数据会实时追加到表格中）
+            let testDataHtml = '<h3>测试数据 <span id="liveTestDataStatus" class="status-badge"></span></h3>';
             if (testData.code === 200 && testData.data && testData.data.length > 0) {
                 testDataHtml += `
                     <table class="data-table">
@@ -353,24 +651,48 @@ async function viewCertificate(certNumber) {
                                 <th>测试时间</th>
                             </tr>
                         </thead>
-                        <tbody>
-                            ${testData.data.map(td => `
-                                <tr>
-                                    <td>${td.deviceAddr}</td>
-                                    <td>${td.testPoint}</td>
-                                    <td>${td.actualPercentage}%</td>
-                                    <td>${td.ratioError}</td>
-                                    <td>${td.angleError}</td>
-                                    <td>${formatDateTime(td.testTimestamp)}</td>
-                                </tr>
-                            `).join('')}
+                        <tbody id="liveTestDataBody">
+                            ${testData.data.map(testDataRowHtml).join('')}
                         </tbody>
                     </table>
                 `;
             } else {
-                testDataHtml += '<p>暂无测试数据</p>';
+                testDataHtml += `
+                    <p id="liveTestDataEmpty">暂无测试数据</p>
+                    <table class="data-table">
+                        <tbody id="liveTestDataBody"></tbody>
+                    </table>
+                `;
             }
             
+            // 测试数据异常标记（仅有权查看测试数据时返回）
+            const anomalies = certData.data.anomalies || [];
+            const anomalyHtml = anomalies.length > 0 ? `
+                <h3>测试数据异常 (${anomalies.length})</h3>
+                <table class="data-table">
+                    <thead>
+                        <tr>
+                            <th>类型</th>
+                            <th>设备地址</th>
+                            <th>测试点</th>
+                            <th>说明</th>
+                            <th>测试时间</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        ${anomalies.map(a => `
+                            <tr>
+                                <td><span class="status-badge status-testing">${getAnomalyTypeText(a.type)}</span></td>
+                                <td>${a.deviceAddr}</td>
+                                <td>${a.testPoint}</td>
+                                <td>${a.message}</td>
+                                <td>${formatDateTime(a.testTimestamp)}</td>
+                            </tr>
+                        `).join('')}
+                    </tbody>
+                </table>
+            ` : '';
+            
             const detailHtml = `
                 <div class="cert-detail">
                     <h3>证书流程</h3>
@@ -422,6 +744,10 @@ async function viewCertificate(certNumber) {
                     
                     ${testDataHtml}
                     
+                    ${uncertaintyBudgetHtml(cert)}
+                    
+                    ${anomalyHtml}
+                    
                     ${cert.blockchainTxId ? `
                         <h3>区块链信息</h3>
                         <div class="blockchain-info">
@@ -445,12 +771,164 @@ async function viewCertificate(certNumber) {
             
             document.getElementById('certDetailContent').innerHTML = detailHtml;
             showModal('viewCertModal');
+            watchTestData(cert.certNumber);
         }
     } catch (error) {
         showNotification('加载证书详情失败', 'error');
     }
 }
 
+// 测试数据异常类型文本
+function getAnomalyTypeText(type) {
+    const texts = {
+        duplicate: '重复数据',
+        out_of_control: '超出控制限',
+        drift: '持续漂移',
+        impossible_timestamp: '时间异常'
+    };
+    return texts[type] || type;
+}
+
+// 测试数据表格行
+function testDataRowHtml(td) {
+    return `
+        <tr>
+            <td>${td.deviceAddr}</td>
+            <td>${td.testPoint}</td>
+            <td>${td.actualPercentage}%</td>
+            <td>${td.ratioError}</td>
+            <td>${td.angleError}</td>
+            <td>${formatDateTime(td.testTimestamp)}</td>
+        </tr>
+    `;
+}
+
+// 测量不确定度评定表格，未评定时返回空字符串
+function uncertaintyBudgetHtml(cert) {
+    const budget = cert.uncertaintyBudget;
+    if (!budget) {
+        return '';
+    }
+    return `
+        <h3>测量不确定度（${budget.method}，k=${budget.coverageFactor}）</h3>
+        ${cert.uncertaintyStale ? '<p><span class="status-badge status-testing">评定后新增了测试数据，需重新评定</span></p>' : ''}
+        <table class="data-table">
+            <thead>
+                <tr>
+                    <th>测试点</th>
+                    <th>读数次数</th>
+                    <th>比差平均值</th>
+                    <th>比差扩展不确定度 U</th>
+                    <th>角差平均值</th>
+                    <th>角差扩展不确定度 U</th>
+                </tr>
+            </thead>
+            <tbody>
+                ${(budget.points || []).map(p => `
+                    <tr>
+                        <td>${p.testPoint}</td>
+                        <td>${p.readings}</td>
+                        <td>${p.meanRatioError}%</td>
+                        <td>${p.ratioExpanded}%</td>
+                        <td>${p.meanAngleError}′</td>
+                        <td>${p.angleExpanded}′</td>
+                    </tr>
+                `).join('')}
+            </tbody>
+        </table>
+        ${(budget.notes || []).map(note => `<p>${note}</p>`).join('')}
+    `;
+}
+
+// 实时接收证书新入库的测试数据（Server-Sent Events）。
+// EventSource 不能携带 Authorization 头，这里用 fetch 读取事件流
+let testDataWatcher = null;
+
+async function watchTestData(certNumber) {
+    stopWatchingTestData();
+    const controller = new AbortController();
+    testDataWatcher = controller;
+    const status = document.getElementById('liveTestDataStatus');
+
+    try {
+        const response = await fetch(`${API_BASE_URL}/test-data/watch/${encodeURIComponent(certNumber)}`, {
+            headers: {
+                'Authorization': `Bearer ${authToken}`
+            },
+            signal: controller.signal
+        });
+        if (!response.ok || !response.body) {
+            return;
+        }
+
+        const reader = response.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+        while (true) {
+            const { value, done } = await reader.read();
+            if (done) {
+                break;
+            }
+            buffer += decoder.decode(value, { stream: true });
+
+            // 事件之间以空行分隔
+            let boundary;
+            while ((boundary = buffer.indexOf('\n\n')) >= 0) {
+                const block = buffer.slice(0, boundary);
+                buffer = buffer.slice(boundary + 2);
+
+                let eventName = 'message';
+                let data = '';
+                block.split('\n').forEach(line => {
+                    if (line.startsWith('event:')) {
+                        eventName = line.slice(6).trim();
+                    } else if (line.startsWith('data:')) {
+                        data += line.slice(5).trim();
+                    }
+                });
+
+                if (eventName === 'ready' && status) {
+                    status.textContent = '实时';
+                } else if (eventName === 'testData') {
+                    appendLiveTestData(JSON.parse(data));
+                }
+            }
+        }
+    } catch (error) {
+        if (error.name !== 'AbortError') {
+            console.warn('实时测试数据连接中断', error);
+        }
+    } finally {
+        if (testDataWatcher === controller) {
+            testDataWatcher = null;
+        }
+        if (status) {
+            status.textContent = '';
+        }
+    }
+}
+
+// 停止接收实时测试数据
+function stopWatchingTestData() {
+    if (testDataWatcher) {
+        testDataWatcher.abort();
+        testDataWatcher = null;
+    }
+}
+
+// 将实时测试数据追加到证书详情的测试数据表格
+function appendLiveTestData(event) {
+    const body = document.getElementById('liveTestDataBody');
+    if (!body || !event.data) {
+        return;
+    }
+    const empty = document.getElementById('liveTestDataEmpty');
+    if (empty) {
+        empty.remove();
+    }
+    body.insertAdjacentHTML('beforeend', event.data.map(testDataRowHtml).join(''));
+}
+
 // 编辑证书
 async function editCertificate(certNumber) {
     try {
@@ -478,6 +956,7 @@ async function editCertificate(certNumber) {
             document.getElementById('editExpireDate').value = cert.expireDate.split('T')[0];
             document.getElementById('editTestResult').value = cert.testResult;
             document.getElementById('editStatus').value = cert.status;
+            editingCertVersion = cert.version;
             
             closeModal('viewCertModal');
             showModal('editCertModal');
@@ -512,14 +991,17 @@ async function handleEditCert(e) {
             method: 'PUT',
             headers: {
                 'Content-Type': 'application/json',
-                'Authorization': `Bearer ${authToken}`
+                'Authorization': `Bearer ${authToken}`,
+                'If-Match': `"${editingCertVersion}"`
             },
             body: JSON.stringify(certData)
         });
         
         const data = await response.json();
         
-        if (data.code === 200) {
+        if (data.code === 412) {
+            showNotification('证书已被他人修改，请重新打开后再编辑', 'error');
+        } else if (data.code === 200) {
             showNotification('证书更新成功', 'success');
             closeModal('editCertModal');
             loadCertificates();
@@ -859,91 +1341,6 @@ async function handleCreateCert(e) {
     }
 }
 
-// 查看证书详情
-async function viewCertificate(certNumber) {
-    try {
-        const response = await fetch(`${API_BASE_URL}/certificates/${certNumber}`, {
-            headers: {
-                'Authorization': `Bearer ${authToken}`
-            }
-        });
-        
-        const data = await response.json();
-        
-        if (data.code === 200) {
-            const cert = data.data.certificate || data.data;
-            const blockchain = data.data.blockchain;
-            
-            const detailHtml = `
-                <div class="cert-detail">
-                    <h3>基本信息</h3>
-                    <div class="detail-grid">
-                        <div class="detail-item">
-                            <label>证书编号:</label>
-                            <span>${cert.certNumber}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>器具名称:</label>
-                            <span>${cert.instrumentName}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>器具编号:</label>
-                            <span>${cert.instrumentNumber || '-'}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>制造厂:</label>
-                            <span>${cert.manufacturer || '-'}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>型号规格:</label>
-                            <span>${cert.modelSpec || '-'}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>准确度:</label>
-                            <span>${cert.instrumentAccuracy || '-'}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>测试日期:</label>
-                            <span>${formatDate(cert.testDate)}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>有效期至:</label>
-                            <span>${formatDate(cert.expireDate)}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>测试结果:</label>
-                            <span>${cert.testResult === 'qualified' ? '✅ 合格' : '❌ 不合格'}</span>
-                        </div>
-                        <div class="detail-item">
-                            <label>状态:</label>
-                            <span class="status-badge status-${getStatusText(cert.status)}">${getStatusText(cert.status)}</span>
-                        </div>
-                    </div>
-                    
-                    ${cert.blockchainTxId ? `
-                        <h3>区块链信息</h3>
-                        <div class="blockchain-info">
-                            <div class="blockchain-item">
-                                <label>交易ID:</label>
-                                <span class="hash-value">${cert.blockchainTxId}</span>
-                            </div>
-                            <div class="blockchain-item">
-                                <label>区块链哈希:</label>
-                                <span class="hash-value">${cert.blockchainHash || '-'}</span>
-                            </div>
-                        </div>
-                    ` : '<p>该证书尚未上链</p>'}
-                </div>
-            `;
-            
-            document.getElementById('certDetailContent').innerHTML = detailHtml;
-            showModal('viewCertModal');
-        }
-    } catch (error) {
-        showNotification('加载证书详情失败', 'error');
-    }
-}
-
 // 验证证书
 async function verifyCertificate() {
     const certNumber = document.getElementById('verifyCertNumber').value;
@@ -980,6 +1377,7 @@ async function verifyCertificate() {
                         <p><strong>器具名称:</strong> ${result.certificate.instrumentName}</p>
                         <p><strong>有效期至:</strong> ${formatDate(result.certificate.expireDate)}</p>
                         <p><strong>状态:</strong> ${getStatusText(result.certificate.status)}</p>
+                        ${uncertaintyBudgetHtml(result.certificate)}
                     `;
                 }
                 
@@ -1082,6 +1480,9 @@ function showModal(modalId) {
 
 function closeModal(modalId) {
     document.getElementById(modalId).classList.remove('show');
+    if (modalId === 'viewCertModal') {
+        stopWatchingTestData();
+    }
 }
 
 function showCreateCertModal() {
@@ -1223,7 +1624,8 @@ async function revokeCertificate(certNumber) {
             method: 'PUT',
             headers: {
                 'Content-Type': 'application/json',
-                'Authorization': `Bearer ${authToken}`
+                'Authorization': `Bearer ${authToken}`,
+                'If-Match': getResponse.headers.get('ETag') || `"${cert.version}"`
             },
             body: JSON.stringify(cert)
         });
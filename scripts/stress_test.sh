@@ -14,10 +14,10 @@ echo "每用户请求数: $REQUESTS_PER_USER"
 get_token() {
     response=$(curl -s -X POST "$API_BASE/auth/login" \
         -H "Content-Type: application/json" \
-        -d '{
-            "username": "admin",
-            "password": "admin123"
-        }')
+        -d "{
+            \"username\": \"admin\",
+            \"password\": \"${ADMIN_PASSWORD:-admin123}\"
+        }")
     
     echo "$response" | jq -r '.data.token' 2>/dev/null
 }
@@ -3,6 +3,9 @@
 API_URL="http://localhost:8080/api/v1"
 PUBLIC_URL="http://localhost:8080/api/v1/public"
 
+# 管理员账号（初始密码需修改时，通过 NEW_ADMIN_PASSWORD 指定新密码）
+ADMIN_PASSWORD="${ADMIN_PASSWORD:-admin123}"
+
 # 颜色输出
 GREEN='\033[0;32m'
 RED='\033[0;31m'
@@ -38,6 +41,11 @@ run_test() {
     fi
 }
 
+# 获取证书当前版本号，用于 PUT 请求的 If-Match
+cert_version() {
+    curl -s -X GET "$API_URL/certificates/$1" -H "$AUTH_HEADER" | jq -r '.data.certificate.version'
+}
+
 # ========== 1. 健康检查 ==========
 echo -e "\n${BLUE}[1] 健康检查${NC}"
 # 先检查原始响应
@@ -76,9 +84,24 @@ run_test "错误密码应被拒绝" "$WRONG_LOGIN_CODE" "401"
 echo -e "\n---> 测试用户登录"
 LOGIN_RESP=$(curl -s -X POST "$API_URL/auth/login" \
   -H "Content-Type: application/json" \
-  -d '{"username": "admin", "password": "admin123"}')
+  -d "{\"username\": \"admin\", \"password\": \"$ADMIN_PASSWORD\"}")
+
+# 初始密码或密码过期时先修改密码
+if [ "$(echo $LOGIN_RESP | jq -r '.data.passwordChangeRequired')" == "true" ]; then
+    if [ -z "$NEW_ADMIN_PASSWORD" ]; then
+        echo -e "${RED}管理员需要修改密码，请设置 NEW_ADMIN_PASSWORD 后重试${NC}"
+        exit 1
+    fi
+    echo -e "\n---> 修改管理员初始密码"
+    LOGIN_RESP=$(curl -s -X POST "$API_URL/auth/password" \
+      -H "Content-Type: application/json" \
+      -H "Authorization: Bearer $(echo $LOGIN_RESP | jq -r '.data.token')" \
+      -d "{\"currentPassword\": \"$ADMIN_PASSWORD\", \"newPassword\": \"$NEW_ADMIN_PASSWORD\"}")
+    run_test "修改初始密码" "$(echo $LOGIN_RESP | jq -r '.code')" "200"
+fi
 
 TOKEN=$(echo $LOGIN_RESP | jq -r '.data.token')
+REFRESH_TOKEN=$(echo $LOGIN_RESP | jq -r '.data.refreshToken')
 USER_ID=$(echo $LOGIN_RESP | jq -r '.data.userId')
 USERNAME=$(echo $LOGIN_RESP | jq -r '.data.username')
 
@@ -156,6 +179,7 @@ echo -e "\n---> 测试更新证书状态为testing"
 UPDATE_STATUS_RESP=$(curl -s -X PUT "$API_URL/certificates/$CERT_NUMBER" \
   -H "Content-Type: application/json" \
   -H "$AUTH_HEADER" \
+  -H "If-Match: \"$(cert_version $CERT_NUMBER)\"" \
   -d "{
     \"certNumber\": \"$CERT_NUMBER\",
     \"customerId\": 1,
@@ -179,6 +203,7 @@ echo -e "\n---> 测试更新证书信息"
 UPDATE_RESP=$(curl -s -X PUT "$API_URL/certificates/$CERT_NUMBER" \
   -H "Content-Type: application/json" \
   -H "$AUTH_HEADER" \
+  -H "If-Match: \"$(cert_version $CERT_NUMBER)\"" \
   -d "{
     \"certNumber\": \"$UPDATED_CERT_NUMBER\",
     \"customerId\": 1,
@@ -208,25 +233,37 @@ ADD_TEST_RESP=$(curl -s -X POST "$API_URL/test-data" \
     \"certNumber\": \"$UPDATED_CERT_NUMBER\",
     \"data\": [
       {
-        \"deviceAddr\": \"DEV-01\",
+        \"deviceAddr\": \"DEV001\",
+        \"dataType\": \"current\",
         \"testPoint\": \"P1\",
+        \"percentageValue\": 100.0,
         \"actualPercentage\": 100.0,
+        \"currentValue\": 5.0,
+        \"currentUnit\": \"A\",
         \"ratioError\": 0.2,
         \"angleError\": 0.1,
         \"testTimestamp\": \"2024-10-26T10:00:00Z\"
       },
       {
-        \"deviceAddr\": \"DEV-01\",
+        \"deviceAddr\": \"DEV001\",
+        \"dataType\": \"current\",
         \"testPoint\": \"P2\",
+        \"percentageValue\": 80.0,
         \"actualPercentage\": 80.0,
+        \"currentValue\": 4.0,
+        \"currentUnit\": \"A\",
         \"ratioError\": 0.3,
         \"angleError\": 0.15,
         \"testTimestamp\": \"2024-10-26T10:05:00Z\"
       },
       {
-        \"deviceAddr\": \"DEV-01\",
+        \"deviceAddr\": \"DEV001\",
+        \"dataType\": \"current\",
         \"testPoint\": \"P3\",
+        \"percentageValue\": 60.0,
         \"actualPercentage\": 60.0,
+        \"currentValue\": 3.0,
+        \"currentUnit\": \"A\",
         \"ratioError\": 0.25,
         \"angleError\": 0.12,
         \"testTimestamp\": \"2024-10-26T10:10:00Z\"
@@ -247,6 +284,48 @@ TEST_DATA_COUNT=$(echo $GET_TEST_RESP | jq '.data | length')
 run_test "获取测试数据" "$GET_TEST_CODE" "200"
 echo -e "${BLUE}  测试数据点数: $TEST_DATA_COUNT${NC}"
 
+# 4.3 工位实时上传：订阅事件流的同时用工位模拟程序通过 WebSocket 上传读数
+echo -e "\n---> 测试工位实时上传测试数据"
+WATCH_OUT=$(mktemp)
+curl -s -N --max-time 20 "$API_URL/test-data/watch/$UPDATED_CERT_NUMBER" -H "$AUTH_HEADER" > "$WATCH_OUT" &
+WATCH_PID=$!
+sleep 1
+(cd "$(dirname "$0")/../application" && go run ./cmd/workstation-sim \
+  -server "${API_URL%/api/v1}" -cert "$UPDATED_CERT_NUMBER" -device DEV001 -token "$TOKEN" \
+  -points "100,120" -repeat 2 -interval 100ms)
+run_test "工位实时上传测试数据" "$?" "0"
+sleep 1
+kill $WATCH_PID 2>/dev/null
+run_test "实时事件流收到新测试数据" "$(grep -c '^event:testData' "$WATCH_OUT")" "4"
+rm -f "$WATCH_OUT"
+
+# 4.4 设备签名：为 DEV003 登记 SM2 公钥后，未签名的数据被拒绝，签名数据入库且可重新校验
+echo -e "\n---> 测试设备签名的测试数据"
+KEY_DIR=$(mktemp -d)
+(cd "$(dirname "$0")/../application" && go run ./cmd/workstation-sim -gen-key sm2 -key "$KEY_DIR/dev003.pem") > "$KEY_DIR/dev003.pub.pem"
+DEV003_ID=$(curl -s -X GET "$API_URL/devices?keyword=DEV003" -H "$AUTH_HEADER" | jq -r '.data[0].id')
+SET_KEY_CODE=$(jq -n --rawfile key "$KEY_DIR/dev003.pub.pem" '{publicKey: $key}' | \
+  curl -s -X PUT "$API_URL/devices/$DEV003_ID/public-key" -H "$AUTH_HEADER" -H "Content-Type: application/json" -d @- | jq -r '.code')
+run_test "登记设备公钥" "$SET_KEY_CODE" "200"
+
+UNSIGNED_CODE=$(curl -s -X POST "$API_URL/test-data" \
+  -H "$AUTH_HEADER" -H "Content-Type: application/json" \
+  -d "{\"certNumber\": \"$UPDATED_CERT_NUMBER\", \"data\": [{\"deviceAddr\": \"DEV003\", \"dataType\": \"current\",
+       \"testPoint\": \"100%\", \"percentageValue\": 100, \"actualPercentage\": 100.01, \"currentValue\": 5,
+       \"testTimestamp\": \"$(date -u +%Y-%m-%dT%H:%M:%SZ)\"}]}" | jq -r '.code')
+run_test "拒绝未签名的测试数据" "$UNSIGNED_CODE" "400"
+
+(cd "$(dirname "$0")/../application" && go run ./cmd/workstation-sim \
+  -server "${API_URL%/api/v1}" -cert "$UPDATED_CERT_NUMBER" -device DEV003 -token "$TOKEN" \
+  -key "$KEY_DIR/dev003.pem" -points "100" -repeat 1 -interval 0)
+run_test "上传签名的测试数据" "$?" "0"
+
+SIGNED_ID=$(curl -s -X GET "$API_URL/test-data/certificate/$UPDATED_CERT_NUMBER" -H "$AUTH_HEADER" | \
+  jq -r '[.data[] | select(.signature != null)] | last | .id')
+SIG_VERIFIED=$(curl -s -X GET "$API_URL/test-data/$SIGNED_ID/signature" -H "$AUTH_HEADER" | jq -r '.data.verified')
+run_test "重新校验设备签名" "$SIG_VERIFIED" "true"
+rm -rf "$KEY_DIR"
+
 # ========== 5. 证书签发流程 ==========
 echo -e "\n${BLUE}[5] 证书签发流程${NC}"
 
@@ -255,6 +334,7 @@ echo -e "\n---> 测试签发证书"
 ISSUE_RESP=$(curl -s -X PUT "$API_URL/certificates/$UPDATED_CERT_NUMBER" \
   -H "Content-Type: application/json" \
   -H "$AUTH_HEADER" \
+  -H "If-Match: \"$(cert_version $UPDATED_CERT_NUMBER)\"" \
   -d "{
     \"certNumber\": \"$UPDATED_CERT_NUMBER\",
     \"customerId\": 1,
@@ -316,6 +396,17 @@ HISTORY_RESP=$(curl -s -X GET "$API_URL/certificates/$UPDATED_CERT_NUMBER/histor
 HISTORY_CODE=$(echo $HISTORY_RESP | jq -r '.code')
 run_test "获取证书历史记录" "$HISTORY_CODE" "200"
 
+echo -e "\n---> 测试测试数据异常分析"
+ANOMALY_RESP=$(curl -s -X GET "$API_URL/analytics/anomalies" \
+  -H "$AUTH_HEADER")
+ANOMALY_CODE=$(echo $ANOMALY_RESP | jq -r '.code')
+run_test "测试数据异常分析" "$ANOMALY_CODE" "200"
+
+ANOMALY_BAD_RESP=$(curl -s -X GET "$API_URL/analytics/anomalies?type=unknown" \
+  -H "$AUTH_HEADER")
+ANOMALY_BAD_CODE=$(echo $ANOMALY_BAD_RESP | jq -r '.code')
+run_test "未知异常类型返回400" "$ANOMALY_BAD_CODE" "400"
+
 # ========== 8. 证书撤销测试 ==========
 echo -e "\n${BLUE}[8] 证书撤销测试${NC}"
 
@@ -346,6 +437,7 @@ echo -e "\n---> 测试撤销证书"
 REVOKE_RESP=$(curl -s -X PUT "$API_URL/certificates/$REVOKE_CERT_NUMBER" \
   -H "Content-Type: application/json" \
   -H "$AUTH_HEADER" \
+  -H "If-Match: \"$(cert_version $REVOKE_CERT_NUMBER)\"" \
   -d "{
     \"certNumber\": \"$REVOKE_CERT_NUMBER\",
     \"customerId\": 2,
@@ -446,11 +538,29 @@ run_test "已删除证书应返回404" "$VERIFY_DELETED_CODE" "404"
 # ========== 12. 登出测试 ==========
 echo -e "\n${BLUE}[12] 用户登出${NC}"
 
+echo -e "\n---> 测试刷新令牌"
+REFRESH_RESP=$(curl -s -X POST "$API_URL/auth/refresh" \
+  -H "Content-Type: application/json" \
+  -d "{\"refreshToken\": \"$REFRESH_TOKEN\"}")
+run_test "刷新访问令牌" "$(echo $REFRESH_RESP | jq -r '.code')" "200"
+TOKEN=$(echo $REFRESH_RESP | jq -r '.data.token')
+AUTH_HEADER="Authorization: Bearer $TOKEN"
+
 echo -e "\n---> 测试用户登出"
 LOGOUT_RESP=$(curl -s -X POST "$API_URL/auth/logout" -H "$AUTH_HEADER")
 LOGOUT_CODE=$(echo $LOGOUT_RESP | jq -r '.code')
 run_test "用户登出" "$LOGOUT_CODE" "200"
 
+echo -e "\n---> 验证登出后令牌失效"
+AFTER_LOGOUT_RESP=$(curl -s -X GET "$API_URL/auth/profile" -H "$AUTH_HEADER")
+run_test "登出后访问令牌应被拒绝" "$(echo $AFTER_LOGOUT_RESP | jq -r '.code')" "401"
+
+echo -e "\n---> 验证旧刷新令牌不能重复使用"
+REUSE_RESP=$(curl -s -X POST "$API_URL/auth/refresh" \
+  -H "Content-Type: application/json" \
+  -d "{\"refreshToken\": \"$REFRESH_TOKEN\"}")
+run_test "重复使用刷新令牌应被拒绝" "$(echo $REUSE_RESP | jq -r '.code')" "401"
+
 # ========== 测试报告 ==========
 echo -e "\n${BLUE}====== API 测试报告 ======${NC}"
 echo -e "测试时间: $(date '+%Y-%m-%d %H:%M:%S')"